@@ -1,6 +1,8 @@
 package connmgr
 
 import (
+	"crypto/x509"
+
 	ma "github.com/multiformats/go-multiaddr"
 
 	"github.com/libp2p/go-libp2p/core/control"
@@ -87,3 +89,15 @@ type ConnectionGater interface {
 	// NOTE: the go-libp2p implementation currently IGNORES the disconnect reason.
 	InterceptUpgraded(network.Conn) (allow bool, reason control.DisconnectReason)
 }
+
+// CertificateGater is an optional interface a ConnectionGater can implement to
+// base gating decisions on the certificate chain presented by the remote peer,
+// for transports that authenticate peers with certificates, e.g. QUIC.
+//
+// Transports that support it call InterceptSecuredWithCert instead of
+// InterceptSecured. Other transports keep calling InterceptSecured.
+type CertificateGater interface {
+	// InterceptSecuredWithCert is like ConnectionGater.InterceptSecured, but also
+	// passes the certificate chain the remote peer presented, leaf first.
+	InterceptSecuredWithCert(network.Direction, peer.ID, network.ConnMultiaddrs, []*x509.Certificate) (allow bool)
+}
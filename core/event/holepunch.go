@@ -0,0 +1,32 @@
+package event
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// EvtHolePunchAttempt is emitted by the hole punching service after every
+// attempt to establish a direct connection to a peer by hole punching, as
+// coordinated by the DCUtR protocol.
+//
+// Experimental: This API is unstable. Any changes to this event will be done without a deprecation notice.
+type EvtHolePunchAttempt struct {
+	// Peer is the peer we hole punched with.
+	Peer peer.ID
+	// Initiator is true if we initiated the hole punch, i.e. we're the peer
+	// that the other peer connected to through a relay.
+	Initiator bool
+	// Transport is the transport of the direct connection, e.g. "quic-v1" or
+	// "tcp". It is empty if the attempt failed.
+	Transport string
+	// RTT is the round trip time to the peer over the relayed connection, as
+	// measured when coordinating the hole punch.
+	RTT time.Duration
+	// Duration is how long the attempt took.
+	Duration time.Duration
+	// Success is true if a direct connection was established.
+	Success bool
+	// Error is why the attempt failed. It is nil on success.
+	Error error
+}
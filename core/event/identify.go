@@ -35,6 +35,10 @@ type EvtPeerIdentificationCompleted struct {
 	// ObservedAddr is the our side's connection address as observed by the
 	// peer. This is not verified, the peer could return anything here.
 	ObservedAddr multiaddr.Multiaddr
+
+	// Metadata holds the key-value pairs the peer advertised, e.g. its
+	// software version. May be nil.
+	Metadata map[string]string
 }
 
 // EvtPeerIdentificationFailed is emitted when the initial identification round for a peer failed.
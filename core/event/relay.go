@@ -0,0 +1,52 @@
+package event
+
+import (
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ReservationLostReason describes why a relay reservation was lost.
+type ReservationLostReason int
+
+const (
+	// ReservationExpired is used when the reservation couldn't be refreshed before it expired.
+	ReservationExpired ReservationLostReason = iota
+	// ReservationRelayDisconnected is used when the connection to the relay was closed.
+	ReservationRelayDisconnected
+)
+
+func (r ReservationLostReason) String() string {
+	switch r {
+	case ReservationExpired:
+		return "expired"
+	case ReservationRelayDisconnected:
+		return "relay disconnected"
+	default:
+		return "unknown"
+	}
+}
+
+// EvtRelayReservationLost is emitted by the circuit v2 client when a relay
+// reservation it manages is no longer valid. The client doesn't attempt to
+// reserve a slot on the relay again; listen on the relay address again, or pick
+// another relay.
+//
+// Experimental: This API is unstable. Any changes to this event will be done without a deprecation notice.
+type EvtRelayReservationLost struct {
+	// Relay is the peer ID of the relay the reservation was held on.
+	Relay peer.ID
+	// Reason is why the reservation was lost.
+	Reason ReservationLostReason
+}
+
+// EvtRelayReservationSwitched is emitted by the circuit v2 client when it
+// reserved a slot on an alternate relay, because a relay it holds a reservation
+// on announced that it's shutting down. The reservation on the alternate relay
+// is refreshed like the one on the old relay, which is kept until it's lost.
+//
+// Experimental: This API is unstable. Any changes to this event will be done without a deprecation notice.
+type EvtRelayReservationSwitched struct {
+	// From is the relay that announced its shutdown.
+	From peer.ID
+	// To is the alternate relay the slot was reserved on.
+	To peer.ID
+}
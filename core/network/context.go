@@ -15,12 +15,14 @@ type dialPeerTimeoutCtxKey struct{}
 type forceDirectDialCtxKey struct{}
 type allowLimitedConnCtxKey struct{}
 type simConnectCtxKey struct{ isClient bool }
+type allowUnknownPeerCtxKey struct{}
 
 var noDial = noDialCtxKey{}
 var forceDirectDial = forceDirectDialCtxKey{}
 var allowLimitedConn = allowLimitedConnCtxKey{}
 var simConnectIsServer = simConnectCtxKey{}
 var simConnectIsClient = simConnectCtxKey{isClient: true}
+var allowUnknownPeer = allowUnknownPeerCtxKey{}
 
 // EXPERIMENTAL
 // WithForceDirectDial constructs a new context with an option that instructs the network
@@ -128,3 +130,23 @@ func GetUseTransient(ctx context.Context) (usetransient bool, reason string) {
 	}
 	return false, ""
 }
+
+// WithAllowUnknownPeer constructs a new context with an option that instructs
+// transports to accept dials with an empty peer ID, connecting to whichever
+// peer answers at the address. The peer ID is learned from the security
+// handshake.
+// EXPERIMENTAL
+func WithAllowUnknownPeer(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, allowUnknownPeer, reason)
+}
+
+// GetAllowUnknownPeer returns true if the allow unknown peer option is set in
+// the context.
+// EXPERIMENTAL
+func GetAllowUnknownPeer(ctx context.Context) (allow bool, reason string) {
+	v := ctx.Value(allowUnknownPeer)
+	if v != nil {
+		return true, v.(string)
+	}
+	return false, ""
+}
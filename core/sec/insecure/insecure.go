@@ -84,8 +84,8 @@ func (t *Transport) SecureInbound(_ context.Context, insecure net.Conn, p peer.I
 // and the key exchange provides no security.
 //
 // SecureOutbound may fail if the remote peer sends an ID and public key that are inconsistent
-// with each other, or if the ID sent by the remote peer does not match the one dialed. It may
-// also fail if a network error occurs during the ID exchange.
+// with each other, or if the ID sent by the remote peer does not match the one dialed, if
+// any. It may also fail if a network error occurs during the ID exchange.
 func (t *Transport) SecureOutbound(_ context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
 	conn := &Conn{
 		Conn:        insecure,
@@ -97,7 +97,7 @@ func (t *Transport) SecureOutbound(_ context.Context, insecure net.Conn, p peer.
 		return nil, err
 	}
 
-	if p != conn.remote {
+	if p != "" && p != conn.remote {
 		return nil, fmt.Errorf("remote peer sent unexpected peer ID. expected=%s received=%s",
 			p, conn.remote)
 	}
@@ -344,6 +344,18 @@ func EnableAutoRelayWithPeerSource(peerSource autorelay.PeerSource, opts ...auto
 	}
 }
 
+// EnableAutoRelayWithPeerSourceChan configures libp2p to enable the AutoRelay
+// subsystem reading relay candidates from peerChan as they arrive. This
+// subsystem performs automatic address rewriting to advertise relay addresses
+// when it detects that the node is publicly unreachable (e.g. behind a NAT).
+func EnableAutoRelayWithPeerSourceChan(peerChan <-chan peer.AddrInfo, opts ...autorelay.Option) Option {
+	return func(cfg *Config) error {
+		cfg.EnableAutoRelay = true
+		cfg.AutoRelayOpts = append([]autorelay.Option{autorelay.WithPeerSourceChan(peerChan)}, opts...)
+		return nil
+	}
+}
+
 // ForceReachabilityPublic overrides automatic reachability detection in the AutoNAT subsystem,
 // forcing the local node to believe it is reachable externally.
 func ForceReachabilityPublic() Option {
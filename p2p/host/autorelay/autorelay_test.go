@@ -601,3 +601,34 @@ func TestAutoRelayAddrsEvent(t *testing.T) {
 	case <-time.After(1 * time.Second):
 	}
 }
+
+func TestPeerSourceChan(t *testing.T) {
+	peerChan := make(chan peer.AddrInfo)
+	h, err := libp2p.New(
+		libp2p.ForceReachabilityPrivate(),
+		libp2p.EnableAutoRelayWithPeerSourceChan(peerChan,
+			autorelay.WithMinCandidates(1),
+			autorelay.WithNumRelays(2),
+			autorelay.WithBootDelay(0),
+			autorelay.WithMinInterval(time.Hour),
+		),
+	)
+	require.NoError(t, err)
+	defer h.Close()
+
+	r1 := newRelay(t)
+	t.Cleanup(func() { r1.Close() })
+	peerChan <- peer.AddrInfo{ID: r1.ID(), Addrs: r1.Addrs()}
+	require.Eventually(t, func() bool { return slices.Contains(usedRelays(h), r1.ID()) }, 5*time.Second, 50*time.Millisecond)
+
+	// candidates arriving later are used as well
+	r2 := newRelay(t)
+	t.Cleanup(func() { r2.Close() })
+	peerChan <- peer.AddrInfo{ID: r2.ID(), Addrs: r2.Addrs()}
+	require.Eventually(t, func() bool { return numRelays(h) == 2 }, 5*time.Second, 50*time.Millisecond)
+	require.ElementsMatch(t, []peer.ID{r1.ID(), r2.ID()}, usedRelays(h))
+
+	// no more candidates
+	close(peerChan)
+	require.Never(t, func() bool { return numRelays(h) != 2 }, 300*time.Millisecond, 50*time.Millisecond)
+}
@@ -56,7 +56,7 @@ var defaultConfig = config{
 }
 
 var (
-	errAlreadyHavePeerSource = errors.New("can only use a single WithPeerSource or WithStaticRelays")
+	errAlreadyHavePeerSource = errors.New("can only use a single WithPeerSource, WithPeerSourceChan or WithStaticRelays")
 )
 
 type Option func(*config) error
@@ -98,6 +98,36 @@ func WithPeerSource(f PeerSource) Option {
 	}
 }
 
+// WithPeerSourceChan makes AutoRelay read relay candidates from ch, e.g. as they
+// are discovered. Unlike with WithPeerSource, candidates are read as they
+// arrive, not only when AutoRelay needs more candidates. Candidates arriving
+// when AutoRelay already has WithMaxCandidates candidates are skipped.
+// Closing ch signals that there won't be any more candidates.
+func WithPeerSourceChan(ch <-chan peer.AddrInfo) Option {
+	return WithPeerSource(func(ctx context.Context, _ int) <-chan peer.AddrInfo {
+		out := make(chan peer.AddrInfo)
+		go func() {
+			defer close(out)
+			for {
+				select {
+				case pi, ok := <-ch:
+					if !ok {
+						return
+					}
+					select {
+					case out <- pi:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return out
+	})
+}
+
 // WithNumRelays sets the number of relays we strive to obtain reservations with.
 func WithNumRelays(n int) Option {
 	return func(c *config) error {
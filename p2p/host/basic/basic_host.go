@@ -775,6 +775,31 @@ func (h *BasicHost) ConfirmedAddrs() (reachable []ma.Multiaddr, unreachable []ma
 	return h.addressManager.ConfirmedAddrs()
 }
 
+// WaitForAddresses blocks until the host has at least one address that isn't a
+// loopback address, and returns the host's addresses, as returned by Addrs.
+// It returns an error if ctx is done or the host is closed first.
+func (h *BasicHost) WaitForAddresses(ctx context.Context) ([]ma.Multiaddr, error) {
+	sub, err := h.eventbus.Subscribe(new(event.EvtLocalAddressesUpdated), eventbus.Name("basichost-wait-addrs"))
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Close()
+
+	for {
+		addrs := h.Addrs()
+		if slices.ContainsFunc(addrs, func(a ma.Multiaddr) bool { return !manet.IsIPLoopback(a) }) {
+			return addrs, nil
+		}
+		select {
+		case <-sub.Out():
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-h.ctx.Done():
+			return nil, errors.New("host closed")
+		}
+	}
+}
+
 func trimHostAddrList(addrs []ma.Multiaddr, maxSize int) []ma.Multiaddr {
 	totalSize := 0
 	for _, a := range addrs {
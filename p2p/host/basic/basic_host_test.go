@@ -981,3 +981,45 @@ func TestHostTimeoutNewStream(t *testing.T) {
 	require.Error(t, err)
 	require.ErrorContains(t, err, "context deadline exceeded")
 }
+
+func TestWaitForAddresses(t *testing.T) {
+	public := ma.StringCast("/ip4/1.2.3.4/tcp/1234")
+	h, err := NewHost(swarmt.GenSwarm(t, swarmt.OptDialOnly), &HostOpts{
+		AddrsFactory: func(addrs []ma.Multiaddr) []ma.Multiaddr {
+			if len(addrs) == 0 {
+				return addrs
+			}
+			return append(addrs, public)
+		},
+	})
+	require.NoError(t, err)
+	defer h.Close()
+	h.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = h.WaitForAddresses(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	errCh := make(chan error, 1)
+	addrsCh := make(chan []ma.Multiaddr, 1)
+	go func() {
+		addrs, err := h.WaitForAddresses(context.Background())
+		errCh <- err
+		addrsCh <- addrs
+	}()
+	select {
+	case <-errCh:
+		t.Fatal("expected WaitForAddresses to block until the host is listening")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.NoError(t, h.Network().Listen(ma.StringCast("/ip4/127.0.0.1/tcp/0")))
+	select {
+	case err := <-errCh:
+		require.NoError(t, err)
+		require.Contains(t, <-addrsCh, public)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for addresses")
+	}
+}
@@ -3,6 +3,7 @@ package pstoreds
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"sort"
 	"sync"
@@ -14,11 +15,9 @@ import (
 	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoreds/pb"
 	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoremem"
 
-	"github.com/hashicorp/golang-lru/arc/v2"
 	ds "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/query"
 	logging "github.com/ipfs/go-log/v2"
-	b32 "github.com/multiformats/go-base32"
 	ma "github.com/multiformats/go-multiaddr"
 	"google.golang.org/protobuf/proto"
 )
@@ -34,7 +33,7 @@ var (
 	log = logging.Logger("peerstore/ds")
 
 	// Peer addresses are stored db key pattern:
-	// /peers/addrs/<b32 peer id no padding>
+	// /peers/addrs/<b32 peer id no padding>, unless overridden with Options.AddrBookKeys
 	addrBookBase = ds.NewKey("/peers/addrs")
 )
 
@@ -46,9 +45,10 @@ type addrsRecord struct {
 }
 
 // flush writes the record to the datastore by calling ds.Put, unless the record is
-// marked for deletion, in which case we call ds.Delete. To be called within a lock.
-func (r *addrsRecord) flush(write ds.Write) (err error) {
-	key := addrBookBase.ChildString(b32.RawStdEncoding.EncodeToString(r.Id))
+// marked for deletion, in which case we call ds.Delete. The record is stored under the key that
+// encode returns for its peer ID. To be called within a lock.
+func (r *addrsRecord) flush(write ds.Write, encode func(peer.ID) ds.Key) (err error) {
+	key := encode(peer.ID(r.Id))
 
 	if len(r.Addrs) == 0 {
 		if err = write.Delete(context.TODO(), key); err == nil {
@@ -138,10 +138,15 @@ type dsAddrBook struct {
 	opts Options
 
 	cache       cache[peer.ID, *addrsRecord]
+	keys        KeyCodec[peer.ID]
 	ds          ds.Batching
 	gc          *dsAddrBookGc
 	subsManager *pstoremem.AddrSubManager
 
+	// dirtyMu guards dirty, the records whose last flush failed.
+	dirtyMu sync.Mutex
+	dirty   map[peer.ID]*addrsRecord
+
 	// controls children goroutine lifetime.
 	childrenDone sync.WaitGroup
 	cancelFn     func()
@@ -172,7 +177,7 @@ var _ pstore.CertifiedAddrBook = (*dsAddrBook)(nil)
 //
 // Addresses and peer records are serialized into protobuf, storing one datastore entry per peer, along with metadata
 // to control address expiration. To alleviate disk access and serde overhead, we internally use a read/write-through
-// ARC cache, the size of which is adjustable via Options.CacheSize.
+// cache, the size and eviction policy of which are adjustable via Options.CacheSize and Options.CacheType.
 //
 // The user has a choice of two GC algorithms:
 //
@@ -195,33 +200,158 @@ func NewAddrBook(ctx context.Context, store ds.Batching, opts Options) (ab *dsAd
 		cancelFn:    cancelFn,
 		subsManager: pstoremem.NewAddrSubManager(),
 		clock:       realclock{},
+		keys:        PeerIDKeyCodec(addrBookBase),
+		dirty:       make(map[peer.ID]*addrsRecord),
 	}
 
 	if opts.Clock != nil {
 		ab.clock = opts.Clock
 	}
 
-	if opts.CacheSize > 0 {
-		if ab.cache, err = arc.NewARC[peer.ID, *addrsRecord](int(opts.CacheSize)); err != nil {
-			return nil, err
+	if opts.AddrBookKeys.isSet() {
+		if opts.AddrBookKeys.Encode == nil || opts.AddrBookKeys.Decode == nil {
+			return nil, errors.New("address book key codec needs both Encode and Decode")
 		}
-	} else {
-		ab.cache = new(noopCache[peer.ID, *addrsRecord])
+		ab.keys = opts.AddrBookKeys
+	}
+
+	if ab.cache, err = newCache[peer.ID, *addrsRecord](opts, ab.clock, ab.flushEvicted); err != nil {
+		return nil, err
 	}
 
 	if ab.gc, err = newAddressBookGc(ctx, ab); err != nil {
 		return nil, err
 	}
 
+	if err = ab.startCacheWarming(); err != nil {
+		ab.Close()
+		return nil, err
+	}
+
 	return ab, nil
 }
 
 func (ab *dsAddrBook) Close() error {
 	ab.cancelFn()
 	ab.childrenDone.Wait()
+	if ab.opts.CacheSnapshotInterval > 0 && ab.opts.CacheSize > 0 {
+		if err := ab.snapshotCache(); err != nil {
+			log.Warnw("failed to snapshot address book cache", "error", err)
+		}
+	}
 	return nil
 }
 
+// ResizeCache changes the capacity of the in-memory record cache, e.g. to react
+// to memory pressure. Shrinking the cache evicts records from memory only; they
+// are still loaded from the datastore when needed. It returns the number of
+// evicted records. If the cache is disabled, it has no effect.
+func (ab *dsAddrBook) ResizeCache(size int) (evicted int, err error) {
+	if size <= 0 {
+		return 0, fmt.Errorf("invalid cache size: %d", size)
+	}
+	return ab.cache.Resize(size), nil
+}
+
+// ClearCache drops all records from the in-memory record cache, e.g. to free memory. Records whose last write to the
+// datastore failed are written again as they are dropped, see Flush. Records are loaded from the datastore again when
+// needed. If the cache is disabled, it has no effect.
+func (ab *dsAddrBook) ClearCache() {
+	ab.cache.Clear()
+}
+
+// CacheStats describes the state of the in-memory record cache of an address book.
+type CacheStats struct {
+	// Len is the number of cached records. It is 0 if the cache is disabled.
+	Len int
+}
+
+// CacheStats returns the current state of the in-memory record cache, e.g. to monitor how full it is.
+func (ab *dsAddrBook) CacheStats() CacheStats {
+	return CacheStats{Len: ab.cache.Len()}
+}
+
+// RangeCachedAddrs calls fn with the unexpired addresses of each peer whose record is in the in-memory cache,
+// stopping early if fn returns false. It doesn't read from the datastore, so peers whose records aren't cached are
+// skipped. fn is called with a snapshot and may call into the address book.
+func (ab *dsAddrBook) RangeCachedAddrs(fn func(p peer.ID, addrs []ma.Multiaddr) bool) {
+	now := ab.clock.Now().Unix()
+	ab.cache.Range(func(p peer.ID, pr *addrsRecord) bool {
+		pr.RLock()
+		addrs := make([]ma.Multiaddr, 0, len(pr.Addrs))
+		for _, a := range pr.Addrs {
+			if a.Expiry <= now {
+				continue
+			}
+			addr, err := ma.NewMultiaddrBytes(a.Addr)
+			if err != nil {
+				log.Warnf("failed to parse cached peerstore entry for peer %v, err: %v", p, err)
+				continue
+			}
+			addrs = append(addrs, addr)
+		}
+		pr.RUnlock()
+		return fn(p, addrs)
+	})
+}
+
+// flushRecord flushes pr to the datastore. If that fails, pr is kept dirty and tracked until it is flushed
+// successfully, see Flush. To be called within the lock of pr.
+func (ab *dsAddrBook) flushRecord(pr *addrsRecord) error {
+	err := pr.flush(ab.ds, ab.keys.Encode)
+	if err != nil {
+		pr.dirty = true
+	}
+	ab.dirtyMu.Lock()
+	if err != nil {
+		ab.dirty[peer.ID(pr.Id)] = pr
+	} else {
+		delete(ab.dirty, peer.ID(pr.Id))
+	}
+	ab.dirtyMu.Unlock()
+	return err
+}
+
+// Flush writes the records whose last write to the datastore failed, e.g. because of a transient datastore
+// error, so that no changes are lost on a graceful shutdown. Records are written to the datastore as they are
+// changed otherwise. It's safe to call Flush concurrently with other operations. It returns the errors of the
+// records that still can't be written.
+func (ab *dsAddrBook) Flush(ctx context.Context) error {
+	ab.dirtyMu.Lock()
+	records := make([]*addrsRecord, 0, len(ab.dirty))
+	for _, pr := range ab.dirty {
+		records = append(records, pr)
+	}
+	ab.dirtyMu.Unlock()
+
+	var errs []error
+	for _, pr := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		pr.Lock()
+		if pr.dirty {
+			if err := ab.flushRecord(pr); err != nil {
+				errs = append(errs, fmt.Errorf("failed to flush address book record of peer %s: %w", peer.ID(pr.Id), err))
+			}
+		}
+		pr.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// flushEvicted flushes a record evicted from the cache if its last flush failed, so that its changes aren't lost.
+func (ab *dsAddrBook) flushEvicted(p peer.ID, pr *addrsRecord) {
+	pr.Lock()
+	defer pr.Unlock()
+	if !pr.dirty {
+		return
+	}
+	if err := ab.flushRecord(pr); err != nil {
+		log.Warnw("failed to flush address book record evicted from the cache", "peer", p, "error", err)
+	}
+}
+
 // loadRecord is a read-through fetch. It fetches a record from cache, falling back to the
 // datastore upon a miss, and returning a newly initialized record if the peer doesn't exist.
 //
@@ -229,32 +359,46 @@ func (ab *dsAddrBook) Close() error {
 // as a result and the update argument is true, the resulting state is saved in the datastore.
 //
 // If the cache argument is true, the record is inserted in the cache when loaded from the datastore.
-func (ab *dsAddrBook) loadRecord(id peer.ID, cache bool, update bool) (pr *addrsRecord, err error) {
-	if pr, ok := ab.cache.Get(id); ok {
+//
+// If the negative argument is true and the cache keeps negative entries, peers that aren't in the datastore are
+// remembered as absent rather than by caching an empty record, and a fresh record that isn't cached is returned
+// for them. Callers about to add addresses must pass false, so that the record they modify is cached.
+func (ab *dsAddrBook) loadRecord(id peer.ID, cache bool, update bool, negative bool) (pr *addrsRecord, err error) {
+	cached, res := ab.cache.Lookup(id)
+	if res == lookupHit {
+		pr = cached
 		pr.Lock()
 		defer pr.Unlock()
 
 		if pr.clean(ab.clock.Now()) && update {
-			err = pr.flush(ab.ds)
+			err = ab.flushRecord(pr)
 		}
 		return pr, err
 	}
 
 	pr = &addrsRecord{AddrBookRecord: &pb.AddrBookRecord{}}
-	key := addrBookBase.ChildString(b32.RawStdEncoding.EncodeToString([]byte(id)))
+	if res == lookupAbsent && negative {
+		pr.Id = []byte(id)
+		return pr, nil
+	}
+	key := ab.keys.Encode(id)
 	data, err := ab.ds.Get(context.TODO(), key)
 
 	switch err {
 	case ds.ErrNotFound:
 		err = nil
 		pr.Id = []byte(id)
+		if negative && ab.opts.CacheNegativeTTL > 0 {
+			ab.cache.AddAbsent(id)
+			return pr, nil
+		}
 	case nil:
 		if err := proto.Unmarshal(data, pr); err != nil {
 			return nil, err
 		}
 		// this record is new and local for now (not in cache), so we don't need to lock.
 		if pr.clean(ab.clock.Now()) && update {
-			err = pr.flush(ab.ds)
+			err = ab.flushRecord(pr)
 		}
 	default:
 		return nil, err
@@ -316,7 +460,7 @@ func (ab *dsAddrBook) ConsumePeerRecord(recordEnvelope *record.Envelope, ttl tim
 }
 
 func (ab *dsAddrBook) latestPeerRecordSeq(p peer.ID) uint64 {
-	pr, err := ab.loadRecord(p, true, false)
+	pr, err := ab.loadRecord(p, true, false, true)
 	if err != nil {
 		// We ignore the error because we don't want to fail storing a new record in this
 		// case.
@@ -341,7 +485,7 @@ func (ab *dsAddrBook) storeSignedPeerRecord(p peer.ID, envelope *record.Envelope
 	// this has to be done after we add the addresses, since if
 	// we try to flush a datastore record with no addresses,
 	// it will just get deleted
-	pr, err := ab.loadRecord(p, true, false)
+	pr, err := ab.loadRecord(p, true, false, true)
 	if err != nil {
 		return err
 	}
@@ -352,7 +496,7 @@ func (ab *dsAddrBook) storeSignedPeerRecord(p peer.ID, envelope *record.Envelope
 		Raw: envelopeBytes,
 	}
 	pr.dirty = true
-	err = pr.flush(ab.ds)
+	err = ab.flushRecord(pr)
 	return err
 }
 
@@ -360,7 +504,7 @@ func (ab *dsAddrBook) storeSignedPeerRecord(p peer.ID, envelope *record.Envelope
 // given peer id, if one exists.
 // Returns nil if no signed PeerRecord exists for the peer.
 func (ab *dsAddrBook) GetPeerRecord(p peer.ID) *record.Envelope {
-	pr, err := ab.loadRecord(p, true, false)
+	pr, err := ab.loadRecord(p, true, false, true)
 	if err != nil {
 		log.Errorf("unable to load record for peer %s: %v", p, err)
 		return nil
@@ -396,7 +540,7 @@ func (ab *dsAddrBook) SetAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duratio
 // UpdateAddrs will update any addresses for a given peer and TTL combination to
 // have a new TTL.
 func (ab *dsAddrBook) UpdateAddrs(p peer.ID, oldTTL time.Duration, newTTL time.Duration) {
-	pr, err := ab.loadRecord(p, true, false)
+	pr, err := ab.loadRecord(p, true, false, true)
 	if err != nil {
 		log.Errorf("failed to update ttls for peer %s: %s\n", p, err)
 		return
@@ -415,13 +559,13 @@ func (ab *dsAddrBook) UpdateAddrs(p peer.ID, oldTTL time.Duration, newTTL time.D
 	}
 
 	if pr.clean(ab.clock.Now()) {
-		pr.flush(ab.ds)
+		ab.flushRecord(pr)
 	}
 }
 
 // Addrs returns all of the non-expired addresses for a given peer.
 func (ab *dsAddrBook) Addrs(p peer.ID) []ma.Multiaddr {
-	pr, err := ab.loadRecord(p, true, true)
+	pr, err := ab.loadRecord(p, true, true, true)
 	if err != nil {
 		log.Warnf("failed to load peerstore entry for peer %s while querying addrs, err: %v", p, err)
 		return nil
@@ -444,11 +588,25 @@ func (ab *dsAddrBook) Addrs(p peer.ID) []ma.Multiaddr {
 
 // Peers returns all of the peer IDs for which the AddrBook has addresses.
 func (ab *dsAddrBook) PeersWithAddrs() peer.IDSlice {
-	ids, err := uniquePeerIds(ab.ds, addrBookBase, func(result query.Result) string {
-		return ds.RawKey(result.Key).Name()
-	})
+	results, err := ab.ds.Query(context.TODO(), query.Query{Prefix: addrBookBase.String(), KeysOnly: true})
 	if err != nil {
 		log.Errorf("error while retrieving peers with addresses: %v", err)
+		return peer.IDSlice{}
+	}
+	defer results.Close()
+
+	ids := peer.IDSlice{}
+	for result := range results.Next() {
+		if result.Error != nil {
+			log.Errorf("error while retrieving peers with addresses: %v", result.Error)
+			continue
+		}
+		id, err := ab.keys.Decode(ds.RawKey(result.Key))
+		if err != nil {
+			log.Warnf("failed while decoding peer ID from key: %v, err: %v", result.Key, err)
+			continue
+		}
+		ids = append(ids, id)
 	}
 	return ids
 }
@@ -463,8 +621,11 @@ func (ab *dsAddrBook) AddrStream(ctx context.Context, p peer.ID) <-chan ma.Multi
 // ClearAddrs will delete all known addresses for a peer ID.
 func (ab *dsAddrBook) ClearAddrs(p peer.ID) {
 	ab.cache.Remove(p)
+	ab.dirtyMu.Lock()
+	delete(ab.dirty, p)
+	ab.dirtyMu.Unlock()
 
-	key := addrBookBase.ChildString(b32.RawStdEncoding.EncodeToString([]byte(p)))
+	key := ab.keys.Encode(p)
 	if err := ab.ds.Delete(context.TODO(), key); err != nil {
 		log.Errorf("failed to clear addresses for peer %s: %v", p, err)
 	}
@@ -475,7 +636,7 @@ func (ab *dsAddrBook) setAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duratio
 		return nil
 	}
 
-	pr, err := ab.loadRecord(p, true, false)
+	pr, err := ab.loadRecord(p, true, false, false)
 	if err != nil {
 		return fmt.Errorf("failed to load peerstore entry for peer %s while setting addrs, err: %v", p, err)
 	}
@@ -549,7 +710,7 @@ func (ab *dsAddrBook) setAddrs(p peer.ID, addrs []ma.Multiaddr, ttl time.Duratio
 
 	pr.dirty = true
 	pr.clean(ab.clock.Now())
-	return pr.flush(ab.ds)
+	return ab.flushRecord(pr)
 }
 
 // deletes addresses in place, avoiding copies until we encounter the first deletion.
@@ -579,7 +740,7 @@ Outer:
 }
 
 func (ab *dsAddrBook) deleteAddrs(p peer.ID, addrs []ma.Multiaddr) (err error) {
-	pr, err := ab.loadRecord(p, false, false)
+	pr, err := ab.loadRecord(p, false, false, true)
 	if err != nil {
 		return fmt.Errorf("failed to load peerstore entry for peer %v while deleting addrs, err: %v", p, err)
 	}
@@ -595,7 +756,7 @@ func (ab *dsAddrBook) deleteAddrs(p peer.ID, addrs []ma.Multiaddr) (err error) {
 
 	pr.dirty = true
 	pr.clean(ab.clock.Now())
-	return pr.flush(ab.ds)
+	return ab.flushRecord(pr)
 }
 
 func cleanAddrs(addrs []ma.Multiaddr, pid peer.ID) []ma.Multiaddr {
@@ -12,12 +12,11 @@ import (
 
 	ds "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/query"
-	b32 "github.com/multiformats/go-base32"
 )
 
 var (
 	// GC lookahead entries are stored in key pattern:
-	// /peers/gc/addrs/<unix timestamp of next visit>/<peer ID b32> => nil
+	// /peers/gc/addrs/<unix timestamp of next visit>/<name of the record key> => nil
 	// in databases with lexicographical key order, this time-indexing allows us to visit
 	// only the timeslice we are interested in.
 	gcLookaheadBase = ds.NewKey("/peers/gc/addrs")
@@ -182,7 +181,7 @@ func (gc *dsAddrBookGc) purgeLookahead() {
 
 	now := gc.ab.clock.Now().Unix()
 
-	// keys: 	/peers/gc/addrs/<unix timestamp of next visit>/<peer ID b32>
+	// keys: 	/peers/gc/addrs/<unix timestamp of next visit>/<name of the record key>
 	// values: 	nil
 	for result := range results.Next() {
 		gcKey := ds.RawKey(result.Key)
@@ -196,14 +195,8 @@ func (gc *dsAddrBookGc) purgeLookahead() {
 			break
 		}
 
-		idb32, err := b32.RawStdEncoding.DecodeString(gcKey.Name())
-		if err != nil {
-			dropInError(gcKey, err, "parsing peer ID")
-			log.Warnf("failed while parsing b32 peer ID from key: %v, err: %v", result.Key, err)
-			continue
-		}
-
-		id, err = peer.IDFromBytes(idb32)
+		entryKey := addrBookBase.ChildString(gcKey.Name())
+		id, err = gc.ab.keys.Decode(entryKey)
 		if err != nil {
 			dropInError(gcKey, err, "decoding peer ID")
 			log.Warnf("failed while decoding peer ID from key: %v, err: %v", result.Key, err)
@@ -214,7 +207,7 @@ func (gc *dsAddrBookGc) purgeLookahead() {
 		if cached, ok := gc.ab.cache.Peek(id); ok {
 			cached.Lock()
 			if cached.clean(gc.ab.clock.Now()) {
-				if err = cached.flush(batch); err != nil {
+				if err = cached.flush(batch, gc.ab.keys.Encode); err != nil {
 					log.Warnf("failed to flush entry modified by GC for peer: %s, err: %v", id, err)
 				}
 			}
@@ -226,7 +219,6 @@ func (gc *dsAddrBookGc) purgeLookahead() {
 		record.Reset()
 
 		// otherwise, fetch it from the store, clean it and flush it.
-		entryKey := addrBookBase.ChildString(gcKey.Name())
 		val, err := gc.ab.ds.Get(context.TODO(), entryKey)
 		if err != nil {
 			// captures all errors, including ErrNotFound.
@@ -239,7 +231,7 @@ func (gc *dsAddrBookGc) purgeLookahead() {
 			continue
 		}
 		if record.clean(gc.ab.clock.Now()) {
-			err = record.flush(batch)
+			err = record.flush(batch, gc.ab.keys.Encode)
 			if err != nil {
 				log.Warnf("failed to flush entry modified by GC for peer: %s, err: %v", id, err)
 			}
@@ -275,6 +267,7 @@ func (gc *dsAddrBookGc) purgeStore() {
 	defer results.Close()
 
 	// keys: 	/peers/addrs/<peer ID b32>
+	var purged []peer.ID
 	for result := range results.Next() {
 		record.Reset()
 		if err = proto.Unmarshal(result.Value, record); err != nil {
@@ -287,11 +280,12 @@ func (gc *dsAddrBookGc) purgeStore() {
 			continue
 		}
 
-		if err := record.flush(batch); err != nil {
+		if err := record.flush(batch, gc.ab.keys.Encode); err != nil {
 			log.Warnf("failed to flush entry modified by GC for peer: &v, err: %v", id, err)
 		}
-		gc.ab.cache.Remove(peer.ID(id))
+		purged = append(purged, peer.ID(id))
 	}
+	gc.ab.cache.RemoveAll(purged)
 
 	if err = batch.Commit(context.TODO()); err != nil {
 		log.Warnf("failed to commit GC purge batch: %v", err)
@@ -334,15 +328,11 @@ func (gc *dsAddrBookGc) populateLookahead() {
 	}
 
 	for result := range results.Next() {
-		idb32 := ds.RawKey(result.Key).Name()
-		k, err := b32.RawStdEncoding.DecodeString(idb32)
-		if err != nil {
+		entryKey := ds.RawKey(result.Key)
+		if id, err = gc.ab.keys.Decode(entryKey); err != nil {
 			log.Warnf("failed while decoding peer ID from key: %v, err: %v", result.Key, err)
 			continue
 		}
-		if id, err = peer.IDFromBytes(k); err != nil {
-			log.Warnf("failed while decoding peer ID from key: %v, err: %v", result.Key, err)
-		}
 
 		// if the record is in cache, use the cached version.
 		if cached, ok := gc.ab.cache.Peek(id); ok {
@@ -351,7 +341,7 @@ func (gc *dsAddrBookGc) populateLookahead() {
 				cached.RUnlock()
 				continue
 			}
-			gcKey := gcLookaheadBase.ChildString(fmt.Sprintf("%d/%s", cached.Addrs[0].Expiry, idb32))
+			gcKey := gcLookaheadBase.ChildString(fmt.Sprintf("%d/%s", cached.Addrs[0].Expiry, entryKey.Name()))
 			if err = batch.Put(context.TODO(), gcKey, []byte{}); err != nil {
 				log.Warnf("failed while inserting GC entry for peer: %s, err: %v", id, err)
 			}
@@ -361,7 +351,7 @@ func (gc *dsAddrBookGc) populateLookahead() {
 
 		record.Reset()
 
-		val, err := gc.ab.ds.Get(context.TODO(), ds.RawKey(result.Key))
+		val, err := gc.ab.ds.Get(context.TODO(), entryKey)
 		if err != nil {
 			log.Warnf("failed which getting record from store for peer: %s, err: %v", id, err)
 			continue
@@ -371,7 +361,7 @@ func (gc *dsAddrBookGc) populateLookahead() {
 			continue
 		}
 		if len(record.Addrs) > 0 && record.Addrs[0].Expiry <= until {
-			gcKey := gcLookaheadBase.ChildString(fmt.Sprintf("%d/%s", record.Addrs[0].Expiry, idb32))
+			gcKey := gcLookaheadBase.ChildString(fmt.Sprintf("%d/%s", record.Addrs[0].Expiry, entryKey.Name()))
 			if err = batch.Put(context.TODO(), gcKey, []byte{}); err != nil {
 				log.Warnf("failed while inserting GC entry for peer: %s, err: %v", id, err)
 			}
@@ -0,0 +1,140 @@
+package pstoreds
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoreds/pb"
+	"google.golang.org/protobuf/proto"
+
+	ds "github.com/ipfs/go-datastore"
+)
+
+var (
+	// The peer IDs of the cached address book records are snapshotted under this key, as a sequence of
+	// varint-prefixed peer IDs, most valuable last.
+	addrBookCacheSnapshotKey = ds.NewKey("/peers/cache/addrs")
+)
+
+// defaultCacheWarmBatchSize is the batch size of the cache warmer if Options.CacheWarmBatchSize is not set.
+const defaultCacheWarmBatchSize = 64
+
+// startCacheWarming starts snapshotting the keys of the cache at the interval set in the options, and warms up the
+// cache from the last snapshot. It doesn't do anything if snapshots are disabled.
+func (ab *dsAddrBook) startCacheWarming() error {
+	if ab.opts.CacheSnapshotInterval < 0 {
+		return fmt.Errorf("negative cache snapshot interval provided: %s", ab.opts.CacheSnapshotInterval)
+	}
+	if ab.opts.CacheWarmBatchSize < 0 {
+		return fmt.Errorf("negative cache warm batch size provided: %d", ab.opts.CacheWarmBatchSize)
+	}
+	if ab.opts.CacheSnapshotInterval == 0 || ab.opts.CacheSize == 0 {
+		return nil
+	}
+
+	ab.childrenDone.Add(1)
+	go ab.warmCache()
+	return nil
+}
+
+// warmCache loads the records of the last snapshot into the cache, and then snapshots the cache periodically. It
+// should be spawned as a goroutine.
+func (ab *dsAddrBook) warmCache() {
+	defer ab.childrenDone.Done()
+
+	ids, err := ab.loadCacheSnapshot()
+	if err != nil {
+		log.Warnw("failed to load address book cache snapshot", "error", err)
+	}
+	batchSize := ab.opts.CacheWarmBatchSize
+	if batchSize == 0 {
+		batchSize = defaultCacheWarmBatchSize
+	}
+	for len(ids) > 0 {
+		n := min(batchSize, len(ids))
+		ab.warmBatch(ids[:n])
+		ids = ids[n:]
+		if ab.ctx.Err() != nil {
+			return
+		}
+	}
+
+	ticker := time.NewTicker(ab.opts.CacheSnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ab.snapshotCache(); err != nil {
+				log.Warnw("failed to snapshot address book cache", "error", err)
+			}
+		case <-ab.ctx.Done():
+			return
+		}
+	}
+}
+
+// warmBatch loads the records of ids into the cache. Records that were loaded in the meantime are left alone.
+func (ab *dsAddrBook) warmBatch(ids []peer.ID) {
+	now := ab.clock.Now()
+	for _, id := range ids {
+		if ab.cache.Contains(id) {
+			continue
+		}
+		data, err := ab.ds.Get(ab.ctx, ab.keys.Encode(id))
+		if err != nil {
+			if !errors.Is(err, ds.ErrNotFound) {
+				log.Debugw("failed to load address book record to warm the cache", "peer", id, "error", err)
+			}
+			continue
+		}
+		pr := &addrsRecord{AddrBookRecord: &pb.AddrBookRecord{}}
+		if err := proto.Unmarshal(data, pr); err != nil {
+			log.Debugw("failed to unmarshal address book record to warm the cache", "peer", id, "error", err)
+			continue
+		}
+		// expired addresses are purged from the datastore when the record is next loaded or by the GC.
+		pr.clean(now)
+		ab.cache.GetOrAdd(id, func() *addrsRecord { return pr })
+	}
+}
+
+// snapshotCache stores the keys of the cache in the datastore.
+func (ab *dsAddrBook) snapshotCache() error {
+	var buf []byte
+	ab.cache.RangeKeys(func(id peer.ID) bool {
+		buf = binary.AppendUvarint(buf, uint64(len(id)))
+		buf = append(buf, id...)
+		return true
+	})
+	return ab.ds.Put(context.Background(), addrBookCacheSnapshotKey, buf)
+}
+
+// loadCacheSnapshot returns the keys of the last snapshot, most valuable last.
+func (ab *dsAddrBook) loadCacheSnapshot() ([]peer.ID, error) {
+	buf, err := ab.ds.Get(ab.ctx, addrBookCacheSnapshotKey)
+	if errors.Is(err, ds.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []peer.ID
+	for len(buf) > 0 {
+		l, n := binary.Uvarint(buf)
+		if n <= 0 || uint64(len(buf)-n) < l {
+			return ids, errors.New("malformed snapshot")
+		}
+		buf = buf[n:]
+		id, err := peer.IDFromBytes(buf[:l])
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+		buf = buf[l:]
+	}
+	return ids, nil
+}
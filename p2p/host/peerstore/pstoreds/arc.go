@@ -0,0 +1,292 @@
+package pstoreds
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+)
+
+// arc is an adaptive replacement cache, following the ARC of
+// hashicorp/golang-lru. Unlike that one, Add reports the entry it evicts, so
+// that tracking evictions doesn't require looking for the entry that is gone,
+// and entries optionally expire a fixed duration after they were added.
+// Expired entries are treated as absent and are removed lazily.
+// It is safe for concurrent use.
+type arc[K comparable, V any] struct {
+	mu   sync.RWMutex
+	size int
+	// p is the target size of t1, adapted to the workload.
+	p int
+
+	// ttl is how long entries live. 0 means they don't expire.
+	ttl   time.Duration
+	clock clock
+
+	t1 *simplelru.LRU[K, arcEntry[V]] // recently used entries
+	b1 *simplelru.LRU[K, struct{}]    // keys recently evicted from t1
+	t2 *simplelru.LRU[K, arcEntry[V]] // frequently used entries
+	b2 *simplelru.LRU[K, struct{}]    // keys recently evicted from t2
+}
+
+type arcEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// newARC creates an ARC holding up to size entries. A ttl of 0 disables
+// expiry.
+func newARC[K comparable, V any](size int, ttl time.Duration, clk clock) (*arc[K, V], error) {
+	if clk == nil {
+		clk = realclock{}
+	}
+	c := &arc[K, V]{ttl: ttl, clock: clk}
+	if err := c.init(size); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// init empties the cache, and sets its size. Caller must hold mu exclusively.
+func (c *arc[K, V]) init(size int) error {
+	t1, err := simplelru.NewLRU[K, arcEntry[V]](size, nil)
+	if err != nil {
+		return err
+	}
+	b1, err := simplelru.NewLRU[K, struct{}](size, nil)
+	if err != nil {
+		return err
+	}
+	t2, err := simplelru.NewLRU[K, arcEntry[V]](size, nil)
+	if err != nil {
+		return err
+	}
+	b2, err := simplelru.NewLRU[K, struct{}](size, nil)
+	if err != nil {
+		return err
+	}
+	c.size, c.p = size, 0
+	c.t1, c.b1, c.t2, c.b2 = t1, b1, t2, b2
+	return nil
+}
+
+// live reports whether e hasn't expired yet.
+func (c *arc[K, V]) live(e arcEntry[V]) bool {
+	return c.ttl == 0 || c.clock.Now().Before(e.expires)
+}
+
+// Get returns the value for key, promoting it to the frequently used entries.
+func (c *arc[K, V]) Get(key K) (value V, ok bool) {
+	value, _, ok = c.GetWithTTL(key)
+	return value, ok
+}
+
+// GetWithTTL is like Get, and also returns the time left until the entry
+// expires. remaining is 0 if entries don't expire.
+func (c *arc[K, V]) GetWithTTL(key K) (value V, remaining time.Duration, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.t1.Peek(key)
+	if ok {
+		c.t1.Remove(key)
+		if ok = c.live(e); ok {
+			c.t2.Add(key, e)
+		}
+	} else if e, ok = c.t2.Get(key); ok && !c.live(e) {
+		c.t2.Remove(key)
+		ok = false
+	}
+	if !ok {
+		return value, 0, false
+	}
+	if c.ttl > 0 {
+		remaining = e.expires.Sub(c.clock.Now())
+	}
+	return e.value, remaining, true
+}
+
+// Add adds or replaces the entry for key, refreshing its expiry. If this
+// evicted another entry, it is returned with ok set.
+func (c *arc[K, V]) Add(key K, value V) (evicted cacheEntry[K, V], ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := arcEntry[V]{value: value}
+	if c.ttl > 0 {
+		e.expires = c.clock.Now().Add(c.ttl)
+	}
+	return c.add(key, e)
+}
+
+// add adds or replaces the entry for key. Caller must hold mu exclusively.
+func (c *arc[K, V]) add(key K, e arcEntry[V]) (evicted cacheEntry[K, V], ok bool) {
+	if c.t1.Contains(key) {
+		c.t1.Remove(key)
+		c.t2.Add(key, e)
+		return evicted, false
+	}
+	if c.t2.Contains(key) {
+		c.t2.Add(key, e)
+		return evicted, false
+	}
+
+	if c.b1.Contains(key) {
+		// The key was evicted from t1 too early, so grow t1.
+		delta := 1
+		if b1, b2 := c.b1.Len(), c.b2.Len(); b2 > b1 {
+			delta = b2 / b1
+		}
+		c.p = min(c.p+delta, c.size)
+		if c.full() {
+			evicted, ok = c.replace(false)
+		}
+		c.b1.Remove(key)
+		c.t2.Add(key, e)
+		return evicted, ok
+	}
+	if c.b2.Contains(key) {
+		// The key was evicted from t2 too early, so shrink t1.
+		delta := 1
+		if b1, b2 := c.b1.Len(), c.b2.Len(); b1 > b2 {
+			delta = b1 / b2
+		}
+		c.p = max(c.p-delta, 0)
+		if c.full() {
+			evicted, ok = c.replace(true)
+		}
+		c.b2.Remove(key)
+		c.t2.Add(key, e)
+		return evicted, ok
+	}
+
+	if c.full() {
+		evicted, ok = c.replace(false)
+	}
+	if c.b1.Len() > c.size-c.p {
+		c.b1.RemoveOldest()
+	}
+	if c.b2.Len() > c.p {
+		c.b2.RemoveOldest()
+	}
+	c.t1.Add(key, e)
+	return evicted, ok
+}
+
+// full reports whether adding an entry requires evicting another one. Caller
+// must hold mu.
+func (c *arc[K, V]) full() bool {
+	return c.t1.Len()+c.t2.Len() >= c.size
+}
+
+// replace evicts the oldest entry of t1 or t2, depending on p, and remembers
+// its key in b1 or b2. Caller must hold mu exclusively.
+func (c *arc[K, V]) replace(b2ContainsKey bool) (evicted cacheEntry[K, V], ok bool) {
+	var e arcEntry[V]
+	if t1 := c.t1.Len(); t1 > 0 && (t1 > c.p || (t1 == c.p && b2ContainsKey)) {
+		if evicted.key, e, ok = c.t1.RemoveOldest(); ok {
+			c.b1.Add(evicted.key, struct{}{})
+		}
+	} else if evicted.key, e, ok = c.t2.RemoveOldest(); ok {
+		c.b2.Add(evicted.key, struct{}{})
+	}
+	evicted.value = e.value
+	return evicted, ok
+}
+
+// Resize empties the cache and sets its size, then re-adds the entries from
+// least to most valuable, so that shrinking evicts the least valuable ones.
+// Entries keep their expiry. The adaptation state is lost. It returns the
+// evicted entries.
+func (c *arc[K, V]) Resize(size int) ([]cacheEntry[K, V], error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t1, t2 := c.t1, c.t2
+	if err := c.init(size); err != nil {
+		return nil, err
+	}
+	var evicted []cacheEntry[K, V]
+	for _, l := range []*simplelru.LRU[K, arcEntry[V]]{t1, t2} {
+		for _, k := range l.Keys() {
+			e, _ := l.Peek(k)
+			if ev, ok := c.add(k, e); ok {
+				evicted = append(evicted, ev)
+			}
+		}
+	}
+	return evicted, nil
+}
+
+// Remove removes key, including from the evicted keys.
+func (c *arc[K, V]) Remove(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t1.Remove(key)
+	c.t2.Remove(key)
+	c.b1.Remove(key)
+	c.b2.Remove(key)
+}
+
+// Purge removes all entries and evicted keys.
+func (c *arc[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t1.Purge()
+	c.t2.Purge()
+	c.b1.Purge()
+	c.b2.Purge()
+}
+
+// Contains reports whether key is cached, without promoting it.
+func (c *arc[K, V]) Contains(key K) bool {
+	_, ok := c.Peek(key)
+	return ok
+}
+
+// Peek returns the value for key, without promoting it.
+func (c *arc[K, V]) Peek(key K) (value V, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.t1.Peek(key)
+	if !ok {
+		e, ok = c.t2.Peek(key)
+	}
+	if !ok || !c.live(e) {
+		return value, false
+	}
+	return e.value, true
+}
+
+// Len returns the number of entries, including expired ones that weren't
+// removed yet.
+func (c *arc[K, V]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.t1.Len() + c.t2.Len()
+}
+
+// Keys returns the keys of the live entries, the recently used ones before
+// the frequently used ones, each ordered from oldest to newest.
+func (c *arc[K, V]) Keys() []K {
+	entries := c.entries()
+	keys := make([]K, len(entries))
+	for i, e := range entries {
+		keys[i] = e.key
+	}
+	return keys
+}
+
+// entries returns the live entries, in the order of Keys.
+func (c *arc[K, V]) entries() []cacheEntry[K, V] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entries := make([]cacheEntry[K, V], 0, c.t1.Len()+c.t2.Len())
+	for _, l := range []*simplelru.LRU[K, arcEntry[V]]{c.t1, c.t2} {
+		values := l.Values()
+		for i, k := range l.Keys() {
+			if c.live(values[i]) {
+				entries = append(entries, cacheEntry[K, V]{key: k, value: values[i].value})
+			}
+		}
+	}
+	return entries
+}
@@ -1,14 +1,346 @@
 package pstoreds
 
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// CacheType selects the eviction policy of the in-memory cache.
+type CacheType int
+
+const (
+	// ARCCache is an adaptive replacement cache. This is the default.
+	ARCCache CacheType = iota
+	// LRUCache is a plain least-recently-used cache.
+	LRUCache
+	// ShardedARCCache partitions the entries across several ARC caches by the
+	// hash of their key, reducing lock contention under heavy concurrent use.
+	// Each shard evicts independently. See Options.CacheShards.
+	ShardedARCCache
+)
+
 // cache abstracts all methods we access from ARCCache, to enable alternate
 // implementations such as a no-op one.
 type cache[K comparable, V any] interface {
 	Get(key K) (value V, ok bool)
+	// GetWithTTL is like Get, but also returns how long the entry stays
+	// cached until it expires, see Options.CacheTTL. remaining is 0 if the
+	// entry doesn't expire.
+	GetWithTTL(key K) (value V, remaining time.Duration, ok bool)
 	Add(key K, value V)
+	// GetOrAdd returns the value for key if it is cached. Otherwise it adds
+	// the value returned by construct, atomically with the lookup, so that
+	// concurrent calls for the same key call construct only once. construct
+	// must not call into the cache.
+	GetOrAdd(key K, construct func() V) (value V, loaded bool)
+	// UpdateIfPresent replaces the value for key with the value returned by
+	// update, which is called with the cached value, if key is cached. It is
+	// atomic like GetOrAdd, and otherwise behaves like Add. It reports whether
+	// key was cached. update must not call into the cache.
+	UpdateIfPresent(key K, update func(old V) V) bool
 	Remove(key K)
 	Contains(key K) bool
 	Peek(key K) (value V, ok bool)
 	Keys() []K
+	// Len returns the number of cached entries, without allocating.
+	Len() int
+	// RangeKeys calls fn for each key in the cache, stopping early if fn
+	// returns false. fn must not call into the cache.
+	RangeKeys(fn func(K) bool)
+	// Range calls fn for each entry in the cache, stopping early if fn
+	// returns false. It iterates a copy of the entries, so fn may call into
+	// the cache, but doesn't observe changes made meanwhile.
+	Range(fn func(K, V) bool)
+	// RemoveAll removes keys as one batch. Concurrent operations observe
+	// either all or none of keys removed.
+	RemoveAll(keys []K)
+	// Resize changes the capacity of the cache to size, which must be
+	// positive, evicting entries if it shrinks. It returns the number of
+	// evicted entries.
+	Resize(size int) (evicted int)
+	// Clear removes all entries, including negative ones. The removed entries
+	// count as evicted, so they are passed to the eviction callback.
+	Clear()
+	// Lookup is like Get, but tells keys marked absent with AddAbsent apart
+	// from unknown ones.
+	Lookup(key K) (value V, res lookupResult)
+	// AddAbsent records that key is known to be absent from the datastore,
+	// until the negative entry expires or key is added or removed. It has no
+	// effect if the cache doesn't support negative entries.
+	AddAbsent(key K)
+}
+
+// lookupResult is the outcome of cache.Lookup.
+type lookupResult int
+
+const (
+	// lookupMiss means that nothing is known about the key.
+	lookupMiss lookupResult = iota
+	// lookupHit means that the key is cached.
+	lookupHit
+	// lookupAbsent means that the key is known to be absent from the datastore.
+	lookupAbsent
+)
+
+// cacheEntry is an entry evicted from a cache, to be passed to its eviction
+// callback once the cache is unlocked.
+type cacheEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// notifyEvicted calls onEvict for every entry of evicted. It must be called
+// without holding the lock of the cache, so that onEvict can call into it.
+func notifyEvicted[K comparable, V any](onEvict func(K, V), evicted []cacheEntry[K, V]) {
+	for _, e := range evicted {
+		onEvict(e.key, e.value)
+	}
+}
+
+// arcCache adapts arc to the cache interface. arc locks internally on every
+// call; mu additionally makes RemoveAll atomic with respect to all other
+// operations.
+type arcCache[K comparable, V any] struct {
+	mu sync.RWMutex
+	*arc[K, V]
+
+	// onEvict, if set, is called for every evicted entry.
+	onEvict func()
+	// onEvictEntry, if set, is called with every evicted entry, after mu is
+	// released.
+	onEvictEntry func(K, V)
+
+	// absent holds the negative entries. nil if they are disabled.
+	absent *lruCache[K, struct{}]
+}
+
+var _ cache[int, int] = (*arcCache[int, int])(nil)
+
+func newARCCache[K comparable, V any](size int) (*arcCache[K, V], error) {
+	return newARCCacheWithTTL[K, V](size, 0, nil)
+}
+
+// newARCCacheWithTTL creates an ARC cache whose entries expire ttl after they
+// were added. A ttl of 0 disables expiry.
+func newARCCacheWithTTL[K comparable, V any](size int, ttl time.Duration, clk clock) (*arcCache[K, V], error) {
+	c, err := newARC[K, V](size, ttl, clk)
+	if err != nil {
+		return nil, err
+	}
+	return &arcCache[K, V]{arc: c}, nil
+}
+
+func (c *arcCache[K, V]) Get(key K) (value V, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.arc.Get(key)
+}
+
+func (c *arcCache[K, V]) GetWithTTL(key K) (value V, remaining time.Duration, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.arc.GetWithTTL(key)
+}
+
+func (c *arcCache[K, V]) Add(key K, value V) {
+	c.mu.RLock()
+	c.forgetAbsent(key)
+	ev := c.add(key, value)
+	c.mu.RUnlock()
+	notifyEvicted(c.onEvictEntry, ev)
+}
+
+// GetOrAdd holds mu exclusively, as arc can't check and insert atomically.
+func (c *arcCache[K, V]) GetOrAdd(key K, construct func() V) (value V, loaded bool) {
+	c.mu.Lock()
+	if value, ok := c.arc.Get(key); ok {
+		c.mu.Unlock()
+		return value, true
+	}
+	value = construct()
+	c.forgetAbsent(key)
+	ev := c.add(key, value)
+	c.mu.Unlock()
+	notifyEvicted(c.onEvictEntry, ev)
+	return value, false
+}
+
+// UpdateIfPresent holds mu exclusively, like GetOrAdd. Replacing a cached entry
+// never evicts another one.
+func (c *arcCache[K, V]) UpdateIfPresent(key K, update func(old V) V) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old, ok := c.arc.Peek(key)
+	if !ok {
+		return false
+	}
+	c.arc.Add(key, update(old))
+	return true
+}
+
+// add adds an entry, reporting evictions. It returns the evicted entry if
+// onEvictEntry is set. Caller must hold mu.
+func (c *arcCache[K, V]) add(key K, value V) []cacheEntry[K, V] {
+	ev, evicted := c.arc.Add(key, value)
+	if !evicted {
+		return nil
+	}
+	if c.onEvict != nil {
+		c.onEvict()
+	}
+	if c.onEvictEntry == nil {
+		return nil
+	}
+	return []cacheEntry[K, V]{ev}
+}
+
+func (c *arcCache[K, V]) Remove(key K) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.forgetAbsent(key)
+	c.arc.Remove(key)
+}
+
+func (c *arcCache[K, V]) Contains(key K) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.arc.Contains(key)
+}
+
+func (c *arcCache[K, V]) Peek(key K) (value V, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.arc.Peek(key)
+}
+
+func (c *arcCache[K, V]) Keys() []K {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.arc.Keys()
+}
+
+func (c *arcCache[K, V]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.arc.Len()
+}
+
+// RangeKeys delegates to Keys, as arc doesn't support iteration.
+func (c *arcCache[K, V]) RangeKeys(fn func(K) bool) {
+	rangeSlice(c.Keys(), fn)
+}
+
+// Range copies the entries under the read lock, as arc doesn't support
+// iteration either.
+func (c *arcCache[K, V]) Range(fn func(K, V) bool) {
+	c.mu.RLock()
+	entries := c.arc.entries()
+	c.mu.RUnlock()
+	for _, e := range entries {
+		if !fn(e.key, e.value) {
+			return
+		}
+	}
+}
+
+func (c *arcCache[K, V]) RemoveAll(keys []K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range keys {
+		c.arc.Remove(k)
+	}
+	if c.absent != nil {
+		c.absent.RemoveAll(keys)
+	}
+}
+
+// Lookup checks the negative entries on a miss. A negative entry can't exist
+// for a cached key, as adding the key removes it.
+func (c *arcCache[K, V]) Lookup(key K) (value V, res lookupResult) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if value, ok := c.arc.Get(key); ok {
+		return value, lookupHit
+	}
+	if c.absent != nil && c.absent.Contains(key) {
+		return value, lookupAbsent
+	}
+	return value, lookupMiss
+}
+
+// AddAbsent holds mu exclusively, so that it doesn't race with adding key.
+func (c *arcCache[K, V]) AddAbsent(key K) {
+	if c.absent == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.arc.Contains(key) {
+		c.absent.Add(key, struct{}{})
+	}
+}
+
+// forgetAbsent removes the negative entry of key. Caller must hold mu.
+func (c *arcCache[K, V]) forgetAbsent(key K) {
+	if c.absent != nil {
+		c.absent.Remove(key)
+	}
+}
+
+// Resize rebuilds the cache with the new size, see arc.Resize.
+func (c *arcCache[K, V]) Resize(size int) (n int) {
+	c.mu.Lock()
+	ev, err := c.arc.Resize(size)
+	if err != nil {
+		c.mu.Unlock()
+		return 0
+	}
+	n = len(ev)
+	if c.onEvictEntry == nil {
+		ev = nil
+	}
+	if c.absent != nil {
+		c.absent.Resize(size)
+	}
+	if c.onEvict != nil {
+		for i := 0; i < n; i++ {
+			c.onEvict()
+		}
+	}
+	c.mu.Unlock()
+	notifyEvicted(c.onEvictEntry, ev)
+	return n
+}
+
+// Clear purges the cache. It copies the entries first if onEvictEntry is set,
+// to pass them to it.
+func (c *arcCache[K, V]) Clear() {
+	c.mu.Lock()
+	var ev []cacheEntry[K, V]
+	if c.onEvictEntry != nil {
+		ev = c.arc.entries()
+	}
+	n := c.arc.Len()
+	c.arc.Purge()
+	if c.absent != nil {
+		c.absent.Clear()
+	}
+	if c.onEvict != nil {
+		for i := 0; i < n; i++ {
+			c.onEvict()
+		}
+	}
+	c.mu.Unlock()
+	notifyEvicted(c.onEvictEntry, ev)
+}
+
+func rangeSlice[K any](keys []K, fn func(K) bool) {
+	for _, k := range keys {
+		if !fn(k) {
+			return
+		}
+	}
 }
 
 // noopCache is a dummy implementation that's used when the cache is disabled.
@@ -21,9 +353,21 @@ func (*noopCache[K, V]) Get(_ K) (value V, ok bool) {
 	return *new(V), false
 }
 
+func (*noopCache[K, V]) GetWithTTL(_ K) (value V, remaining time.Duration, ok bool) {
+	return value, 0, false
+}
+
 func (*noopCache[K, V]) Add(_ K, _ V) {
 }
 
+func (*noopCache[K, V]) GetOrAdd(_ K, construct func() V) (value V, loaded bool) {
+	return construct(), false
+}
+
+func (*noopCache[K, V]) UpdateIfPresent(_ K, _ func(V) V) bool {
+	return false
+}
+
 func (*noopCache[K, V]) Remove(_ K) {
 }
 
@@ -38,3 +382,103 @@ func (*noopCache[K, V]) Peek(_ K) (value V, ok bool) {
 func (*noopCache[K, V]) Keys() (keys []K) {
 	return keys
 }
+
+func (*noopCache[K, V]) Len() int {
+	return 0
+}
+
+func (c *noopCache[K, V]) RangeKeys(fn func(K) bool) {
+	rangeSlice(c.Keys(), fn)
+}
+
+func (*noopCache[K, V]) Range(_ func(K, V) bool) {
+}
+
+func (*noopCache[K, V]) RemoveAll(_ []K) {
+}
+
+func (*noopCache[K, V]) Resize(_ int) (evicted int) {
+	return 0
+}
+
+func (*noopCache[K, V]) Clear() {
+}
+
+func (*noopCache[K, V]) Lookup(_ K) (value V, res lookupResult) {
+	return value, lookupMiss
+}
+
+func (*noopCache[K, V]) AddAbsent(_ K) {
+}
+
+// newCache builds the cache described by opts. It returns a noopCache if the
+// cache is disabled, wrapped to report to a CacheMetricsTracer if
+// opts.CacheMetricsTracer or opts.CacheMetricsRegisterer is set.
+// If opts.CacheNegativeTTL is set, the cache keeps negative entries for that
+// long, in addition to opts.CacheSize regular entries.
+// If onEvict is set, it is called with every entry evicted to make room for
+// another one, by Resize or by Clear, after the cache was unlocked. Entries that are
+// removed, replaced, or that expired aren't passed to it.
+func newCache[K comparable, V any](opts Options, clk clock, onEvict func(K, V)) (c cache[K, V], err error) {
+	tracer := opts.CacheMetricsTracer
+	if tracer == nil && opts.CacheMetricsRegisterer != nil {
+		tracer = NewCacheMetricsTracer(WithRegisterer(opts.CacheMetricsRegisterer))
+	}
+	var countEvict func()
+	if tracer != nil {
+		countEvict = tracer.Evict
+	}
+
+	if opts.CacheNegativeTTL < 0 {
+		return nil, fmt.Errorf("negative cache TTL provided: %s", opts.CacheNegativeTTL)
+	}
+
+	if opts.CacheShards < 0 {
+		return nil, fmt.Errorf("negative number of cache shards provided: %d", opts.CacheShards)
+	}
+	makeARC := func(size int) (*arcCache[K, V], error) {
+		ac, err := newARCCacheWithTTL[K, V](size, opts.CacheTTL, clk)
+		if err != nil {
+			return nil, err
+		}
+		ac.onEvict = countEvict
+		ac.onEvictEntry = onEvict
+		if opts.CacheNegativeTTL > 0 {
+			ac.absent = newLRUCache[K, struct{}](size, opts.CacheNegativeTTL, clk)
+		}
+		return ac, nil
+	}
+
+	switch {
+	case opts.CacheSize == 0:
+		c = new(noopCache[K, V])
+	case opts.CacheType == ARCCache || opts.CacheType == ShardedARCCache:
+		if opts.CacheType == ARCCache {
+			if c, err = makeARC(int(opts.CacheSize)); err != nil {
+				return nil, err
+			}
+			break
+		}
+		shards := opts.CacheShards
+		if shards == 0 {
+			shards = defaultCacheShards()
+		}
+		if c, err = newShardedCache(int(opts.CacheSize), shards, makeARC); err != nil {
+			return nil, err
+		}
+	case opts.CacheType == LRUCache:
+		if opts.CacheNegativeTTL > 0 {
+			return nil, fmt.Errorf("negative cache entries are only supported by the ARC cache")
+		}
+		lru := newLRUCache[K, V](int(opts.CacheSize), opts.CacheTTL, clk)
+		lru.onEvict = countEvict
+		lru.onEvictEntry = onEvict
+		c = lru
+	default:
+		return nil, fmt.Errorf("unknown cache type: %d", opts.CacheType)
+	}
+	if tracer != nil {
+		c = newStatsCache(c, tracer)
+	}
+	return c, nil
+}
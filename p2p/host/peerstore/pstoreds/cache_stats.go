@@ -0,0 +1,166 @@
+package pstoreds
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricNamespace = "libp2p_peerstore_ds"
+
+var (
+	cacheOpsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "cache_ops_total",
+			Help:      "Operations on the in-memory peerstore cache",
+		},
+		[]string{"op"},
+	)
+
+	collectors = []prometheus.Collector{
+		cacheOpsTotal,
+	}
+)
+
+const (
+	cacheOpGetHit  = "get_hit"
+	cacheOpGetMiss = "get_miss"
+	cacheOpAdd     = "add"
+	cacheOpRemove  = "remove"
+	cacheOpEvict   = "evict"
+)
+
+// CacheMetricsTracer is notified of operations on the in-memory peerstore cache.
+// Peek and Contains aren't traced.
+type CacheMetricsTracer interface {
+	// Add is called when an entry is added or updated.
+	Add()
+	// Get is called on lookups. hit reports whether the entry was found.
+	Get(hit bool)
+	// Remove is called for every entry that is removed explicitly.
+	Remove()
+	// Evict is called for every entry that is evicted to make room for
+	// another one, or because the cache was resized or cleared.
+	Evict()
+}
+
+type cacheMetricsTracer struct{}
+
+var _ CacheMetricsTracer = &cacheMetricsTracer{}
+
+type cacheMetricsTracerSetting struct {
+	reg prometheus.Registerer
+}
+
+type CacheMetricsTracerOption func(*cacheMetricsTracerSetting)
+
+func WithRegisterer(reg prometheus.Registerer) CacheMetricsTracerOption {
+	return func(s *cacheMetricsTracerSetting) {
+		if reg != nil {
+			s.reg = reg
+		}
+	}
+}
+
+// NewCacheMetricsTracer returns a CacheMetricsTracer counting cache operations
+// with Prometheus.
+func NewCacheMetricsTracer(opts ...CacheMetricsTracerOption) CacheMetricsTracer {
+	setting := &cacheMetricsTracerSetting{reg: prometheus.DefaultRegisterer}
+	for _, opt := range opts {
+		opt(setting)
+	}
+	metricshelper.RegisterCollectors(setting.reg, collectors...)
+	return &cacheMetricsTracer{}
+}
+
+func (*cacheMetricsTracer) Add() {
+	cacheOpsTotal.WithLabelValues(cacheOpAdd).Inc()
+}
+
+func (*cacheMetricsTracer) Get(hit bool) {
+	if hit {
+		cacheOpsTotal.WithLabelValues(cacheOpGetHit).Inc()
+	} else {
+		cacheOpsTotal.WithLabelValues(cacheOpGetMiss).Inc()
+	}
+}
+
+func (*cacheMetricsTracer) Remove() {
+	cacheOpsTotal.WithLabelValues(cacheOpRemove).Inc()
+}
+
+func (*cacheMetricsTracer) Evict() {
+	cacheOpsTotal.WithLabelValues(cacheOpEvict).Inc()
+}
+
+// statsCache wraps a cache and reports Gets, Adds and Removes to a
+// CacheMetricsTracer. Evictions are reported by the wrapped cache.
+type statsCache[K comparable, V any] struct {
+	cache[K, V]
+	tracer CacheMetricsTracer
+}
+
+var _ cache[int, int] = (*statsCache[int, int])(nil)
+
+func newStatsCache[K comparable, V any](c cache[K, V], tracer CacheMetricsTracer) *statsCache[K, V] {
+	return &statsCache[K, V]{cache: c, tracer: tracer}
+}
+
+func (c *statsCache[K, V]) Get(key K) (value V, ok bool) {
+	value, ok = c.cache.Get(key)
+	c.tracer.Get(ok)
+	return value, ok
+}
+
+func (c *statsCache[K, V]) GetWithTTL(key K) (value V, remaining time.Duration, ok bool) {
+	value, remaining, ok = c.cache.GetWithTTL(key)
+	c.tracer.Get(ok)
+	return value, remaining, ok
+}
+
+// Lookup counts negative entries as hits, as they spare a datastore lookup.
+func (c *statsCache[K, V]) Lookup(key K) (value V, res lookupResult) {
+	value, res = c.cache.Lookup(key)
+	c.tracer.Get(res != lookupMiss)
+	return value, res
+}
+
+func (c *statsCache[K, V]) Add(key K, value V) {
+	c.tracer.Add()
+	c.cache.Add(key, value)
+}
+
+// GetOrAdd counts as a Get, and as an Add on a miss.
+func (c *statsCache[K, V]) GetOrAdd(key K, construct func() V) (value V, loaded bool) {
+	value, loaded = c.cache.GetOrAdd(key, construct)
+	c.tracer.Get(loaded)
+	if !loaded {
+		c.tracer.Add()
+	}
+	return value, loaded
+}
+
+// UpdateIfPresent counts as a Get, and as an Add on a hit.
+func (c *statsCache[K, V]) UpdateIfPresent(key K, update func(old V) V) bool {
+	ok := c.cache.UpdateIfPresent(key, update)
+	c.tracer.Get(ok)
+	if ok {
+		c.tracer.Add()
+	}
+	return ok
+}
+
+func (c *statsCache[K, V]) Remove(key K) {
+	c.tracer.Remove()
+	c.cache.Remove(key)
+}
+
+func (c *statsCache[K, V]) RemoveAll(keys []K) {
+	for range keys {
+		c.tracer.Remove()
+	}
+	c.cache.RemoveAll(keys)
+}
@@ -0,0 +1,824 @@
+package pstoreds
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mockclock "github.com/benbjohnson/clock"
+	lruarc "github.com/hashicorp/golang-lru/arc/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUCacheEvictionOrder(t *testing.T) {
+	c := newLRUCache[int, string](3, 0, nil)
+	c.Add(1, "one")
+	c.Add(2, "two")
+	c.Add(3, "three")
+	require.Equal(t, []int{1, 2, 3}, c.Keys())
+
+	// Using 1 makes 2 the least recently used entry.
+	v, ok := c.Get(1)
+	require.True(t, ok)
+	require.Equal(t, "one", v)
+
+	c.Add(4, "four")
+	require.False(t, c.Contains(2))
+	require.Equal(t, []int{3, 1, 4}, c.Keys())
+
+	// Re-adding an existing key updates it in place.
+	c.Add(3, "drei")
+	c.Add(5, "five")
+	require.False(t, c.Contains(1))
+	v, ok = c.Peek(3)
+	require.True(t, ok)
+	require.Equal(t, "drei", v)
+
+	c.Remove(3)
+	require.Equal(t, []int{4, 5}, c.Keys())
+}
+
+func TestLRUCacheContainsAndPeekDontPromote(t *testing.T) {
+	c := newLRUCache[int, int](2, 0, nil)
+	c.Add(1, 1)
+	c.Add(2, 2)
+
+	require.True(t, c.Contains(1))
+	_, ok := c.Peek(1)
+	require.True(t, ok)
+
+	c.Add(3, 3)
+	require.False(t, c.Contains(1), "Contains and Peek must not promote entries")
+	require.True(t, c.Contains(2))
+}
+
+func TestLRUCacheTTL(t *testing.T) {
+	clk := mockclock.NewMock()
+	c := newLRUCache[int, int](10, time.Minute, clk)
+	c.Add(1, 1)
+	clk.Add(30 * time.Second)
+	c.Add(2, 2)
+
+	_, ok := c.Get(1)
+	require.True(t, ok)
+
+	clk.Add(30 * time.Second)
+	_, ok = c.Get(1)
+	require.False(t, ok)
+	_, ok = c.Peek(2)
+	require.True(t, ok)
+	require.Equal(t, []int{2}, c.Keys())
+
+	// Adding again refreshes the expiry.
+	c.Add(2, 2)
+	clk.Add(45 * time.Second)
+	require.True(t, c.Contains(2))
+	clk.Add(15 * time.Second)
+	require.False(t, c.Contains(2))
+	require.Empty(t, c.Keys())
+}
+
+func TestARCCacheTTL(t *testing.T) {
+	clk := mockclock.NewMock()
+	c, err := newARCCacheWithTTL[int, int](10, time.Minute, clk)
+	require.NoError(t, err)
+	c.Add(1, 1)
+	clk.Add(30 * time.Second)
+	c.Add(2, 2)
+
+	_, ok := c.Get(1)
+	require.True(t, ok)
+
+	clk.Add(30 * time.Second)
+	_, ok = c.Get(1)
+	require.False(t, ok)
+	_, ok = c.Peek(2)
+	require.True(t, ok)
+	require.Equal(t, []int{2}, c.Keys())
+
+	// Adding again refreshes the expiry, and resizing keeps it.
+	c.Add(2, 2)
+	clk.Add(45 * time.Second)
+	require.Zero(t, c.Resize(5))
+	require.True(t, c.Contains(2))
+	clk.Add(15 * time.Second)
+	require.False(t, c.Contains(2))
+	require.Empty(t, c.Keys())
+}
+
+func TestCacheGetWithTTL(t *testing.T) {
+	clk := mockclock.NewMock()
+	arcTTL, err := newARCCacheWithTTL[int, int](10, time.Minute, clk)
+	require.NoError(t, err)
+	for name, c := range map[string]cache[int, int]{"ARC": arcTTL, "LRU": newLRUCache[int, int](10, time.Minute, clk)} {
+		t.Run(name+" with expiry", func(t *testing.T) {
+			c.Add(1, 1)
+			v, remaining, ok := c.GetWithTTL(1)
+			require.True(t, ok)
+			require.Equal(t, 1, v)
+			require.Equal(t, time.Minute, remaining)
+
+			clk.Add(40 * time.Second)
+			_, remaining, ok = c.GetWithTTL(1)
+			require.True(t, ok)
+			require.Equal(t, 20*time.Second, remaining)
+
+			clk.Add(20 * time.Second)
+			_, _, ok = c.GetWithTTL(1)
+			require.False(t, ok)
+			_, ok = c.Get(1)
+			require.False(t, ok)
+		})
+	}
+
+	// Entries of caches without expiry have no remaining lifetime.
+	arc, err := newARCCache[int, int](10)
+	require.NoError(t, err)
+	for name, c := range map[string]cache[int, int]{"ARC": arc, "LRU": newLRUCache[int, int](10, 0, clk)} {
+		t.Run(name, func(t *testing.T) {
+			c.Add(1, 1)
+			v, remaining, ok := c.GetWithTTL(1)
+			require.True(t, ok)
+			require.Equal(t, 1, v)
+			require.Zero(t, remaining)
+			_, _, ok = c.GetWithTTL(2)
+			require.False(t, ok)
+		})
+	}
+
+	var noop noopCache[int, int]
+	noop.Add(1, 1)
+	_, _, ok := noop.GetWithTTL(1)
+	require.False(t, ok)
+}
+
+func TestNewCache(t *testing.T) {
+	opts := DefaultOpts()
+	c, err := newCache[int, int](opts, nil, nil)
+	require.NoError(t, err)
+	require.NotNil(t, c)
+
+	opts.CacheTTL = time.Minute
+	c, err = newCache[int, int](opts, nil, nil)
+	require.NoError(t, err)
+	require.IsType(t, &arcCache[int, int]{}, c)
+
+	opts.CacheTTL = 0
+	opts.CacheNegativeTTL = time.Minute
+	c, err = newCache[int, int](opts, nil, nil)
+	require.NoError(t, err)
+	require.IsType(t, &arcCache[int, int]{}, c)
+
+	opts.CacheTTL = time.Minute
+	opts.CacheType = LRUCache
+	_, err = newCache[int, int](opts, nil, nil)
+	require.Error(t, err)
+	opts.CacheNegativeTTL = 0
+	c, err = newCache[int, int](opts, nil, nil)
+	require.NoError(t, err)
+	require.IsType(t, &lruCache[int, int]{}, c)
+
+	opts.CacheSize = 0
+	c, err = newCache[int, int](opts, nil, nil)
+	require.NoError(t, err)
+	require.IsType(t, &noopCache[int, int]{}, c)
+}
+
+func getCacheOpsValue(t *testing.T, op string) int {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := cacheOpsTotal.WithLabelValues(op).Write(m); err != nil {
+		t.Errorf("failed to extract counter value %s", err)
+		return 0
+	}
+	return int(*m.Counter.Value)
+}
+
+func TestStatsCache(t *testing.T) {
+	cacheOpsTotal.Reset()
+	opts := DefaultOpts()
+	opts.CacheSize = 2
+	opts.CacheType = LRUCache
+	opts.CacheMetricsRegisterer = prometheus.NewRegistry()
+	c, err := newCache[int, int](opts, nil, nil)
+	require.NoError(t, err)
+	require.IsType(t, &statsCache[int, int]{}, c)
+
+	c.Add(1, 1)
+	c.Add(2, 2)
+	c.Get(1)
+	c.Get(3)
+	c.Add(3, 3) // evicts 2
+	c.Get(2)
+	c.Remove(1)
+	c.Get(1)
+	// Peek and Contains aren't counted.
+	c.Peek(3)
+	c.Contains(3)
+
+	require.Equal(t, 1, getCacheOpsValue(t, cacheOpGetHit))
+	require.Equal(t, 3, getCacheOpsValue(t, cacheOpGetMiss))
+	require.Equal(t, 3, getCacheOpsValue(t, cacheOpAdd))
+	require.Equal(t, 1, getCacheOpsValue(t, cacheOpRemove))
+	require.Equal(t, 1, getCacheOpsValue(t, cacheOpEvict))
+}
+
+type fakeCacheTracer struct {
+	adds, hits, misses, removes, evictions atomic.Int32
+}
+
+func (t *fakeCacheTracer) Add() { t.adds.Add(1) }
+func (t *fakeCacheTracer) Get(hit bool) {
+	if hit {
+		t.hits.Add(1)
+	} else {
+		t.misses.Add(1)
+	}
+}
+func (t *fakeCacheTracer) Remove() { t.removes.Add(1) }
+func (t *fakeCacheTracer) Evict()  { t.evictions.Add(1) }
+
+func TestCacheMetricsTracer(t *testing.T) {
+	for name, cacheType := range map[string]CacheType{"ARC": ARCCache, "LRU": LRUCache} {
+		t.Run(name, func(t *testing.T) {
+			tracer := &fakeCacheTracer{}
+			opts := DefaultOpts()
+			opts.CacheSize = 2
+			opts.CacheType = cacheType
+			opts.CacheMetricsTracer = tracer
+			c, err := newCache[int, int](opts, nil, nil)
+			require.NoError(t, err)
+
+			c.Add(1, 1)
+			c.Add(2, 2)
+			c.Add(2, 2) // updating an entry doesn't evict
+			require.Zero(t, tracer.evictions.Load())
+			c.Add(3, 3) // evicts an entry
+			require.EqualValues(t, 1, tracer.evictions.Load())
+			require.Len(t, c.Keys(), 2)
+
+			c.Get(3)
+			c.Get(4)
+			require.Equal(t, 1, c.Resize(1))
+			c.Remove(c.Keys()[0])
+
+			require.EqualValues(t, 4, tracer.adds.Load())
+			require.EqualValues(t, 1, tracer.hits.Load())
+			require.EqualValues(t, 1, tracer.misses.Load())
+			require.EqualValues(t, 1, tracer.removes.Load())
+			require.EqualValues(t, 2, tracer.evictions.Load())
+		})
+	}
+}
+
+func TestRangeKeysEarlyTermination(t *testing.T) {
+	arc, err := newARCCache[int, int](10)
+	require.NoError(t, err)
+	caches := map[string]cache[int, int]{
+		"ARC":   arc,
+		"LRU":   newLRUCache[int, int](10, 0, nil),
+		"stats": newStatsCache[int, int](newLRUCache[int, int](10, 0, nil), NewCacheMetricsTracer(WithRegisterer(prometheus.NewRegistry()))),
+	}
+	for name, c := range caches {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 5; i++ {
+				c.Add(i, i)
+			}
+			var all []int
+			c.RangeKeys(func(k int) bool {
+				all = append(all, k)
+				return true
+			})
+			require.ElementsMatch(t, c.Keys(), all)
+
+			var seen []int
+			c.RangeKeys(func(k int) bool {
+				seen = append(seen, k)
+				return len(seen) < 2
+			})
+			require.Len(t, seen, 2)
+		})
+	}
+
+	var calls int
+	new(noopCache[int, int]).RangeKeys(func(int) bool {
+		calls++
+		return true
+	})
+	require.Zero(t, calls)
+}
+
+func TestRemoveAllConcurrentAdd(t *testing.T) {
+	arc, err := newARCCache[int, int](100)
+	require.NoError(t, err)
+	caches := map[string]cache[int, int]{
+		"ARC": arc,
+		"LRU": newLRUCache[int, int](100, 0, nil),
+	}
+	keys := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	for name, c := range caches {
+		t.Run(name, func(t *testing.T) {
+			done := make(chan struct{})
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; ; j++ {
+						select {
+						case <-done:
+							return
+						default:
+						}
+						c.Add(keys[j%len(keys)], j)
+					}
+				}()
+			}
+			for i := 0; i < 1000; i++ {
+				c.RemoveAll(keys)
+			}
+			close(done)
+			wg.Wait()
+
+			c.Add(42, 42)
+			c.RemoveAll(keys)
+			for _, k := range keys {
+				require.False(t, c.Contains(k))
+			}
+			require.Equal(t, []int{42}, c.Keys())
+		})
+	}
+}
+
+func TestCacheResize(t *testing.T) {
+	arc, err := newARCCache[int, int](10)
+	require.NoError(t, err)
+	caches := map[string]cache[int, int]{
+		"ARC":   arc,
+		"LRU":   newLRUCache[int, int](10, 0, nil),
+		"stats": newStatsCache[int, int](newLRUCache[int, int](10, 0, nil), NewCacheMetricsTracer(WithRegisterer(prometheus.NewRegistry()))),
+	}
+	for name, c := range caches {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 10; i++ {
+				c.Add(i, i)
+			}
+			require.Equal(t, 6, c.Resize(4))
+			keys := c.Keys()
+			require.Len(t, keys, 4)
+			// The most recently added entries are kept.
+			require.ElementsMatch(t, []int{6, 7, 8, 9}, keys)
+
+			// The new size is enforced for subsequent additions.
+			c.Add(10, 10)
+			require.Len(t, c.Keys(), 4)
+			require.True(t, c.Contains(10))
+
+			// Growing doesn't evict anything.
+			require.Zero(t, c.Resize(8))
+			for i := 11; i < 15; i++ {
+				c.Add(i, i)
+			}
+			require.Len(t, c.Keys(), 8)
+		})
+	}
+
+	require.Zero(t, new(noopCache[int, int]).Resize(4))
+}
+
+func TestGetOrAddConcurrent(t *testing.T) {
+	arc, err := newARCCache[int, int](10)
+	require.NoError(t, err)
+	caches := map[string]cache[int, int]{
+		"ARC":   arc,
+		"LRU":   newLRUCache[int, int](10, 0, nil),
+		"stats": newStatsCache[int, int](newLRUCache[int, int](10, 0, nil), new(fakeCacheTracer)),
+	}
+	for name, c := range caches {
+		t.Run(name, func(t *testing.T) {
+			var constructed atomic.Int32
+			construct := func() int {
+				constructed.Add(1)
+				return 42
+			}
+			var wg sync.WaitGroup
+			values := make([]int, 50)
+			loaded := make([]bool, 50)
+			for i := range values {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					values[i], loaded[i] = c.GetOrAdd(1, construct)
+				}()
+			}
+			wg.Wait()
+			require.Equal(t, int32(1), constructed.Load())
+			var loadedCount int
+			for i, v := range values {
+				require.Equal(t, 42, v)
+				if loaded[i] {
+					loadedCount++
+				}
+			}
+			require.Equal(t, 49, loadedCount)
+			v, ok := c.Get(1)
+			require.True(t, ok)
+			require.Equal(t, 42, v)
+		})
+	}
+
+	t.Run("noop", func(t *testing.T) {
+		c := new(noopCache[int, int])
+		for i := 0; i < 2; i++ {
+			v, loaded := c.GetOrAdd(1, func() int { return i })
+			require.False(t, loaded)
+			require.Equal(t, i, v)
+		}
+	})
+}
+
+func TestCacheEvictionCallback(t *testing.T) {
+	for name, cacheType := range map[string]CacheType{"ARC": ARCCache, "LRU": LRUCache} {
+		t.Run(name, func(t *testing.T) {
+			opts := DefaultOpts()
+			opts.CacheSize = 2
+			opts.CacheType = cacheType
+			var c cache[int, string]
+			evicted := map[int]string{}
+			c, err := newCache(opts, nil, func(k int, v string) {
+				// The callback runs without holding the lock of the cache.
+				require.False(t, c.Contains(k))
+				evicted[k] = v
+			})
+			require.NoError(t, err)
+
+			c.Add(1, "one")
+			c.Add(2, "two")
+			c.Add(2, "zwei") // updating an entry doesn't evict
+			c.Remove(2)      // neither does removing one
+			c.Add(2, "two")
+			require.Empty(t, evicted)
+
+			c.Add(3, "three")
+			require.Equal(t, map[int]string{1: "one"}, evicted)
+
+			_, loaded := c.GetOrAdd(4, func() string { return "four" })
+			require.False(t, loaded)
+			require.Len(t, evicted, 2)
+			require.Equal(t, "two", evicted[2])
+
+			require.Equal(t, 1, c.Resize(1))
+			require.Equal(t, map[int]string{1: "one", 2: "two", 3: "three"}, evicted)
+			require.Equal(t, []int{4}, c.Keys())
+		})
+	}
+}
+
+func TestARCEvictions(t *testing.T) {
+	c, err := newARC[int, int](8, 0, nil)
+	require.NoError(t, err)
+	// The eviction policy matches the ARC of golang-lru.
+	ref, err := lruarc.NewARC[int, int](8)
+	require.NoError(t, err)
+
+	values := map[int]int{}
+	r := rand.New(rand.NewPCG(1, 2))
+	for i := 0; i < 10000; i++ {
+		k := r.IntN(24)
+		if r.IntN(3) == 0 {
+			v, ok := c.Get(k)
+			refV, refOK := ref.Get(k)
+			require.Equal(t, refOK, ok)
+			require.Equal(t, refV, v)
+			continue
+		}
+		present := c.Contains(k)
+		ev, evicted := c.Add(k, i)
+		ref.Add(k, i)
+		require.Equal(t, ref.Keys(), c.Keys())
+		if present {
+			require.False(t, evicted)
+		} else if evicted {
+			require.False(t, c.Contains(ev.key))
+			require.Equal(t, values[ev.key], ev.value)
+			require.Equal(t, 8, c.Len())
+		}
+		values[k] = i
+	}
+}
+
+func TestCacheNegativeEntries(t *testing.T) {
+	clk := mockclock.NewMock()
+	opts := DefaultOpts()
+	opts.CacheSize = 10
+	opts.CacheNegativeTTL = time.Minute
+	c, err := newCache[int, int](opts, clk, nil)
+	require.NoError(t, err)
+
+	_, res := c.Lookup(1)
+	require.Equal(t, lookupMiss, res)
+	c.AddAbsent(1)
+	_, res = c.Lookup(1)
+	require.Equal(t, lookupAbsent, res)
+	_, ok := c.Get(1)
+	require.False(t, ok)
+
+	// Negative entries expire.
+	clk.Add(time.Minute)
+	_, res = c.Lookup(1)
+	require.Equal(t, lookupMiss, res)
+
+	// Adding or removing a key clears its negative entry.
+	c.AddAbsent(1)
+	c.Add(1, 100)
+	v, res := c.Lookup(1)
+	require.Equal(t, lookupHit, res)
+	require.Equal(t, 100, v)
+	c.AddAbsent(1)
+	_, res = c.Lookup(1)
+	require.Equal(t, lookupHit, res, "cached keys can't be marked absent")
+	c.Remove(1)
+	_, res = c.Lookup(1)
+	require.Equal(t, lookupMiss, res)
+}
+
+func TestCacheLen(t *testing.T) {
+	arc, err := newARCCache[int, int](4)
+	require.NoError(t, err)
+	caches := map[string]cache[int, int]{
+		"ARC":   arc,
+		"LRU":   newLRUCache[int, int](4, 0, nil),
+		"stats": newStatsCache[int, int](newLRUCache[int, int](4, 0, nil), NewCacheMetricsTracer(WithRegisterer(prometheus.NewRegistry()))),
+	}
+	for name, c := range caches {
+		t.Run(name, func(t *testing.T) {
+			require.Zero(t, c.Len())
+			c.Add(1, 1)
+			c.Add(2, 2)
+			require.Equal(t, 2, c.Len())
+			// Updating an entry doesn't change the length.
+			c.Add(2, 3)
+			require.Equal(t, 2, c.Len())
+			c.Remove(1)
+			require.Equal(t, 1, c.Len())
+			// Additions to a full cache evict entries.
+			for i := 10; i < 20; i++ {
+				c.Add(i, i)
+			}
+			require.Equal(t, 4, c.Len())
+			c.Resize(2)
+			require.Equal(t, 2, c.Len())
+		})
+	}
+
+	var noop noopCache[int, int]
+	noop.Add(1, 1)
+	require.Zero(t, noop.Len())
+}
+
+func TestCacheUpdateIfPresent(t *testing.T) {
+	arc, err := newARCCache[int, int](4)
+	require.NoError(t, err)
+	sharded, err := newShardedCache(4, 2, newARCCache[int, int])
+	require.NoError(t, err)
+	caches := map[string]cache[int, int]{
+		"ARC":     arc,
+		"LRU":     newLRUCache[int, int](4, 0, nil),
+		"sharded": sharded,
+		"stats":   newStatsCache[int, int](newLRUCache[int, int](4, 0, nil), NewCacheMetricsTracer(WithRegisterer(prometheus.NewRegistry()))),
+	}
+	for name, c := range caches {
+		t.Run(name, func(t *testing.T) {
+			require.False(t, c.UpdateIfPresent(1, func(int) int {
+				t.Fatal("update called for an absent key")
+				return 0
+			}))
+			require.False(t, c.Contains(1), "absent key was added")
+
+			c.Add(1, 10)
+			require.True(t, c.UpdateIfPresent(1, func(old int) int {
+				require.Equal(t, 10, old)
+				return old + 1
+			}))
+			v, ok := c.Get(1)
+			require.True(t, ok)
+			require.Equal(t, 11, v)
+			require.Equal(t, 1, c.Len())
+		})
+	}
+
+	var noop noopCache[int, int]
+	noop.Add(1, 1)
+	require.False(t, noop.UpdateIfPresent(1, func(old int) int { return old }))
+}
+
+func TestCacheClear(t *testing.T) {
+	for name, cacheType := range map[string]CacheType{"ARC": ARCCache, "LRU": LRUCache, "sharded": ShardedARCCache} {
+		t.Run(name, func(t *testing.T) {
+			opts := DefaultOpts()
+			opts.CacheSize = 8
+			opts.CacheType = cacheType
+			opts.CacheMetricsRegisterer = prometheus.NewRegistry()
+			evicted := map[int]int{}
+			c, err := newCache(opts, nil, func(k, v int) { evicted[k] = v })
+			require.NoError(t, err)
+
+			for i := 0; i < 4; i++ {
+				c.Add(i, i*10)
+			}
+			c.Clear()
+			require.Zero(t, c.Len())
+			require.Empty(t, c.Keys())
+			require.Equal(t, map[int]int{0: 0, 1: 10, 2: 20, 3: 30}, evicted)
+
+			// The cache is still usable.
+			c.Add(1, 1)
+			require.Equal(t, []int{1}, c.Keys())
+		})
+	}
+
+	opts := DefaultOpts()
+	opts.CacheSize = 8
+	opts.CacheNegativeTTL = time.Minute
+	c, err := newCache[int, int](opts, nil, nil)
+	require.NoError(t, err)
+	c.AddAbsent(1)
+	c.Clear()
+	_, res := c.Lookup(1)
+	require.Equal(t, lookupMiss, res, "negative entries weren't cleared")
+
+	var noop noopCache[int, int]
+	noop.Add(1, 1)
+	noop.Clear()
+	require.Zero(t, noop.Len())
+}
+
+func TestCacheRange(t *testing.T) {
+	arc, err := newARCCache[int, int](4)
+	require.NoError(t, err)
+	caches := map[string]cache[int, int]{
+		"ARC":   arc,
+		"LRU":   newLRUCache[int, int](4, 0, nil),
+		"stats": newStatsCache[int, int](newLRUCache[int, int](4, 0, nil), NewCacheMetricsTracer(WithRegisterer(prometheus.NewRegistry()))),
+	}
+	for name, c := range caches {
+		t.Run(name, func(t *testing.T) {
+			for i := 0; i < 6; i++ {
+				c.Add(i, i*10)
+			}
+			c.Remove(3)
+			visited := map[int]int{}
+			c.Range(func(k, v int) bool {
+				visited[k] = v
+				// fn may call into the cache.
+				c.Contains(k)
+				return true
+			})
+			require.Equal(t, map[int]int{2: 20, 4: 40, 5: 50}, visited)
+
+			var n int
+			c.Range(func(_, _ int) bool {
+				n++
+				return n < 2
+			})
+			require.Equal(t, 2, n)
+		})
+	}
+
+	var noop noopCache[int, int]
+	noop.Add(1, 1)
+	noop.Range(func(_, _ int) bool {
+		t.Fatal("noop cache has entries")
+		return true
+	})
+}
+
+func TestShardedCache(t *testing.T) {
+	opts := DefaultOpts()
+	opts.CacheType = ShardedARCCache
+	opts.CacheSize = 64
+	opts.CacheShards = 4
+	c, err := newCache[int, int](opts, nil, nil)
+	require.NoError(t, err)
+	require.IsType(t, &shardedCache[int, int]{}, c)
+	require.Len(t, c.(*shardedCache[int, int]).shards, 4)
+
+	// As long as nothing is evicted, the sharded cache behaves like a single ARC cache.
+	arc, err := newARCCache[int, int](64)
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		k := rand.IntN(32)
+		switch rand.IntN(6) {
+		case 0:
+			arc.Add(k, i)
+			c.Add(k, i)
+		case 1:
+			arc.Remove(k)
+			c.Remove(k)
+		case 2:
+			v1, ok1 := arc.GetOrAdd(k, func() int { return i })
+			v2, ok2 := c.GetOrAdd(k, func() int { return i })
+			require.Equal(t, v1, v2)
+			require.Equal(t, ok1, ok2)
+		case 3:
+			keys := []int{k, rand.IntN(32)}
+			arc.RemoveAll(keys)
+			c.RemoveAll(keys)
+		default:
+			v1, ok1 := arc.Get(k)
+			v2, ok2 := c.Get(k)
+			require.Equal(t, v1, v2)
+			require.Equal(t, ok1, ok2)
+		}
+		require.Equal(t, arc.Len(), c.Len())
+	}
+	require.ElementsMatch(t, arc.Keys(), c.Keys())
+
+	// The capacity is spread across the shards.
+	for i := 0; i < 1000; i++ {
+		c.Add(i, i)
+	}
+	require.Equal(t, 64, c.Len())
+	require.Equal(t, 32, c.Resize(32))
+	require.Equal(t, 32, c.Len())
+
+	// A single shard behaves like an ARC cache, including evictions.
+	opts.CacheSize = 8
+	opts.CacheShards = 1
+	c, err = newCache[int, int](opts, nil, nil)
+	require.NoError(t, err)
+	arc, err = newARCCache[int, int](8)
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		k := rand.IntN(32)
+		if rand.IntN(2) == 0 {
+			arc.Add(k, i)
+			c.Add(k, i)
+			continue
+		}
+		v1, ok1 := arc.Get(k)
+		v2, ok2 := c.Get(k)
+		require.Equal(t, v1, v2)
+		require.Equal(t, ok1, ok2)
+	}
+	require.Equal(t, arc.Keys(), c.Keys())
+
+	// There are no more shards than entries.
+	opts.CacheSize = 2
+	opts.CacheShards = 8
+	c, err = newCache[int, int](opts, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, c.(*shardedCache[int, int]).shards, 2)
+
+	opts.CacheShards = -1
+	_, err = newCache[int, int](opts, nil, nil)
+	require.ErrorContains(t, err, "negative number of cache shards")
+}
+
+func TestDefaultCacheShards(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	for procs, shards := range map[int]int{1: 1, 2: 2, 3: 4, 8: 8, 9: 16} {
+		runtime.GOMAXPROCS(procs)
+		require.Equal(t, shards, defaultCacheShards())
+	}
+}
+
+func BenchmarkCacheContention(b *testing.B) {
+	for _, cacheType := range []CacheType{ARCCache, ShardedARCCache} {
+		name := "ARC"
+		if cacheType == ShardedARCCache {
+			name = "ShardedARC"
+		}
+		b.Run(name, func(b *testing.B) {
+			opts := DefaultOpts()
+			opts.CacheType = cacheType
+			// Track evictions like the address book, so that additions lock exclusively.
+			c, err := newCache(opts, nil, func(string, int) {})
+			require.NoError(b, err)
+			// The keys fit into the cache, so that evictions don't skew the results.
+			keys := make([]string, opts.CacheSize/2)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("peer-%d", i)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := rand.IntN(len(keys))
+				for pb.Next() {
+					k := keys[i%len(keys)]
+					if i%4 == 0 {
+						c.Add(k, i)
+					} else {
+						c.Get(k)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
@@ -2,16 +2,25 @@ package pstoreds
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/peer"
 	pstore "github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/p2p/host/peerstore/pstoreds/pb"
 	pt "github.com/libp2p/go-libp2p/p2p/host/peerstore/test"
 
 	mockclock "github.com/benbjohnson/clock"
 	ds "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/sync"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 )
 
 func mapDBStore(_ testing.TB) (ds.Batching, func()) {
@@ -60,6 +69,28 @@ func TestDsAddrBook(t *testing.T) {
 			pt.TestAddrBook(t, addressBookFactory(t, dsFactory, opts), clk)
 		})
 
+		t.Run(name+" LRU", func(t *testing.T) {
+			opts := DefaultOpts()
+			opts.GCPurgeInterval = 1 * time.Second
+			opts.CacheSize = 1024
+			opts.CacheType = LRUCache
+			clk := mockclock.NewMock()
+			opts.Clock = clk
+
+			pt.TestAddrBook(t, addressBookFactory(t, dsFactory, opts), clk)
+		})
+
+		t.Run(name+" Custom keys", func(t *testing.T) {
+			opts := DefaultOpts()
+			opts.GCPurgeInterval = 1 * time.Second
+			opts.CacheSize = 1024
+			opts.AddrBookKeys = hexAddrBookKeys
+			clk := mockclock.NewMock()
+			opts.Clock = clk
+
+			pt.TestAddrBook(t, addressBookFactory(t, dsFactory, opts), clk)
+		})
+
 		t.Run(name+" Cacheless", func(t *testing.T) {
 			opts := DefaultOpts()
 			opts.GCPurgeInterval = 1 * time.Second
@@ -135,6 +166,157 @@ func addressBookFactory(tb testing.TB, storeFactory datastoreFactory, opts Optio
 	}
 }
 
+func TestAddrBookResizeCache(t *testing.T) {
+	store, closeStore := mapDBStore(t)
+	defer closeStore()
+	opts := DefaultOpts()
+	opts.CacheSize = 10
+	ab, err := NewAddrBook(context.Background(), store, opts)
+	require.NoError(t, err)
+	defer ab.Close()
+
+	peers := make([]peer.ID, 10)
+	for i := range peers {
+		peers[i] = test.RandPeerIDFatal(t)
+		ab.AddAddr(peers[i], ma.StringCast("/ip4/1.2.3.4/tcp/1"), time.Hour)
+	}
+	_, err = ab.ResizeCache(0)
+	require.Error(t, err)
+	evicted, err := ab.ResizeCache(3)
+	require.NoError(t, err)
+	require.Equal(t, 7, evicted)
+	require.Len(t, ab.cache.Keys(), 3)
+	// Evicted records are still served from the datastore.
+	for _, p := range peers {
+		require.Len(t, ab.Addrs(p), 1)
+	}
+}
+
+func TestAddrBookCacheWarming(t *testing.T) {
+	store, closeStore := mapDBStore(t)
+	defer closeStore()
+	opts := DefaultOpts()
+	opts.CacheSize = 10
+	opts.CacheType = LRUCache
+	opts.CacheSnapshotInterval = time.Hour
+	opts.CacheWarmBatchSize = 2
+	ab, err := NewAddrBook(context.Background(), store, opts)
+	require.NoError(t, err)
+
+	peers := make([]peer.ID, 5)
+	for i := range peers {
+		peers[i] = test.RandPeerIDFatal(t)
+		ab.AddAddr(peers[i], ma.StringCast("/ip4/1.2.3.4/tcp/1"), time.Hour)
+	}
+	// a peer whose record is gone by the time the cache is warmed.
+	gone := test.RandPeerIDFatal(t)
+	ab.AddAddr(gone, ma.StringCast("/ip4/1.2.3.4/tcp/2"), time.Hour)
+	// closing snapshots the cache.
+	require.NoError(t, ab.Close())
+	require.NoError(t, store.Delete(context.Background(), ab.keys.Encode(gone)))
+
+	// simulate a restart.
+	ab, err = NewAddrBook(context.Background(), store, opts)
+	require.NoError(t, err)
+	defer ab.Close()
+	require.Eventually(t, func() bool { return len(ab.cache.Keys()) == len(peers) }, 5*time.Second, 10*time.Millisecond)
+	// the most recently used records are still the most recently used.
+	require.Equal(t, peers, ab.cache.Keys())
+	for _, p := range peers {
+		pr, ok := ab.cache.Peek(p)
+		require.True(t, ok)
+		require.Len(t, pr.Addrs, 1)
+	}
+	require.False(t, ab.cache.Contains(gone))
+
+	t.Run("periodic snapshots", func(t *testing.T) {
+		store, closeStore := mapDBStore(t)
+		defer closeStore()
+		opts := opts
+		opts.CacheSnapshotInterval = 10 * time.Millisecond
+		ab, err := NewAddrBook(context.Background(), store, opts)
+		require.NoError(t, err)
+		defer ab.Close()
+
+		ab.AddAddr(peers[0], ma.StringCast("/ip4/1.2.3.4/tcp/1"), time.Hour)
+		require.Eventually(t, func() bool {
+			ids, err := ab.loadCacheSnapshot()
+			return err == nil && len(ids) == 1 && ids[0] == peers[0]
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		opts := opts
+		opts.CacheWarmBatchSize = -1
+		_, err := NewAddrBook(context.Background(), store, opts)
+		require.Error(t, err)
+	})
+}
+
+// hexAddrBookKeys names address records by the hex encoding of the peer ID.
+var hexAddrBookKeys = KeyCodec[peer.ID]{
+	Encode: func(p peer.ID) ds.Key {
+		return addrBookBase.ChildString(hex.EncodeToString([]byte(p)))
+	},
+	Decode: func(k ds.Key) (peer.ID, error) {
+		b, err := hex.DecodeString(k.Name())
+		if err != nil {
+			return "", err
+		}
+		return peer.IDFromBytes(b)
+	},
+}
+
+func TestAddrBookKeyCodec(t *testing.T) {
+	ctx := context.Background()
+	store, closeStore := mapDBStore(t)
+	defer closeStore()
+	clk := mockclock.NewMock()
+	opts := DefaultOpts()
+	opts.Clock = clk
+	// only run GC explicitly.
+	opts.GCInitialDelay = 90 * time.Hour
+	opts.GCLookaheadInterval = 10 * time.Second
+	opts.GCPurgeInterval = time.Second
+	opts.AddrBookKeys = hexAddrBookKeys
+	ab, err := NewAddrBook(ctx, store, opts)
+	require.NoError(t, err)
+	defer ab.Close()
+
+	p1, p2 := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	ab.AddAddr(p1, addr, time.Hour)
+	ab.AddAddr(p2, addr, time.Second)
+
+	has, err := store.Has(ctx, hexAddrBookKeys.Encode(p1))
+	require.NoError(t, err)
+	require.True(t, has)
+	has, err = store.Has(ctx, PeerIDKeyCodec(addrBookBase).Encode(p1))
+	require.NoError(t, err)
+	require.False(t, has)
+	require.ElementsMatch(t, peer.IDSlice{p1, p2}, ab.PeersWithAddrs())
+
+	// Records that aren't cached are loaded from the datastore.
+	ab.cache.Remove(p1)
+	require.Equal(t, []ma.Multiaddr{addr}, ab.Addrs(p1))
+
+	// The lookahead GC finds the records of uncached peers.
+	ab.cache.Remove(p2)
+	ab.gc.populateLookahead()
+	clk.Add(2 * time.Second)
+	ab.gc.purgeLookahead()
+	require.Equal(t, peer.IDSlice{p1}, ab.PeersWithAddrs())
+
+	ab.ClearAddrs(p1)
+	has, err = store.Has(ctx, hexAddrBookKeys.Encode(p1))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	opts.AddrBookKeys = KeyCodec[peer.ID]{Encode: hexAddrBookKeys.Encode}
+	_, err = NewAddrBook(ctx, store, opts)
+	require.Error(t, err)
+}
+
 func keyBookFactory(tb testing.TB, storeFactory datastoreFactory, opts Options) pt.KeyBookFactory {
 	return func() (pstore.KeyBook, func()) {
 		store, storeCloseFn := storeFactory(tb)
@@ -145,3 +327,171 @@ func keyBookFactory(tb testing.TB, storeFactory datastoreFactory, opts Options)
 		return kb, storeCloseFn
 	}
 }
+
+// failingStore is a datastore whose writes fail while failing is set.
+type failingStore struct {
+	ds.Batching
+	failing atomic.Bool
+}
+
+func (s *failingStore) Put(ctx context.Context, key ds.Key, value []byte) error {
+	if s.failing.Load() {
+		return errors.New("datastore unavailable")
+	}
+	return s.Batching.Put(ctx, key, value)
+}
+
+func TestPeerstoreFlush(t *testing.T) {
+	mapStore, closeStore := mapDBStore(t)
+	defer closeStore()
+	store := &failingStore{Batching: mapStore}
+	ps, err := NewPeerstore(context.Background(), store, DefaultOpts())
+	require.NoError(t, err)
+	defer ps.Close()
+
+	stored := func(p peer.ID) []ma.Multiaddr {
+		data, err := mapStore.Get(context.Background(), ps.dsAddrBook.keys.Encode(p))
+		if errors.Is(err, ds.ErrNotFound) {
+			return nil
+		}
+		require.NoError(t, err)
+		pr := &addrsRecord{AddrBookRecord: &pb.AddrBookRecord{}}
+		require.NoError(t, proto.Unmarshal(data, pr))
+		addrs := make([]ma.Multiaddr, 0, len(pr.Addrs))
+		for _, a := range pr.Addrs {
+			addr, err := ma.NewMultiaddrBytes(a.Addr)
+			require.NoError(t, err)
+			addrs = append(addrs, addr)
+		}
+		return addrs
+	}
+
+	peers := make([]peer.ID, 5)
+	for i := range peers {
+		peers[i] = test.RandPeerIDFatal(t)
+		ps.AddAddr(peers[i], ma.StringCast("/ip4/1.2.3.4/tcp/1"), time.Hour)
+	}
+	require.NoError(t, ps.Flush(context.Background()))
+
+	// Changes made while the datastore fails are kept in memory.
+	store.failing.Store(true)
+	for i, p := range peers {
+		ps.AddAddr(p, ma.StringCast(fmt.Sprintf("/ip4/1.2.3.4/tcp/%d", i+2)), time.Hour)
+	}
+	for _, p := range peers {
+		require.Len(t, stored(p), 1)
+	}
+	require.Len(t, ps.dsAddrBook.dirty, len(peers))
+	require.ErrorContains(t, ps.Flush(context.Background()), "datastore unavailable")
+
+	store.failing.Store(false)
+	require.NoError(t, ps.Flush(context.Background()))
+	for i, p := range peers {
+		require.ElementsMatch(t, []ma.Multiaddr{
+			ma.StringCast("/ip4/1.2.3.4/tcp/1"),
+			ma.StringCast(fmt.Sprintf("/ip4/1.2.3.4/tcp/%d", i+2)),
+		}, stored(p))
+	}
+	require.Empty(t, ps.dsAddrBook.dirty)
+	require.Equal(t, len(peers), ps.CacheStats().Len, "records weren't evicted")
+
+	// Cleared records aren't written back.
+	store.failing.Store(true)
+	ps.AddAddr(peers[0], ma.StringCast("/ip4/1.2.3.4/tcp/100"), time.Hour)
+	store.failing.Store(false)
+	ps.ClearAddrs(peers[0])
+	require.NoError(t, ps.Flush(context.Background()))
+	require.Empty(t, stored(peers[0]))
+}
+
+// countingStore counts the lookups of a key.
+type countingStore struct {
+	ds.Batching
+	key  ds.Key
+	gets atomic.Int32
+}
+
+func (s *countingStore) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	if key == s.key {
+		s.gets.Add(1)
+	}
+	return s.Batching.Get(ctx, key)
+}
+
+func TestAddrBookNegativeCache(t *testing.T) {
+	mapStore, closeStore := mapDBStore(t)
+	defer closeStore()
+	p := test.RandPeerIDFatal(t)
+	store := &countingStore{Batching: mapStore, key: PeerIDKeyCodec(addrBookBase).Encode(p)}
+	clk := mockclock.NewMock()
+	opts := DefaultOpts()
+	opts.Clock = clk
+	// only run GC explicitly.
+	opts.GCInitialDelay = 90 * time.Hour
+	opts.CacheNegativeTTL = time.Minute
+	ab, err := NewAddrBook(context.Background(), store, opts)
+	require.NoError(t, err)
+	defer ab.Close()
+
+	require.Empty(t, ab.Addrs(p))
+	require.Empty(t, ab.Addrs(p))
+	require.Nil(t, ab.GetPeerRecord(p))
+	require.Equal(t, 1, int(store.gets.Load()))
+	require.False(t, ab.cache.Contains(p), "absent peers take up cache entries")
+
+	clk.Add(time.Minute)
+	require.Empty(t, ab.Addrs(p))
+	require.Equal(t, 2, int(store.gets.Load()))
+
+	// Adding addresses replaces the negative entry.
+	addr := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	ab.AddAddr(p, addr, time.Hour)
+	require.Equal(t, []ma.Multiaddr{addr}, ab.Addrs(p))
+
+	// Records evicted in the meantime are found in the datastore.
+	ab.cache.Remove(p)
+	require.Equal(t, []ma.Multiaddr{addr}, ab.Addrs(p))
+
+	opts.CacheType = LRUCache
+	_, err = NewAddrBook(context.Background(), store, opts)
+	require.ErrorContains(t, err, "only supported by the ARC cache")
+}
+
+func TestAddrBookRangeCachedAddrs(t *testing.T) {
+	store, closeStore := mapDBStore(t)
+	defer closeStore()
+	clk := mockclock.NewMock()
+	opts := DefaultOpts()
+	opts.Clock = clk
+	// only run GC explicitly.
+	opts.GCInitialDelay = 90 * time.Hour
+	ab, err := NewAddrBook(context.Background(), store, opts)
+	require.NoError(t, err)
+	defer ab.Close()
+
+	p1, p2, p3 := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+	addr1 := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	addr2 := ma.StringCast("/ip4/1.2.3.4/tcp/2")
+	ab.AddAddr(p1, addr1, time.Hour)
+	ab.AddAddr(p1, addr2, time.Minute)
+	ab.AddAddr(p2, addr2, time.Hour)
+	ab.AddAddr(p3, addr1, time.Hour)
+	// Records that aren't cached are skipped.
+	ab.cache.Remove(p3)
+	clk.Add(time.Minute)
+
+	visited := map[peer.ID][]ma.Multiaddr{}
+	ab.RangeCachedAddrs(func(p peer.ID, addrs []ma.Multiaddr) bool {
+		visited[p] = addrs
+		return true
+	})
+	require.Equal(t, map[peer.ID][]ma.Multiaddr{p1: {addr1}, p2: {addr2}}, visited)
+	require.False(t, ab.cache.Contains(p3), "ranging loaded records from the datastore")
+
+	var n int
+	ab.RangeCachedAddrs(func(peer.ID, []ma.Multiaddr) bool {
+		n++
+		return false
+	})
+	require.Equal(t, 1, n)
+}
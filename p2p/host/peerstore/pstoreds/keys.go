@@ -0,0 +1,39 @@
+package pstoreds
+
+import (
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	ds "github.com/ipfs/go-datastore"
+	b32 "github.com/multiformats/go-base32"
+)
+
+// KeyCodec converts the keys of an in-memory cache into the datastore keys of
+// the records they cache, and back. Encode must be injective and Decode must
+// invert it, so that records found by querying the datastore can be matched
+// with their cache entries.
+type KeyCodec[K comparable] struct {
+	Encode func(K) ds.Key
+	Decode func(ds.Key) (K, error)
+}
+
+func (c KeyCodec[K]) isSet() bool {
+	return c.Encode != nil || c.Decode != nil
+}
+
+// PeerIDKeyCodec returns the KeyCodec naming records by the unpadded base32
+// encoding of the peer ID, as children of base. This is the encoding the
+// address book uses by default.
+func PeerIDKeyCodec(base ds.Key) KeyCodec[peer.ID] {
+	return KeyCodec[peer.ID]{
+		Encode: func(p peer.ID) ds.Key {
+			return base.ChildString(b32.RawStdEncoding.EncodeToString([]byte(p)))
+		},
+		Decode: func(k ds.Key) (peer.ID, error) {
+			b, err := b32.RawStdEncoding.DecodeString(k.Name())
+			if err != nil {
+				return "", err
+			}
+			return peer.IDFromBytes(b)
+		},
+	}
+}
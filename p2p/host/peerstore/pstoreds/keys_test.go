@@ -0,0 +1,98 @@
+package pstoreds
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/test"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	b32 "github.com/multiformats/go-base32"
+	"github.com/stretchr/testify/require"
+)
+
+var protoBase = ds.NewKey("/test/protos")
+
+// protoKey is a cache key that isn't a peer ID.
+type protoKey struct {
+	peer  peer.ID
+	proto protocol.ID
+}
+
+var protoKeys = KeyCodec[protoKey]{
+	Encode: func(k protoKey) ds.Key {
+		return protoBase.ChildString(b32.RawStdEncoding.EncodeToString([]byte(k.peer)) + "." + b32.RawStdEncoding.EncodeToString([]byte(k.proto)))
+	},
+	Decode: func(k ds.Key) (protoKey, error) {
+		p, proto, ok := strings.Cut(k.Name(), ".")
+		if !ok {
+			return protoKey{}, fmt.Errorf("malformed key: %s", k)
+		}
+		pb, err := b32.RawStdEncoding.DecodeString(p)
+		if err != nil {
+			return protoKey{}, err
+		}
+		id, err := peer.IDFromBytes(pb)
+		if err != nil {
+			return protoKey{}, err
+		}
+		protob, err := b32.RawStdEncoding.DecodeString(proto)
+		if err != nil {
+			return protoKey{}, err
+		}
+		return protoKey{peer: id, proto: protocol.ID(protob)}, nil
+	},
+}
+
+func TestKeyCodecRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store, closeStore := mapDBStore(t)
+	defer closeStore()
+	c, err := newCache[protoKey, string](DefaultOpts(), nil, nil)
+	require.NoError(t, err)
+
+	p := test.RandPeerIDFatal(t)
+	for i := 0; i < 10; i++ {
+		k := protoKey{peer: p, proto: protocol.ID(fmt.Sprintf("/test/%d.0.0", i))}
+		decoded, err := protoKeys.Decode(protoKeys.Encode(k))
+		require.NoError(t, err)
+		require.Equal(t, k, decoded)
+		v := fmt.Sprintf("record %d", i)
+		require.NoError(t, store.Put(ctx, protoKeys.Encode(k), []byte(v)))
+		c.Add(k, v)
+	}
+
+	// Every record in the datastore maps back to its cache entry.
+	results, err := store.Query(ctx, query.Query{Prefix: protoBase.String()})
+	require.NoError(t, err)
+	defer results.Close()
+	var n int
+	for result := range results.Next() {
+		require.NoError(t, result.Error)
+		k, err := protoKeys.Decode(ds.RawKey(result.Key))
+		require.NoError(t, err)
+		v, ok := c.Peek(k)
+		require.True(t, ok)
+		require.Equal(t, string(result.Value), v)
+		n++
+	}
+	require.Equal(t, 10, n)
+}
+
+func TestPeerIDKeyCodec(t *testing.T) {
+	p := test.RandPeerIDFatal(t)
+	codec := PeerIDKeyCodec(addrBookBase)
+	k := codec.Encode(p)
+	require.Equal(t, "/peers/addrs/"+b32.RawStdEncoding.EncodeToString([]byte(p)), k.String())
+	decoded, err := codec.Decode(k)
+	require.NoError(t, err)
+	require.Equal(t, p, decoded)
+
+	_, err = codec.Decode(addrBookBase.ChildString("not base32!"))
+	require.Error(t, err)
+}
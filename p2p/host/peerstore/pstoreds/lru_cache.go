@@ -0,0 +1,319 @@
+package pstoreds
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// lruCache is a plain least-recently-used cache. Entries optionally expire a
+// fixed duration after they were added; expired entries are treated as absent
+// and are removed lazily.
+type lruCache[K comparable, V any] struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	clock clock
+
+	ll    *list.List // front is most recently used
+	items map[K]*list.Element
+
+	// onEvict, if set, is called for every evicted entry.
+	onEvict func()
+	// onEvictEntry, if set, is called with every evicted entry, after mu is
+	// released.
+	onEvictEntry func(K, V)
+}
+
+type lruEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+var _ cache[int, int] = (*lruCache[int, int])(nil)
+
+// newLRUCache creates an LRU cache holding up to size entries. A ttl of 0
+// disables expiry.
+func newLRUCache[K comparable, V any](size int, ttl time.Duration, clk clock) *lruCache[K, V] {
+	if clk == nil {
+		clk = realclock{}
+	}
+	return &lruCache[K, V]{
+		size:  size,
+		ttl:   ttl,
+		clock: clk,
+		ll:    list.New(),
+		items: make(map[K]*list.Element, size),
+	}
+}
+
+// lookup returns the live element for key, removing it if it has expired.
+// Caller must hold the lock.
+func (c *lruCache[K, V]) lookup(key K) (*list.Element, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	if c.ttl > 0 && !c.clock.Now().Before(el.Value.(*lruEntry[K, V]).expires) {
+		c.removeElement(el)
+		return nil, false
+	}
+	return el, true
+}
+
+func (c *lruCache[K, V]) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*lruEntry[K, V]).key)
+}
+
+func (c *lruCache[K, V]) Get(key K) (value V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.lookup(key)
+	if !ok {
+		return value, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*lruEntry[K, V]).value, true
+}
+
+// GetWithTTL is like Get, and also returns the time left until the entry
+// expires.
+func (c *lruCache[K, V]) GetWithTTL(key K) (value V, remaining time.Duration, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.lookup(key)
+	if !ok {
+		return value, 0, false
+	}
+	c.ll.MoveToFront(el)
+	e := el.Value.(*lruEntry[K, V])
+	if c.ttl > 0 {
+		remaining = e.expires.Sub(c.clock.Now())
+	}
+	return e.value, remaining, true
+}
+
+func (c *lruCache[K, V]) Add(key K, value V) {
+	c.mu.Lock()
+	ev := c.add(key, value)
+	c.mu.Unlock()
+	notifyEvicted(c.onEvictEntry, ev)
+}
+
+func (c *lruCache[K, V]) GetOrAdd(key K, construct func() V) (value V, loaded bool) {
+	c.mu.Lock()
+	if el, ok := c.lookup(key); ok {
+		c.ll.MoveToFront(el)
+		value = el.Value.(*lruEntry[K, V]).value
+		c.mu.Unlock()
+		return value, true
+	}
+	value = construct()
+	ev := c.add(key, value)
+	c.mu.Unlock()
+	notifyEvicted(c.onEvictEntry, ev)
+	return value, false
+}
+
+// UpdateIfPresent refreshes the expiry of the entry, like Add.
+func (c *lruCache[K, V]) UpdateIfPresent(key K, update func(old V) V) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.lookup(key)
+	if !ok {
+		return false
+	}
+	// Updating an entry doesn't evict any.
+	c.add(key, update(el.Value.(*lruEntry[K, V]).value))
+	return true
+}
+
+// add adds or updates an entry, and returns the evicted entries if
+// onEvictEntry is set. Caller must hold the lock.
+func (c *lruCache[K, V]) add(key K, value V) []cacheEntry[K, V] {
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = c.clock.Now().Add(c.ttl)
+	}
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*lruEntry[K, V])
+		e.value = value
+		e.expires = expires
+		c.ll.MoveToFront(el)
+		return nil
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry[K, V]{key: key, value: value, expires: expires})
+	_, ev := c.evictOverflow()
+	return ev
+}
+
+// evictOverflow removes the least recently used entries exceeding the size.
+// The evicted entries are only returned if onEvictEntry is set.
+// Caller must hold the lock.
+func (c *lruCache[K, V]) evictOverflow() (n int, evicted []cacheEntry[K, V]) {
+	for c.ll.Len() > c.size {
+		e := c.ll.Back().Value.(*lruEntry[K, V])
+		c.removeElement(c.ll.Back())
+		n++
+		if c.onEvict != nil {
+			c.onEvict()
+		}
+		if c.onEvictEntry != nil {
+			evicted = append(evicted, cacheEntry[K, V]{key: e.key, value: e.value})
+		}
+	}
+	return n, evicted
+}
+
+// Resize evicts the least recently used entries if the cache shrinks.
+func (c *lruCache[K, V]) Resize(size int) (evicted int) {
+	c.mu.Lock()
+	c.size = size
+	evicted, ev := c.evictOverflow()
+	c.mu.Unlock()
+	notifyEvicted(c.onEvictEntry, ev)
+	return evicted
+}
+
+// Clear removes all entries. Expired entries aren't reported as evicted.
+func (c *lruCache[K, V]) Clear() {
+	c.mu.Lock()
+	var ev []cacheEntry[K, V]
+	c.rangeEntries(func(e *lruEntry[K, V]) bool {
+		if c.onEvict != nil {
+			c.onEvict()
+		}
+		if c.onEvictEntry != nil {
+			ev = append(ev, cacheEntry[K, V]{key: e.key, value: e.value})
+		}
+		return true
+	})
+	c.ll.Init()
+	clear(c.items)
+	c.mu.Unlock()
+	notifyEvicted(c.onEvictEntry, ev)
+}
+
+func (c *lruCache[K, V]) Remove(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *lruCache[K, V]) RemoveAll(keys []K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, k := range keys {
+		if el, ok := c.items[k]; ok {
+			c.removeElement(el)
+		}
+	}
+}
+
+// Lookup is Get, as the LRU cache has no negative entries.
+func (c *lruCache[K, V]) Lookup(key K) (value V, res lookupResult) {
+	if value, ok := c.Get(key); ok {
+		return value, lookupHit
+	}
+	return value, lookupMiss
+}
+
+func (*lruCache[K, V]) AddAbsent(_ K) {
+}
+
+// Contains reports whether key is in the cache, without updating its recency.
+func (c *lruCache[K, V]) Contains(key K) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.lookup(key)
+	return ok
+}
+
+// Peek returns the value for key, without updating its recency.
+func (c *lruCache[K, V]) Peek(key K) (value V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.lookup(key)
+	if !ok {
+		return value, false
+	}
+	return el.Value.(*lruEntry[K, V]).value, true
+}
+
+// Len returns the number of entries, including expired ones that weren't
+// removed yet.
+func (c *lruCache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Keys returns the keys of all live entries, from least to most recently used.
+func (c *lruCache[K, V]) Keys() []K {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]K, 0, c.ll.Len())
+	c.rangeKeys(func(k K) bool {
+		keys = append(keys, k)
+		return true
+	})
+	return keys
+}
+
+// RangeKeys calls fn for each live entry, from least to most recently used,
+// without materializing the key set.
+func (c *lruCache[K, V]) RangeKeys(fn func(K) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rangeKeys(fn)
+}
+
+// Range copies the live entries, from least to most recently used, before
+// calling fn.
+func (c *lruCache[K, V]) Range(fn func(K, V) bool) {
+	c.mu.Lock()
+	var entries []cacheEntry[K, V]
+	c.rangeEntries(func(e *lruEntry[K, V]) bool {
+		entries = append(entries, cacheEntry[K, V]{key: e.key, value: e.value})
+		return true
+	})
+	c.mu.Unlock()
+	for _, e := range entries {
+		if !fn(e.key, e.value) {
+			return
+		}
+	}
+}
+
+// rangeKeys removes expired entries while iterating. Caller must hold the lock.
+func (c *lruCache[K, V]) rangeKeys(fn func(K) bool) {
+	c.rangeEntries(func(e *lruEntry[K, V]) bool { return fn(e.key) })
+}
+
+// rangeEntries is like rangeKeys, but passes the entries. Caller must hold the lock.
+func (c *lruCache[K, V]) rangeEntries(fn func(*lruEntry[K, V]) bool) {
+	now := c.clock.Now()
+	for el := c.ll.Back(); el != nil; {
+		prev := el.Prev()
+		e := el.Value.(*lruEntry[K, V])
+		if c.ttl > 0 && !now.Before(e.expires) {
+			c.removeElement(el)
+		} else if !fn(e) {
+			return
+		}
+		el = prev
+	}
+}
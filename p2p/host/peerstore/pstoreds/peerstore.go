@@ -13,6 +13,7 @@ import (
 	ds "github.com/ipfs/go-datastore"
 	"github.com/ipfs/go-datastore/query"
 	"github.com/multiformats/go-base32"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Configuration object for the peerstore.
@@ -20,6 +21,45 @@ type Options struct {
 	// The size of the in-memory cache. A value of 0 or lower disables the cache.
 	CacheSize uint
 
+	// CacheType selects the eviction policy of the in-memory cache. Defaults to ARCCache.
+	CacheType CacheType
+
+	// CacheShards is the number of shards of a ShardedARCCache, each holding an equal share of CacheSize. There are
+	// at most CacheSize shards. Defaults to the smallest power of two that is at least GOMAXPROCS.
+	CacheShards int
+
+	// CacheTTL is how long entries stay in the in-memory cache before they expire. A value of 0 disables
+	// expiry.
+	CacheTTL time.Duration
+
+	// CacheNegativeTTL is how long the cache remembers that a peer has no address book record in the datastore,
+	// so that repeated lookups of absent peers don't hit the datastore. Records written to the datastore other than
+	// through this peerstore in the meantime are missed until then. A value of 0 disables negative entries. Only
+	// supported by ARCCache and ShardedARCCache.
+	CacheNegativeTTL time.Duration
+
+	// CacheMetricsRegisterer, if set, enables counting cache hits, misses, additions, removals and evictions,
+	// and registers the metrics with it.
+	CacheMetricsRegisterer prometheus.Registerer
+
+	// CacheMetricsTracer, if set, is notified of cache operations. It takes precedence over
+	// CacheMetricsRegisterer. See NewCacheMetricsTracer for the Prometheus-backed implementation.
+	CacheMetricsTracer CacheMetricsTracer
+
+	// CacheSnapshotInterval is the interval at which the peer IDs of the records in the address book cache are
+	// saved to the datastore. They are also saved when the address book is closed. On startup, the records of the
+	// last snapshot are loaded into the cache in the background, so that it doesn't start cold. A value of 0
+	// disables snapshots.
+	CacheSnapshotInterval time.Duration
+
+	// CacheWarmBatchSize is the number of records loaded at a time when warming up the cache from a snapshot.
+	// Warming stops between batches if the address book is closed. Defaults to 64.
+	CacheWarmBatchSize int
+
+	// AddrBookKeys, if set, replaces the encoding of the peer IDs the address book is keyed by into datastore
+	// keys. Encoded keys must be direct children of /peers/addrs. Defaults to PeerIDKeyCodec(/peers/addrs).
+	AddrBookKeys KeyCodec[peer.ID]
+
 	// MaxProtocols is the maximum number of protocols we store for one peer.
 	MaxProtocols int
 
@@ -41,6 +81,7 @@ type Options struct {
 // DefaultOpts returns the default options for a persistent peerstore, with the full-purge GC algorithm:
 //
 // * Cache size: 1024.
+// * Cache type: ARC.
 // * MaxProtocols: 1024.
 // * GC purge interval: 2 hours.
 // * GC lookahead interval: disabled.
@@ -154,6 +195,12 @@ func (ps *pstoreds) Close() (err error) {
 	return nil
 }
 
+// Flush writes the changes that couldn't be written to the datastore so far. Only the address book can hold
+// such changes, the other components write to the datastore directly. See dsAddrBook.Flush.
+func (ps *pstoreds) Flush(ctx context.Context) error {
+	return ps.dsAddrBook.Flush(ctx)
+}
+
 func (ps *pstoreds) Peers() peer.IDSlice {
 	set := map[peer.ID]struct{}{}
 	for _, p := range ps.PeersWithKeys() {
@@ -0,0 +1,201 @@
+package pstoreds
+
+import (
+	"fmt"
+	"hash/maphash"
+	"math/bits"
+	"runtime"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// shardedCache partitions keys across several ARC caches by their hash, so
+// that concurrent operations on different keys mostly don't contend for the
+// same lock. Each shard holds its share of the capacity and evicts
+// independently, so the entries evicted first are only the least valuable ones
+// within their shard.
+type shardedCache[K comparable, V any] struct {
+	hash   func(K) uint64
+	shards []*arcCache[K, V]
+}
+
+var _ cache[int, int] = (*shardedCache[int, int])(nil)
+
+// defaultCacheShards returns the smallest power of two that is at least
+// GOMAXPROCS.
+func defaultCacheShards() int {
+	return 1 << bits.Len(uint(runtime.GOMAXPROCS(0)-1))
+}
+
+// newShardedCache creates a cache holding up to size entries across n shards.
+// There are at most size shards, so that every shard holds at least one entry.
+// newShard creates a shard holding up to the given number of entries.
+func newShardedCache[K comparable, V any](size, n int, newShard func(size int) (*arcCache[K, V], error)) (*shardedCache[K, V], error) {
+	n = min(n, size)
+	c := &shardedCache[K, V]{hash: newKeyHasher[K](maphash.MakeSeed()), shards: make([]*arcCache[K, V], n)}
+	for i := range c.shards {
+		shard, err := newShard(shardSize(size, n, i))
+		if err != nil {
+			return nil, err
+		}
+		c.shards[i] = shard
+	}
+	return c, nil
+}
+
+// shardSize returns the capacity of shard i when spreading size entries across
+// n shards, which is at least 1.
+func shardSize(size, n, i int) int {
+	s := size / n
+	if i < size%n {
+		s++
+	}
+	return max(s, 1)
+}
+
+// shard returns the shard key belongs to.
+func (c *shardedCache[K, V]) shard(key K) *arcCache[K, V] {
+	return c.shards[c.shardIndex(key)]
+}
+
+func (c *shardedCache[K, V]) shardIndex(key K) int {
+	return int(c.hash(key) % uint64(len(c.shards)))
+}
+
+// newKeyHasher returns a hash function for keys of type K. Peer IDs and strings
+// are hashed directly, other keys by their formatted value, which is slower but
+// only used in tests. The function is picked once, as converting every key to
+// an interface to tell its type would allocate.
+func newKeyHasher[K comparable](seed maphash.Seed) func(K) uint64 {
+	var zero K
+	switch any(zero).(type) {
+	case peer.ID:
+		return any(func(k peer.ID) uint64 { return maphash.String(seed, string(k)) }).(func(K) uint64)
+	case string:
+		return any(func(k string) uint64 { return maphash.String(seed, k) }).(func(K) uint64)
+	}
+	return func(k K) uint64 { return maphash.String(seed, fmt.Sprintf("%v", k)) }
+}
+
+func (c *shardedCache[K, V]) Get(key K) (value V, ok bool) {
+	return c.shard(key).Get(key)
+}
+
+func (c *shardedCache[K, V]) GetWithTTL(key K) (value V, remaining time.Duration, ok bool) {
+	return c.shard(key).GetWithTTL(key)
+}
+
+func (c *shardedCache[K, V]) Add(key K, value V) {
+	c.shard(key).Add(key, value)
+}
+
+func (c *shardedCache[K, V]) GetOrAdd(key K, construct func() V) (value V, loaded bool) {
+	return c.shard(key).GetOrAdd(key, construct)
+}
+
+func (c *shardedCache[K, V]) UpdateIfPresent(key K, update func(old V) V) bool {
+	return c.shard(key).UpdateIfPresent(key, update)
+}
+
+func (c *shardedCache[K, V]) Remove(key K) {
+	c.shard(key).Remove(key)
+}
+
+func (c *shardedCache[K, V]) Contains(key K) bool {
+	return c.shard(key).Contains(key)
+}
+
+func (c *shardedCache[K, V]) Peek(key K) (value V, ok bool) {
+	return c.shard(key).Peek(key)
+}
+
+// Keys concatenates the keys of the shards, each ordered like the keys of an
+// ARC cache. It isn't a consistent snapshot across shards.
+func (c *shardedCache[K, V]) Keys() []K {
+	var keys []K
+	for _, s := range c.shards {
+		keys = append(keys, s.Keys()...)
+	}
+	return keys
+}
+
+func (c *shardedCache[K, V]) Len() int {
+	var n int
+	for _, s := range c.shards {
+		n += s.Len()
+	}
+	return n
+}
+
+func (c *shardedCache[K, V]) RangeKeys(fn func(K) bool) {
+	rangeSlice(c.Keys(), fn)
+}
+
+// Range calls fn with the entries of one shard after the other, copying the
+// entries of each shard before.
+func (c *shardedCache[K, V]) Range(fn func(K, V) bool) {
+	for _, s := range c.shards {
+		cont := true
+		s.Range(func(k K, v V) bool {
+			cont = fn(k, v)
+			return cont
+		})
+		if !cont {
+			return
+		}
+	}
+}
+
+// RemoveAll locks all shards that keys belong to, in order, so that the
+// removal stays atomic.
+func (c *shardedCache[K, V]) RemoveAll(keys []K) {
+	byShard := make(map[int][]K)
+	for _, k := range keys {
+		i := c.shardIndex(k)
+		byShard[i] = append(byShard[i], k)
+	}
+	for i, s := range c.shards {
+		if _, ok := byShard[i]; ok {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+		}
+	}
+	for i, keys := range byShard {
+		s := c.shards[i]
+		for _, k := range keys {
+			s.arc.Remove(k)
+		}
+		if s.absent != nil {
+			s.absent.RemoveAll(keys)
+		}
+	}
+}
+
+// Resize spreads size across the shards like on construction. Since every
+// shard holds at least one entry, the capacity doesn't shrink below the number
+// of shards.
+func (c *shardedCache[K, V]) Resize(size int) (evicted int) {
+	if size <= 0 {
+		return 0
+	}
+	for i, s := range c.shards {
+		evicted += s.Resize(shardSize(size, len(c.shards), i))
+	}
+	return evicted
+}
+
+// Clear clears one shard after the other, so it isn't atomic across shards.
+func (c *shardedCache[K, V]) Clear() {
+	for _, s := range c.shards {
+		s.Clear()
+	}
+}
+
+func (c *shardedCache[K, V]) Lookup(key K) (value V, res lookupResult) {
+	return c.shard(key).Lookup(key)
+}
+
+func (c *shardedCache[K, V]) AddAbsent(key K) {
+	c.shard(key).AddAbsent(key)
+}
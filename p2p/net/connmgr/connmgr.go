@@ -36,6 +36,9 @@ type BasicConnMgr struct {
 
 	plk       sync.RWMutex
 	protected map[peer.ID]map[string]struct{}
+	// protectionTimers holds the timers removing the protections added with
+	// ProtectWithExpiry.
+	protectionTimers map[protection]*clock.Timer
 
 	// channel-based semaphore that enforces only a single trim is in progress
 	trimMutex sync.Mutex
@@ -53,6 +56,11 @@ type BasicConnMgr struct {
 	unregisterMemoryWatcher func()
 }
 
+type protection struct {
+	id  peer.ID
+	tag string
+}
+
 var (
 	_ connmgr.ConnManager = (*BasicConnMgr)(nil)
 	_ connmgr.Decayer     = (*BasicConnMgr)(nil)
@@ -131,6 +139,8 @@ func NewConnManager(low, hi int, opts ...Option) (*BasicConnMgr, error) {
 		clock:     cfg.clock,
 		protected: make(map[peer.ID]map[string]struct{}, 16),
 		segments:  segments{},
+
+		protectionTimers: make(map[protection]*clock.Timer),
 	}
 
 	for i := range cm.segments.buckets {
@@ -196,6 +206,34 @@ func (cm *BasicConnMgr) Protect(id peer.ID, tag string) {
 	cm.plk.Lock()
 	defer cm.plk.Unlock()
 
+	cm.protect(id, tag)
+}
+
+// ProtectWithExpiry protects a peer like Protect, but removes the protection
+// after d, unless it is removed or renewed before. It replaces an existing
+// protection of the peer with the same tag.
+func (cm *BasicConnMgr) ProtectWithExpiry(id peer.ID, tag string, d time.Duration) {
+	cm.plk.Lock()
+	defer cm.plk.Unlock()
+
+	cm.protect(id, tag)
+	key := protection{id: id, tag: tag}
+	var timer *clock.Timer
+	timer = cm.clock.AfterFunc(d, func() {
+		cm.plk.Lock()
+		defer cm.plk.Unlock()
+		// The protection was removed or replaced in the meantime.
+		if cm.protectionTimers[key] != timer {
+			return
+		}
+		cm.unprotect(id, tag)
+	})
+	cm.protectionTimers[key] = timer
+}
+
+// protect must be called with plk held.
+func (cm *BasicConnMgr) protect(id peer.ID, tag string) {
+	cm.stopProtectionTimer(id, tag)
 	tags, ok := cm.protected[id]
 	if !ok {
 		tags = make(map[string]struct{}, 2)
@@ -204,10 +242,25 @@ func (cm *BasicConnMgr) Protect(id peer.ID, tag string) {
 	tags[tag] = struct{}{}
 }
 
+// stopProtectionTimer must be called with plk held.
+func (cm *BasicConnMgr) stopProtectionTimer(id peer.ID, tag string) {
+	key := protection{id: id, tag: tag}
+	if timer, ok := cm.protectionTimers[key]; ok {
+		timer.Stop()
+		delete(cm.protectionTimers, key)
+	}
+}
+
 func (cm *BasicConnMgr) Unprotect(id peer.ID, tag string) (protected bool) {
 	cm.plk.Lock()
 	defer cm.plk.Unlock()
 
+	return cm.unprotect(id, tag)
+}
+
+// unprotect must be called with plk held.
+func (cm *BasicConnMgr) unprotect(id peer.ID, tag string) (protected bool) {
+	cm.stopProtectionTimer(id, tag)
 	tags, ok := cm.protected[id]
 	if !ok {
 		return false
@@ -258,7 +311,8 @@ type peerInfo struct {
 
 	conns map[network.Conn]time.Time // start time of each connection
 
-	firstSeen time.Time // timestamp when we began tracking this peer.
+	firstSeen  time.Time // timestamp when we began tracking this peer.
+	lastSpared time.Time // when the BeforeTrim hook last spared this peer.
 }
 
 type peerInfos []*peerInfo
@@ -515,6 +569,9 @@ func (cm *BasicConnMgr) getConnsToClose() []network.Conn {
 		if target <= 0 {
 			break
 		}
+		if cm.spare(inf) {
+			continue
+		}
 
 		// lock this to protect from concurrent modifications from connect/disconnect events
 		s := cm.segments.get(inf.id)
@@ -535,6 +592,27 @@ func (cm *BasicConnMgr) getConnsToClose() []network.Conn {
 	return selected
 }
 
+// spare asks the BeforeTrim hook whether the connections to the peer should be
+// kept open in this trim. A peer is spared at most once per backoff period.
+func (cm *BasicConnMgr) spare(inf *peerInfo) bool {
+	if cm.cfg.beforeTrim == nil {
+		return false
+	}
+	now := cm.clock.Now()
+	s := cm.segments.get(inf.id)
+	s.Lock()
+	canSpare := len(inf.conns) > 0 && now.Sub(inf.lastSpared) >= cm.cfg.beforeTrimBackoff
+	s.Unlock()
+	if !canSpare || cm.cfg.beforeTrim(inf.id) {
+		return false
+	}
+	log.Debugw("sparing conns", "peer", inf.id)
+	s.Lock()
+	inf.lastSpared = now
+	s.Unlock()
+	return true
+}
+
 // GetTagInfo is called to fetch the tag information associated with a given
 // peer, nil is returned if p refers to an unknown peer.
 func (cm *BasicConnMgr) GetTagInfo(p peer.ID) *connmgr.TagInfo {
@@ -594,6 +594,113 @@ func TestPeerProtectionSingleTag(t *testing.T) {
 	}
 }
 
+func TestPeerProtectionWithExpiry(t *testing.T) {
+	mockClock := clock.NewMock()
+	cm, err := NewConnManager(19, 20, WithGracePeriod(0), WithSilencePeriod(time.Hour), WithClock(mockClock))
+	require.NoError(t, err)
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var conns []network.Conn
+	addConn := func(value int) {
+		rc := randConn(t, not.Disconnected)
+		conns = append(conns, rc)
+		not.Connected(nil, rc)
+		cm.TagPeer(rc.RemotePeer(), "test", value)
+	}
+
+	for i := 0; i < 20; i++ {
+		addConn(20)
+	}
+	protected := conns[0]
+	cm.ProtectWithExpiry(protected.RemotePeer(), "global", time.Minute)
+	// tag it negatively to make it preferred for pruning.
+	cm.TagPeer(protected.RemotePeer(), "test", -100)
+
+	// send the connection manager overboard.
+	for i := 0; i < 2; i++ {
+		addConn(20)
+	}
+	cm.TrimOpenConns(context.Background())
+	require.False(t, protected.(*tconn).isClosed(), "protected connection was closed by connection manager")
+
+	mockClock.Add(2 * time.Minute)
+	require.Eventually(t, func() bool { return !cm.IsProtected(protected.RemotePeer(), "") }, time.Second, 10*time.Millisecond)
+	require.Empty(t, cm.protectionTimers)
+
+	for i := 0; i < 2; i++ {
+		addConn(20)
+	}
+	cm.TrimOpenConns(context.Background())
+	require.True(t, protected.(*tconn).isClosed(), "connection was kept open after the protection expired")
+}
+
+func TestPeerProtectionWithExpiryReplaced(t *testing.T) {
+	mockClock := clock.NewMock()
+	cm, err := NewConnManager(10, 20, WithClock(mockClock))
+	require.NoError(t, err)
+	defer cm.Close()
+
+	id := tu.RandPeerIDFatal(t)
+	cm.ProtectWithExpiry(id, "tag", time.Minute)
+	// protecting without an expiry removes the expiry.
+	cm.Protect(id, "tag")
+	cm.ProtectWithExpiry(id, "tag2", time.Minute)
+	require.True(t, cm.Unprotect(id, "tag2"))
+	require.Empty(t, cm.protectionTimers)
+
+	mockClock.Add(2 * time.Minute)
+	require.True(t, cm.IsProtected(id, "tag"))
+}
+
+func TestBeforeTrim(t *testing.T) {
+	var spared sync.Map
+	mockClock := clock.NewMock()
+	cm, err := NewConnManager(10, 20,
+		WithGracePeriod(0),
+		WithSilencePeriod(time.Hour),
+		WithClock(mockClock),
+		WithBeforeTrim(func(p peer.ID) bool {
+			_, ok := spared.Load(p)
+			return !ok
+		}, time.Minute),
+	)
+	require.NoError(t, err)
+	defer cm.Close()
+	not := cm.Notifee()
+
+	var conns []network.Conn
+	for i := 0; i < 25; i++ {
+		rc := randConn(t, not.Disconnected)
+		conns = append(conns, rc)
+		not.Connected(nil, rc)
+		cm.TagPeer(rc.RemotePeer(), "test", i)
+	}
+	// spare the peers with the lowest values, which would be trimmed first.
+	for _, c := range conns[:3] {
+		spared.Store(c.RemotePeer(), struct{}{})
+	}
+
+	cm.TrimOpenConns(context.Background())
+	for i, c := range conns {
+		// the 15 peers with the lowest value are trimmed, except for the spared ones.
+		require.Equal(t, i >= 3 && i < 18, c.(*tconn).isClosed(), "conn %d", i)
+	}
+
+	// within the backoff period, the peers can't be spared again.
+	for i := 0; i < 5; i++ {
+		rc := randConn(t, not.Disconnected)
+		conns = append(conns, rc)
+		not.Connected(nil, rc)
+		cm.TagPeer(rc.RemotePeer(), "test", 100)
+	}
+	mockClock.Add(time.Second)
+	cm.TrimOpenConns(context.Background())
+	for _, c := range conns[:3] {
+		require.True(t, c.(*tconn).isClosed())
+	}
+}
+
 func TestPeerProtectionMultipleTags(t *testing.T) {
 	cm, err := NewConnManager(19, 20, WithGracePeriod(0), WithSilencePeriod(time.Hour))
 	require.NoError(t, err)
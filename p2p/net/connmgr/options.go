@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/benbjohnson/clock"
+	"github.com/libp2p/go-libp2p/core/peer"
 )
 
 // config is the configuration struct for the basic connection manager.
@@ -15,6 +16,9 @@ type config struct {
 	silencePeriod time.Duration
 	decayer       *DecayerCfg
 	clock         clock.Clock
+
+	beforeTrim        func(peer.ID) bool
+	beforeTrimBackoff time.Duration
 }
 
 // Option represents an option for the basic connection manager.
@@ -61,3 +65,24 @@ func WithSilencePeriod(p time.Duration) Option {
 		return nil
 	}
 }
+
+// WithBeforeTrim sets a hook that is called before the connections to a peer
+// are closed in a trim. If it returns false, the connections are kept open for
+// this trim, and the next peer is picked instead. To not starve trims, a peer
+// spared by the hook can be trimmed without consulting the hook again until
+// backoff has passed.
+// The hook isn't called by ForceTrim. It must not block or call into the
+// connection manager.
+func WithBeforeTrim(f func(peer.ID) bool, backoff time.Duration) Option {
+	return func(cfg *config) error {
+		if f == nil {
+			return errors.New("nil before trim hook")
+		}
+		if backoff < 0 {
+			return errors.New("before trim backoff must be non-negative")
+		}
+		cfg.beforeTrim = f
+		cfg.beforeTrimBackoff = backoff
+		return nil
+	}
+}
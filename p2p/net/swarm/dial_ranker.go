@@ -105,6 +105,59 @@ func DefaultDialRanker(addrs []ma.Multiaddr) []network.AddrDelay {
 	return res
 }
 
+// rankWithPreferences groups addrs by the preferences of opts, and ranks each
+// group with ranker. The addresses of a group are dialed PublicTCPDelay after
+// the last address of the preceding group, relay addresses avoided by opts
+// RelayDelay after it.
+func rankWithPreferences(addrs []ma.Multiaddr, ranker network.DialRanker, opts *dialOptions) []network.AddrDelay {
+	// groups holds the addresses of each preferred transport, followed by the
+	// other addresses and the avoided relay addresses.
+	groups := make([][]ma.Multiaddr, len(opts.transports)+2)
+	for _, a := range addrs {
+		i := opts.group(a)
+		groups[i] = append(groups[i], a)
+	}
+
+	res := make([]network.AddrDelay, 0, len(addrs))
+	var offset time.Duration
+	for i, g := range groups {
+		if len(g) == 0 {
+			continue
+		}
+		if len(res) > 0 {
+			if i == len(groups)-1 {
+				offset += RelayDelay
+			} else {
+				offset += PublicTCPDelay
+			}
+		}
+		var maxDelay time.Duration
+		for _, ad := range ranker(g) {
+			maxDelay = max(maxDelay, ad.Delay)
+			ad.Delay += offset
+			res = append(res, ad)
+		}
+		offset += maxDelay
+	}
+	return res
+}
+
+// group returns the index of the group of a in rankWithPreferences.
+func (o *dialOptions) group(a ma.Multiaddr) int {
+	if isRelayAddr(a) {
+		if o.avoidRelays {
+			return len(o.transports) + 1
+		}
+		return len(o.transports)
+	}
+	for i, p := range o.transports {
+		if isProtocolAddr(a, p) {
+			return i
+		}
+	}
+	return len(o.transports)
+}
+
 // getAddrDelay ranks a group of addresses according to the ranking logic explained in
 // documentation for defaultDialRanker.
 // offset is used to delay all addresses by a fixed duration. This is useful for delaying all relay
@@ -8,6 +8,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/test"
 	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
 )
 
 func sortAddrDelays(addrDelays []network.AddrDelay) {
@@ -290,6 +291,69 @@ func TestDelayRankerRelay(t *testing.T) {
 	}
 }
 
+func TestRankWithPreferences(t *testing.T) {
+	q1 := ma.StringCast("/ip4/1.2.3.4/udp/1/quic-v1")
+	t1 := ma.StringCast("/ip4/1.2.3.4/tcp/1")
+	t2 := ma.StringCast("/ip4/1.2.3.4/tcp/2")
+
+	pid := test.RandPeerIDFatal(t)
+	r1 := ma.StringCast(fmt.Sprintf("/ip4/1.2.3.4/udp/1/quic-v1/p2p-circuit/p2p/%s", pid))
+
+	testCase := []struct {
+		name   string
+		opts   dialOptions
+		output []network.AddrDelay
+	}{
+		{
+			name: "no preferences",
+			output: []network.AddrDelay{
+				{Addr: q1, Delay: 0},
+				{Addr: t1, Delay: PublicQUICDelay},
+				{Addr: t2, Delay: PublicQUICDelay + PublicTCPDelay},
+				{Addr: r1, Delay: RelayDelay},
+			},
+		},
+		{
+			name: "prefer TCP",
+			opts: dialOptions{transports: []int{ma.P_TCP}},
+			output: []network.AddrDelay{
+				{Addr: t1, Delay: 0},
+				{Addr: t2, Delay: PublicTCPDelay},
+				{Addr: q1, Delay: 2 * PublicTCPDelay},
+				{Addr: r1, Delay: 2*PublicTCPDelay + RelayDelay},
+			},
+		},
+		{
+			name: "avoid relays",
+			opts: dialOptions{avoidRelays: true},
+			output: []network.AddrDelay{
+				{Addr: q1, Delay: 0},
+				{Addr: t1, Delay: PublicQUICDelay},
+				{Addr: t2, Delay: PublicQUICDelay + PublicTCPDelay},
+				{Addr: r1, Delay: PublicQUICDelay + PublicTCPDelay + RelayDelay},
+			},
+		},
+		{
+			name: "prefer TCP and avoid relays",
+			opts: dialOptions{transports: []int{ma.P_TCP}, avoidRelays: true},
+			output: []network.AddrDelay{
+				{Addr: t1, Delay: 0},
+				{Addr: t2, Delay: PublicTCPDelay},
+				{Addr: q1, Delay: 2 * PublicTCPDelay},
+				{Addr: r1, Delay: 2*PublicTCPDelay + RelayDelay},
+			},
+		},
+	}
+	for _, tc := range testCase {
+		t.Run(tc.name, func(t *testing.T) {
+			res := rankWithPreferences([]ma.Multiaddr{r1, t2, t1, q1}, DefaultDialRanker, &tc.opts)
+			sortAddrDelays(res)
+			sortAddrDelays(tc.output)
+			require.Equal(t, tc.output, res)
+		})
+	}
+}
+
 func TestDelayRankerOtherTransportDelay(t *testing.T) {
 	q1v1 := ma.StringCast("/ip4/1.2.3.4/udp/1/quic-v1")
 	q1v16 := ma.StringCast("/ip6/1::2/udp/1/quic-v1")
@@ -49,6 +49,9 @@ func (ad *activeDial) dial(ctx context.Context) (*Conn, error) {
 	if simConnect, isClient, reason := network.GetSimultaneousConnect(ctx); simConnect {
 		dialCtx = network.WithSimultaneousConnect(dialCtx, isClient, reason)
 	}
+	if o := getDialOptions(ctx); o != nil {
+		dialCtx = context.WithValue(dialCtx, dialOptionsKey{}, o)
+	}
 
 	resch := make(chan dialResponse, 1)
 	select {
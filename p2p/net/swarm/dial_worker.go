@@ -1,8 +1,10 @@
 package swarm
 
 import (
+	"cmp"
 	"context"
 	"math"
+	"slices"
 	"sync"
 	"time"
 
@@ -46,6 +48,9 @@ type pendRequest struct {
 	// the addr is removed from the map and err is updated. On a successful dial, the dialRequest is
 	// completed and response is sent with the connection
 	addrs map[string]struct{}
+	// ranking is the ranking of the addresses of the request. It is only set
+	// if the swarm has a dial ranking observer.
+	ranking []network.AddrDelay
 }
 
 // addrDial tracks dials to a particular multiaddress.
@@ -190,7 +195,7 @@ loop:
 
 			// get the delays to dial these addrs from the swarms dialRanker
 			simConnect, _, _ := network.GetSimultaneousConnect(req.ctx)
-			addrRanking := w.rankAddrs(addrs, simConnect)
+			addrRanking := w.rankAddrs(addrs, simConnect, getDialOptions(req.ctx))
 			addrDelay := make(map[string]time.Duration, len(addrRanking))
 
 			// create the pending request object
@@ -203,6 +208,10 @@ loop:
 				pr.addrs[string(adelay.Addr.Bytes())] = struct{}{}
 				addrDelay[string(adelay.Addr.Bytes())] = adelay.Delay
 			}
+			if w.s.dialRankingObserver != nil {
+				pr.ranking = slices.Clone(addrRanking)
+				slices.SortStableFunc(pr.ranking, func(a, b network.AddrDelay) int { return cmp.Compare(a.Delay, b.Delay) })
+			}
 
 			// Check if dials to any of the addrs have completed already
 			// If they have errored, record the error in pr. If they have succeeded,
@@ -221,7 +230,7 @@ loop:
 
 				if ad.conn != nil {
 					// dial to this addr was successful, complete the request
-					req.resch <- dialResponse{conn: ad.conn}
+					w.respond(pr, dialResponse{conn: ad.conn})
 					continue loop
 				}
 
@@ -239,7 +248,7 @@ loop:
 			if len(todial) == 0 && len(tojoin) == 0 {
 				// all request applicable addrs have been dialed, we must have errored
 				pr.err.Cause = ErrAllDialsFailed
-				req.resch <- dialResponse{err: pr.err}
+				w.respond(pr, dialResponse{err: pr.err})
 				continue loop
 			}
 
@@ -350,7 +359,7 @@ loop:
 
 				for pr := range w.pendingRequests {
 					if _, ok := pr.addrs[string(ad.addr.Bytes())]; ok {
-						pr.req.resch <- dialResponse{conn: conn}
+						w.respond(pr, dialResponse{conn: conn})
 						delete(w.pendingRequests, pr)
 					}
 				}
@@ -386,6 +395,18 @@ loop:
 	}
 }
 
+// respond completes the pending request pr with res.
+func (w *dialWorker) respond(pr *pendRequest, res dialResponse) {
+	pr.req.resch <- res
+	if w.s.dialRankingObserver != nil {
+		r := DialRanking{Peer: w.peer, Ranking: pr.ranking, Err: res.err}
+		if res.conn != nil {
+			r.Selected = res.conn.RemoteMultiaddr()
+		}
+		w.s.dialRankingObserver(r)
+	}
+}
+
 // dispatches an error to a specific addr dial
 func (w *dialWorker) dispatchError(ad *addrDial, err error) {
 	ad.err = err
@@ -400,10 +421,10 @@ func (w *dialWorker) dispatchError(ad *addrDial, err error) {
 				// a simultaneous dial that started later and added new acceptable addrs
 				c := w.s.bestAcceptableConnToPeer(pr.req.ctx, w.peer)
 				if c != nil {
-					pr.req.resch <- dialResponse{conn: c}
+					w.respond(pr, dialResponse{conn: c})
 				} else {
 					pr.err.Cause = ErrAllDialsFailed
-					pr.req.resch <- dialResponse{err: pr.err}
+					w.respond(pr, dialResponse{err: pr.err})
 				}
 				delete(w.pendingRequests, pr)
 			}
@@ -421,10 +442,13 @@ func (w *dialWorker) dispatchError(ad *addrDial, err error) {
 
 // rankAddrs ranks addresses for dialing. if it's a simConnect request we
 // dial all addresses immediately without any delay
-func (w *dialWorker) rankAddrs(addrs []ma.Multiaddr, isSimConnect bool) []network.AddrDelay {
+func (w *dialWorker) rankAddrs(addrs []ma.Multiaddr, isSimConnect bool, opts *dialOptions) []network.AddrDelay {
 	if isSimConnect {
 		return NoDelayDialRanker(addrs)
 	}
+	if opts != nil {
+		return rankWithPreferences(addrs, w.s.dialRanker, opts)
+	}
 	return w.s.dialRanker(addrs)
 }
 
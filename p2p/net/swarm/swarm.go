@@ -115,6 +115,35 @@ func WithDialRanker(d network.DialRanker) Option {
 	}
 }
 
+// DialRanking describes how the addresses of a peer were ranked for a dial
+// request, and its outcome.
+type DialRanking struct {
+	Peer peer.ID
+	// Ranking holds the ranked addresses with the delay after which they are
+	// dialed, ordered by delay. Addresses dialed by a concurrent dial request
+	// to the peer are dialed with the delay of that request.
+	Ranking []network.AddrDelay
+	// Selected is the remote address of the connection the request was
+	// completed with. It is nil if the dial failed.
+	Selected ma.Multiaddr
+	Err      error
+}
+
+// WithDialRankingObserver configures swarm to call f with the ranking of the
+// peer's addresses for every dial request, once the request completes. Requests
+// that complete without ranking the addresses, e.g. because there already is a
+// connection to the peer, aren't reported.
+// f is called from the dial loop of the peer, so it must not block.
+func WithDialRankingObserver(f func(DialRanking)) Option {
+	return func(s *Swarm) error {
+		if f == nil {
+			return errors.New("swarm: dial ranking observer cannot be nil")
+		}
+		s.dialRankingObserver = f
+		return nil
+	}
+}
+
 // WithUDPBlackHoleSuccessCounter configures swarm to use the provided config for UDP black hole detection
 // n is the size of the sliding window used to evaluate black hole state
 // min is the minimum number of successes out of n required to not block requests
@@ -216,6 +245,8 @@ type Swarm struct {
 	metricsTracer MetricsTracer
 
 	dialRanker network.DialRanker
+	// dialRankingObserver is optional
+	dialRankingObserver func(DialRanking)
 
 	connectednessEventEmitter *connectednessEventEmitter
 	udpBHF                    *BlackHoleSuccessCounter
@@ -233,6 +233,87 @@ func (s *Swarm) DialPeer(ctx context.Context, p peer.ID) (network.Conn, error) {
 	return c, nil
 }
 
+// DialAddr connects to addr without knowing the peer ID of the peer listening
+// on it, and returns the connection, whose RemotePeer is the peer ID learned
+// from the security handshake. The connection is added to the swarm like any
+// other. Only transports that support network.WithAllowUnknownPeer can be used.
+// The peer is trusted to be whoever is listening on the address, so this should
+// only be used when the address itself is trusted.
+func (s *Swarm) DialAddr(ctx context.Context, addr ma.Multiaddr) (network.Conn, error) {
+	tpt := s.TransportForDialing(addr)
+	if tpt == nil {
+		return nil, ErrNoTransport
+	}
+	ctx, cancel := context.WithTimeout(ctx, network.GetDialPeerTimeout(ctx))
+	defer cancel()
+	tc, err := tpt.Dial(network.WithAllowUnknownPeer(ctx, "dial address"), addr, "")
+	if err != nil {
+		return nil, err
+	}
+	p := tc.RemotePeer()
+	if p == s.local {
+		tc.Close()
+		return nil, ErrDialToSelf
+	}
+	if s.gater != nil && (!s.gater.InterceptPeerDial(p) || !s.gater.InterceptAddrDial(p, addr)) {
+		tc.CloseWithError(network.ConnGated)
+		return nil, &DialError{Peer: p, Cause: ErrGaterDisallowedConnection}
+	}
+	c, err := s.addConn(tc, network.DirOutbound)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// DialOption configures a single DialPeerWithOptions call.
+type DialOption func(*dialOptions)
+
+type dialOptions struct {
+	// transports are multiaddr protocol codes, in order of preference
+	transports  []int
+	avoidRelays bool
+}
+
+type dialOptionsKey struct{}
+
+// WithPreferredTransports makes DialPeerWithOptions prefer addresses using the
+// given transports, e.g. ma.P_QUIC_V1 and ma.P_TCP, in order of preference.
+// An address uses the first of the transports whose protocol it contains.
+// Addresses of a less preferred transport are only dialed after the addresses
+// of the more preferred ones, followed by the addresses of other transports.
+// Relay addresses are ranked like addresses of other transports.
+func WithPreferredTransports(protocols ...int) DialOption {
+	return func(o *dialOptions) {
+		o.transports = protocols
+	}
+}
+
+// WithAvoidRelays makes DialPeerWithOptions dial relay addresses only after
+// all direct addresses. Use network.WithForceDirectDial to not dial relay
+// addresses at all.
+func WithAvoidRelays() DialOption {
+	return func(o *dialOptions) {
+		o.avoidRelays = true
+	}
+}
+
+// DialPeerWithOptions is like DialPeer, but applies the options to the ranking
+// of the peer's addresses for this call. The options don't apply to simultaneous
+// connect dials, nor to addresses a concurrent dial to the peer is already dialing.
+func (s *Swarm) DialPeerWithOptions(ctx context.Context, p peer.ID, opts ...DialOption) (network.Conn, error) {
+	var o dialOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return s.DialPeer(context.WithValue(ctx, dialOptionsKey{}, &o), p)
+}
+
+func getDialOptions(ctx context.Context) *dialOptions {
+	o, _ := ctx.Value(dialOptionsKey{}).(*dialOptions)
+	return o
+}
+
 // internal dial method that returns an unwrapped conn
 //
 // It is gated by the swarm's dial synchronization systems: dialsync and
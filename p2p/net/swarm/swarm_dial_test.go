@@ -429,3 +429,112 @@ func TestSkipDialingManyDNS(t *testing.T) {
 	require.NoError(t, err)
 	require.Less(t, len(resolved), 3, "got: %v", resolved)
 }
+
+func TestDialAddr(t *testing.T) {
+	s1 := makeSwarm(t)
+	defer s1.Close()
+	s2 := makeSwarm(t)
+	defer s2.Close()
+
+	for _, addr := range s2.ListenAddresses() {
+		t.Run(addr.String(), func(t *testing.T) {
+			c, err := s1.DialAddr(context.Background(), addr)
+			require.NoError(t, err)
+			require.Equal(t, s2.LocalPeer(), c.RemotePeer())
+			require.Equal(t, network.DirOutbound, c.Stat().Direction)
+			require.Contains(t, s1.ConnsToPeer(s2.LocalPeer()), c)
+			require.NoError(t, c.Close())
+		})
+	}
+
+	_, err := s1.DialAddr(context.Background(), s1.ListenAddresses()[0])
+	require.ErrorIs(t, err, ErrDialToSelf)
+}
+
+func TestDialPeerWithOptions(t *testing.T) {
+	s1 := makeSwarm(t)
+	defer s1.Close()
+	s2 := makeSwarm(t)
+	defer s2.Close()
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), s2.ListenAddresses(), peerstore.PermanentAddrTTL)
+
+	dial := func(t *testing.T, dialer func() (network.Conn, error)) ma.Multiaddr {
+		t.Helper()
+		c, err := dialer()
+		require.NoError(t, err)
+		raddr := c.RemoteMultiaddr()
+		require.NoError(t, s1.ClosePeer(s2.LocalPeer()))
+		require.Eventually(t, func() bool { return len(s2.ConnsToPeer(s1.LocalPeer())) == 0 }, 5*time.Second, 10*time.Millisecond)
+		return raddr
+	}
+	isTCP := func(a ma.Multiaddr) bool { return isProtocolAddr(a, ma.P_TCP) }
+
+	// The default ranking prefers QUIC.
+	raddr := dial(t, func() (network.Conn, error) { return s1.DialPeer(context.Background(), s2.LocalPeer()) })
+	require.True(t, isQUICAddr(raddr), "expected a QUIC connection, got %s", raddr)
+
+	raddr = dial(t, func() (network.Conn, error) {
+		return s1.DialPeerWithOptions(context.Background(), s2.LocalPeer(), WithPreferredTransports(ma.P_TCP))
+	})
+	require.True(t, isTCP(raddr), "expected a TCP connection, got %s", raddr)
+
+	raddr = dial(t, func() (network.Conn, error) {
+		return s1.DialPeerWithOptions(context.Background(), s2.LocalPeer(), WithPreferredTransports(ma.P_QUIC_V1, ma.P_TCP))
+	})
+	require.True(t, isQUICAddr(raddr), "expected a QUIC connection, got %s", raddr)
+
+	// The preferences only apply to the call they're passed to.
+	raddr = dial(t, func() (network.Conn, error) { return s1.DialPeer(context.Background(), s2.LocalPeer()) })
+	require.True(t, isQUICAddr(raddr), "expected a QUIC connection, got %s", raddr)
+}
+
+func TestDialRankingObserver(t *testing.T) {
+	rankings := make(chan DialRanking, 10)
+	s1 := makeSwarmWithNoListenAddrs(t, WithDialRankingObserver(func(r DialRanking) { rankings <- r }))
+	defer s1.Close()
+	s2 := makeSwarm(t)
+	defer s2.Close()
+
+	closed := ma.StringCast("/ip4/127.0.0.1/tcp/1")
+	addrs := append(s2.ListenAddresses(), closed)
+	s1.Peerstore().AddAddrs(s2.LocalPeer(), addrs, peerstore.PermanentAddrTTL)
+
+	c, err := s1.DialPeer(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+
+	var r DialRanking
+	select {
+	case r = <-rankings:
+	case <-time.After(time.Second):
+		t.Fatal("expected the dial ranking to be observed")
+	}
+	require.Equal(t, s2.LocalPeer(), r.Peer)
+	require.NoError(t, r.Err)
+	require.Equal(t, c.RemoteMultiaddr(), r.Selected)
+
+	expected := DefaultDialRanker(addrs)
+	sort.SliceStable(expected, func(i, j int) bool { return expected[i].Delay < expected[j].Delay })
+	require.Equal(t, expected, r.Ranking)
+	require.True(t, isQUICAddr(r.Ranking[0].Addr), "expected QUIC to be ranked first")
+	require.Zero(t, r.Ranking[0].Delay)
+
+	// Dials served by an existing connection don't rank any addresses.
+	_, err = s1.DialPeer(context.Background(), s2.LocalPeer())
+	require.NoError(t, err)
+	require.Empty(t, rankings)
+
+	// Failed dials are reported with their error.
+	p := test.RandPeerIDFatal(t)
+	s1.Peerstore().AddAddrs(p, []ma.Multiaddr{closed}, peerstore.PermanentAddrTTL)
+	_, err = s1.DialPeer(context.Background(), p)
+	require.Error(t, err)
+	select {
+	case r = <-rankings:
+	case <-time.After(time.Second):
+		t.Fatal("expected the dial ranking to be observed")
+	}
+	require.Equal(t, p, r.Peer)
+	require.Error(t, r.Err)
+	require.Nil(t, r.Selected)
+	require.Equal(t, []network.AddrDelay{{Addr: closed}}, r.Ranking)
+}
@@ -21,7 +21,8 @@ import (
 )
 
 // ErrNilPeer is returned when attempting to upgrade an outbound connection
-// without specifying a peer ID.
+// without specifying a peer ID, unless the context allows it, see
+// network.WithAllowUnknownPeer.
 var ErrNilPeer = errors.New("nil peer")
 
 // AcceptQueueLength is the number of connections to fully setup before not accepting any new connections
@@ -145,7 +146,9 @@ func (u *upgrader) Upgrade(ctx context.Context, t transport.Transport, maconn ma
 
 func (u *upgrader) upgrade(ctx context.Context, t transport.Transport, maconn manet.Conn, dir network.Direction, p peer.ID, connScope network.ConnManagementScope) (transport.CapableConn, error) {
 	if dir == network.DirOutbound && p == "" {
-		return nil, ErrNilPeer
+		if ok, _ := network.GetAllowUnknownPeer(ctx); !ok {
+			return nil, ErrNilPeer
+		}
 	}
 	var stat network.ConnStats
 	if cs, ok := maconn.(network.ConnStat); ok {
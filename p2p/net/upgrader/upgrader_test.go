@@ -17,6 +17,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/transport"
 	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
 	"github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
 
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
@@ -202,3 +203,48 @@ func TestOutboundResourceManagement(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func TestEarlyMuxerNegotiation(t *testing.T) {
+	muxers := []upgrader.StreamMuxer{{ID: yamux.ID, Muxer: yamux.DefaultTransport}}
+	// newNoiseUpgrader returns an upgrader securing connections with Noise,
+	// which selects the muxer during the handshake if both peers pass muxers
+	// to it.
+	newNoiseUpgrader := func(t *testing.T, earlyMuxers []upgrader.StreamMuxer) (peer.ID, transport.Upgrader) {
+		id, priv := newPeer(t)
+		tr, err := noise.New(noise.ID, priv, earlyMuxers)
+		require.NoError(t, err)
+		u, err := upgrader.New([]sec.SecureTransport{tr}, muxers, nil, nil, nil)
+		require.NoError(t, err)
+		return id, u
+	}
+
+	upgrade := func(t *testing.T, clientEarlyMuxers []upgrader.StreamMuxer) (client, server network.ConnectionState) {
+		id, serverUpgrader := newNoiseUpgrader(t, muxers)
+		ln := createListener(t, serverUpgrader)
+		defer ln.Close()
+		_, clientUpgrader := newNoiseUpgrader(t, clientEarlyMuxers)
+		cconn, err := dial(t, clientUpgrader, ln.Multiaddr(), id, &network.NullScope{})
+		require.NoError(t, err)
+		defer cconn.Close()
+		sconn, err := ln.Accept()
+		require.NoError(t, err)
+		defer sconn.Close()
+		testConn(t, cconn, sconn)
+		return cconn.ConnState(), sconn.ConnState()
+	}
+
+	fastClient, fastServer := upgrade(t, muxers)
+	require.True(t, fastClient.UsedEarlyMuxerNegotiation)
+	require.True(t, fastServer.UsedEarlyMuxerNegotiation)
+
+	// Without early data from the client, the muxer is negotiated after the handshake.
+	fallbackClient, fallbackServer := upgrade(t, nil)
+	require.False(t, fallbackClient.UsedEarlyMuxerNegotiation)
+	require.False(t, fallbackServer.UsedEarlyMuxerNegotiation)
+
+	// Otherwise, the connections are equivalent.
+	for _, s := range []*network.ConnectionState{&fastClient, &fastServer, &fallbackClient, &fallbackServer} {
+		s.UsedEarlyMuxerNegotiation = false
+		require.Equal(t, network.ConnectionState{StreamMultiplexer: yamux.ID, Security: noise.ID, Transport: "tcp"}, *s)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
+	"golang.org/x/time/rate"
 )
 
 const (
@@ -43,6 +44,9 @@ var (
 	ErrNoPeers = errors.New("no peers for autonat v2")
 	// ErrPrivateAddrs is returned when the request has private IP addresses.
 	ErrPrivateAddrs = errors.New("private addresses cannot be verified with autonatv2")
+	// ErrProbeRateLimited is returned by CheckReachability when on demand
+	// reachability checks are made too often.
+	ErrProbeRateLimited = errors.New("autonat v2 reachability check rate limited")
 
 	log = logging.Logger("autonatv2")
 )
@@ -90,6 +94,8 @@ type AutoNAT struct {
 	// allowPrivateAddrs enables using private and localhost addresses for reachability checks.
 	// This is only useful for testing.
 	allowPrivateAddrs bool
+	// probeLimiter limits the on demand reachability checks made with CheckReachability.
+	probeLimiter *rate.Limiter
 }
 
 // New returns a new AutoNAT instance.
@@ -113,6 +119,7 @@ func New(dialerHost host.Host, opts ...AutoNATOption) (*AutoNAT, error) {
 		peers:                newPeersMap(),
 		throttlePeer:         make(map[peer.ID]time.Time),
 		throttlePeerDuration: s.throttlePeerDuration,
+		probeLimiter:         rate.NewLimiter(rate.Limit(float64(s.probeRPM)/60), s.probeBurst),
 	}
 	return an, nil
 }
@@ -226,6 +233,26 @@ func (an *AutoNAT) GetReachability(ctx context.Context, reqs []Request) (Result,
 	return res, nil
 }
 
+// CheckReachability makes an on demand dial request for checking the reachability
+// of addr, and returns the verdict. The verdict is network.ReachabilityUnknown if
+// the server refused to dial addr.
+// Checks are rate limited, see WithProbeRateLimit. If the limit is exceeded,
+// ErrProbeRateLimited is returned. As for GetReachability, a server is only sent
+// a dial request once every few minutes.
+func (an *AutoNAT) CheckReachability(ctx context.Context, addr ma.Multiaddr) (network.Reachability, error) {
+	if !an.probeLimiter.Allow() {
+		return network.ReachabilityUnknown, ErrProbeRateLimited
+	}
+	res, err := an.GetReachability(ctx, []Request{{Addr: addr, SendDialData: true}})
+	if err != nil {
+		return network.ReachabilityUnknown, err
+	}
+	if res.AllAddrsRefused {
+		return network.ReachabilityUnknown, nil
+	}
+	return res.Reachability, nil
+}
+
 func (an *AutoNAT) updatePeer(p peer.ID) {
 	an.mx.Lock()
 	defer an.mx.Unlock()
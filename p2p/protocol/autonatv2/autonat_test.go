@@ -829,3 +829,28 @@ func FuzzClient(f *testing.F) {
 		c.GetReachability(context.Background(), reqs)
 	})
 }
+
+func TestCheckReachability(t *testing.T) {
+	an := newAutoNAT(t, nil, allowPrivateAddrs, withAmplificationAttackPreventionDialWait(0))
+	defer an.Close()
+	defer an.host.Close()
+
+	c := newAutoNAT(t, nil, allowPrivateAddrs, WithProbeRateLimit(1, 2))
+	defer c.Close()
+	defer c.host.Close()
+
+	idAndWait(t, c, an)
+
+	reachability, err := c.CheckReachability(context.Background(), c.host.Addrs()[0])
+	require.NoError(t, err)
+	require.Equal(t, network.ReachabilityPublic, reachability)
+
+	reachability, err = c.CheckReachability(context.Background(), ma.StringCast("/ip4/1.2.3.4/tcp/2"))
+	require.NoError(t, err)
+	require.Equal(t, network.ReachabilityPrivate, reachability)
+
+	// the burst is used up
+	reachability, err = c.CheckReachability(context.Background(), c.host.Addrs()[0])
+	require.ErrorIs(t, err, ErrProbeRateLimited)
+	require.Equal(t, network.ReachabilityUnknown, reachability)
+}
@@ -1,6 +1,9 @@
 package autonatv2
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 // autoNATSettings is used to configure AutoNAT
 type autoNATSettings struct {
@@ -14,6 +17,8 @@ type autoNATSettings struct {
 	amplificatonAttackPreventionDialWait time.Duration
 	metricsTracer                        MetricsTracer
 	throttlePeerDuration                 time.Duration
+	probeRPM                             int
+	probeBurst                           int
 }
 
 func defaultSettings() *autoNATSettings {
@@ -27,6 +32,8 @@ func defaultSettings() *autoNATSettings {
 		amplificatonAttackPreventionDialWait: 3 * time.Second,
 		now:                                  time.Now,
 		throttlePeerDuration:                 defaultThrottlePeerDuration,
+		probeRPM:                             6, // 1 every 10 seconds
+		probeBurst:                           3,
 	}
 }
 
@@ -42,6 +49,19 @@ func WithServerRateLimit(rpm, perPeerRPM, dialDataRPM int, maxConcurrentRequests
 	}
 }
 
+// WithProbeRateLimit limits the on demand reachability checks made with
+// AutoNAT.CheckReachability to rpm per minute, allowing bursts of burst checks.
+func WithProbeRateLimit(rpm, burst int) AutoNATOption {
+	return func(s *autoNATSettings) error {
+		if rpm <= 0 || burst <= 0 {
+			return errors.New("probe rate limit must be positive")
+		}
+		s.probeRPM = rpm
+		s.probeBurst = burst
+		return nil
+	}
+}
+
 func WithMetricsTracer(m MetricsTracer) AutoNATOption {
 	return func(s *autoNATSettings) error {
 		s.metricsTracer = m
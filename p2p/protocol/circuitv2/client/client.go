@@ -2,9 +2,13 @@ package client
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"sync"
+	"time"
 
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/transport"
@@ -29,11 +33,65 @@ type Client struct {
 	host      host.Host
 	upgrader  transport.Upgrader
 
+	skipResolve bool
+	// resolveRelayID is set if relays can be specified without their peer ID.
+	resolveRelayID bool
+	stopTimeout    time.Duration
+
+	upgradeTimeout time.Duration
+
+	// connectRetries is the number of times a circuit is retried after a
+	// transient relay error, waiting connectRetryBackoff, doubling every time.
+	connectRetries      int
+	connectRetryBackoff time.Duration
+
+	// connBandwidth is the rate in bytes/s each relayed connection is limited to. 0 means unlimited.
+	connBandwidth int
+	connBurst     int
+
+	// readTimeout and writeTimeout bound how long reads and writes on relayed
+	// connections may block, unless a deadline is set explicitly. 0 means unbounded.
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// maxConns is the maximum number of relayed connections, dialed and
+	// accepted, open at the same time. 0 means unlimited.
+	maxConns int
+
+	// vouchers are presented when reserving slots on the respective relays.
+	vouchers map[peer.ID][]byte
+
+	// connectMetadata is attached to CONNECT requests. nil if not set.
+	connectMetadata []byte
+
+	// keepaliveInterval is the interval at which relays we hold a reservation
+	// on are pinged. 0 if they aren't pinged.
+	keepaliveInterval time.Duration
+
+	// alternateRelays returns the relays to reserve a slot on when a relay
+	// announces its shutdown. nil if announcements are ignored.
+	alternateRelays         func(context.Context) []peer.AddrInfo
+	relaySelector           RelaySelector
+	emitReservationSwitched event.Emitter
+
+	emitReservationLost event.Emitter
+	closeOnce           sync.Once
+	closeErr            error
+
+	// dials tracks in-flight dials, so that CloseWithContext can wait for them.
+	// New dials are only added while closing is false.
+	dials sync.WaitGroup
+
 	incoming chan accept
 
-	mx          sync.Mutex
-	activeDials map[peer.ID]*completion
-	hopCount    map[peer.ID]int
+	mx           sync.Mutex
+	closing      bool
+	activeDials  map[peer.ID]*completion
+	hopCount     map[peer.ID]int
+	reservations map[peer.ID]*Reservation
+	relayConns   map[peer.ID]*relayConn
+	// numConns is the number of relayed connections currently open.
+	numConns int
 }
 
 var _ io.Closer = &Client{}
@@ -52,25 +110,147 @@ type completion struct {
 
 // New constructs a new p2p-circuit/v2 client, attached to the given host and using the given
 // upgrader to perform connection upgrades.
-func New(h host.Host, upgrader transport.Upgrader) (*Client, error) {
+func New(h host.Host, upgrader transport.Upgrader, opts ...Option) (*Client, error) {
 	cl := &Client{
-		host:        h,
-		upgrader:    upgrader,
-		incoming:    make(chan accept),
-		activeDials: make(map[peer.ID]*completion),
-		hopCount:    make(map[peer.ID]int),
+		host:           h,
+		upgrader:       upgrader,
+		skipResolve:    true,
+		stopTimeout:    DefaultStopHandshakeTimeout,
+		upgradeTimeout: DefaultUpgradeTimeout,
+		incoming:       make(chan accept),
+		activeDials:    make(map[peer.ID]*completion),
+		hopCount:       make(map[peer.ID]int),
+		reservations:   make(map[peer.ID]*Reservation),
+		relayConns:     make(map[peer.ID]*relayConn),
+		vouchers:       make(map[peer.ID][]byte),
+		relaySelector:  inOrderSelector{},
+
+		connectRetries:      DefaultConnectRetries,
+		connectRetryBackoff: DefaultConnectRetryBackoff,
+	}
+	for _, opt := range opts {
+		if err := opt(cl); err != nil {
+			return nil, fmt.Errorf("error applying circuit client option: %w", err)
+		}
+	}
+	// The host may be nil if the client is only used to match addresses.
+	// Reservations, and thus their events, require one.
+	if h != nil {
+		var err error
+		cl.emitReservationLost, err = h.EventBus().Emitter(new(event.EvtRelayReservationLost))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create reservation lost emitter: %w", err)
+		}
+		cl.emitReservationSwitched, err = h.EventBus().Emitter(new(event.EvtRelayReservationSwitched))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create reservation switched emitter: %w", err)
+		}
 	}
 	cl.ctx, cl.ctxCancel = context.WithCancel(context.Background())
 	return cl, nil
 }
 
+// acquireConnSlot reserves a slot for a new relayed connection. It returns
+// false if the maximum number of relayed connections is already open.
+func (c *Client) acquireConnSlot() bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.maxConns > 0 && c.numConns >= c.maxConns {
+		return false
+	}
+	c.numConns++
+	return true
+}
+
+func (c *Client) releaseConnSlot() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.numConns--
+}
+
 // Start registers the circuit (client) protocol stream handlers
 func (c *Client) Start() {
 	c.host.SetStreamHandler(proto.ProtoIDv2Stop, c.handleStreamV2)
+	if c.alternateRelays != nil {
+		c.host.SetStreamHandler(proto.ProtoIDv2Shutdown, c.handleShutdown)
+	}
 }
 
+// defaultCloseTimeout is how long Close waits for in-flight dials to finish.
+const defaultCloseTimeout = 2 * time.Second
+
+// ErrClientClosed is returned when dialing or listening on a Client that is
+// closing or closed.
+var ErrClientClosed = errors.New("circuit client closed")
+
+// Close closes the client, waiting up to defaultCloseTimeout for in-flight
+// dials to finish. See CloseWithContext.
+//
+// Close is safe to call multiple times, as the swarm closes its transports
+// in addition to the owner of the Client.
 func (c *Client) Close() error {
-	c.ctxCancel()
-	c.host.RemoveStreamHandler(proto.ProtoIDv2Stop)
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), defaultCloseTimeout)
+	defer cancel()
+	return c.CloseWithContext(ctx)
+}
+
+// CloseWithContext gracefully closes the client. New dials and listens fail
+// with ErrClientClosed right away, while dials that are already in flight are
+// given until ctx is done to finish. The client is closed either way, and
+// ctx.Err() is returned if it stopped waiting for dials.
+func (c *Client) CloseWithContext(ctx context.Context) error {
+	if c.ctx.Err() != nil {
+		// already closed, don't wait for dials again.
+		return c.close()
+	}
+
+	c.mx.Lock()
+	c.closing = true
+	c.mx.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.dials.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		c.close()
+		return ctx.Err()
+	}
+	return c.close()
+}
+
+// startDial registers an in-flight dial. It returns false if the client is
+// closing, in which case the dial must not proceed. Otherwise, the caller must
+// call c.dials.Done when the dial is complete.
+func (c *Client) startDial() bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.closing {
+		return false
+	}
+	c.dials.Add(1)
+	return true
+}
+
+func (c *Client) isClosing() bool {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.closing
+}
+
+func (c *Client) close() error {
+	c.closeOnce.Do(func() {
+		c.ctxCancel()
+		c.host.RemoveStreamHandler(proto.ProtoIDv2Stop)
+		if c.alternateRelays != nil {
+			c.host.RemoveStreamHandler(proto.ProtoIDv2Shutdown)
+		}
+		if c.emitReservationLost != nil {
+			c.closeErr = errors.Join(c.emitReservationLost.Close(), c.emitReservationSwitched.Close())
+		}
+	})
+	return c.closeErr
 }
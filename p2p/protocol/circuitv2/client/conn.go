@@ -1,8 +1,11 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
@@ -11,6 +14,7 @@ import (
 
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
+	"golang.org/x/time/rate"
 )
 
 // HopTagWeight is the connection manager weight for connections carrying relay hop streams
@@ -30,6 +34,22 @@ type Conn struct {
 	stat   network.ConnStats
 
 	client *Client
+	// limiter limits the rate of outgoing data. nil if unlimited.
+	limiter *rate.Limiter
+	// release releases the relay connection a dialed circuit uses, see
+	// Client.acquireRelayConn. nil for accepted circuits.
+	release func()
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (c *Client) newConn(s network.Stream, remote peer.AddrInfo, stat network.ConnStats) *Conn {
+	conn := &Conn{stream: s, remote: remote, stat: stat, client: c}
+	if c.connBandwidth > 0 {
+		conn.limiter = rate.NewLimiter(rate.Limit(c.connBandwidth), c.connBurst)
+	}
+	return conn
 }
 
 type NetAddr struct {
@@ -50,9 +70,18 @@ func (n *NetAddr) String() string {
 // Conn interface
 var _ manet.Conn = (*Conn)(nil)
 
+// Close closes the connection. It is safe to call multiple times, as both
+// the upgrader and the dialer close connections that failed to upgrade.
 func (c *Conn) Close() error {
-	c.untagHop()
-	return c.stream.Reset()
+	c.closeOnce.Do(func() {
+		c.untagHop()
+		c.closeErr = c.stream.Reset()
+		c.client.releaseConnSlot()
+		if c.release != nil {
+			c.release()
+		}
+	})
+	return c.closeErr
 }
 
 func (c *Conn) Read(buf []byte) (int, error) {
@@ -60,7 +89,37 @@ func (c *Conn) Read(buf []byte) (int, error) {
 }
 
 func (c *Conn) Write(buf []byte) (int, error) {
-	return c.stream.Write(buf)
+	if c.limiter == nil {
+		return c.stream.Write(buf)
+	}
+	var written int
+	for len(buf) > 0 {
+		chunk := min(len(buf), c.limiter.Burst())
+		// The client context is canceled on Close, unblocking writes on shutdown.
+		if err := c.limiter.WaitN(c.client.ctx, chunk); err != nil {
+			return written, err
+		}
+		n, err := c.stream.Write(buf[:chunk])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		buf = buf[chunk:]
+	}
+	return written, nil
+}
+
+// CloseWrite closes the connection for writing. The relay forwards the
+// half-close, so that reads on the other end return io.EOF once all data was
+// read, while data can still be sent the other way.
+func (c *Conn) CloseWrite() error {
+	return c.stream.CloseWrite()
+}
+
+// CloseRead closes the connection for reading, with the semantics of
+// network.Stream.CloseRead on the stream to the relay.
+func (c *Conn) CloseRead() error {
+	return c.stream.CloseRead()
 }
 
 func (c *Conn) SetDeadline(t time.Time) error {
@@ -152,9 +211,135 @@ type capableConnWithStat interface {
 }
 
 type capableConn struct {
+	timeoutConn
+	relay peer.ID
+}
+
+// timeoutConn applies the I/O timeouts of the client to the streams of a
+// relayed connection, see WithIOTimeouts.
+type timeoutConn struct {
 	capableConnWithStat
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+func (c *Client) newTimeoutConn(cc capableConnWithStat) timeoutConn {
+	return timeoutConn{capableConnWithStat: cc, readTimeout: c.readTimeout, writeTimeout: c.writeTimeout}
+}
+
+func (c timeoutConn) OpenStream(ctx context.Context) (network.MuxedStream, error) {
+	s, err := c.capableConnWithStat.OpenStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return c.wrapStream(s), nil
+}
+
+func (c timeoutConn) AcceptStream() (network.MuxedStream, error) {
+	s, err := c.capableConnWithStat.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return c.wrapStream(s), nil
+}
+
+func (c timeoutConn) wrapStream(s network.MuxedStream) network.MuxedStream {
+	if c.readTimeout == 0 && c.writeTimeout == 0 {
+		return s
+	}
+	return &timeoutStream{MuxedStream: s, readTimeout: c.readTimeout, writeTimeout: c.writeTimeout}
+}
+
+// timeoutStream refreshes the read or write deadline of the stream before
+// every Read or Write, so that they only time out once the stream is idle for
+// longer than the timeout.
+type timeoutStream struct {
+	network.MuxedStream
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// readDeadline and writeDeadline are set while a deadline set with
+	// SetDeadline, SetReadDeadline or SetWriteDeadline is in effect, which
+	// takes precedence over the timeouts.
+	readDeadline  atomic.Bool
+	writeDeadline atomic.Bool
+}
+
+func (s *timeoutStream) Read(buf []byte) (int, error) {
+	if s.readTimeout > 0 && !s.readDeadline.Load() {
+		s.MuxedStream.SetReadDeadline(time.Now().Add(s.readTimeout))
+	}
+	return s.MuxedStream.Read(buf)
+}
+
+func (s *timeoutStream) Write(buf []byte) (int, error) {
+	if s.writeTimeout > 0 && !s.writeDeadline.Load() {
+		s.MuxedStream.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	}
+	return s.MuxedStream.Write(buf)
+}
+
+func (s *timeoutStream) SetDeadline(t time.Time) error {
+	s.readDeadline.Store(!t.IsZero())
+	s.writeDeadline.Store(!t.IsZero())
+	return s.MuxedStream.SetDeadline(t)
+}
+
+func (s *timeoutStream) SetReadDeadline(t time.Time) error {
+	s.readDeadline.Store(!t.IsZero())
+	return s.MuxedStream.SetReadDeadline(t)
+}
+
+func (s *timeoutStream) SetWriteDeadline(t time.Time) error {
+	s.writeDeadline.Store(!t.IsZero())
+	return s.MuxedStream.SetWriteDeadline(t)
+}
+
+// timeoutListener applies the I/O timeouts of the client to the streams of
+// accepted relayed connections.
+type timeoutListener struct {
+	tpt.Listener
+	client *Client
+}
+
+func (l timeoutListener) Accept() (tpt.CapableConn, error) {
+	cc, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.client.newTimeoutConn(cc.(capableConnWithStat)), nil
 }
 
+// Limit describes the limits a relay imposes on a relayed connection.
+type Limit struct {
+	// Duration is the maximum duration of the connection. 0 means unlimited.
+	Duration time.Duration
+	// Data is the maximum number of bytes relayed in each direction. 0 means unlimited.
+	Data int64
+}
+
+// LimitedConn is implemented by connections dialed through a relay. Use it to
+// avoid sending large payloads over limited relayed connections:
+//
+//	if lc, ok := conn.(client.LimitedConn); ok && lc.Limit() != nil {
+//		// the relay limits this connection
+//	}
+type LimitedConn interface {
+	// Limit returns the limits advertised by the relay, or nil if the
+	// connection is unlimited.
+	Limit() *Limit
+}
+
+var _ LimitedConn = capableConn{}
+
+// RelayedConn is implemented by connections dialed through a relay.
+type RelayedConn interface {
+	// Relay returns the peer ID of the relay the connection goes through.
+	Relay() peer.ID
+}
+
+var _ RelayedConn = capableConn{}
+
 var transportName = ma.ProtocolWithCode(ma.P_CIRCUIT).Name
 
 func (c capableConn) ConnState() network.ConnectionState {
@@ -162,3 +347,20 @@ func (c capableConn) ConnState() network.ConnectionState {
 		Transport: transportName,
 	}
 }
+
+func (c capableConn) Relay() peer.ID { return c.relay }
+
+func (c capableConn) Limit() *Limit {
+	stat := c.Stat()
+	if !stat.Limited {
+		return nil
+	}
+	var l Limit
+	if d, ok := stat.Extra[StatLimitDuration].(time.Duration); ok {
+		l.Duration = d
+	}
+	if d, ok := stat.Extra[StatLimitData].(uint64); ok {
+		l.Data = int64(d)
+	}
+	return &l
+}
@@ -2,7 +2,9 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/libp2p/go-libp2p/core/network"
@@ -20,9 +22,28 @@ const maxMessageSize = 4096
 var DialTimeout = time.Minute
 var DialRelayTimeout = 5 * time.Second
 
+// DefaultStopHandshakeTimeout is the default for WithStopHandshakeTimeout.
+const DefaultStopHandshakeTimeout = 10 * time.Second
+
+// DefaultUpgradeTimeout is the default for WithUpgradeTimeout.
+const DefaultUpgradeTimeout = 15 * time.Second
+
+// DefaultConnectRetries and DefaultConnectRetryBackoff are the defaults for
+// WithConnectRetries. Dials don't retry by default.
+const (
+	DefaultConnectRetries      = 0
+	DefaultConnectRetryBackoff = 250 * time.Millisecond
+)
+
+// MaxConnectMetadataSize is the maximum size of the metadata set with
+// WithConnectMetadata.
+const MaxConnectMetadataSize = 256
+
 // relay protocol errors; used for signalling deduplication
 type relayError struct {
 	err string
+	// status is the status the relay responded with, if any.
+	status pbv2.Status
 }
 
 func (e relayError) Error() string {
@@ -38,20 +59,75 @@ func isRelayError(err error) bool {
 	return ok
 }
 
-// dialer
-func (c *Client) dial(ctx context.Context, a ma.Multiaddr, p peer.ID) (*Conn, error) {
-	// split /a/p2p-circuit/b into (/a, /p2p-circuit/b)
-	relayaddr, destaddr := ma.SplitFunc(a, func(c ma.Component) bool {
-		return c.Protocol().Code == ma.P_CIRCUIT
-	})
+// isTransientRelayError reports whether err is a failure status of the relay
+// that may go away by itself, so that the circuit is worth retrying. Other
+// statuses, e.g. PERMISSION_DENIED or NO_RESERVATION, are permanent.
+func isTransientRelayError(err error) bool {
+	rerr, ok := err.(relayError)
+	if !ok {
+		return false
+	}
+	switch rerr.status {
+	case pbv2.Status_RESOURCE_LIMIT_EXCEEDED, pbv2.Status_CONNECTION_FAILED:
+		return true
+	default:
+		return false
+	}
+}
+
+// validateCircuitAddr checks that a is a relayed address of the form
+// [<relay transport addr>]/p2p/<relay ID>/p2p-circuit[/p2p/<target ID>].
+// If allowNoRelayID is set, the relay may also be specified by its transport
+// address only.
+func validateCircuitAddr(a ma.Multiaddr, allowNoRelayID bool) error {
+	relayaddr, destaddr := splitCircuitAddr(a)
 
 	// If the address contained no /p2p-circuit part, the second part is nil.
 	if destaddr == nil {
-		return nil, fmt.Errorf("%s is not a relay address", a)
+		return fmt.Errorf("%s is not a relay address", a)
 	}
 
 	if relayaddr == nil {
-		return nil, fmt.Errorf("can't dial a p2p-circuit without specifying a relay: %s", a)
+		return fmt.Errorf("can't dial a p2p-circuit without specifying a relay: %s", a)
+	}
+	if !hasRelayID(relayaddr) && !allowNoRelayID {
+		return fmt.Errorf("relay address has no relay peer ID: %s", a)
+	}
+
+	_, target := ma.SplitFirst(destaddr)
+	switch {
+	case len(target) == 0:
+	case len(target) == 1 && target[0].Protocol().Code == ma.P_P2P:
+	default:
+		return fmt.Errorf("only a target peer ID can follow the p2p-circuit component: %s", a)
+	}
+	return nil
+}
+
+func hasRelayID(relayaddr ma.Multiaddr) bool {
+	_, last := ma.SplitLast(relayaddr)
+	return last != nil && last.Protocol().Code == ma.P_P2P
+}
+
+// splitCircuitAddr splits /a/p2p-circuit/b into (/a, /p2p-circuit/b).
+func splitCircuitAddr(a ma.Multiaddr) (ma.Multiaddr, ma.Multiaddr) {
+	return ma.SplitFunc(a, func(c ma.Component) bool {
+		return c.Protocol().Code == ma.P_CIRCUIT
+	})
+}
+
+// dialer
+func (c *Client) dial(ctx context.Context, a ma.Multiaddr, p peer.ID) (*Conn, error) {
+	if err := validateCircuitAddr(a, c.resolveRelayID); err != nil {
+		return nil, err
+	}
+	relayaddr, destaddr := splitCircuitAddr(a)
+	if !hasRelayID(relayaddr) {
+		relayID, err := c.learnRelayID(ctx, relayaddr)
+		if err != nil {
+			return nil, err
+		}
+		relayaddr = relayaddr.Encapsulate(ma.StringCast("/p2p/" + relayID.String()))
 	}
 
 	dinfo := peer.AddrInfo{ID: p}
@@ -123,14 +199,166 @@ func (c *Client) dialPeer(ctx context.Context, relay, dest peer.AddrInfo) (*Conn
 
 	dialCtx, cancel := context.WithTimeout(ctx, DialRelayTimeout)
 	defer cancel()
-	s, err := c.host.NewStream(dialCtx, relay.ID, proto.ProtoIDv2Hop)
+	rc, err := c.acquireRelayConn(dialCtx, relay.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to relay: %w", err)
+	}
+	release := sync.OnceFunc(func() { c.releaseRelayConn(relay.ID, rc) })
+
+	// Transient failures are retried over the same relay connection.
+	backoff := c.connectRetryBackoff
+	for attempt := 0; ; attempt++ {
+		conn, err := c.openCircuit(dialCtx, ctx, relay.ID, dest)
+		if err == nil {
+			conn.release = release
+			return conn, nil
+		}
+		if attempt >= c.connectRetries || !isTransientRelayError(err) {
+			release()
+			return nil, err
+		}
+		log.Debugw("transient relay error, retrying", "relay", relay.ID, "peer", dest.ID, "error", err, "backoff", backoff)
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			release()
+			return nil, fmt.Errorf("%w: %w", ctx.Err(), err)
+		}
+		backoff *= 2
+	}
+}
+
+// openCircuit opens a hop stream to the relay using streamCtx, and asks the
+// relay to connect it to dest.
+func (c *Client) openCircuit(streamCtx, ctx context.Context, relay peer.ID, dest peer.AddrInfo) (*Conn, error) {
+	s, err := c.host.NewStream(streamCtx, relay, proto.ProtoIDv2Hop)
 	if err != nil {
 		return nil, fmt.Errorf("error opening hop stream to relay: %w", err)
 	}
-	return c.connect(s, dest)
+	return c.connect(ctx, s, dest)
+}
+
+// learnRelayID learns the peer ID of the relay at addr by connecting to it
+// through the host without expecting a particular peer, and reading the peer ID
+// the relay authenticated as. The connection is kept, so that the relay is
+// dialed over it.
+func (c *Client) learnRelayID(ctx context.Context, addr ma.Multiaddr) (peer.ID, error) {
+	d, ok := c.host.Network().(addrDialer)
+	if !ok {
+		return "", errors.New("the host's network can't dial relays without their peer ID")
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, DialRelayTimeout)
+	defer cancel()
+	conn, err := d.DialAddr(dialCtx, addr)
+	if err != nil {
+		return "", fmt.Errorf("failed to learn the peer ID of the relay at %s: %w", addr, err)
+	}
+	log.Debugw("learned relay peer ID", "addr", addr, "relay", conn.RemotePeer())
+	return conn.RemotePeer(), nil
+}
+
+// addrDialer is implemented by the swarm, to dial peers by their address only.
+type addrDialer interface {
+	DialAddr(ctx context.Context, addr ma.Multiaddr) (network.Conn, error)
+}
+
+// relayConnProtectTag protects connections to relays from the connection
+// manager while circuits are dialed through them.
+const relayConnProtectTag = "relay-circuit-dial"
+
+// relayConn is a connection to a relay, shared by the circuits dialed through it.
+type relayConn struct {
+	// ready is closed once the connection is established or failed.
+	ready chan struct{}
+	conn  network.Conn
+	err   error
+
+	// refs is the number of circuits using the connection. Protected by Client.mx.
+	refs int
+}
+
+// stale reports whether the connection failed or was closed, so that it must
+// be replaced. Caller must hold Client.mx.
+func (rc *relayConn) stale() bool {
+	select {
+	case <-rc.ready:
+		return rc.err != nil || rc.conn.IsClosed()
+	default:
+		return false
+	}
 }
 
-func (c *Client) connect(s network.Stream, dest peer.AddrInfo) (*Conn, error) {
+// acquireRelayConn returns a connection to relay shared with the other circuits
+// dialed through it, connecting to the relay if there is none. The relay is
+// protected from the connection manager until the last circuit releases the
+// connection with releaseRelayConn.
+func (c *Client) acquireRelayConn(ctx context.Context, relay peer.ID) (*relayConn, error) {
+	for {
+		c.mx.Lock()
+		rc, ok := c.relayConns[relay]
+		if !ok || rc.stale() {
+			rc = &relayConn{ready: make(chan struct{}), refs: 1}
+			c.relayConns[relay] = rc
+			c.mx.Unlock()
+
+			rc.conn, rc.err = c.host.Network().DialPeer(ctx, relay)
+			if rc.err == nil {
+				c.mx.Lock()
+				c.host.ConnManager().Protect(relay, relayConnProtectTag)
+				c.mx.Unlock()
+			}
+			close(rc.ready)
+			if rc.err != nil {
+				c.releaseRelayConn(relay, rc)
+				return nil, rc.err
+			}
+			return rc, nil
+		}
+		rc.refs++
+		c.mx.Unlock()
+
+		select {
+		case <-rc.ready:
+		case <-ctx.Done():
+			c.releaseRelayConn(relay, rc)
+			return nil, ctx.Err()
+		}
+		if rc.err == nil {
+			return rc, nil
+		}
+		// The concurrent dial failed, possibly because its context was
+		// canceled. Connect to the relay ourselves.
+		c.releaseRelayConn(relay, rc)
+	}
+}
+
+// releaseRelayConn releases a connection acquired with acquireRelayConn. The
+// last release unprotects the relay, leaving the lifetime of the connection to
+// the connection manager.
+func (c *Client) releaseRelayConn(relay peer.ID, rc *relayConn) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	rc.refs--
+	if rc.refs > 0 {
+		return
+	}
+	// A connection that replaced rc is still protected.
+	cur, ok := c.relayConns[relay]
+	if ok && cur != rc {
+		return
+	}
+	delete(c.relayConns, relay)
+	c.host.ConnManager().Unprotect(relay, relayConnProtectTag)
+}
+
+// connect sends the CONNECT request on the hop stream s. The exchange is bounded
+// by DialTimeout and the deadline of ctx, whichever is earlier, and canceling ctx
+// aborts it. Waiting for the relay's response, which it sends once it completed
+// the STOP handshake with the target, is additionally bounded by c.stopTimeout.
+func (c *Client) connect(ctx context.Context, s network.Stream, dest peer.AddrInfo) (*Conn, error) {
 	if err := s.Scope().ReserveMemory(maxMessageSize, network.ReservationPriorityAlways); err != nil {
 		s.Reset()
 		return nil, err
@@ -145,23 +373,44 @@ func (c *Client) connect(s network.Stream, dest peer.AddrInfo) (*Conn, error) {
 
 	msg.Type = pbv2.HopMessage_CONNECT.Enum()
 	msg.Peer = util.PeerInfoToPeerV2(dest)
+	msg.Metadata = c.connectMetadata
+
+	deadline := time.Now().Add(DialTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	s.SetDeadline(deadline)
 
-	s.SetDeadline(time.Now().Add(DialTimeout))
+	stop := context.AfterFunc(ctx, func() { s.Reset() })
+	defer stop()
 
 	err := wr.WriteMsg(&msg)
 	if err != nil {
 		s.Reset()
-		return nil, err
+		return nil, ctxOrErr(ctx, err)
 	}
 
 	msg.Reset()
 
+	stopDeadline := time.Now().Add(c.stopTimeout)
+	stopBounded := stopDeadline.Before(deadline)
+	if stopBounded {
+		s.SetReadDeadline(stopDeadline)
+	}
+
 	err = rd.ReadMsg(&msg)
 	if err != nil {
 		s.Reset()
-		return nil, err
+		if stopBounded && !time.Now().Before(stopDeadline) {
+			return nil, fmt.Errorf("relay didn't complete the STOP handshake with %s within %s: %w", dest.ID, c.stopTimeout, err)
+		}
+		return nil, ctxOrErr(ctx, err)
 	}
 
+	if !stop() {
+		// ctx was canceled and the stream was reset
+		return nil, ctx.Err()
+	}
 	s.SetDeadline(time.Time{})
 
 	if msg.GetType() != pbv2.HopMessage_STATUS {
@@ -172,7 +421,10 @@ func (c *Client) connect(s network.Stream, dest peer.AddrInfo) (*Conn, error) {
 	status := msg.GetStatus()
 	if status != pbv2.Status_OK {
 		s.Reset()
-		return nil, newRelayError("error opening relay circuit: %s (%d)", pbv2.Status_name[int32(status)], status)
+		return nil, relayError{
+			err:    fmt.Sprintf("error opening relay circuit: %s (%d)", pbv2.Status_name[int32(status)], status),
+			status: status,
+		}
 	}
 
 	// check for a limit provided by the relay; if the limit is not nil, then this is a limited
@@ -185,5 +437,13 @@ func (c *Client) connect(s network.Stream, dest peer.AddrInfo) (*Conn, error) {
 		stat.Extra[StatLimitData] = limit.GetData()
 	}
 
-	return &Conn{stream: s, remote: dest, stat: stat, client: c}, nil
+	return c.newConn(s, dest, stat), nil
+}
+
+// ctxOrErr returns the context's error if it is done, and err otherwise.
+func ctxOrErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%w: %w", ctxErr, err)
+	}
+	return err
 }
@@ -75,16 +75,23 @@ func (c *Client) handleStreamV2(s network.Stream) {
 		stat.Extra[StatLimitData] = limit.GetData()
 	}
 
+	if !c.acquireConnSlot() {
+		log.Debugw("refusing relayed connection: too many relayed connections", "peer", src.ID, "limit", c.maxConns)
+		handleError(pbv2.Status_RESOURCE_LIMIT_EXCEEDED)
+		return
+	}
+
 	log.Debugf("incoming relay connection from: %s", src.ID)
 
 	select {
 	case c.incoming <- accept{
-		conn: &Conn{stream: s, remote: src, stat: stat, client: c},
+		conn: c.newConn(s, src, stat),
 		writeResponse: func() error {
 			return writeResponse(pbv2.Status_OK)
 		},
 	}:
 	case <-time.After(AcceptTimeout):
+		c.releaseConnSlot()
 		handleError(pbv2.Status_CONNECTION_FAILED)
 	}
 }
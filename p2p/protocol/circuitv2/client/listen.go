@@ -1,9 +1,18 @@
 package client
 
 import (
+	"context"
+	"fmt"
+	"math/rand/v2"
 	"net"
+	"time"
 
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
 )
@@ -17,6 +26,10 @@ func (c *Client) Listener() *Listener {
 }
 
 func (l *Listener) Accept() (manet.Conn, error) {
+	return l.accept(l.ctx)
+}
+
+func (l *Listener) accept(ctx context.Context) (manet.Conn, error) {
 	for {
 		select {
 		case evt := <-l.incoming:
@@ -24,6 +37,7 @@ func (l *Listener) Accept() (manet.Conn, error) {
 			if err != nil {
 				log.Debugf("error writing relay response: %s", err.Error())
 				evt.conn.stream.Reset()
+				(*Client)(l).releaseConnSlot()
 				continue
 			}
 
@@ -32,7 +46,7 @@ func (l *Listener) Accept() (manet.Conn, error) {
 			evt.conn.tagHop()
 			return evt.conn, nil
 
-		case <-l.ctx.Done():
+		case <-ctx.Done():
 			return nil, transport.ErrListenerClosed
 		}
 	}
@@ -52,3 +66,281 @@ func (l *Listener) Multiaddr() ma.Multiaddr {
 func (l *Listener) Close() error {
 	return (*Client)(l).Close()
 }
+
+// Reservations made by Listen are refreshed at a random point between
+// ReservationRefreshMin and ReservationRefreshMax of their lifetime, so that
+// clients holding reservations on the same relay don't refresh them in lockstep.
+var (
+	ReservationRefreshMin = 0.8
+	ReservationRefreshMax = 0.95
+)
+
+// ReservationRetryInterval is the initial interval at which a failed
+// reservation refresh is retried, as long as the previous reservation didn't
+// expire. The interval doubles with every failed attempt, and is jittered.
+var ReservationRetryInterval = 10 * time.Second
+
+// relayListener is a listener holding a reservation on a specific relay.
+// Closing it stops refreshing the reservation, but doesn't close the Client.
+type relayListener struct {
+	*Listener
+	relay peer.ID
+	addr  ma.Multiaddr
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var _ manet.Listener = (*relayListener)(nil)
+
+func (c *Client) listenOnRelay(relay peer.AddrInfo, addr ma.Multiaddr) (*relayListener, error) {
+	c.mx.Lock()
+	_, ok := c.reservations[relay.ID]
+	c.mx.Unlock()
+	if ok {
+		return nil, fmt.Errorf("already listening through relay %s", relay.ID)
+	}
+
+	rsvp, err := c.reserve(c.ctx, relay)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mx.Lock()
+	if _, ok := c.reservations[relay.ID]; ok {
+		c.mx.Unlock()
+		return nil, fmt.Errorf("already listening through relay %s", relay.ID)
+	}
+	c.reservations[relay.ID] = rsvp
+	c.mx.Unlock()
+
+	ln := &relayListener{Listener: c.Listener(), relay: relay.ID, addr: addr}
+	ln.ctx, ln.cancel = context.WithCancel(c.ctx)
+	go c.refreshReservation(ln.ctx, relay, rsvp)
+	return ln, nil
+}
+
+// ReservationExpiry returns when the reservation that the Client holds on
+// relay expires, if it holds one. Reservations made by Listen are refreshed
+// before they expire, which moves the expiry forward.
+func (c *Client) ReservationExpiry(relay peer.ID) (time.Time, bool) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	rsvp, ok := c.reservations[relay]
+	if !ok {
+		return time.Time{}, false
+	}
+	return rsvp.Expiration, true
+}
+
+func (c *Client) reserve(ctx context.Context, relay peer.AddrInfo) (*Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, ReserveTimeout)
+	defer cancel()
+	if voucher, ok := c.vouchers[relay.ID]; ok {
+		return ReserveWithVoucher(ctx, c.host, relay, voucher)
+	}
+	return Reserve(ctx, c.host, relay)
+}
+
+// refreshReservation keeps refreshing the reservation on the relay until ctx
+// is canceled or the reservation is lost, either because it expired or because
+// the relay disconnected. A lost reservation is announced with an
+// EvtRelayReservationLost event.
+// If keepalives are enabled, it also pings the relay, reconnecting and
+// reserving again if the relay doesn't answer.
+func (c *Client) refreshReservation(ctx context.Context, relay peer.AddrInfo, rsvp *Reservation) {
+	defer func() {
+		c.mx.Lock()
+		delete(c.reservations, relay.ID)
+		c.mx.Unlock()
+	}()
+
+	sub, err := c.host.EventBus().Subscribe(new(event.EvtPeerConnectednessChanged))
+	if err != nil {
+		log.Errorw("failed to subscribe to connectedness events", "error", err)
+		return
+	}
+	defer sub.Close()
+	if c.host.Network().Connectedness(relay.ID) != network.Connected {
+		c.reservationLost(relay.ID, event.ReservationRelayDisconnected)
+		return
+	}
+
+	var keepalive <-chan time.Time
+	if c.keepaliveInterval > 0 {
+		ticker := time.NewTicker(c.keepaliveInterval)
+		defer ticker.Stop()
+		keepalive = ticker.C
+	}
+
+	timer := time.NewTimer(refreshDelay(rsvp.Expiration))
+	defer timer.Stop()
+	retryInterval := ReservationRetryInterval
+	for {
+		select {
+		case <-timer.C:
+		case <-keepalive:
+			err := c.pingRelay(ctx, relay.ID)
+			if err == nil {
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			log.Debugw("relay didn't answer keepalive ping, reserving again", "relay", relay.ID, "error", err)
+			c.host.Network().ClosePeer(relay.ID)
+			newRsvp, err := c.reserve(ctx, relay)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Debugw("failed to reserve again after keepalive ping", "relay", relay.ID, "error", err)
+					c.reservationLost(relay.ID, event.ReservationRelayDisconnected)
+				}
+				return
+			}
+			retryInterval = ReservationRetryInterval
+			rsvp = newRsvp
+			c.mx.Lock()
+			c.reservations[relay.ID] = rsvp
+			c.mx.Unlock()
+			timer.Reset(refreshDelay(rsvp.Expiration))
+			continue
+		case e := <-sub.Out():
+			evt := e.(event.EvtPeerConnectednessChanged)
+			// After a failed keepalive, we may have reconnected since.
+			if evt.Peer == relay.ID && evt.Connectedness != network.Connected && c.host.Network().Connectedness(relay.ID) != network.Connected {
+				log.Debugw("relay disconnected", "relay", relay.ID)
+				c.reservationLost(relay.ID, event.ReservationRelayDisconnected)
+				return
+			}
+			continue
+		case <-ctx.Done():
+			return
+		}
+
+		newRsvp, err := c.reserve(ctx, relay)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if !time.Now().Before(rsvp.Expiration) {
+				log.Debugw("reservation expired", "relay", relay.ID, "error", err)
+				c.reservationLost(relay.ID, event.ReservationExpired)
+				return
+			}
+			log.Debugw("failed to refresh reservation", "relay", relay.ID, "error", err)
+			timer.Reset(min(jitter(retryInterval), time.Until(rsvp.Expiration)))
+			retryInterval *= 2
+			continue
+		}
+		retryInterval = ReservationRetryInterval
+		rsvp = newRsvp
+		c.mx.Lock()
+		c.reservations[relay.ID] = rsvp
+		c.mx.Unlock()
+		timer.Reset(refreshDelay(rsvp.Expiration))
+	}
+}
+
+// pingRelay pings the relay over the existing connection, failing if it
+// doesn't answer within the keepalive interval.
+func (c *Client) pingRelay(ctx context.Context, relay peer.ID) error {
+	ctx, cancel := context.WithTimeout(network.WithNoDial(ctx, "relay keepalive"), c.keepaliveInterval)
+	defer cancel()
+	res := <-ping.Ping(ctx, c.host, relay)
+	if res.Error != nil {
+		return res.Error
+	}
+	// Ping doesn't return a result once ctx is done.
+	return ctx.Err()
+}
+
+func (c *Client) reservationLost(relay peer.ID, reason event.ReservationLostReason) {
+	if err := c.emitReservationLost.Emit(event.EvtRelayReservationLost{Relay: relay, Reason: reason}); err != nil {
+		log.Debugw("failed to emit reservation lost event", "error", err)
+	}
+}
+
+// handleShutdown handles the shutdown announcement of a relay, see
+// WithAlternateRelays.
+func (c *Client) handleShutdown(s network.Stream) {
+	relay := s.Conn().RemotePeer()
+	s.Close()
+
+	c.mx.Lock()
+	_, ok := c.reservations[relay]
+	c.mx.Unlock()
+	if !ok {
+		return
+	}
+	log.Debugw("relay is shutting down, reserving a slot on an alternate relay", "relay", relay)
+	go c.switchRelay(relay)
+}
+
+// switchRelay reserves a slot on the first alternate relay that grants one, in
+// the order picked by the relay selector, skipping the relay that is shutting
+// down and relays that we already hold a reservation on.
+func (c *Client) switchRelay(from peer.ID) {
+	var candidates []peer.AddrInfo
+	for _, ai := range c.alternateRelays(c.ctx) {
+		if ai.ID != from && ai.ID != c.host.ID() {
+			candidates = append(candidates, ai)
+		}
+	}
+	for _, ai := range c.relaySelector.SelectRelay(candidates) {
+		c.mx.Lock()
+		_, ok := c.reservations[ai.ID]
+		c.mx.Unlock()
+		if ok {
+			continue
+		}
+
+		rsvp, err := c.reserve(c.ctx, ai)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			log.Debugw("failed to reserve a slot on alternate relay", "relay", ai.ID, "error", err)
+			continue
+		}
+		c.mx.Lock()
+		if _, ok := c.reservations[ai.ID]; ok {
+			c.mx.Unlock()
+			continue
+		}
+		c.reservations[ai.ID] = rsvp
+		c.mx.Unlock()
+
+		go c.refreshReservation(c.ctx, ai, rsvp)
+		if err := c.emitReservationSwitched.Emit(event.EvtRelayReservationSwitched{From: from, To: ai.ID}); err != nil {
+			log.Debugw("failed to emit reservation switched event", "error", err)
+		}
+		return
+	}
+	log.Debugw("no alternate relay granted a reservation", "relay", from)
+}
+
+// refreshDelay returns the delay until a reservation expiring at expiration is
+// refreshed, at a random point of the window between ReservationRefreshMin and
+// ReservationRefreshMax of its remaining lifetime.
+func refreshDelay(expiration time.Time) time.Duration {
+	d := float64(time.Until(expiration))
+	return time.Duration(d * (ReservationRefreshMin + rand.Float64()*(ReservationRefreshMax-ReservationRefreshMin)))
+}
+
+// jitter returns a random duration between d/2 and d.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + rand.N(d/2+1)
+}
+
+func (l *relayListener) Accept() (manet.Conn, error) {
+	return l.accept(l.ctx)
+}
+
+func (l *relayListener) Multiaddr() ma.Multiaddr {
+	return l.addr
+}
+
+func (l *relayListener) Close() error {
+	l.cancel()
+	return nil
+}
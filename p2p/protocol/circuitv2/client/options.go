@@ -0,0 +1,239 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+type Option func(*Client) error
+
+// WithSkipResolve sets whether SkipResolve reports that relay addresses should not
+// be resolved before dialing. By default, resolution is skipped and left to the
+// transport used to reach the relay. Disable it to have relay addresses resolved
+// up front, e.g. to pin the relay's IP.
+//
+// Transports wrapping the Client have to implement transport.SkipResolver and
+// forward calls to the Client for this option to take effect.
+func WithSkipResolve(skip bool) Option {
+	return func(c *Client) error {
+		c.skipResolve = skip
+		return nil
+	}
+}
+
+// WithConnBandwidthLimit limits the rate at which data is sent on each relayed
+// connection to bytesPerSecond, allowing bursts of up to burst bytes. The limit
+// applies per connection and covers all bytes written to the relay, including
+// security and muxer overhead. It doesn't limit incoming data.
+// Relays enforce their own data limits, see StatLimitData. This option allows
+// staying well below them.
+// By default, relayed connections are not rate limited.
+func WithConnBandwidthLimit(bytesPerSecond, burst int) Option {
+	return func(c *Client) error {
+		if bytesPerSecond <= 0 || burst <= 0 {
+			return errors.New("bandwidth limit and burst must be positive")
+		}
+		c.connBandwidth = bytesPerSecond
+		c.connBurst = burst
+		return nil
+	}
+}
+
+// WithStopHandshakeTimeout bounds how long a dial waits for the relay to
+// complete the STOP handshake with the target, separately from the deadline
+// of the dial's context. It prevents dials from hanging on misbehaving relays.
+// Defaults to DefaultStopHandshakeTimeout.
+func WithStopHandshakeTimeout(d time.Duration) Option {
+	return func(c *Client) error {
+		if d <= 0 {
+			return errors.New("STOP handshake timeout must be positive")
+		}
+		c.stopTimeout = d
+		return nil
+	}
+}
+
+// WithUpgradeTimeout bounds how long a dial waits for the relayed connection
+// to be upgraded, i.e. for the security and stream multiplexer negotiations,
+// separately from the deadline of the dial's context. On expiry, the relayed
+// connection is closed and the dial fails with an *UpgradeTimeoutError.
+// Defaults to DefaultUpgradeTimeout.
+func WithUpgradeTimeout(d time.Duration) Option {
+	return func(c *Client) error {
+		if d <= 0 {
+			return errors.New("upgrade timeout must be positive")
+		}
+		c.upgradeTimeout = d
+		return nil
+	}
+}
+
+// WithIOTimeouts bounds how long a single Read or Write on a stream over a
+// relayed connection may block, so that a relay that stops forwarding doesn't
+// leave readers and writers blocked forever. The deadline is refreshed on every
+// call, so it only expires on streams that are idle for longer, and calls fail
+// with a net.Error whose Timeout method returns true. Deadlines set explicitly
+// on the stream take precedence until they are cleared. A timeout of 0 leaves
+// the respective direction unbounded.
+// By default, reads and writes aren't bounded.
+func WithIOTimeouts(readTimeout, writeTimeout time.Duration) Option {
+	return func(c *Client) error {
+		if readTimeout < 0 || writeTimeout < 0 {
+			return errors.New("read and write timeouts must not be negative")
+		}
+		c.readTimeout = readTimeout
+		c.writeTimeout = writeTimeout
+		return nil
+	}
+}
+
+// WithConnectRetries sets how often a dial retries opening the circuit after
+// the relay responded with a transient error, i.e. RESOURCE_LIMIT_EXCEEDED or
+// CONNECTION_FAILED. It waits backoff before the first retry, doubling the wait
+// for every further one. Permanent errors, e.g. PERMISSION_DENIED, fail the
+// dial right away. A retries value of 0 disables retrying, which is the
+// default. Defaults to DefaultConnectRetries and DefaultConnectRetryBackoff.
+func WithConnectRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) error {
+		if retries < 0 {
+			return errors.New("connect retries must not be negative")
+		}
+		if backoff <= 0 {
+			return errors.New("connect retry backoff must be positive")
+		}
+		c.connectRetries = retries
+		c.connectRetryBackoff = backoff
+		return nil
+	}
+}
+
+// WithRelayIDDiscovery allows dialing through relays specified by their
+// transport address only, e.g. /ip4/1.2.3.4/tcp/1/p2p-circuit/p2p/QmTarget.
+// The peer ID of the relay is learned from the security handshake with it, and
+// added to the peerstore along with the address. The host's network must be
+// able to dial addresses without a peer ID, like the swarm does for TCP and QUIC
+// addresses.
+// Relays found this way are trusted to be whoever is listening on the address,
+// so this should only be used when the address itself is trusted, e.g. for
+// bootstrapping.
+func WithRelayIDDiscovery() Option {
+	return func(c *Client) error {
+		c.resolveRelayID = true
+		return nil
+	}
+}
+
+// WithReservationVoucher presents voucher when reserving a slot on relay, for
+// relays that only grant reservations to authorized peers. See
+// ReserveWithVoucher. The voucher is also presented when the reservation is
+// refreshed. Reservations on other relays are made without a voucher.
+func WithReservationVoucher(relay peer.ID, voucher []byte) Option {
+	return func(c *Client) error {
+		if len(voucher) == 0 {
+			return errors.New("reservation voucher must not be empty")
+		}
+		c.vouchers[relay] = slices.Clone(voucher)
+		return nil
+	}
+}
+
+// WithConnectMetadata attaches metadata to the CONNECT requests of all dials,
+// e.g. a tenant ID that relays use to apply per-tenant policy. The metadata is
+// opaque to the client and at most MaxConnectMetadataSize bytes long. Relays
+// that don't support it ignore it.
+func WithConnectMetadata(metadata []byte) Option {
+	return func(c *Client) error {
+		if len(metadata) == 0 {
+			return errors.New("connect metadata must not be empty")
+		}
+		if len(metadata) > MaxConnectMetadataSize {
+			return fmt.Errorf("connect metadata exceeds %d bytes", MaxConnectMetadataSize)
+		}
+		c.connectMetadata = slices.Clone(metadata)
+		return nil
+	}
+}
+
+// WithMaxConns limits the number of relayed connections open at the same time
+// to n, counting both dialed and accepted connections. Beyond the limit, Dial
+// fails with a *TooManyConnsError, and incoming relayed connections are
+// refused with RESOURCE_LIMIT_EXCEEDED.
+// By default, the number of relayed connections is only limited by the
+// resource manager.
+func WithMaxConns(n int) Option {
+	return func(c *Client) error {
+		if n <= 0 {
+			return errors.New("max relayed connections must be positive")
+		}
+		c.maxConns = n
+		return nil
+	}
+}
+
+// WithAlternateRelays makes the client switch relays gracefully. When a relay
+// it holds a reservation on announces that it's shutting down, see
+// relay.Relay.AnnounceShutdown, the client reserves a slot on the first relay
+// returned by alternates that it doesn't hold a reservation on yet, see also
+// WithRelaySelector, while the
+// old relay still relays connections. Success is announced with an
+// EvtRelayReservationSwitched event, and the new reservation is refreshed
+// until it's lost. The client must be started to receive announcements, see
+// Start.
+// By default, announcements are ignored.
+func WithAlternateRelays(alternates func(ctx context.Context) []peer.AddrInfo) Option {
+	return func(c *Client) error {
+		c.alternateRelays = alternates
+		return nil
+	}
+}
+
+// RelaySelector picks the relays the client reserves a slot on, among
+// candidates, e.g. by RTT, location or reputation.
+type RelaySelector interface {
+	// SelectRelay returns the candidates to try, in the order to try them in.
+	// It may drop candidates.
+	SelectRelay(candidates []peer.AddrInfo) []peer.AddrInfo
+}
+
+// inOrderSelector tries the candidates in the order they are given.
+type inOrderSelector struct{}
+
+func (inOrderSelector) SelectRelay(candidates []peer.AddrInfo) []peer.AddrInfo {
+	return candidates
+}
+
+// WithRelaySelector sets the strategy picking the relay to switch to among the
+// alternate relays, see WithAlternateRelays. The candidates passed to it
+// exclude the relay that is shutting down.
+// By default, the alternate relays are tried in the order they are returned.
+func WithRelaySelector(s RelaySelector) Option {
+	return func(c *Client) error {
+		if s == nil {
+			return errors.New("relay selector must not be nil")
+		}
+		c.relaySelector = s
+		return nil
+	}
+}
+
+// WithRelayKeepalive makes the client ping relays it holds a reservation on
+// every interval, using the ping protocol on the existing connection, so that
+// connections that died silently, e.g. behind a NAT, are detected. If a relay
+// doesn't answer a ping within interval, the client closes the connection and
+// reserves a slot on the relay again. If that fails, the reservation is lost,
+// see EvtRelayReservationLost.
+// By default, relays aren't pinged.
+func WithRelayKeepalive(interval time.Duration) Option {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return errors.New("relay keepalive interval must be positive")
+		}
+		c.keepaliveInterval = interval
+		return nil
+	}
+}
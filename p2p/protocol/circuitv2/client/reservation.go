@@ -61,6 +61,23 @@ func (re ReservationError) Unwrap() error {
 // Reserve reserves a slot in a relay and returns the reservation information.
 // Clients must reserve slots in order for the relay to relay connections to them.
 func Reserve(ctx context.Context, h host.Host, ai peer.AddrInfo) (*Reservation, error) {
+	return reserve(ctx, h, ai, nil)
+}
+
+// ReserveWithVoucher is like Reserve, but presents voucher to the relay with the
+// reservation request. Relays that only serve authorized peers use it to decide
+// whether to grant the reservation. The voucher is a signed record envelope,
+// typically obtained from the relay operator out of band.
+// If the relay rejects the voucher, the returned ReservationError carries the
+// status sent by the relay.
+func ReserveWithVoucher(ctx context.Context, h host.Host, ai peer.AddrInfo, voucher []byte) (*Reservation, error) {
+	if len(voucher) == 0 {
+		return nil, ReservationError{Status: pbv2.Status_MALFORMED_MESSAGE, Reason: "empty voucher"}
+	}
+	return reserve(ctx, h, ai, voucher)
+}
+
+func reserve(ctx context.Context, h host.Host, ai peer.AddrInfo, voucher []byte) (*Reservation, error) {
 	if len(ai.Addrs) > 0 {
 		h.Peerstore().AddAddrs(ai.ID, ai.Addrs, peerstore.TempAddrTTL)
 	}
@@ -77,6 +94,9 @@ func Reserve(ctx context.Context, h host.Host, ai peer.AddrInfo) (*Reservation,
 
 	var msg pbv2.HopMessage
 	msg.Type = pbv2.HopMessage_RESERVE.Enum()
+	if voucher != nil {
+		msg.Reservation = &pbv2.Reservation{Voucher: voucher}
+	}
 
 	s.SetDeadline(time.Now().Add(ReserveTimeout))
 
@@ -97,6 +117,9 @@ func Reserve(ctx context.Context, h host.Host, ai peer.AddrInfo) (*Reservation,
 	}
 
 	if status := msg.GetStatus(); status != pbv2.Status_OK {
+		if voucher != nil {
+			return nil, ReservationError{Status: status, Reason: "reservation with voucher rejected"}
+		}
 		return nil, ReservationError{Status: msg.GetStatus(), Reason: "reservation failed"}
 	}
 
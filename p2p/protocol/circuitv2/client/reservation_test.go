@@ -9,6 +9,7 @@ import (
 
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/record"
@@ -159,3 +160,93 @@ func TestReservationFailures(t *testing.T) {
 		})
 	}
 }
+
+// newVoucherRelay returns a relay that only grants reservations to peers
+// presenting a voucher it signed for them, and a function to issue such vouchers.
+func newVoucherRelay(t *testing.T) (host.Host, func(p peer.ID) []byte) {
+	t.Helper()
+	relay, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	t.Cleanup(func() { relay.Close() })
+
+	authorized := func(s network.Stream, voucher []byte) bool {
+		env, rec, err := record.ConsumeEnvelope(voucher, proto.RecordDomain)
+		if err != nil {
+			return false
+		}
+		v, ok := rec.(*proto.ReservationVoucher)
+		return ok && env.PublicKey.Equals(relay.Peerstore().PubKey(relay.ID())) && v.Peer == s.Conn().RemotePeer()
+	}
+	relay.SetStreamHandler(proto.ProtoIDv2Hop, func(s network.Stream) {
+		defer s.Close()
+		rd := util.NewDelimitedReader(s, 4096)
+		defer rd.Close()
+		var msg pbv2.HopMessage
+		if err := rd.ReadMsg(&msg); err != nil || msg.GetType() != pbv2.HopMessage_RESERVE {
+			s.Reset()
+			return
+		}
+		rsp := &pbv2.HopMessage{Type: pbv2.HopMessage_STATUS.Enum()}
+		if authorized(s, msg.GetReservation().GetVoucher()) {
+			expire := uint64(time.Now().Add(time.Hour).Unix())
+			rsp.Status = pbv2.Status_OK.Enum()
+			rsp.Reservation = &pbv2.Reservation{Expire: &expire}
+		} else {
+			rsp.Status = pbv2.Status_PERMISSION_DENIED.Enum()
+		}
+		util.NewDelimitedWriter(s).WriteMsg(rsp)
+	})
+
+	issue := func(p peer.ID) []byte {
+		env, err := record.Seal(&proto.ReservationVoucher{
+			Relay:      relay.ID(),
+			Peer:       p,
+			Expiration: time.Now().Add(time.Hour),
+		}, relay.Peerstore().PrivKey(relay.ID()))
+		require.NoError(t, err)
+		b, err := env.Marshal()
+		require.NoError(t, err)
+		return b
+	}
+	return relay, issue
+}
+
+func TestReserveWithVoucher(t *testing.T) {
+	relay, issueVoucher := newVoucherRelay(t)
+	ai := peer.AddrInfo{ID: relay.ID(), Addrs: relay.Addrs()}
+
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer h.Close()
+
+	requireRejected := func(t *testing.T, err error, reason string) {
+		t.Helper()
+		var re client.ReservationError
+		require.ErrorAs(t, err, &re)
+		require.Equal(t, pbv2.Status_PERMISSION_DENIED, re.Status)
+		require.Equal(t, reason, re.Reason)
+	}
+
+	t.Run("without voucher", func(t *testing.T) {
+		_, err := client.Reserve(context.Background(), h, ai)
+		requireRejected(t, err, "reservation failed")
+	})
+
+	t.Run("voucher for another peer", func(t *testing.T) {
+		other, err := test.RandPeerID()
+		require.NoError(t, err)
+		_, err = client.ReserveWithVoucher(context.Background(), h, ai, issueVoucher(other))
+		requireRejected(t, err, "reservation with voucher rejected")
+	})
+
+	t.Run("empty voucher", func(t *testing.T) {
+		_, err := client.ReserveWithVoucher(context.Background(), h, ai, nil)
+		require.ErrorContains(t, err, "empty voucher")
+	})
+
+	t.Run("valid voucher", func(t *testing.T) {
+		rsvp, err := client.ReserveWithVoucher(context.Background(), h, ai, issueVoucher(h.ID()))
+		require.NoError(t, err)
+		require.True(t, rsvp.Expiration.After(time.Now()))
+	})
+}
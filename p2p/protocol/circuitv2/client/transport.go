@@ -2,8 +2,10 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
@@ -16,27 +18,36 @@ import (
 var circuitProtocol = ma.ProtocolWithCode(ma.P_CIRCUIT)
 var circuitAddr = ma.Cast(circuitProtocol.VCode)
 
+// Errors returned by AddTransport. They wrap the underlying cause, so they can be
+// matched with errors.Is.
+var (
+	ErrNotTransportNetwork = errors.New("not a transport network")
+	ErrConstructClient     = errors.New("error constructing circuit client")
+	ErrAddTransport        = errors.New("error adding circuit transport")
+	ErrListenCircuit       = errors.New("error listening to circuit addr")
+)
+
 // AddTransport constructs a new p2p-circuit/v2 client and adds it as a transport to the
 // host network
-func AddTransport(h host.Host, upgrader transport.Upgrader) error {
+func AddTransport(h host.Host, upgrader transport.Upgrader, opts ...Option) error {
 	n, ok := h.Network().(transport.TransportNetwork)
 	if !ok {
-		return fmt.Errorf("%v is not a transport network", h.Network())
+		return fmt.Errorf("%v is %w", h.Network(), ErrNotTransportNetwork)
 	}
 
-	c, err := New(h, upgrader)
+	c, err := New(h, upgrader, opts...)
 	if err != nil {
-		return fmt.Errorf("error constructing circuit client: %w", err)
+		return fmt.Errorf("%w: %w", ErrConstructClient, err)
 	}
 
 	err = n.AddTransport(c)
 	if err != nil {
-		return fmt.Errorf("error adding circuit transport: %w", err)
+		return fmt.Errorf("%w: %w", ErrAddTransport, err)
 	}
 
 	err = n.Listen(circuitAddr)
 	if err != nil {
-		return fmt.Errorf("error listening to circuit addr: %w", err)
+		return fmt.Errorf("%w: %w", ErrListenCircuit, err)
 	}
 
 	c.Start()
@@ -53,14 +64,20 @@ var _ transport.Transport = (*Client)(nil)
 var _ transport.SkipResolver = (*Client)(nil)
 var _ io.Closer = (*Client)(nil)
 
-// SkipResolve returns true since we always defer to the inner transport for
-// the actual connection. By skipping resolution here, we let the inner
-// transport decide how to resolve the multiaddr
+// SkipResolve returns true by default since we always defer to the inner
+// transport for the actual connection. By skipping resolution here, we let the
+// inner transport decide how to resolve the multiaddr. Use WithSkipResolve to
+// resolve relay addresses up front instead.
 func (c *Client) SkipResolve(_ context.Context, _ ma.Multiaddr) bool {
-	return true
+	return c.skipResolve
 }
 
 func (c *Client) Dial(ctx context.Context, a ma.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	if !c.startDial() {
+		return nil, ErrClientClosed
+	}
+	defer c.dials.Done()
+
 	connScope, err := c.host.Network().ResourceManager().OpenConnection(network.DirOutbound, false, a)
 
 	if err != nil {
@@ -74,34 +91,137 @@ func (c *Client) Dial(ctx context.Context, a ma.Multiaddr, p peer.ID) (transport
 	return conn, nil
 }
 
+// DialVia dials p through the given relays, trying them in order until a
+// connection is established. If all relays fail, the returned error joins the
+// errors of all attempts.
+// Addresses of the relays are taken from the peerstore.
+func (c *Client) DialVia(ctx context.Context, relays []peer.ID, p peer.ID) (transport.CapableConn, error) {
+	if len(relays) == 0 {
+		return nil, errors.New("no relays to dial through")
+	}
+	var errs []error
+	for _, relay := range relays {
+		a, err := ma.NewMultiaddr(fmt.Sprintf("/p2p/%s/p2p-circuit/p2p/%s", relay, p))
+		if err != nil {
+			return nil, err
+		}
+		conn, err := c.Dial(ctx, a, p)
+		if err == nil {
+			return conn, nil
+		}
+		log.Debugw("failed to dial through relay", "relay", relay, "peer", p, "error", err)
+		errs = append(errs, fmt.Errorf("relay %s: %w", relay, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
 func (c *Client) dialAndUpgrade(ctx context.Context, a ma.Multiaddr, p peer.ID, connScope network.ConnManagementScope) (transport.CapableConn, error) {
+	if !c.acquireConnSlot() {
+		return nil, &TooManyConnsError{Limit: c.maxConns}
+	}
 	if err := connScope.SetPeer(p); err != nil {
+		c.releaseConnSlot()
 		return nil, err
 	}
 	conn, err := c.dial(ctx, a, p)
 	if err != nil {
+		c.releaseConnSlot()
 		return nil, err
 	}
 	conn.tagHop()
-	cc, err := c.upgrader.Upgrade(ctx, c, conn, network.DirOutbound, p, connScope)
+
+	upgradeCtx, cancel := context.WithTimeout(ctx, c.upgradeTimeout)
+	defer cancel()
+	// Closing the relayed conn aborts negotiations that don't respect the context.
+	stop := context.AfterFunc(upgradeCtx, func() { conn.Close() })
+	cc, err := c.upgrader.Upgrade(upgradeCtx, c, conn, network.DirOutbound, p, connScope)
+	if !stop() && err == nil {
+		// The conn was closed right as the upgrade completed.
+		cc.Close()
+		err = upgradeCtx.Err()
+	}
 	if err != nil {
+		conn.Close()
+		if ctx.Err() == nil && errors.Is(upgradeCtx.Err(), context.DeadlineExceeded) {
+			return nil, &UpgradeTimeoutError{Peer: p, Timeout: c.upgradeTimeout, Err: err}
+		}
 		return nil, err
 	}
-	return capableConn{cc.(capableConnWithStat)}, nil
+	return capableConn{timeoutConn: c.newTimeoutConn(cc.(capableConnWithStat)), relay: conn.stream.Conn().RemotePeer()}, nil
+}
+
+// TooManyConnsError is returned by Dial if the client already has the maximum
+// number of relayed connections open, see WithMaxConns.
+type TooManyConnsError struct {
+	Limit int
+}
+
+func (e *TooManyConnsError) Error() string {
+	return fmt.Sprintf("too many relayed connections (limit: %d)", e.Limit)
 }
 
+// UpgradeTimeoutError is returned by Dial if the relayed connection wasn't
+// upgraded within the upgrade timeout, see WithUpgradeTimeout.
+type UpgradeTimeoutError struct {
+	Peer    peer.ID
+	Timeout time.Duration
+	// Err is the error the upgrade failed with.
+	Err error
+}
+
+func (e *UpgradeTimeoutError) Error() string {
+	return fmt.Sprintf("upgrading relayed connection to %s timed out after %s: %s", e.Peer, e.Timeout, e.Err)
+}
+
+func (e *UpgradeTimeoutError) Unwrap() error { return e.Err }
+
+// CanDial returns true if addr is a relayed address of the form
+// [<relay transport addr>]/p2p/<relay ID>/p2p-circuit[/p2p/<target ID>].
+// With WithRelayIDDiscovery, the relay ID may be omitted.
 func (c *Client) CanDial(addr ma.Multiaddr) bool {
-	_, err := addr.ValueForProtocol(ma.P_CIRCUIT)
-	return err == nil
+	return validateCircuitAddr(addr, c.resolveRelayID) == nil
 }
 
+// Listen listens for incoming relayed connections. If addr specifies a relay,
+// e.g. /ip4/1.2.3.4/tcp/1/p2p/QmRelay/p2p-circuit, a slot is reserved on that
+// relay before returning, and the reservation is refreshed until the returned
+// listener is closed.
 func (c *Client) Listen(addr ma.Multiaddr) (transport.Listener, error) {
-	// TODO connect to the relay and reserve slot if specified
+	if c.isClosing() {
+		return nil, ErrClientClosed
+	}
 	if _, err := addr.ValueForProtocol(ma.P_CIRCUIT); err != nil {
 		return nil, err
 	}
 
-	return c.upgrader.UpgradeGatedMaListener(c, c.upgrader.GateMaListener(c.Listener())), nil
+	relayaddr, _ := ma.SplitFunc(addr, func(c ma.Component) bool {
+		return c.Protocol().Code == ma.P_CIRCUIT
+	})
+	if len(relayaddr) == 0 {
+		return c.wrapListener(c.upgrader.UpgradeGatedMaListener(c, c.upgrader.GateMaListener(c.Listener()))), nil
+	}
+
+	rinfo, err := peer.AddrInfoFromP2pAddr(relayaddr)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing relay multiaddr '%s': %w", relayaddr, err)
+	}
+	ln, err := c.listenOnRelay(*rinfo, addr)
+	if err != nil {
+		return nil, err
+	}
+	return c.wrapListener(c.upgrader.UpgradeGatedMaListener(c, c.upgrader.GateMaListener(ln))), nil
+}
+
+// wrapListener applies the I/O timeouts of the client, if any, to the
+// connections accepted by ln.
+func (c *Client) wrapListener(ln transport.Listener) transport.Listener {
+	if c.readTimeout == 0 && c.writeTimeout == 0 {
+		return ln
+	}
+	return timeoutListener{Listener: ln, client: c}
 }
 
 func (c *Client) Protocols() []int {
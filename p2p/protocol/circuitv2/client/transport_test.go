@@ -0,0 +1,1323 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/sec"
+	"github.com/libp2p/go-libp2p/core/sec/insecure"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/muxer/yamux"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	swarmt "github.com/libp2p/go-libp2p/p2p/net/swarm/testing"
+	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	pbv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/pb"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/proto"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/util"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/stretchr/testify/require"
+)
+
+// newMockRelay returns a host that answers reservation requests with status,
+// granting reservations for ttl. It counts the reservation requests it receives.
+func newMockRelay(t *testing.T, status pbv2.Status, ttl time.Duration) (host.Host, *atomic.Int32) {
+	t.Helper()
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+
+	var reservations atomic.Int32
+	h.SetStreamHandler(proto.ProtoIDv2Hop, func(s network.Stream) {
+		defer s.Close()
+		rd := util.NewDelimitedReader(s, 4096)
+		defer rd.Close()
+		var msg pbv2.HopMessage
+		if err := rd.ReadMsg(&msg); err != nil || msg.GetType() != pbv2.HopMessage_RESERVE {
+			s.Reset()
+			return
+		}
+		reservations.Add(1)
+		expire := uint64(time.Now().Add(ttl).Unix())
+		util.NewDelimitedWriter(s).WriteMsg(&pbv2.HopMessage{
+			Type:        pbv2.HopMessage_STATUS.Enum(),
+			Status:      status.Enum(),
+			Reservation: &pbv2.Reservation{Expire: &expire},
+		})
+	})
+	return h, &reservations
+}
+
+func relayCircuitAddr(t *testing.T, relay host.Host) ma.Multiaddr {
+	t.Helper()
+	return relay.Addrs()[0].Encapsulate(ma.StringCast("/p2p/" + relay.ID().String() + "/p2p-circuit"))
+}
+
+func TestListenReservesSlot(t *testing.T) {
+	relay, reservations := newMockRelay(t, pbv2.Status_OK, 2*time.Second)
+
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer h.Close()
+
+	addr := relayCircuitAddr(t, relay)
+	require.NoError(t, h.Network().Listen(addr))
+	require.Equal(t, int32(1), reservations.Load())
+	require.Contains(t, h.Network().ListenAddresses(), addr)
+
+	// The reservation is refreshed before it expires.
+	require.Eventually(t, func() bool { return reservations.Load() >= 3 }, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestReservationRefreshJitter(t *testing.T) {
+	type reservation struct {
+		at, expire time.Time
+	}
+	var mx sync.Mutex
+	reservations := map[peer.ID][]reservation{}
+	relay, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer relay.Close()
+	relay.SetStreamHandler(proto.ProtoIDv2Hop, func(s network.Stream) {
+		defer s.Close()
+		rd := util.NewDelimitedReader(s, 4096)
+		defer rd.Close()
+		var msg pbv2.HopMessage
+		if err := rd.ReadMsg(&msg); err != nil || msg.GetType() != pbv2.HopMessage_RESERVE {
+			s.Reset()
+			return
+		}
+		now := time.Now()
+		expire := uint64(now.Add(3 * time.Second).Unix())
+		mx.Lock()
+		reservations[s.Conn().RemotePeer()] = append(reservations[s.Conn().RemotePeer()], reservation{at: now, expire: time.Unix(int64(expire), 0)})
+		mx.Unlock()
+		util.NewDelimitedWriter(s).WriteMsg(&pbv2.HopMessage{
+			Type:        pbv2.HopMessage_STATUS.Enum(),
+			Status:      pbv2.Status_OK.Enum(),
+			Reservation: &pbv2.Reservation{Expire: &expire},
+		})
+	})
+
+	const numClients = 5
+	for range numClients {
+		h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+		require.NoError(t, err)
+		defer h.Close()
+		require.NoError(t, h.Network().Listen(relayCircuitAddr(t, relay)))
+	}
+	require.Eventually(t, func() bool {
+		mx.Lock()
+		defer mx.Unlock()
+		for _, rs := range reservations {
+			if len(rs) < 2 {
+				return false
+			}
+		}
+		return len(reservations) == numClients
+	}, 5*time.Second, 50*time.Millisecond)
+
+	mx.Lock()
+	defer mx.Unlock()
+	const slack = 100 * time.Millisecond
+	for p, rs := range reservations {
+		lifetime := rs[0].expire.Sub(rs[0].at)
+		refresh := rs[1].at.Sub(rs[0].at)
+		require.GreaterOrEqual(t, refresh, time.Duration(float64(lifetime)*client.ReservationRefreshMin)-slack, "peer %s", p)
+		require.LessOrEqual(t, refresh, time.Duration(float64(lifetime)*client.ReservationRefreshMax)+slack, "peer %s", p)
+	}
+}
+
+func TestListenReservationFailure(t *testing.T) {
+	relay, reservations := newMockRelay(t, pbv2.Status_PERMISSION_DENIED, time.Hour)
+
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer h.Close()
+
+	err = h.Network().Listen(relayCircuitAddr(t, relay))
+	require.ErrorContains(t, err, "reservation failed")
+	require.Equal(t, int32(1), reservations.Load())
+}
+
+func subscribeReservationLost(t *testing.T, h host.Host) event.Subscription {
+	t.Helper()
+	sub, err := h.EventBus().Subscribe(new(event.EvtRelayReservationLost))
+	require.NoError(t, err)
+	t.Cleanup(func() { sub.Close() })
+	return sub
+}
+
+func requireReservationLostOnce(t *testing.T, sub event.Subscription, relay peer.ID, reason event.ReservationLostReason) {
+	t.Helper()
+	select {
+	case e := <-sub.Out():
+		evt := e.(event.EvtRelayReservationLost)
+		require.Equal(t, relay, evt.Relay)
+		require.Equal(t, reason, evt.Reason)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a reservation lost event")
+	}
+	select {
+	case e := <-sub.Out():
+		t.Fatalf("unexpected event: %v", e)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestReservationLostOnDisconnect(t *testing.T) {
+	relay, _ := newMockRelay(t, pbv2.Status_OK, time.Hour)
+
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer h.Close()
+	sub := subscribeReservationLost(t, h)
+
+	require.NoError(t, h.Network().Listen(relayCircuitAddr(t, relay)))
+	require.NoError(t, h.Network().ClosePeer(relay.ID()))
+	requireReservationLostOnce(t, sub, relay.ID(), event.ReservationRelayDisconnected)
+}
+
+func TestReservationLostOnExpiry(t *testing.T) {
+	defer func(d time.Duration) { client.ReservationRetryInterval = d }(client.ReservationRetryInterval)
+	client.ReservationRetryInterval = 100 * time.Millisecond
+
+	relay, reservations := newMockRelay(t, pbv2.Status_OK, 2*time.Second)
+
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer h.Close()
+	sub := subscribeReservationLost(t, h)
+
+	require.NoError(t, h.Network().Listen(relayCircuitAddr(t, relay)))
+	require.Equal(t, int32(1), reservations.Load())
+	// Refreshing the reservation fails from now on.
+	relay.SetStreamHandler(proto.ProtoIDv2Hop, func(s network.Stream) { s.Reset() })
+	requireReservationLostOnce(t, sub, relay.ID(), event.ReservationExpired)
+}
+
+// setupUnresponsiveRelay returns a client connected to a relay that never
+// answers CONNECT requests, and a circuit address through that relay.
+func setupUnresponsiveRelay(t *testing.T, opts ...client.Option) (*client.Client, ma.Multiaddr, peer.ID) {
+	t.Helper()
+	relay, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	t.Cleanup(func() { relay.Close() })
+	relay.SetStreamHandler(proto.ProtoIDv2Hop, func(s network.Stream) {
+		io.Copy(io.Discard, s)
+	})
+
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	require.NoError(t, h.Connect(context.Background(), peer.AddrInfo{ID: relay.ID(), Addrs: relay.Addrs()}))
+
+	cl, err := client.New(h, nil, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { cl.Close() })
+
+	target, err := test.RandPeerID()
+	require.NoError(t, err)
+	return cl, ma.StringCast("/p2p/" + relay.ID().String() + "/p2p-circuit/p2p/" + target.String()), target
+}
+
+func TestDialRespectsContextDeadline(t *testing.T) {
+	cl, addr, target := setupUnresponsiveRelay(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := cl.Dial(ctx, addr, target)
+	require.Error(t, err)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestDialAbortsOnCancel(t *testing.T) {
+	cl, addr, target := setupUnresponsiveRelay(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := cl.Dial(ctx, addr, target)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestStopHandshakeTimeout(t *testing.T) {
+	cl, addr, target := setupUnresponsiveRelay(t, client.WithStopHandshakeTimeout(200*time.Millisecond))
+
+	start := time.Now()
+	_, err := cl.Dial(context.Background(), addr, target)
+	require.ErrorContains(t, err, "STOP handshake")
+	require.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	require.Less(t, time.Since(start), 2*time.Second)
+
+	_, err = client.New(nil, nil, client.WithStopHandshakeTimeout(0))
+	require.Error(t, err)
+}
+
+func TestSkipResolve(t *testing.T) {
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer h.Close()
+	addr := ma.StringCast("/dns4/relay.example.com/tcp/1/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit")
+
+	cl, err := client.New(h, nil)
+	require.NoError(t, err)
+	defer cl.Close()
+	require.True(t, cl.SkipResolve(context.Background(), addr))
+
+	cl, err = client.New(h, nil, client.WithSkipResolve(false))
+	require.NoError(t, err)
+	defer cl.Close()
+	require.False(t, cl.SkipResolve(context.Background(), addr))
+}
+
+func TestCanDial(t *testing.T) {
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer h.Close()
+	cl, err := client.New(h, nil)
+	require.NoError(t, err)
+	defer cl.Close()
+
+	relay := test.RandPeerIDFatal(t)
+	target := test.RandPeerIDFatal(t)
+	for _, tc := range []struct {
+		name    string
+		addr    string
+		canDial bool
+	}{
+		{"full", fmt.Sprintf("/ip4/1.2.3.4/tcp/1/p2p/%s/p2p-circuit/p2p/%s", relay, target), true},
+		{"no target", fmt.Sprintf("/ip4/1.2.3.4/udp/1/quic-v1/p2p/%s/p2p-circuit", relay), true},
+		{"relay ID only", fmt.Sprintf("/p2p/%s/p2p-circuit/p2p/%s", relay, target), true},
+		{"not relayed", fmt.Sprintf("/ip4/1.2.3.4/tcp/1/p2p/%s", target), false},
+		{"no relay", fmt.Sprintf("/p2p-circuit/p2p/%s", target), false},
+		{"no relay ID", fmt.Sprintf("/ip4/1.2.3.4/tcp/1/p2p-circuit/p2p/%s", target), false},
+		{"address after circuit", fmt.Sprintf("/p2p/%s/p2p-circuit/ip4/5.6.7.8/tcp/2", relay), false},
+		{"two targets", fmt.Sprintf("/p2p/%s/p2p-circuit/p2p/%s/p2p/%s", relay, target, target), false},
+		{"nested circuit", fmt.Sprintf("/p2p/%s/p2p-circuit/p2p/%s/p2p-circuit", relay, target), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := ma.StringCast(tc.addr)
+			require.Equal(t, tc.canDial, cl.CanDial(addr))
+			if !tc.canDial {
+				_, err := cl.Dial(context.Background(), addr, target)
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer h.Close()
+	cl, err := client.New(h, nil)
+	require.NoError(t, err)
+	require.NoError(t, cl.Close())
+	require.NoError(t, cl.Close())
+}
+
+// wrappedHost overrides the network of a host.
+type wrappedHost struct {
+	host.Host
+	net network.Network
+}
+
+func (h *wrappedHost) Network() network.Network { return h.net }
+
+// plainNetwork hides the transport.TransportNetwork methods of a network.
+type plainNetwork struct{ network.Network }
+
+// failingListenNetwork is a transport network that fails every Listen call.
+type failingListenNetwork struct{ transport.TransportNetwork }
+
+func (n *failingListenNetwork) Listen(...ma.Multiaddr) error { return errors.New("listen failed") }
+
+func TestAddTransportErrors(t *testing.T) {
+	newHost := func(t *testing.T) host.Host {
+		h, err := libp2p.New(libp2p.DisableRelay(), libp2p.ResourceManager(&network.NullResourceManager{}))
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+
+	t.Run("not a transport network", func(t *testing.T) {
+		h := newHost(t)
+		err := client.AddTransport(&wrappedHost{Host: h, net: &plainNetwork{h.Network()}}, nil)
+		require.ErrorIs(t, err, client.ErrNotTransportNetwork)
+		require.ErrorContains(t, err, "is not a transport network")
+	})
+
+	t.Run("construct", func(t *testing.T) {
+		optErr := errors.New("bad option")
+		err := client.AddTransport(newHost(t), nil, func(*client.Client) error { return optErr })
+		require.ErrorIs(t, err, client.ErrConstructClient)
+		require.ErrorIs(t, err, optErr)
+	})
+
+	t.Run("add transport", func(t *testing.T) {
+		// The default host already has a circuit transport.
+		h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+		require.NoError(t, err)
+		defer h.Close()
+		err = client.AddTransport(h, nil)
+		require.ErrorIs(t, err, client.ErrAddTransport)
+		require.ErrorContains(t, err, "error adding circuit transport: transports already registered")
+	})
+
+	t.Run("listen", func(t *testing.T) {
+		h := newHost(t)
+		n := &failingListenNetwork{h.Network().(transport.TransportNetwork)}
+		err := client.AddTransport(&wrappedHost{Host: h, net: n}, nil)
+		require.ErrorIs(t, err, client.ErrListenCircuit)
+		require.EqualError(t, err, "error listening to circuit addr: listen failed")
+	})
+}
+
+// circuitClient returns the circuit client transport of h.
+func circuitClient(t *testing.T, h host.Host) *client.Client {
+	t.Helper()
+	tpt := h.Network().(*swarm.Swarm).TransportForDialing(ma.StringCast("/p2p/" + h.ID().String() + "/p2p-circuit"))
+	cl, ok := tpt.(*client.Client)
+	require.True(t, ok)
+	return cl
+}
+
+func TestDialVia(t *testing.T) {
+	newHost := func(t *testing.T) host.Host {
+		h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	connect := func(t *testing.T, a, b host.Host) {
+		require.NoError(t, a.Connect(context.Background(), peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()}))
+	}
+
+	badRelay := newHost(t)
+	badRelay.SetStreamHandler(proto.ProtoIDv2Hop, func(s network.Stream) { s.Reset() })
+	goodRelay := newHost(t)
+	r, err := relay.New(goodRelay)
+	require.NoError(t, err)
+	defer r.Close()
+
+	target := newHost(t)
+	connect(t, target, goodRelay)
+	_, err = client.Reserve(context.Background(), target, peer.AddrInfo{ID: goodRelay.ID(), Addrs: goodRelay.Addrs()})
+	require.NoError(t, err)
+
+	dialer := newHost(t)
+	connect(t, dialer, badRelay)
+	connect(t, dialer, goodRelay)
+	cl := circuitClient(t, dialer)
+
+	t.Run("first relay fails", func(t *testing.T) {
+		conn, err := cl.DialVia(context.Background(), []peer.ID{badRelay.ID(), goodRelay.ID()}, target.ID())
+		require.NoError(t, err)
+		defer conn.Close()
+		require.Equal(t, target.ID(), conn.RemotePeer())
+		relayID, err := conn.RemoteMultiaddr().ValueForProtocol(ma.P_P2P)
+		require.NoError(t, err)
+		require.Equal(t, goodRelay.ID().String(), relayID)
+		rc, ok := conn.(client.RelayedConn)
+		require.True(t, ok)
+		require.Equal(t, goodRelay.ID(), rc.Relay())
+	})
+
+	t.Run("all relays fail", func(t *testing.T) {
+		other, err := test.RandPeerID()
+		require.NoError(t, err)
+		_, err = cl.DialVia(context.Background(), []peer.ID{badRelay.ID(), goodRelay.ID()}, other)
+		require.ErrorContains(t, err, "relay "+badRelay.ID().String())
+		require.ErrorContains(t, err, "relay "+goodRelay.ID().String())
+	})
+}
+
+func TestConnLimit(t *testing.T) {
+	newHost := func(t *testing.T) host.Host {
+		h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	connect := func(t *testing.T, a, b host.Host) {
+		require.NoError(t, a.Connect(context.Background(), peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()}))
+	}
+	dialThroughRelay := func(t *testing.T, opts ...relay.Option) transport.CapableConn {
+		relayHost := newHost(t)
+		r, err := relay.New(relayHost, opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() { r.Close() })
+
+		target := newHost(t)
+		connect(t, target, relayHost)
+		_, err = client.Reserve(context.Background(), target, peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()})
+		require.NoError(t, err)
+
+		dialer := newHost(t)
+		connect(t, dialer, relayHost)
+		conn, err := circuitClient(t, dialer).DialVia(context.Background(), []peer.ID{relayHost.ID()}, target.ID())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	t.Run("limited", func(t *testing.T) {
+		conn := dialThroughRelay(t, relay.WithLimit(&relay.RelayLimit{Duration: time.Minute, Data: 1 << 20}))
+		lc, ok := conn.(client.LimitedConn)
+		require.True(t, ok)
+		require.Equal(t, &client.Limit{Duration: time.Minute, Data: 1 << 20}, lc.Limit())
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		conn := dialThroughRelay(t, relay.WithInfiniteLimits())
+		lc, ok := conn.(client.LimitedConn)
+		require.True(t, ok)
+		require.Nil(t, lc.Limit())
+	})
+}
+
+// blockingUpgrader blocks upgrades until unblock is closed.
+type blockingUpgrader struct {
+	transport.Upgrader
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (u *blockingUpgrader) Upgrade(ctx context.Context, t transport.Transport, maconn manet.Conn, dir network.Direction, p peer.ID, scope network.ConnManagementScope) (transport.CapableConn, error) {
+	close(u.started)
+	<-u.unblock
+	return u.Upgrader.Upgrade(ctx, t, maconn, dir, p, scope)
+}
+
+func TestCloseWithContext(t *testing.T) {
+	newHost := func(t *testing.T, opts ...libp2p.Option) host.Host {
+		h, err := libp2p.New(append(opts, libp2p.ResourceManager(&network.NullResourceManager{}))...)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	connect := func(t *testing.T, a, b host.Host) {
+		require.NoError(t, a.Connect(context.Background(), peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()}))
+	}
+
+	relayHost := newHost(t)
+	r, err := relay.New(relayHost)
+	require.NoError(t, err)
+	defer r.Close()
+
+	// The target uses plaintext, to match the upgrader generated for the dialer.
+	target := newHost(t, libp2p.NoSecurity)
+	connect(t, target, relayHost)
+	_, err = client.Reserve(context.Background(), target, peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()})
+	require.NoError(t, err)
+
+	dialer := newHost(t)
+	connect(t, dialer, relayHost)
+	newClient := func(t *testing.T) (*client.Client, *blockingUpgrader) {
+		u := &blockingUpgrader{
+			Upgrader: swarmt.GenUpgrader(t, dialer.Network().(*swarm.Swarm), nil),
+			started:  make(chan struct{}),
+			unblock:  make(chan struct{}),
+		}
+		cl, err := client.New(dialer, u)
+		require.NoError(t, err)
+		return cl, u
+	}
+	startDial := func(cl *client.Client) <-chan error {
+		dialed := make(chan error, 1)
+		go func() {
+			conn, err := cl.DialVia(context.Background(), []peer.ID{relayHost.ID()}, target.ID())
+			if err == nil {
+				conn.Close()
+			}
+			dialed <- err
+		}()
+		return dialed
+	}
+
+	t.Run("waits for in-flight dials", func(t *testing.T) {
+		cl, u := newClient(t)
+		dialed := startDial(cl)
+		<-u.started
+
+		closed := make(chan error, 1)
+		go func() { closed <- cl.CloseWithContext(context.Background()) }()
+
+		// new dials are rejected while closing.
+		relayAddr := ma.StringCast(fmt.Sprintf("/p2p/%s/p2p-circuit", relayHost.ID()))
+		require.Eventually(t, func() bool {
+			_, err := cl.Dial(context.Background(), relayAddr, target.ID())
+			return errors.Is(err, client.ErrClientClosed)
+		}, time.Second, 10*time.Millisecond)
+		_, err := cl.Listen(relayAddr)
+		require.ErrorIs(t, err, client.ErrClientClosed)
+
+		select {
+		case <-closed:
+			t.Fatal("closed before the in-flight dial completed")
+		case <-time.After(100 * time.Millisecond):
+		}
+		close(u.unblock)
+		require.NoError(t, <-dialed)
+		require.NoError(t, <-closed)
+	})
+
+	t.Run("context expires", func(t *testing.T) {
+		cl, u := newClient(t)
+		dialed := startDial(cl)
+		<-u.started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, cl.CloseWithContext(ctx), context.DeadlineExceeded)
+		// closing again doesn't wait for the dial.
+		require.NoError(t, cl.Close())
+		close(u.unblock)
+		<-dialed
+	})
+}
+
+func TestListenWithReservationVoucher(t *testing.T) {
+	relay, issueVoucher := newVoucherRelay(t)
+	addr := relayCircuitAddr(t, relay)
+
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer h.Close()
+	upgrader := swarmt.GenUpgrader(t, h.Network().(*swarm.Swarm), nil)
+
+	t.Run("without voucher", func(t *testing.T) {
+		cl, err := client.New(h, upgrader)
+		require.NoError(t, err)
+		defer cl.Close()
+		_, err = cl.Listen(addr)
+		var re client.ReservationError
+		require.ErrorAs(t, err, &re)
+		require.Equal(t, pbv2.Status_PERMISSION_DENIED, re.Status)
+	})
+
+	t.Run("with voucher", func(t *testing.T) {
+		cl, err := client.New(h, upgrader, client.WithReservationVoucher(relay.ID(), issueVoucher(h.ID())))
+		require.NoError(t, err)
+		defer cl.Close()
+		ln, err := cl.Listen(addr)
+		require.NoError(t, err)
+		defer ln.Close()
+		require.Equal(t, addr, ln.Multiaddr())
+	})
+
+	t.Run("empty voucher", func(t *testing.T) {
+		_, err := client.New(h, upgrader, client.WithReservationVoucher(relay.ID(), nil))
+		require.ErrorContains(t, err, "reservation voucher must not be empty")
+	})
+}
+
+func TestReservationExpiry(t *testing.T) {
+	relay, _ := newMockRelay(t, pbv2.Status_OK, time.Hour)
+
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer h.Close()
+	cl, err := client.New(h, swarmt.GenUpgrader(t, h.Network().(*swarm.Swarm), nil))
+	require.NoError(t, err)
+	defer cl.Close()
+
+	_, ok := cl.ReservationExpiry(relay.ID())
+	require.False(t, ok)
+
+	ln, err := cl.Listen(relayCircuitAddr(t, relay))
+	require.NoError(t, err)
+	expiry, ok := cl.ReservationExpiry(relay.ID())
+	require.True(t, ok)
+	// The relay grants the reservation for an hour, with a precision of seconds.
+	require.WithinDuration(t, time.Now().Add(time.Hour), expiry, 2*time.Second)
+
+	// Closing the listener gives up the reservation.
+	require.NoError(t, ln.Close())
+	require.Eventually(t, func() bool {
+		_, ok := cl.ReservationExpiry(relay.ID())
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDialReusesRelayConn(t *testing.T) {
+	newHost := func(t *testing.T) host.Host {
+		h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	relayHost := newHost(t)
+	r, err := relay.New(relayHost)
+	require.NoError(t, err)
+	defer r.Close()
+	relayInfo := peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()}
+
+	var targets []host.Host
+	for range 2 {
+		target := newHost(t)
+		require.NoError(t, target.Connect(context.Background(), relayInfo))
+		_, err := client.Reserve(context.Background(), target, relayInfo)
+		require.NoError(t, err)
+		targets = append(targets, target)
+	}
+
+	dialer := newHost(t)
+	dialer.Peerstore().AddAddrs(relayHost.ID(), relayHost.Addrs(), time.Hour)
+	cl := circuitClient(t, dialer)
+
+	conns := make([]transport.CapableConn, len(targets))
+	errs := make(chan error, len(targets))
+	for i, target := range targets {
+		go func() {
+			var err error
+			conns[i], err = cl.DialVia(context.Background(), []peer.ID{relayHost.ID()}, target.ID())
+			errs <- err
+		}()
+	}
+	for range targets {
+		require.NoError(t, <-errs)
+	}
+	require.Len(t, dialer.Network().ConnsToPeer(relayHost.ID()), 1)
+
+	// The relay connection is protected as long as a circuit uses it, and is
+	// left to the connection manager afterwards.
+	require.True(t, dialer.ConnManager().IsProtected(relayHost.ID(), ""))
+	require.NoError(t, conns[0].Close())
+	require.True(t, dialer.ConnManager().IsProtected(relayHost.ID(), ""))
+	require.NoError(t, conns[1].Close())
+	require.False(t, dialer.ConnManager().IsProtected(relayHost.ID(), ""))
+	require.Len(t, dialer.Network().ConnsToPeer(relayHost.ID()), 1)
+}
+
+// stallingMuxer never completes setting up a muxed connection, until the
+// underlying connection is closed.
+type stallingMuxer struct{}
+
+func (stallingMuxer) NewConn(c net.Conn, _ bool, _ network.PeerScope) (network.MuxedConn, error) {
+	io.Copy(io.Discard, c)
+	return nil, errors.New("muxer stalled")
+}
+
+func TestUpgradeTimeout(t *testing.T) {
+	newHost := func(t *testing.T, opts ...libp2p.Option) host.Host {
+		h, err := libp2p.New(append(opts, libp2p.ResourceManager(&network.NullResourceManager{}))...)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	relayHost := newHost(t)
+	r, err := relay.New(relayHost)
+	require.NoError(t, err)
+	defer r.Close()
+	relayInfo := peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()}
+
+	// The target uses plaintext, to match the upgrader of the dialer.
+	target := newHost(t, libp2p.NoSecurity)
+	require.NoError(t, target.Connect(context.Background(), relayInfo))
+	_, err = client.Reserve(context.Background(), target, relayInfo)
+	require.NoError(t, err)
+
+	dialer := newHost(t)
+	require.NoError(t, dialer.Connect(context.Background(), relayInfo))
+	priv := dialer.Peerstore().PrivKey(dialer.ID())
+	upgrader, err := tptu.New(
+		[]sec.SecureTransport{insecure.NewWithIdentity(insecure.ID, dialer.ID(), priv)},
+		[]tptu.StreamMuxer{{ID: yamux.ID, Muxer: stallingMuxer{}}},
+		nil, nil, nil,
+	)
+	require.NoError(t, err)
+	cl, err := client.New(dialer, upgrader, client.WithUpgradeTimeout(200*time.Millisecond))
+	require.NoError(t, err)
+	defer cl.Close()
+
+	start := time.Now()
+	_, err = cl.DialVia(context.Background(), []peer.ID{relayHost.ID()}, target.ID())
+	var timeoutErr *client.UpgradeTimeoutError
+	require.ErrorAs(t, err, &timeoutErr)
+	require.Equal(t, target.ID(), timeoutErr.Peer)
+	require.Equal(t, 200*time.Millisecond, timeoutErr.Timeout)
+	require.Less(t, time.Since(start), 5*time.Second)
+
+	_, err = client.New(dialer, upgrader, client.WithUpgradeTimeout(0))
+	require.ErrorContains(t, err, "upgrade timeout must be positive")
+}
+
+func TestRelayIDDiscovery(t *testing.T) {
+	newHost := func(t *testing.T, opts ...libp2p.Option) host.Host {
+		h, err := libp2p.New(append(opts, libp2p.ResourceManager(&network.NullResourceManager{}))...)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	relayHost := newHost(t, libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0", "/ip4/127.0.0.1/udp/0/quic-v1"))
+	r, err := relay.New(relayHost)
+	require.NoError(t, err)
+	defer r.Close()
+	relayInfo := peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()}
+
+	// The target uses plaintext, to match the upgrader of the dialer. It
+	// connects to the relay over QUIC, which secures connections itself.
+	target := newHost(t, libp2p.NoSecurity)
+	require.NoError(t, target.Connect(context.Background(), relayInfo))
+	_, err = client.Reserve(context.Background(), target, relayInfo)
+	require.NoError(t, err)
+
+	newDialer := func(t *testing.T) (host.Host, transport.Upgrader) {
+		dialer := newHost(t)
+		priv := dialer.Peerstore().PrivKey(dialer.ID())
+		upgrader, err := tptu.New(
+			[]sec.SecureTransport{insecure.NewWithIdentity(insecure.ID, dialer.ID(), priv)},
+			[]tptu.StreamMuxer{{ID: yamux.ID, Muxer: yamux.DefaultTransport}},
+			nil, nil, nil,
+		)
+		require.NoError(t, err)
+		return dialer, upgrader
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		dialer, upgrader := newDialer(t)
+		cl, err := client.New(dialer, upgrader)
+		require.NoError(t, err)
+		defer cl.Close()
+		addr := relayHost.Addrs()[0].Encapsulate(ma.StringCast("/p2p-circuit/p2p/" + target.ID().String()))
+		require.False(t, cl.CanDial(addr))
+		_, err = cl.Dial(context.Background(), addr, target.ID())
+		require.ErrorContains(t, err, "relay address has no relay peer ID")
+	})
+
+	for _, relayAddr := range relayHost.Addrs() {
+		t.Run(relayAddr.String(), func(t *testing.T) {
+			dialer, upgrader := newDialer(t)
+			cl, err := client.New(dialer, upgrader, client.WithRelayIDDiscovery())
+			require.NoError(t, err)
+			defer cl.Close()
+			addr := relayAddr.Encapsulate(ma.StringCast("/p2p-circuit/p2p/" + target.ID().String()))
+			require.True(t, cl.CanDial(addr))
+			conn, err := cl.Dial(context.Background(), addr, target.ID())
+			require.NoError(t, err)
+			defer conn.Close()
+			require.Equal(t, target.ID(), conn.RemotePeer())
+			relayID, err := conn.RemoteMultiaddr().ValueForProtocol(ma.P_P2P)
+			require.NoError(t, err)
+			require.Equal(t, relayHost.ID().String(), relayID)
+			require.Contains(t, dialer.Peerstore().Addrs(relayHost.ID()), relayAddr)
+			// The connection used to learn the relay's peer ID is dialed through.
+			require.Len(t, dialer.Network().ConnsToPeer(relayHost.ID()), 1)
+		})
+	}
+}
+
+func TestMaxConns(t *testing.T) {
+	newHost := func(t *testing.T, opts ...libp2p.Option) host.Host {
+		h, err := libp2p.New(append(opts, libp2p.ResourceManager(&network.NullResourceManager{}))...)
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	relayHost := newHost(t)
+	r, err := relay.New(relayHost, relay.WithInfiniteLimits())
+	require.NoError(t, err)
+	defer r.Close()
+	relayInfo := peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()}
+
+	// The targets use plaintext, to match the upgrader of the dialer.
+	var targets []host.Host
+	for range 3 {
+		target := newHost(t, libp2p.NoSecurity)
+		require.NoError(t, target.Connect(context.Background(), relayInfo))
+		_, err := client.Reserve(context.Background(), target, relayInfo)
+		require.NoError(t, err)
+		targets = append(targets, target)
+	}
+
+	dialer := newHost(t)
+	require.NoError(t, dialer.Connect(context.Background(), relayInfo))
+	priv := dialer.Peerstore().PrivKey(dialer.ID())
+	upgrader, err := tptu.New(
+		[]sec.SecureTransport{insecure.NewWithIdentity(insecure.ID, dialer.ID(), priv)},
+		[]tptu.StreamMuxer{{ID: yamux.ID, Muxer: yamux.DefaultTransport}},
+		nil, nil, nil,
+	)
+	require.NoError(t, err)
+	cl, err := client.New(dialer, upgrader, client.WithMaxConns(2))
+	require.NoError(t, err)
+	defer cl.Close()
+
+	var conns []transport.CapableConn
+	for _, target := range targets[:2] {
+		conn, err := cl.DialVia(context.Background(), []peer.ID{relayHost.ID()}, target.ID())
+		require.NoError(t, err)
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+
+	_, err = cl.DialVia(context.Background(), []peer.ID{relayHost.ID()}, targets[2].ID())
+	var tooManyErr *client.TooManyConnsError
+	require.ErrorAs(t, err, &tooManyErr)
+	require.Equal(t, 2, tooManyErr.Limit)
+
+	// Closing a connection frees its slot.
+	require.NoError(t, conns[0].Close())
+	conn, err := cl.DialVia(context.Background(), []peer.ID{relayHost.ID()}, targets[2].ID())
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	_, err = client.New(dialer, upgrader, client.WithMaxConns(0))
+	require.ErrorContains(t, err, "max relayed connections must be positive")
+}
+
+// capturingUpgrader hands out the relayed connections it is asked to upgrade,
+// and fails the upgrade once ctx is done.
+type capturingUpgrader struct {
+	transport.Upgrader
+	conns chan manet.Conn
+}
+
+func (u *capturingUpgrader) Upgrade(ctx context.Context, _ transport.Transport, maconn manet.Conn, _ network.Direction, _ peer.ID, _ network.ConnManagementScope) (transport.CapableConn, error) {
+	u.conns <- maconn
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestHalfClose(t *testing.T) {
+	newHost := func(t *testing.T) host.Host {
+		h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	relayHost := newHost(t)
+	r, err := relay.New(relayHost, relay.WithInfiniteLimits())
+	require.NoError(t, err)
+	defer r.Close()
+	relayInfo := peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()}
+
+	// The target's client only accepts the relayed connection, without upgrading it.
+	target := newHost(t)
+	require.NoError(t, target.Connect(context.Background(), relayInfo))
+	_, err = client.Reserve(context.Background(), target, relayInfo)
+	require.NoError(t, err)
+	targetClient, err := client.New(target, nil)
+	require.NoError(t, err)
+	defer targetClient.Close()
+	targetClient.Start()
+
+	dialer := newHost(t)
+	require.NoError(t, dialer.Connect(context.Background(), relayInfo))
+	upgrader := &capturingUpgrader{conns: make(chan manet.Conn, 1)}
+	cl, err := client.New(dialer, upgrader)
+	require.NoError(t, err)
+	defer cl.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dialErr := make(chan error, 1)
+	go func() {
+		_, err := cl.DialVia(ctx, []peer.ID{relayHost.ID()}, target.ID())
+		dialErr <- err
+	}()
+	defer func() {
+		cancel()
+		require.Error(t, <-dialErr)
+	}()
+	// The dial completes once the target accepted the connection.
+	accepted, err := targetClient.Listener().Accept()
+	require.NoError(t, err)
+	defer accepted.Close()
+	conn := <-upgrader.conns
+
+	_, err = conn.Write([]byte("request"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*client.Conn).CloseWrite())
+	req, err := io.ReadAll(accepted)
+	require.NoError(t, err)
+	require.Equal(t, "request", string(req))
+
+	// The other direction is still open.
+	_, err = accepted.Write([]byte("response"))
+	require.NoError(t, err)
+	require.NoError(t, accepted.(*client.Conn).CloseWrite())
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "response", string(resp))
+}
+
+// newStatusRelay returns a host that answers circuit requests with statuses,
+// one per request, and with OK once they are used up. It counts the requests.
+func newStatusRelay(t *testing.T, statuses ...pbv2.Status) (host.Host, *atomic.Int32) {
+	t.Helper()
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+
+	var requests atomic.Int32
+	h.SetStreamHandler(proto.ProtoIDv2Hop, func(s network.Stream) {
+		rd := util.NewDelimitedReader(s, 4096)
+		defer rd.Close()
+		var msg pbv2.HopMessage
+		if err := rd.ReadMsg(&msg); err != nil || msg.GetType() != pbv2.HopMessage_CONNECT {
+			s.Reset()
+			return
+		}
+		status := pbv2.Status_OK
+		if n := int(requests.Add(1)); n <= len(statuses) {
+			status = statuses[n-1]
+		}
+		util.NewDelimitedWriter(s).WriteMsg(&pbv2.HopMessage{Type: pbv2.HopMessage_STATUS.Enum(), Status: status.Enum()})
+		if status != pbv2.Status_OK {
+			s.Close()
+		}
+	})
+	return h, &requests
+}
+
+// failingUpgrader fails all upgrades with err.
+type failingUpgrader struct {
+	transport.Upgrader
+	err error
+}
+
+func (u *failingUpgrader) Upgrade(context.Context, transport.Transport, manet.Conn, network.Direction, peer.ID, network.ConnManagementScope) (transport.CapableConn, error) {
+	return nil, u.err
+}
+
+func TestConnectRetries(t *testing.T) {
+	errUpgrade := errors.New("upgrade attempted")
+	retries := []client.Option{client.WithConnectRetries(2, 10*time.Millisecond)}
+	dial := func(t *testing.T, opts []client.Option, statuses ...pbv2.Status) (int, error) {
+		relay, requests := newStatusRelay(t, statuses...)
+		h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+		require.NoError(t, err)
+		defer h.Close()
+		require.NoError(t, h.Connect(context.Background(), peer.AddrInfo{ID: relay.ID(), Addrs: relay.Addrs()}))
+		cl, err := client.New(h, &failingUpgrader{err: errUpgrade}, opts...)
+		require.NoError(t, err)
+		defer cl.Close()
+
+		target, err := test.RandPeerID()
+		require.NoError(t, err)
+		_, err = cl.DialVia(context.Background(), []peer.ID{relay.ID()}, target)
+		return int(requests.Load()), err
+	}
+
+	t.Run("transient errors", func(t *testing.T) {
+		requests, err := dial(t, retries, pbv2.Status_RESOURCE_LIMIT_EXCEEDED, pbv2.Status_CONNECTION_FAILED)
+		// The circuit was opened on the third attempt.
+		require.ErrorIs(t, err, errUpgrade)
+		require.Equal(t, 3, requests)
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		requests, err := dial(t, retries, pbv2.Status_RESOURCE_LIMIT_EXCEEDED, pbv2.Status_RESOURCE_LIMIT_EXCEEDED, pbv2.Status_RESOURCE_LIMIT_EXCEEDED)
+		require.ErrorContains(t, err, "RESOURCE_LIMIT_EXCEEDED")
+		require.Equal(t, 3, requests)
+	})
+
+	t.Run("permanent error", func(t *testing.T) {
+		requests, err := dial(t, retries, pbv2.Status_PERMISSION_DENIED)
+		require.ErrorContains(t, err, "PERMISSION_DENIED")
+		require.Equal(t, 1, requests)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		requests, err := dial(t, nil, pbv2.Status_RESOURCE_LIMIT_EXCEEDED)
+		require.ErrorContains(t, err, "RESOURCE_LIMIT_EXCEEDED")
+		require.Equal(t, 1, requests)
+	})
+
+	_, err := client.New(nil, nil, client.WithConnectRetries(1, 0))
+	require.ErrorContains(t, err, "connect retry backoff must be positive")
+}
+
+func TestConnectMetadata(t *testing.T) {
+	relay, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer relay.Close()
+	metadata := make(chan []byte, 1)
+	relay.SetStreamHandler(proto.ProtoIDv2Hop, func(s network.Stream) {
+		defer s.Close()
+		rd := util.NewDelimitedReader(s, 4096)
+		defer rd.Close()
+		var msg pbv2.HopMessage
+		if err := rd.ReadMsg(&msg); err != nil || msg.GetType() != pbv2.HopMessage_CONNECT {
+			s.Reset()
+			return
+		}
+		metadata <- msg.GetMetadata()
+		util.NewDelimitedWriter(s).WriteMsg(&pbv2.HopMessage{Type: pbv2.HopMessage_STATUS.Enum(), Status: pbv2.Status_PERMISSION_DENIED.Enum()})
+	})
+
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer h.Close()
+	require.NoError(t, h.Connect(context.Background(), peer.AddrInfo{ID: relay.ID(), Addrs: relay.Addrs()}))
+	target, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	dial := func(t *testing.T, opts ...client.Option) []byte {
+		cl, err := client.New(h, nil, opts...)
+		require.NoError(t, err)
+		defer cl.Close()
+		_, err = cl.DialVia(context.Background(), []peer.ID{relay.ID()}, target)
+		require.ErrorContains(t, err, "PERMISSION_DENIED")
+		return <-metadata
+	}
+
+	t.Run("with metadata", func(t *testing.T) {
+		require.Equal(t, []byte("tenant-1"), dial(t, client.WithConnectMetadata([]byte("tenant-1"))))
+	})
+
+	t.Run("without metadata", func(t *testing.T) {
+		require.Nil(t, dial(t))
+	})
+
+	_, err = client.New(nil, nil, client.WithConnectMetadata(make([]byte, client.MaxConnectMetadataSize+1)))
+	require.ErrorContains(t, err, "connect metadata exceeds")
+	_, err = client.New(nil, nil, client.WithConnectMetadata(nil))
+	require.ErrorContains(t, err, "connect metadata must not be empty")
+}
+
+func TestIOTimeouts(t *testing.T) {
+	newHost := func(t *testing.T) host.Host {
+		h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		return h
+	}
+	relayHost := newHost(t)
+	r, err := relay.New(relayHost)
+	require.NoError(t, err)
+	defer r.Close()
+	relayInfo := peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()}
+
+	// The target uses plaintext, to match the upgrader of the dialer.
+	target, err := libp2p.New(libp2p.NoSecurity, libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer target.Close()
+	require.NoError(t, target.Connect(context.Background(), relayInfo))
+	_, err = client.Reserve(context.Background(), target, relayInfo)
+	require.NoError(t, err)
+
+	dialer := newHost(t)
+	require.NoError(t, dialer.Connect(context.Background(), relayInfo))
+	priv := dialer.Peerstore().PrivKey(dialer.ID())
+	upgrader, err := tptu.New(
+		[]sec.SecureTransport{insecure.NewWithIdentity(insecure.ID, dialer.ID(), priv)},
+		[]tptu.StreamMuxer{{ID: yamux.ID, Muxer: yamux.DefaultTransport}},
+		nil, nil, nil,
+	)
+	require.NoError(t, err)
+	cl, err := client.New(dialer, upgrader, client.WithIOTimeouts(100*time.Millisecond, 0))
+	require.NoError(t, err)
+	defer cl.Close()
+
+	conn, err := cl.DialVia(context.Background(), []peer.ID{relayHost.ID()}, target.ID())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The target waits for the stream's protocol to be proposed, so the
+	// stream becomes idle once the target sent the multistream header.
+	s, err := conn.OpenStream(context.Background())
+	require.NoError(t, err)
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, s)
+		readErr <- err
+	}()
+	select {
+	case err := <-readErr:
+		var nerr net.Error
+		require.ErrorAs(t, err, &nerr)
+		require.True(t, nerr.Timeout())
+	case <-time.After(5 * time.Second):
+		t.Fatal("read didn't time out")
+	}
+	// Only the stream timed out, not the relayed connection.
+	require.False(t, conn.IsClosed())
+
+	// Explicit deadlines take precedence.
+	s, err = conn.OpenStream(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, s.SetReadDeadline(time.Now().Add(time.Hour)))
+	go func() {
+		_, err := io.Copy(io.Discard, s)
+		readErr <- err
+	}()
+	select {
+	case err := <-readErr:
+		t.Fatalf("read returned before the explicit deadline: %v", err)
+	case <-time.After(300 * time.Millisecond):
+	}
+	require.NoError(t, s.Reset())
+	require.Error(t, <-readErr)
+
+	_, err = client.New(nil, nil, client.WithIOTimeouts(-time.Second, 0))
+	require.ErrorContains(t, err, "must not be negative")
+}
+
+func TestSwitchRelayOnShutdown(t *testing.T) {
+	newRelay := func(t *testing.T) (host.Host, *relay.Relay) {
+		h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+		require.NoError(t, err)
+		t.Cleanup(func() { h.Close() })
+		r, err := relay.New(h)
+		require.NoError(t, err)
+		t.Cleanup(func() { r.Close() })
+		return h, r
+	}
+	oldRelayHost, oldRelay := newRelay(t)
+	newRelayHost, _ := newRelay(t)
+
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer h.Close()
+	sub, err := h.EventBus().Subscribe(new(event.EvtRelayReservationSwitched))
+	require.NoError(t, err)
+	defer sub.Close()
+	upgrader := swarmt.GenUpgrader(t, h.Network().(*swarm.Swarm), nil)
+	cl, err := client.New(h, upgrader, client.WithAlternateRelays(func(context.Context) []peer.AddrInfo {
+		return []peer.AddrInfo{
+			{ID: oldRelayHost.ID(), Addrs: oldRelayHost.Addrs()},
+			{ID: newRelayHost.ID(), Addrs: newRelayHost.Addrs()},
+		}
+	}))
+	require.NoError(t, err)
+	defer cl.Close()
+	cl.Start()
+	ln, err := cl.Listen(relayCircuitAddr(t, oldRelayHost))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	oldRelay.AnnounceShutdown(context.Background())
+	select {
+	case e := <-sub.Out():
+		evt := e.(event.EvtRelayReservationSwitched)
+		require.Equal(t, oldRelayHost.ID(), evt.From)
+		require.Equal(t, newRelayHost.ID(), evt.To)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a reservation switched event")
+	}
+	// The old relay is still connected.
+	require.Equal(t, network.Connected, h.Network().Connectedness(oldRelayHost.ID()))
+}
+
+// reverseSelector tries the candidates in reverse order, recording the
+// candidates it was passed.
+type reverseSelector struct {
+	candidates chan []peer.AddrInfo
+}
+
+func (s *reverseSelector) SelectRelay(candidates []peer.AddrInfo) []peer.AddrInfo {
+	s.candidates <- candidates
+	reversed := slices.Clone(candidates)
+	slices.Reverse(reversed)
+	return reversed
+}
+
+func TestRelaySelector(t *testing.T) {
+	oldRelayHost, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer oldRelayHost.Close()
+	oldRelay, err := relay.New(oldRelayHost)
+	require.NoError(t, err)
+	defer oldRelay.Close()
+	first, firstReservations := newMockRelay(t, pbv2.Status_OK, time.Hour)
+	second, secondReservations := newMockRelay(t, pbv2.Status_OK, time.Hour)
+	third, thirdReservations := newMockRelay(t, pbv2.Status_PERMISSION_DENIED, time.Hour)
+	alternates := []peer.AddrInfo{
+		{ID: oldRelayHost.ID(), Addrs: oldRelayHost.Addrs()},
+		{ID: first.ID(), Addrs: first.Addrs()},
+		{ID: second.ID(), Addrs: second.Addrs()},
+		{ID: third.ID(), Addrs: third.Addrs()},
+	}
+
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer h.Close()
+	sub, err := h.EventBus().Subscribe(new(event.EvtRelayReservationSwitched))
+	require.NoError(t, err)
+	defer sub.Close()
+	selector := &reverseSelector{candidates: make(chan []peer.AddrInfo, 1)}
+	cl, err := client.New(h, swarmt.GenUpgrader(t, h.Network().(*swarm.Swarm), nil),
+		client.WithAlternateRelays(func(context.Context) []peer.AddrInfo { return alternates }),
+		client.WithRelaySelector(selector),
+	)
+	require.NoError(t, err)
+	defer cl.Close()
+	cl.Start()
+	ln, err := cl.Listen(relayCircuitAddr(t, oldRelayHost))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	oldRelay.AnnounceShutdown(context.Background())
+	select {
+	case e := <-sub.Out():
+		// The third relay is tried first, but denies the reservation.
+		require.Equal(t, second.ID(), e.(event.EvtRelayReservationSwitched).To)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a reservation switched event")
+	}
+	require.Equal(t, alternates[1:], <-selector.candidates)
+	require.Equal(t, int32(1), thirdReservations.Load())
+	require.Equal(t, int32(1), secondReservations.Load())
+	require.Zero(t, firstReservations.Load())
+
+	_, err = client.New(h, nil, client.WithRelaySelector(nil))
+	require.ErrorContains(t, err, "relay selector must not be nil")
+}
+
+func TestRelayKeepalive(t *testing.T) {
+	const interval = 200 * time.Millisecond
+	relay, reservations := newMockRelay(t, pbv2.Status_OK, time.Hour)
+
+	h, err := libp2p.New(libp2p.ResourceManager(&network.NullResourceManager{}))
+	require.NoError(t, err)
+	defer h.Close()
+	sub := subscribeReservationLost(t, h)
+	upgrader := swarmt.GenUpgrader(t, h.Network().(*swarm.Swarm), nil)
+	cl, err := client.New(h, upgrader, client.WithRelayKeepalive(interval))
+	require.NoError(t, err)
+	defer cl.Close()
+	ln, err := cl.Listen(relayCircuitAddr(t, relay))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	// The relay answers pings, so the reservation is kept.
+	time.Sleep(3 * interval)
+	require.Equal(t, int32(1), reservations.Load())
+
+	// The relay stops answering pings. The client reconnects and reserves again
+	// after the ping timed out.
+	relay.SetStreamHandler(ping.ID, func(s network.Stream) { io.Copy(io.Discard, s) })
+	start := time.Now()
+	require.Eventually(t, func() bool { return reservations.Load() >= 2 }, 5*time.Second, 10*time.Millisecond)
+	require.Less(t, time.Since(start), 2*interval+time.Second)
+
+	// Reserving again fails, so the reservation is lost.
+	relay.SetStreamHandler(proto.ProtoIDv2Hop, func(s network.Stream) { s.Reset() })
+	requireReservationLostOnce(t, sub, relay.ID(), event.ReservationRelayDisconnected)
+
+	_, err = client.New(nil, nil, client.WithRelayKeepalive(0))
+	require.ErrorContains(t, err, "relay keepalive interval must be positive")
+}
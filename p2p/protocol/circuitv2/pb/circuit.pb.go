@@ -188,11 +188,14 @@ type HopMessage struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// This field is marked optional for backwards compatibility with proto2.
 	// Users should make sure to always set this.
-	Type          *HopMessage_Type `protobuf:"varint,1,opt,name=type,proto3,enum=circuit.pb.HopMessage_Type,oneof" json:"type,omitempty"`
-	Peer          *Peer            `protobuf:"bytes,2,opt,name=peer,proto3,oneof" json:"peer,omitempty"`
-	Reservation   *Reservation     `protobuf:"bytes,3,opt,name=reservation,proto3,oneof" json:"reservation,omitempty"`
-	Limit         *Limit           `protobuf:"bytes,4,opt,name=limit,proto3,oneof" json:"limit,omitempty"`
-	Status        *Status          `protobuf:"varint,5,opt,name=status,proto3,enum=circuit.pb.Status,oneof" json:"status,omitempty"`
+	Type        *HopMessage_Type `protobuf:"varint,1,opt,name=type,proto3,enum=circuit.pb.HopMessage_Type,oneof" json:"type,omitempty"`
+	Peer        *Peer            `protobuf:"bytes,2,opt,name=peer,proto3,oneof" json:"peer,omitempty"`
+	Reservation *Reservation     `protobuf:"bytes,3,opt,name=reservation,proto3,oneof" json:"reservation,omitempty"`
+	Limit       *Limit           `protobuf:"bytes,4,opt,name=limit,proto3,oneof" json:"limit,omitempty"`
+	Status      *Status          `protobuf:"varint,5,opt,name=status,proto3,enum=circuit.pb.Status,oneof" json:"status,omitempty"`
+	// Opaque application data attached to CONNECT requests, e.g. a tenant ID.
+	// Relays that don't support it ignore it.
+	Metadata      []byte `protobuf:"bytes,6,opt,name=metadata,proto3,oneof" json:"metadata,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -262,6 +265,13 @@ func (x *HopMessage) GetStatus() Status {
 	return Status_UNUSED
 }
 
+func (x *HopMessage) GetMetadata() []byte {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
 type StopMessage struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// This field is marked optional for backwards compatibility with proto2.
@@ -505,14 +515,15 @@ var File_p2p_protocol_circuitv2_pb_circuit_proto protoreflect.FileDescriptor
 const file_p2p_protocol_circuitv2_pb_circuit_proto_rawDesc = "" +
 	"\n" +
 	"'p2p/protocol/circuitv2/pb/circuit.proto\x12\n" +
-	"circuit.pb\"\xf1\x02\n" +
+	"circuit.pb\"\x9f\x03\n" +
 	"\n" +
 	"HopMessage\x124\n" +
 	"\x04type\x18\x01 \x01(\x0e2\x1b.circuit.pb.HopMessage.TypeH\x00R\x04type\x88\x01\x01\x12)\n" +
 	"\x04peer\x18\x02 \x01(\v2\x10.circuit.pb.PeerH\x01R\x04peer\x88\x01\x01\x12>\n" +
 	"\vreservation\x18\x03 \x01(\v2\x17.circuit.pb.ReservationH\x02R\vreservation\x88\x01\x01\x12,\n" +
 	"\x05limit\x18\x04 \x01(\v2\x11.circuit.pb.LimitH\x03R\x05limit\x88\x01\x01\x12/\n" +
-	"\x06status\x18\x05 \x01(\x0e2\x12.circuit.pb.StatusH\x04R\x06status\x88\x01\x01\",\n" +
+	"\x06status\x18\x05 \x01(\x0e2\x12.circuit.pb.StatusH\x04R\x06status\x88\x01\x01\x12\x1f\n" +
+	"\bmetadata\x18\x06 \x01(\fH\x05R\bmetadata\x88\x01\x01\",\n" +
 	"\x04Type\x12\v\n" +
 	"\aRESERVE\x10\x00\x12\v\n" +
 	"\aCONNECT\x10\x01\x12\n" +
@@ -522,7 +533,8 @@ const file_p2p_protocol_circuitv2_pb_circuit_proto_rawDesc = "" +
 	"\x05_peerB\x0e\n" +
 	"\f_reservationB\b\n" +
 	"\x06_limitB\t\n" +
-	"\a_status\"\x96\x02\n" +
+	"\a_statusB\v\n" +
+	"\t_metadata\"\x96\x02\n" +
 	"\vStopMessage\x125\n" +
 	"\x04type\x18\x01 \x01(\x0e2\x1c.circuit.pb.StopMessage.TypeH\x00R\x04type\x88\x01\x01\x12)\n" +
 	"\x04peer\x18\x02 \x01(\v2\x10.circuit.pb.PeerH\x01R\x04peer\x88\x01\x01\x12,\n" +
@@ -3,4 +3,9 @@ package proto
 const (
 	ProtoIDv2Hop  = "/libp2p/circuit/relay/0.2.0/hop"
 	ProtoIDv2Stop = "/libp2p/circuit/relay/0.2.0/stop"
+	// ProtoIDv2Shutdown is used by relays to announce to the peers holding a
+	// reservation that they are shutting down. The relay opens a stream and
+	// closes it right away. It isn't part of the circuit v2 specification, so
+	// it is specific to go-libp2p and kept out of the /libp2p namespace.
+	ProtoIDv2Shutdown = "/libp2p-go/circuit/relay/shutdown/1.0.0"
 )
@@ -130,6 +130,36 @@ func (r *Relay) Close() error {
 	return nil
 }
 
+// AnnounceShutdown notifies the peers holding a reservation that the relay is
+// about to shut down, so that they can reserve a slot on another relay while
+// this one still relays their connections. Peers that don't support the
+// announcement are skipped. It returns once all peers were notified or ctx is
+// done, and doesn't close the relay.
+func (r *Relay) AnnounceShutdown(ctx context.Context) {
+	r.mx.Lock()
+	peers := make([]peer.ID, 0, len(r.rsvp))
+	for p := range r.rsvp {
+		peers = append(peers, p)
+	}
+	r.mx.Unlock()
+
+	ctx = network.WithNoDial(ctx, "relay shutdown announcement")
+	var wg sync.WaitGroup
+	for _, p := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := r.host.NewStream(ctx, p, proto.ProtoIDv2Shutdown)
+			if err != nil {
+				log.Debugf("error announcing shutdown to %s: %s", p, err)
+				return
+			}
+			s.Close()
+		}()
+	}
+	wg.Wait()
+}
+
 func (r *Relay) handleStream(s network.Stream) {
 	log.Infof("new relay stream from: %s", s.Conn().RemotePeer())
 
@@ -90,8 +90,8 @@ func connect(t *testing.T, a, b host.Host) {
 	}
 }
 
-func addTransport(t *testing.T, h host.Host, upgrader transport.Upgrader) {
-	if err := client.AddTransport(h, upgrader); err != nil {
+func addTransport(t *testing.T, h host.Host, upgrader transport.Upgrader, opts ...client.Option) {
+	if err := client.AddTransport(h, upgrader, opts...); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -382,3 +382,55 @@ func TestRelayLimitData(t *testing.T) {
 	}
 
 }
+
+func TestRelayConnBandwidthLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const rate = 32 << 10 // bytes/s
+	hosts, upgraders := getNetHosts(t, ctx, 3)
+	addTransport(t, hosts[0], upgraders[0])
+	addTransport(t, hosts[2], upgraders[2], client.WithConnBandwidthLimit(rate, 1024))
+
+	rch := make(chan int64, 1)
+	hosts[0].SetStreamHandler("test", func(s network.Stream) {
+		defer s.Close()
+		n, _ := io.Copy(io.Discard, s)
+		rch <- n
+	})
+
+	r, err := relay.New(hosts[1], relay.WithInfiniteLimits())
+	require.NoError(t, err)
+	defer r.Close()
+
+	connect(t, hosts[0], hosts[1])
+	connect(t, hosts[1], hosts[2])
+
+	rinfo := hosts[1].Peerstore().PeerInfo(hosts[1].ID())
+	_, err = client.Reserve(ctx, hosts[0], rinfo)
+	require.NoError(t, err)
+
+	raddr, err := ma.NewMultiaddr(fmt.Sprintf("/p2p/%s/p2p-circuit/p2p/%s", hosts[1].ID(), hosts[0].ID()))
+	require.NoError(t, err)
+	require.NoError(t, hosts[2].Connect(ctx, peer.AddrInfo{ID: hosts[0].ID(), Addrs: []ma.Multiaddr{raddr}}))
+
+	s, err := hosts[2].NewStream(network.WithAllowLimitedConn(ctx, "test"), hosts[0].ID(), "test")
+	require.NoError(t, err)
+
+	buf := make([]byte, 48<<10)
+	_, err = rand.Read(buf)
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = s.Write(buf)
+	require.NoError(t, err)
+	require.NoError(t, s.CloseWrite())
+	select {
+	case n := <-rch:
+		require.Equal(t, int64(len(buf)), n)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the data")
+	}
+	throughput := float64(len(buf)) / time.Since(start).Seconds()
+	// Allow some slack for the initial burst.
+	require.LessOrEqual(t, throughput, 1.05*rate)
+}
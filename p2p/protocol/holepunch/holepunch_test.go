@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -320,6 +321,68 @@ func TestEndToEndSimConnect(t *testing.T) {
 	}
 }
 
+func TestHolePunchAttemptEvent(t *testing.T) {
+	router := &simconn.SimpleFirewallRouter{}
+	relay := MustNewHost(t,
+		quicSimConn(true, router),
+		libp2p.ListenAddrs(ma.StringCast("/ip4/1.2.0.1/udp/8000/quic-v1")),
+		libp2p.DisableRelay(),
+		libp2p.ResourceManager(&network.NullResourceManager{}),
+		libp2p.WithFxOption(fx.Invoke(func(h host.Host) {
+			// Setup relay service
+			_, err := relayv2.New(h)
+			require.NoError(t, err)
+		})),
+	)
+
+	h1 := MustNewHost(t,
+		quicSimConn(false, router),
+		libp2p.EnableHolePunching(holepunch.DirectDialTimeout(100*time.Millisecond)),
+		libp2p.ListenAddrs(ma.StringCast("/ip4/2.2.0.1/udp/8000/quic-v1")),
+		libp2p.ResourceManager(&network.NullResourceManager{}),
+		libp2p.ForceReachabilityPrivate(),
+	)
+
+	h2 := MustNewHost(t,
+		quicSimConn(false, router),
+		libp2p.ListenAddrs(ma.StringCast("/ip4/2.2.0.2/udp/8001/quic-v1")),
+		libp2p.ResourceManager(&network.NullResourceManager{}),
+		connectToRelay(&relay),
+		libp2p.EnableHolePunching(holepunch.DirectDialTimeout(100*time.Millisecond)),
+		libp2p.ForceReachabilityPrivate(),
+	)
+
+	defer h1.Close()
+	defer h2.Close()
+	defer relay.Close()
+
+	sub, err := h2.EventBus().Subscribe(new(event.EvtHolePunchAttempt))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	waitForHolePunchingSvcActive(t, h1)
+	waitForHolePunchingSvcActive(t, h2)
+
+	learnAddrs(h1, h2)
+	pingAtoB(t, h1, h2)
+	ensureDirectConn(t, h1, h2)
+
+	// h1 connected to h2 through the relay, so h2 initiates the hole punch.
+	select {
+	case e := <-sub.Out():
+		evt := e.(event.EvtHolePunchAttempt)
+		require.Equal(t, h1.ID(), evt.Peer)
+		require.True(t, evt.Initiator)
+		require.True(t, evt.Success)
+		require.NoError(t, evt.Error)
+		require.Equal(t, "quic-v1", evt.Transport)
+		require.Positive(t, evt.RTT)
+		require.Positive(t, evt.Duration)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a hole punch attempt event")
+	}
+}
+
 func TestFailuresOnInitiator(t *testing.T) {
 	tcs := map[string]struct {
 		rhandler         func(s network.Stream)
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -48,6 +49,8 @@ type holePuncher struct {
 
 	tracer *tracer
 	filter AddrFilter
+	// emitter emits event.EvtHolePunchAttempt. It is nil in tests.
+	emitter event.Emitter
 
 	// Prior to https://github.com/libp2p/go-libp2p/pull/3044, go-libp2p would
 	// pick the opposite roles for client/server a hole punch. Setting this to
@@ -169,6 +172,7 @@ func (hp *holePuncher) directConnect(rp peer.ID) error {
 			cancel()
 			dt := time.Since(start)
 			hp.tracer.EndHolePunch(rp, dt, err)
+			emitHolePunchAttempt(hp.emitter, hp.host, rp, true, rtt, dt, err)
 			if err == nil {
 				log.Debugw("hole punching with successful", "peer", rp, "time", dt)
 				hp.tracer.HolePunchFinished("initiator", i, addrs, obsAddrs, getDirectConnection(hp.host, rp))
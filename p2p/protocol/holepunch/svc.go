@@ -8,6 +8,7 @@ import (
 	"time"
 
 	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
@@ -69,6 +70,8 @@ type Service struct {
 
 	tracer *tracer
 	filter AddrFilter
+	// emitter emits event.EvtHolePunchAttempt
+	emitter event.Emitter
 
 	refCount sync.WaitGroup
 
@@ -114,6 +117,12 @@ func NewService(h host.Host, ids identify.IDService, listenAddrs func() []ma.Mul
 			return nil, err
 		}
 	}
+	var err error
+	s.emitter, err = h.EventBus().Emitter(new(event.EvtHolePunchAttempt))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
 	s.tracer.Start()
 
 	s.refCount.Add(1)
@@ -162,6 +171,7 @@ func (s *Service) waitForPublicAddr() {
 	s.holePuncher = newHolePuncher(s.host, s.ids, s.listenAddrs, s.tracer, s.filter)
 	s.holePuncher.directDialTimeout = s.directDialTimeout
 	s.holePuncher.legacyBehavior = s.legacyBehavior
+	s.holePuncher.emitter = s.emitter
 	s.holePuncherMx.Unlock()
 	close(s.hasPublicAddrsChan)
 }
@@ -178,6 +188,7 @@ func (s *Service) Close() error {
 	s.tracer.Close()
 	s.host.RemoveStreamHandler(Protocol)
 	s.refCount.Wait()
+	s.emitter.Close()
 	return err
 }
 
@@ -293,6 +304,7 @@ func (s *Service) handleNewStream(str network.Stream) {
 	dt := time.Since(start)
 	s.tracer.EndHolePunch(rp, dt, err)
 	s.tracer.HolePunchFinished("receiver", 1, addrs, ownAddrs, getDirectConnection(s.host, rp))
+	emitHolePunchAttempt(s.emitter, s.host, rp, false, rtt, dt, err)
 }
 
 // DirectConnect is only exposed for testing purposes.
@@ -3,10 +3,13 @@ package holepunch
 import (
 	"context"
 	"slices"
+	"time"
 
+	"github.com/libp2p/go-libp2p/core/event"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
 
 	ma "github.com/multiformats/go-multiaddr"
 )
@@ -60,3 +63,25 @@ func holePunchConnect(ctx context.Context, host host.Host, pi peer.AddrInfo, isC
 	log.Debugw("hole punch successful", "peer", pi.ID)
 	return nil
 }
+
+// emitHolePunchAttempt emits the outcome of a hole punch attempt with p on em,
+// if it isn't nil.
+func emitHolePunchAttempt(em event.Emitter, h host.Host, p peer.ID, initiator bool, rtt, dt time.Duration, err error) {
+	if em == nil {
+		return
+	}
+	evt := event.EvtHolePunchAttempt{
+		Peer:      p,
+		Initiator: initiator,
+		RTT:       rtt,
+		Duration:  dt,
+		Success:   err == nil,
+		Error:     err,
+	}
+	if err == nil {
+		if c := getDirectConnection(h, p); c != nil {
+			evt.Transport = metricshelper.GetTransport(c.RemoteMultiaddr())
+		}
+	}
+	em.Emit(evt)
+}
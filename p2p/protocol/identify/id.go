@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/netip"
 	"slices"
 	"sync"
@@ -50,13 +51,24 @@ const (
 	maxOwnIdentifyMsgSize = 4 * 1024 // smaller than what we accept. This is 4k to be compatible with rust-libp2p
 	maxMessages           = 10
 	maxPushConcurrency    = 32
+	// pushDelay is how long changes of our protocols, addresses and metadata
+	// are collected before pushing them, so that rapid changes result in a
+	// single push.
+	pushDelay = 100 * time.Millisecond
 	// number of addresses to keep for peers we have disconnected from for peerstore.RecentlyConnectedTTL time
 	// This number can be small as we already filter peer addresses based on whether the peer is connected to us over
 	// localhost, private IP or public IP address
 	recentlyConnectedPeerMaxAddrs = 20
 	connectedPeerMaxAddrs         = 500
+	// MaxMetadataSize is the maximum total size of the keys and values of the
+	// metadata, see IDService.SetMetadata.
+	MaxMetadataSize = 1024
 )
 
+// ErrMetadataTooLarge is returned by IDService.SetMetadata if the metadata
+// would exceed MaxMetadataSize.
+var ErrMetadataTooLarge = errors.New("identify metadata too large")
+
 var (
 	defaultNetworkPrefixRateLimits = []rate.PrefixLimit{
 		{Prefix: netip.MustParsePrefix("127.0.0.0/8"), Limit: rate.Limit{}}, // inf
@@ -77,6 +89,7 @@ type identifySnapshot struct {
 	protocols []protocol.ID
 	addrs     []ma.Multiaddr
 	record    *record.Envelope
+	metadata  map[string]string
 }
 
 // Equal says if two snapshots are identical.
@@ -93,6 +106,9 @@ func (s identifySnapshot) Equal(other *identifySnapshot) bool {
 	if !slices.Equal(s.protocols, other.protocols) {
 		return false
 	}
+	if !maps.Equal(s.metadata, other.metadata) {
+		return false
+	}
 	if len(s.addrs) != len(other.addrs) {
 		return false
 	}
@@ -119,6 +135,13 @@ type IDService interface {
 	// ObservedAddrsFor returns the addresses peers have reported we've dialed from,
 	// for a specific local address.
 	ObservedAddrsFor(local ma.Multiaddr) []ma.Multiaddr
+	// SetMetadata sets a key-value pair that is sent to peers in the identify
+	// message, e.g. a software version. Connected peers are notified with an
+	// identify push. It returns ErrMetadataTooLarge if the total size of the
+	// keys and values would exceed MaxMetadataSize.
+	SetMetadata(key, value string) error
+	// RemoveMetadata removes the key-value pair set with SetMetadata.
+	RemoveMetadata(key string)
 	Start()
 	io.Closer
 }
@@ -175,6 +198,12 @@ type idService struct {
 
 	addrMu sync.Mutex
 
+	metadataMu sync.Mutex
+	metadata   map[string]string
+	// metadataUpdated is notified when the metadata changes, to update the
+	// snapshot and push it to peers.
+	metadataUpdated chan struct{}
+
 	// our own observed addresses.
 	observedAddrMgr            *ObservedAddrManager
 	disableObservedAddrManager bool
@@ -224,6 +253,7 @@ func NewIDService(h host.Host, opts ...Option) (*idService, error) {
 		conns:                   make(map[network.Conn]entry),
 		disableSignedPeerRecord: cfg.disableSignedPeerRecord,
 		setupCompleted:          make(chan struct{}),
+		metadataUpdated:         make(chan struct{}, 1),
 		metricsTracer:           cfg.metricsTracer,
 		timeout:                 cfg.timeout,
 		rateLimiter: &rate.Limiter{
@@ -318,6 +348,10 @@ func (ids *idService) loop(ctx context.Context) {
 		}
 	}()
 
+	// pushTimer is running while changes are collected before pushing them.
+	// The snapshot is updated right away, so that identify responses are up
+	// to date.
+	var pushTimer <-chan time.Time
 	for {
 		select {
 		case e, ok := <-sub.Out():
@@ -330,13 +364,66 @@ func (ids *idService) loop(ctx context.Context) {
 			if ids.metricsTracer != nil {
 				ids.metricsTracer.TriggeredPushes(e)
 			}
-			select {
-			case triggerPush <- struct{}{}:
-			default: // we already have one more push queued, no need to queue another one
+			if pushTimer == nil {
+				pushTimer = time.After(pushDelay)
 			}
+			continue
+		case <-ids.metadataUpdated:
+			if updated := ids.updateSnapshot(); updated && pushTimer == nil {
+				pushTimer = time.After(pushDelay)
+			}
+			continue
+		case <-pushTimer:
+			pushTimer = nil
 		case <-ctx.Done():
 			return
 		}
+		select {
+		case triggerPush <- struct{}{}:
+		default: // we already have one more push queued, no need to queue another one
+		}
+	}
+}
+
+func (ids *idService) SetMetadata(key, value string) error {
+	if key == "" {
+		return errors.New("identify metadata key cannot be empty")
+	}
+	ids.metadataMu.Lock()
+	defer ids.metadataMu.Unlock()
+
+	size := len(key) + len(value)
+	for k, v := range ids.metadata {
+		if k != key {
+			size += len(k) + len(v)
+		}
+	}
+	if size > MaxMetadataSize {
+		return ErrMetadataTooLarge
+	}
+	if ids.metadata == nil {
+		ids.metadata = make(map[string]string)
+	}
+	ids.metadata[key] = value
+	ids.notifyMetadataUpdated()
+	return nil
+}
+
+func (ids *idService) RemoveMetadata(key string) {
+	ids.metadataMu.Lock()
+	defer ids.metadataMu.Unlock()
+
+	if _, ok := ids.metadata[key]; !ok {
+		return
+	}
+	delete(ids.metadata, key)
+	ids.notifyMetadataUpdated()
+}
+
+func (ids *idService) notifyMetadataUpdated() {
+	select {
+	case ids.metadataUpdated <- struct{}{}:
+	default: // an update is already queued
 	}
 }
 
@@ -638,15 +725,23 @@ func (ids *idService) updateSnapshot() (updated bool) {
 	addrs := ids.Host.Addrs()
 	slices.SortFunc(addrs, func(a, b ma.Multiaddr) int { return bytes.Compare(a.Bytes(), b.Bytes()) })
 
+	ids.metadataMu.Lock()
+	metadata := maps.Clone(ids.metadata)
+	ids.metadataMu.Unlock()
+
 	usedSpace := len(ids.ProtocolVersion) + len(ids.UserAgent)
 	for i := 0; i < len(protos); i++ {
 		usedSpace += len(protos[i])
 	}
+	for k, v := range metadata {
+		usedSpace += len(k) + len(v)
+	}
 	addrs = trimHostAddrList(addrs, maxOwnIdentifyMsgSize-usedSpace-256) // 256 bytes of buffer
 
 	snapshot := identifySnapshot{
 		addrs:     addrs,
 		protocols: protos,
+		metadata:  metadata,
 	}
 
 	if !ids.disableSignedPeerRecord {
@@ -734,6 +829,10 @@ func (ids *idService) createBaseIdentifyResponse(conn network.Conn, snapshot *id
 	mes.ProtocolVersion = &ids.ProtocolVersion
 	mes.AgentVersion = &ids.UserAgent
 
+	for _, k := range slices.Sorted(maps.Keys(snapshot.metadata)) {
+		mes.Metadata = append(mes.Metadata, &pb.MetadataEntry{Key: proto.String(k), Value: proto.String(snapshot.metadata[k])})
+	}
+
 	return mes
 }
 
@@ -887,6 +986,11 @@ func (ids *idService) consumeMessage(mes *pb.Identify, c network.Conn, isPush bo
 	// get the key from the other side. we may not have it (no-auth transport)
 	ids.consumeReceivedPubKey(c, mes.PublicKey)
 
+	metadata := parseMetadata(mes.GetMetadata())
+	if metadata == nil && len(mes.GetMetadata()) > 0 {
+		log.Debugw("ignoring oversized metadata", "peer", p)
+	}
+
 	ids.emitters.evtPeerIdentificationCompleted.Emit(event.EvtPeerIdentificationCompleted{
 		Peer:             c.RemotePeer(),
 		Conn:             c,
@@ -896,9 +1000,27 @@ func (ids *idService) consumeMessage(mes *pb.Identify, c network.Conn, isPush bo
 		ObservedAddr:     obsAddr,
 		ProtocolVersion:  pv,
 		AgentVersion:     av,
+		Metadata:         metadata,
 	})
 }
 
+// parseMetadata returns the metadata sent by a peer. It returns nil if there is
+// none, or if it exceeds MaxMetadataSize.
+func parseMetadata(entries []*pb.MetadataEntry) map[string]string {
+	var size int
+	for _, e := range entries {
+		size += len(e.GetKey()) + len(e.GetValue())
+	}
+	if len(entries) == 0 || size > MaxMetadataSize {
+		return nil
+	}
+	metadata := make(map[string]string, len(entries))
+	for _, e := range entries {
+		metadata[e.GetKey()] = e.GetValue()
+	}
+	return metadata
+}
+
 func (ids *idService) consumeSignedPeerRecord(p peer.ID, signedPeerRecord *record.Envelope) ([]ma.Multiaddr, error) {
 	if signedPeerRecord.PublicKey == nil {
 		return nil, errors.New("missing pubkey")
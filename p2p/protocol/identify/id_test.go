@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math/rand"
 	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -616,6 +617,102 @@ func TestSendPush(t *testing.T) {
 	}, time.Second, 10*time.Millisecond)
 }
 
+func TestPushProtocolChanges(t *testing.T) {
+	h1 := blhost.NewBlankHost(swarmt.GenSwarm(t))
+	h2 := blhost.NewBlankHost(swarmt.GenSwarm(t))
+	defer h2.Close()
+	defer h1.Close()
+
+	ids1, err := identify.NewIDService(h1)
+	require.NoError(t, err)
+	defer ids1.Close()
+	ids1.Start()
+
+	ids2, err := identify.NewIDService(h2)
+	require.NoError(t, err)
+	defer ids2.Close()
+	ids2.Start()
+
+	sub, err := h2.EventBus().Subscribe(new(event.EvtPeerProtocolsUpdated))
+	require.NoError(t, err)
+	defer sub.Close()
+
+	require.NoError(t, h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()}))
+	ids1.IdentifyConn(h1.Network().ConnsToPeer(h2.ID())[0])
+	ids2.IdentifyConn(h2.Network().ConnsToPeer(h1.ID())[0])
+
+	// rapid changes are pushed at once
+	for _, p := range []protocol.ID{"/foo", "/bar", "/baz"} {
+		h1.SetStreamHandler(p, func(network.Stream) {})
+	}
+	select {
+	case e := <-sub.Out():
+		evt := e.(event.EvtPeerProtocolsUpdated)
+		require.Equal(t, h1.ID(), evt.Peer)
+		require.ElementsMatch(t, []protocol.ID{"/foo", "/bar", "/baz"}, evt.Added)
+		require.Empty(t, evt.Removed)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a push")
+	}
+	sup, err := h2.Peerstore().SupportsProtocols(h1.ID(), "/foo", "/bar", "/baz")
+	require.NoError(t, err)
+	require.Len(t, sup, 3)
+
+	select {
+	case e := <-sub.Out():
+		t.Fatalf("didn't expect another push: %+v", e)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	h1 := blhost.NewBlankHost(swarmt.GenSwarm(t))
+	h2 := blhost.NewBlankHost(swarmt.GenSwarm(t))
+	defer h2.Close()
+	defer h1.Close()
+
+	ids1, err := identify.NewIDService(h1)
+	require.NoError(t, err)
+	defer ids1.Close()
+	require.NoError(t, ids1.SetMetadata("version", "1.2.3"))
+	ids1.Start()
+
+	ids2, err := identify.NewIDService(h2)
+	require.NoError(t, err)
+	defer ids2.Close()
+	ids2.Start()
+
+	sub, err := h2.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	require.NoError(t, err)
+	defer sub.Close()
+	nextMetadata := func() map[string]string {
+		t.Helper()
+		select {
+		case e := <-sub.Out():
+			return e.(event.EvtPeerIdentificationCompleted).Metadata
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected EvtPeerIdentificationCompleted event")
+			return nil
+		}
+	}
+
+	require.NoError(t, h2.Connect(context.Background(), peer.AddrInfo{ID: h1.ID(), Addrs: h1.Addrs()}))
+	ids2.IdentifyConn(h2.Network().ConnsToPeer(h1.ID())[0])
+	require.Equal(t, map[string]string{"version": "1.2.3"}, nextMetadata())
+
+	// metadata set later is pushed
+	require.NoError(t, ids1.SetMetadata("foo", "bar"))
+	require.Equal(t, map[string]string{"version": "1.2.3", "foo": "bar"}, nextMetadata())
+	ids1.RemoveMetadata("foo")
+	require.Equal(t, map[string]string{"version": "1.2.3"}, nextMetadata())
+
+	// oversized metadata is rejected
+	require.ErrorIs(t, ids1.SetMetadata("large", strings.Repeat("a", identify.MaxMetadataSize)), identify.ErrMetadataTooLarge)
+	require.NoError(t, ids1.SetMetadata("version", strings.Repeat("a", identify.MaxMetadataSize-len("version"))))
+	require.ErrorIs(t, ids1.SetMetadata("foo", "bar"), identify.ErrMetadataTooLarge)
+	require.Error(t, ids1.SetMetadata("", "bar"))
+}
+
 func TestLargeIdentifyMessage(t *testing.T) {
 	if race.WithRace() {
 		t.Skip("setting peerstore.RecentlyConnectedAddrTTL is racy")
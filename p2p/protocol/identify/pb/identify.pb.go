@@ -46,8 +46,10 @@ type Identify struct {
 	// see github.com/libp2p/go-libp2p/core/record/pb/envelope.proto and
 	// github.com/libp2p/go-libp2p/core/peer/pb/peer_record.proto for message definitions.
 	SignedPeerRecord []byte `protobuf:"bytes,8,opt,name=signedPeerRecord" json:"signedPeerRecord,omitempty"`
-	unknownFields    protoimpl.UnknownFields
-	sizeCache        protoimpl.SizeCache
+	// metadata are application defined key-value pairs, e.g. a software version.
+	Metadata      []*MetadataEntry `protobuf:"bytes,1024,rep,name=metadata" json:"metadata,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
 func (x *Identify) Reset() {
@@ -129,11 +131,70 @@ func (x *Identify) GetSignedPeerRecord() []byte {
 	return nil
 }
 
+func (x *Identify) GetMetadata() []*MetadataEntry {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+type MetadataEntry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           *string                `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Value         *string                `protobuf:"bytes,2,opt,name=value" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *MetadataEntry) Reset() {
+	*x = MetadataEntry{}
+	mi := &file_p2p_protocol_identify_pb_identify_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *MetadataEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetadataEntry) ProtoMessage() {}
+
+func (x *MetadataEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_protocol_identify_pb_identify_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetadataEntry.ProtoReflect.Descriptor instead.
+func (*MetadataEntry) Descriptor() ([]byte, []int) {
+	return file_p2p_protocol_identify_pb_identify_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *MetadataEntry) GetKey() string {
+	if x != nil && x.Key != nil {
+		return *x.Key
+	}
+	return ""
+}
+
+func (x *MetadataEntry) GetValue() string {
+	if x != nil && x.Value != nil {
+		return *x.Value
+	}
+	return ""
+}
+
 var File_p2p_protocol_identify_pb_identify_proto protoreflect.FileDescriptor
 
 const file_p2p_protocol_identify_pb_identify_proto_rawDesc = "" +
 	"\n" +
-	"'p2p/protocol/identify/pb/identify.proto\x12\videntify.pb\"\x86\x02\n" +
+	"'p2p/protocol/identify/pb/identify.proto\x12\videntify.pb\"\xbf\x02\n" +
 	"\bIdentify\x12(\n" +
 	"\x0fprotocolVersion\x18\x05 \x01(\tR\x0fprotocolVersion\x12\"\n" +
 	"\fagentVersion\x18\x06 \x01(\tR\fagentVersion\x12\x1c\n" +
@@ -141,7 +202,11 @@ const file_p2p_protocol_identify_pb_identify_proto_rawDesc = "" +
 	"\vlistenAddrs\x18\x02 \x03(\fR\vlistenAddrs\x12\"\n" +
 	"\fobservedAddr\x18\x04 \x01(\fR\fobservedAddr\x12\x1c\n" +
 	"\tprotocols\x18\x03 \x03(\tR\tprotocols\x12*\n" +
-	"\x10signedPeerRecord\x18\b \x01(\fR\x10signedPeerRecordB6Z4github.com/libp2p/go-libp2p/p2p/protocol/identify/pb"
+	"\x10signedPeerRecord\x18\b \x01(\fR\x10signedPeerRecord\x127\n" +
+	"\bmetadata\x18\x80\b \x03(\v2\x1a.identify.pb.MetadataEntryR\bmetadata\"7\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05valueB6Z4github.com/libp2p/go-libp2p/p2p/protocol/identify/pb"
 
 var (
 	file_p2p_protocol_identify_pb_identify_proto_rawDescOnce sync.Once
@@ -155,16 +220,18 @@ func file_p2p_protocol_identify_pb_identify_proto_rawDescGZIP() []byte {
 	return file_p2p_protocol_identify_pb_identify_proto_rawDescData
 }
 
-var file_p2p_protocol_identify_pb_identify_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_p2p_protocol_identify_pb_identify_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_p2p_protocol_identify_pb_identify_proto_goTypes = []any{
-	(*Identify)(nil), // 0: identify.pb.Identify
+	(*Identify)(nil),      // 0: identify.pb.Identify
+	(*MetadataEntry)(nil), // 1: identify.pb.MetadataEntry
 }
 var file_p2p_protocol_identify_pb_identify_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	1, // 0: identify.pb.Identify.metadata:type_name -> identify.pb.MetadataEntry
+	1, // [1:1] is the sub-list for method output_type
+	1, // [1:1] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
 }
 
 func init() { file_p2p_protocol_identify_pb_identify_proto_init() }
@@ -178,7 +245,7 @@ func file_p2p_protocol_identify_pb_identify_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_p2p_protocol_identify_pb_identify_proto_rawDesc), len(file_p2p_protocol_identify_pb_identify_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   1,
+			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
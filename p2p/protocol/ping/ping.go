@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	mrand "math/rand"
 	"time"
@@ -20,7 +21,11 @@ import (
 var log = logging.Logger("ping")
 
 const (
-	PingSize     = 32
+	PingSize = 32
+	// MaxPingSize is the largest payload size that can be set with
+	// PingService.SetPayloadSize. The payload is echoed while it's still being
+	// written, so it has to fit into the stream's flow control window.
+	MaxPingSize  = 64 << 10
 	pingTimeout  = 10 * time.Second
 	pingDuration = 30 * time.Second
 
@@ -29,12 +34,19 @@ const (
 	ServiceName = "libp2p.ping"
 )
 
+// ErrInvalidPingSize is returned by PingService.SetPayloadSize if the size
+// isn't a multiple of PingSize between PingSize and MaxPingSize.
+var ErrInvalidPingSize = fmt.Errorf("ping payload size must be a multiple of %d between %d and %d", PingSize, PingSize, MaxPingSize)
+
 type PingService struct {
 	Host host.Host
+
+	// payloadSize is the size of the payload sent by Ping. 0 means PingSize.
+	payloadSize int
 }
 
 func NewPingService(h host.Host) *PingService {
-	ps := &PingService{h}
+	ps := &PingService{Host: h}
 	h.SetStreamHandler(ID, ps.PingHandler)
 	return ps
 }
@@ -100,8 +112,23 @@ type Result struct {
 	Error error
 }
 
+// SetPayloadSize sets the size of the payload sent by Ping, e.g. to test the
+// path MTU. Peers echo the payload in chunks of PingSize, so size needs to be a
+// multiple of PingSize. It must not be called concurrently with Ping.
+func (ps *PingService) SetPayloadSize(size int) error {
+	if size < PingSize || size > MaxPingSize || size%PingSize != 0 {
+		return ErrInvalidPingSize
+	}
+	ps.payloadSize = size
+	return nil
+}
+
 func (ps *PingService) Ping(ctx context.Context, p peer.ID) <-chan Result {
-	return Ping(ctx, ps.Host, p)
+	size := ps.payloadSize
+	if size == 0 {
+		size = PingSize
+	}
+	return doPing(ctx, ps.Host, p, size)
 }
 
 func pingError(err error) chan Result {
@@ -114,6 +141,10 @@ func pingError(err error) chan Result {
 // Ping pings the remote peer until the context is canceled, returning a stream
 // of RTTs or errors.
 func Ping(ctx context.Context, h host.Host, p peer.ID) <-chan Result {
+	return doPing(ctx, h, p, PingSize)
+}
+
+func doPing(ctx context.Context, h host.Host, p peer.ID, size int) <-chan Result {
 	s, err := h.NewStream(network.WithAllowLimitedConn(ctx, "ping"), p, ID)
 	if err != nil {
 		return pingError(err)
@@ -142,7 +173,7 @@ func Ping(ctx context.Context, h host.Host, p peer.ID) <-chan Result {
 
 		for ctx.Err() == nil {
 			var res Result
-			res.RTT, res.Error = ping(s, ra)
+			res.RTT, res.Error = ping(s, ra, size)
 
 			// canceled, ignore everything.
 			if ctx.Err() != nil {
@@ -169,15 +200,15 @@ func Ping(ctx context.Context, h host.Host, p peer.ID) <-chan Result {
 	return out
 }
 
-func ping(s network.Stream, randReader io.Reader) (time.Duration, error) {
-	if err := s.Scope().ReserveMemory(2*PingSize, network.ReservationPriorityAlways); err != nil {
+func ping(s network.Stream, randReader io.Reader, size int) (time.Duration, error) {
+	if err := s.Scope().ReserveMemory(2*size, network.ReservationPriorityAlways); err != nil {
 		log.Debugf("error reserving memory for ping stream: %s", err)
 		s.Reset()
 		return 0, err
 	}
-	defer s.Scope().ReleaseMemory(2 * PingSize)
+	defer s.Scope().ReleaseMemory(2 * size)
 
-	buf := pool.Get(PingSize)
+	buf := pool.Get(size)
 	defer pool.Put(buf)
 
 	if _, err := io.ReadFull(randReader, buf); err != nil {
@@ -189,7 +220,7 @@ func ping(s network.Stream, randReader io.Reader) (time.Duration, error) {
 		return 0, err
 	}
 
-	rbuf := pool.Get(PingSize)
+	rbuf := pool.Get(size)
 	defer pool.Put(rbuf)
 
 	if _, err := io.ReadFull(s, rbuf); err != nil {
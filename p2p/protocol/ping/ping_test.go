@@ -48,6 +48,7 @@ func testPing(t *testing.T, ps *ping.PingService, p peer.ID) {
 		select {
 		case res := <-ts:
 			require.NoError(t, res.Error)
+			require.Positive(t, res.RTT)
 			t.Log("ping took: ", res.RTT)
 		case <-time.After(time.Second * 4):
 			t.Fatal("failed to receive ping")
@@ -55,3 +56,29 @@ func testPing(t *testing.T, ps *ping.PingService, p peer.ID) {
 	}
 
 }
+
+func TestPingPayloadSize(t *testing.T) {
+	h1, err := bhost.NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h1.Close()
+	h1.Start()
+	h2, err := bhost.NewHost(swarmt.GenSwarm(t), nil)
+	require.NoError(t, err)
+	defer h2.Close()
+	h2.Start()
+
+	err = h1.Connect(context.Background(), peer.AddrInfo{ID: h2.ID(), Addrs: h2.Addrs()})
+	require.NoError(t, err)
+
+	ps1 := ping.NewPingService(h1)
+	ping.NewPingService(h2)
+
+	for _, size := range []int{0, ping.PingSize - 1, ping.PingSize + 1, ping.MaxPingSize + ping.PingSize} {
+		require.ErrorIs(t, ps1.SetPayloadSize(size), ping.ErrInvalidPingSize, "size %d", size)
+	}
+
+	// The payload is compared to the echoed one, so a successful ping means it
+	// was echoed intact.
+	require.NoError(t, ps1.SetPayloadSize(ping.MaxPingSize))
+	testPing(t, ps1, h2.ID())
+}
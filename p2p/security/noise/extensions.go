@@ -0,0 +1,108 @@
+package noise
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/p2p/security/noise/pb"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// maxCustomExtensions is the maximum number of custom extensions accepted from
+// a peer. Extensions beyond it are ignored.
+const maxCustomExtensions = 100
+
+// ExtensionID identifies a custom extension of the handshake payload, see
+// Transport.RegisterExtension. Both peers need to agree on its meaning.
+type ExtensionID uint64
+
+// Extension is a custom extension of the handshake payload, e.g. to advertise
+// supported features during the handshake.
+// The responder's extensions are encrypted but sent before the initiator is
+// authenticated, so they must not carry secrets.
+type Extension struct {
+	// Encode returns the data to send to the peer. The extension isn't sent
+	// if it returns nil.
+	Encode func() []byte
+	// Decode parses the data received from the peer. If it fails, the
+	// handshake fails. The result can be read from the secured connection,
+	// see ExtensionsConn.
+	Decode func(data []byte) (any, error)
+}
+
+// ExtensionsConn is implemented by the connections secured by the Transport.
+type ExtensionsConn interface {
+	// Extension returns the decoded value of the custom extension with the
+	// given ID sent by the peer, if the peer sent it.
+	Extension(id ExtensionID) (value any, ok bool)
+}
+
+var _ ExtensionsConn = &secureSession{}
+
+// RegisterExtension registers a custom extension with the given ID. It is
+// sent and decoded on all connections secured afterwards. Extensions sent by
+// peers that aren't registered are ignored.
+// It's not supported by SessionTransports, which replace the early data of
+// the Transport with their own, see EarlyData.
+func (t *Transport) RegisterExtension(id ExtensionID, ext Extension) error {
+	if ext.Encode == nil || ext.Decode == nil {
+		return errors.New("extension needs both Encode and Decode")
+	}
+	t.extensionsMu.Lock()
+	defer t.extensionsMu.Unlock()
+	if _, ok := t.extensions[id]; ok {
+		return fmt.Errorf("extension %d already registered", id)
+	}
+	if t.extensions == nil {
+		t.extensions = make(map[ExtensionID]Extension)
+	}
+	t.extensions[id] = ext
+	return nil
+}
+
+// encodeExtensions encodes the registered extensions.
+func (t *Transport) encodeExtensions() []*pb.NoiseCustomExtension {
+	t.extensionsMu.RLock()
+	defer t.extensionsMu.RUnlock()
+	var exts []*pb.NoiseCustomExtension
+	for id, ext := range t.extensions {
+		data := ext.Encode()
+		if data == nil {
+			continue
+		}
+		exts = append(exts, &pb.NoiseCustomExtension{Id: proto.Uint64(uint64(id)), Data: data})
+	}
+	return exts
+}
+
+// decodeExtensions decodes the registered extensions among exts.
+func (t *Transport) decodeExtensions(exts []*pb.NoiseCustomExtension) (map[ExtensionID]any, error) {
+	if len(exts) > maxCustomExtensions {
+		exts = exts[:maxCustomExtensions]
+	}
+	t.extensionsMu.RLock()
+	defer t.extensionsMu.RUnlock()
+	var decoded map[ExtensionID]any
+	for _, e := range exts {
+		id := ExtensionID(e.GetId())
+		ext, ok := t.extensions[id]
+		if !ok {
+			continue
+		}
+		v, err := ext.Decode(e.GetData())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode extension %d: %w", id, err)
+		}
+		if decoded == nil {
+			decoded = make(map[ExtensionID]any)
+		}
+		decoded[id] = v
+	}
+	return decoded, nil
+}
+
+func (s *secureSession) Extension(id ExtensionID) (value any, ok bool) {
+	value, ok = s.extensions[id]
+	return value, ok
+}
@@ -25,8 +25,12 @@ type NoiseExtensions struct {
 	state                  protoimpl.MessageState `protogen:"open.v1"`
 	WebtransportCerthashes [][]byte               `protobuf:"bytes,1,rep,name=webtransport_certhashes,json=webtransportCerthashes" json:"webtransport_certhashes,omitempty"`
 	StreamMuxers           []string               `protobuf:"bytes,2,rep,name=stream_muxers,json=streamMuxers" json:"stream_muxers,omitempty"`
-	unknownFields          protoimpl.UnknownFields
-	sizeCache              protoimpl.SizeCache
+	// Extensions registered by applications. The field number is far from
+	// those of the specified extensions, so that it doesn't collide with
+	// future ones.
+	CustomExtensions []*NoiseCustomExtension `protobuf:"bytes,1024,rep,name=custom_extensions,json=customExtensions" json:"custom_extensions,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
 }
 
 func (x *NoiseExtensions) Reset() {
@@ -73,6 +77,65 @@ func (x *NoiseExtensions) GetStreamMuxers() []string {
 	return nil
 }
 
+func (x *NoiseExtensions) GetCustomExtensions() []*NoiseCustomExtension {
+	if x != nil {
+		return x.CustomExtensions
+	}
+	return nil
+}
+
+type NoiseCustomExtension struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            *uint64                `protobuf:"varint,1,opt,name=id" json:"id,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data" json:"data,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *NoiseCustomExtension) Reset() {
+	*x = NoiseCustomExtension{}
+	mi := &file_p2p_security_noise_pb_payload_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *NoiseCustomExtension) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NoiseCustomExtension) ProtoMessage() {}
+
+func (x *NoiseCustomExtension) ProtoReflect() protoreflect.Message {
+	mi := &file_p2p_security_noise_pb_payload_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NoiseCustomExtension.ProtoReflect.Descriptor instead.
+func (*NoiseCustomExtension) Descriptor() ([]byte, []int) {
+	return file_p2p_security_noise_pb_payload_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *NoiseCustomExtension) GetId() uint64 {
+	if x != nil && x.Id != nil {
+		return *x.Id
+	}
+	return 0
+}
+
+func (x *NoiseCustomExtension) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
 type NoiseHandshakePayload struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	IdentityKey   []byte                 `protobuf:"bytes,1,opt,name=identity_key,json=identityKey" json:"identity_key,omitempty"`
@@ -84,7 +147,7 @@ type NoiseHandshakePayload struct {
 
 func (x *NoiseHandshakePayload) Reset() {
 	*x = NoiseHandshakePayload{}
-	mi := &file_p2p_security_noise_pb_payload_proto_msgTypes[1]
+	mi := &file_p2p_security_noise_pb_payload_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -96,7 +159,7 @@ func (x *NoiseHandshakePayload) String() string {
 func (*NoiseHandshakePayload) ProtoMessage() {}
 
 func (x *NoiseHandshakePayload) ProtoReflect() protoreflect.Message {
-	mi := &file_p2p_security_noise_pb_payload_proto_msgTypes[1]
+	mi := &file_p2p_security_noise_pb_payload_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -109,7 +172,7 @@ func (x *NoiseHandshakePayload) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use NoiseHandshakePayload.ProtoReflect.Descriptor instead.
 func (*NoiseHandshakePayload) Descriptor() ([]byte, []int) {
-	return file_p2p_security_noise_pb_payload_proto_rawDescGZIP(), []int{1}
+	return file_p2p_security_noise_pb_payload_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *NoiseHandshakePayload) GetIdentityKey() []byte {
@@ -137,10 +200,14 @@ var File_p2p_security_noise_pb_payload_proto protoreflect.FileDescriptor
 
 const file_p2p_security_noise_pb_payload_proto_rawDesc = "" +
 	"\n" +
-	"#p2p/security/noise/pb/payload.proto\x12\x02pb\"o\n" +
+	"#p2p/security/noise/pb/payload.proto\x12\x02pb\"\xb7\x01\n" +
 	"\x0fNoiseExtensions\x127\n" +
 	"\x17webtransport_certhashes\x18\x01 \x03(\fR\x16webtransportCerthashes\x12#\n" +
-	"\rstream_muxers\x18\x02 \x03(\tR\fstreamMuxers\"\x92\x01\n" +
+	"\rstream_muxers\x18\x02 \x03(\tR\fstreamMuxers\x12F\n" +
+	"\x11custom_extensions\x18\x80\b \x03(\v2\x18.pb.NoiseCustomExtensionR\x10customExtensions\":\n" +
+	"\x14NoiseCustomExtension\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\"\x92\x01\n" +
 	"\x15NoiseHandshakePayload\x12!\n" +
 	"\fidentity_key\x18\x01 \x01(\fR\videntityKey\x12!\n" +
 	"\fidentity_sig\x18\x02 \x01(\fR\videntitySig\x123\n" +
@@ -160,18 +227,20 @@ func file_p2p_security_noise_pb_payload_proto_rawDescGZIP() []byte {
 	return file_p2p_security_noise_pb_payload_proto_rawDescData
 }
 
-var file_p2p_security_noise_pb_payload_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_p2p_security_noise_pb_payload_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
 var file_p2p_security_noise_pb_payload_proto_goTypes = []any{
 	(*NoiseExtensions)(nil),       // 0: pb.NoiseExtensions
-	(*NoiseHandshakePayload)(nil), // 1: pb.NoiseHandshakePayload
+	(*NoiseCustomExtension)(nil),  // 1: pb.NoiseCustomExtension
+	(*NoiseHandshakePayload)(nil), // 2: pb.NoiseHandshakePayload
 }
 var file_p2p_security_noise_pb_payload_proto_depIdxs = []int32{
-	0, // 0: pb.NoiseHandshakePayload.extensions:type_name -> pb.NoiseExtensions
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	1, // 0: pb.NoiseExtensions.custom_extensions:type_name -> pb.NoiseCustomExtension
+	0, // 1: pb.NoiseHandshakePayload.extensions:type_name -> pb.NoiseExtensions
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_p2p_security_noise_pb_payload_proto_init() }
@@ -185,7 +254,7 @@ func file_p2p_security_noise_pb_payload_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_p2p_security_noise_pb_payload_proto_rawDesc), len(file_p2p_security_noise_pb_payload_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   3,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
@@ -45,6 +45,9 @@ type secureSession struct {
 
 	// ConnectionState holds state information releated to the secureSession entity.
 	connectionState network.ConnectionState
+
+	// extensions holds the decoded custom extensions received from the peer.
+	extensions map[ExtensionID]any
 }
 
 // newSecureSession creates a Noise session over the given insecureConn Conn, using
@@ -3,6 +3,7 @@ package noise
 import (
 	"context"
 	"net"
+	"sync"
 
 	"github.com/libp2p/go-libp2p/core/canonicallog"
 	"github.com/libp2p/go-libp2p/core/crypto"
@@ -24,6 +25,9 @@ type Transport struct {
 	localID    peer.ID
 	privateKey crypto.PrivKey
 	muxers     []protocol.ID
+
+	extensionsMu sync.RWMutex
+	extensions   map[ExtensionID]Extension
 }
 
 var _ sec.SecureTransport = &Transport{}
@@ -60,6 +64,9 @@ func (t *Transport) SecureInbound(ctx context.Context, insecure net.Conn, p peer
 			canonicallog.LogPeerStatus(100, p, addr, "handshake_failure", "noise", "err", err.Error())
 		}
 	}
+	if c != nil {
+		c.extensions = responderEDH.receivedExtensions
+	}
 	return SessionWithConnState(c, responderEDH.MatchMuxers(false)), err
 }
 
@@ -70,6 +77,7 @@ func (t *Transport) SecureOutbound(ctx context.Context, insecure net.Conn, p pee
 	if err != nil {
 		return c, err
 	}
+	c.extensions = initiatorEDH.receivedExtensions
 	return SessionWithConnState(c, initiatorEDH.MatchMuxers(true)), err
 }
 
@@ -99,8 +107,9 @@ func matchMuxers(initiatorMuxers, responderMuxers []protocol.ID) protocol.ID {
 }
 
 type transportEarlyDataHandler struct {
-	transport      *Transport
-	receivedMuxers []protocol.ID
+	transport          *Transport
+	receivedMuxers     []protocol.ID
+	receivedExtensions map[ExtensionID]any
 }
 
 var _ EarlyDataHandler = &transportEarlyDataHandler{}
@@ -111,7 +120,8 @@ func newTransportEDH(t *Transport) *transportEarlyDataHandler {
 
 func (i *transportEarlyDataHandler) Send(context.Context, net.Conn, peer.ID) *pb.NoiseExtensions {
 	return &pb.NoiseExtensions{
-		StreamMuxers: protocol.ConvertToStrings(i.transport.muxers),
+		StreamMuxers:     protocol.ConvertToStrings(i.transport.muxers),
+		CustomExtensions: i.transport.encodeExtensions(),
 	}
 }
 
@@ -120,7 +130,9 @@ func (i *transportEarlyDataHandler) Received(_ context.Context, _ net.Conn, exte
 	if extension != nil && len(extension.StreamMuxers) <= maxProtoNum {
 		i.receivedMuxers = protocol.ConvertFromStrings(extension.GetStreamMuxers())
 	}
-	return nil
+	var err error
+	i.receivedExtensions, err = i.transport.decodeExtensions(extension.GetCustomExtensions())
+	return err
 }
 
 func (i *transportEarlyDataHandler) MatchMuxers(isInitiator bool) protocol.ID {
@@ -713,3 +713,77 @@ func TestHandshakeWithTransportEarlyData(t *testing.T) {
 		})
 	}
 }
+
+func TestCustomExtensions(t *testing.T) {
+	newExtension := func(data string) Extension {
+		return Extension{
+			Encode: func() []byte { return []byte(data) },
+			Decode: func(b []byte) (any, error) { return string(b), nil },
+		}
+	}
+	initTransport := newTestTransport(t, crypto.Ed25519, 2048)
+	require.NoError(t, initTransport.RegisterExtension(1, newExtension("initiator features")))
+	require.NoError(t, initTransport.RegisterExtension(3, newExtension("only known to the initiator")))
+	respTransport := newTestTransport(t, crypto.Ed25519, 2048)
+	require.NoError(t, respTransport.RegisterExtension(1, newExtension("responder features")))
+	require.NoError(t, respTransport.RegisterExtension(2, newExtension("only known to the responder")))
+	// Extensions that encode to nil aren't sent.
+	require.NoError(t, respTransport.RegisterExtension(3, Extension{
+		Encode: func() []byte { return nil },
+		Decode: func([]byte) (any, error) { return nil, nil },
+	}))
+
+	initConn, respConn := connect(t, initTransport, respTransport)
+	defer initConn.Close()
+	defer respConn.Close()
+	v, ok := initConn.Extension(1)
+	require.True(t, ok)
+	require.Equal(t, "responder features", v)
+	v, ok = respConn.Extension(1)
+	require.True(t, ok)
+	require.Equal(t, "initiator features", v)
+	// Extensions that aren't registered are ignored.
+	_, ok = initConn.Extension(2)
+	require.False(t, ok)
+	_, ok = initConn.Extension(3)
+	require.False(t, ok)
+	v, ok = respConn.Extension(3)
+	require.True(t, ok)
+	require.Nil(t, v)
+
+	require.ErrorContains(t, initTransport.RegisterExtension(1, newExtension("again")), "already registered")
+	require.ErrorContains(t, initTransport.RegisterExtension(4, Extension{}), "needs both Encode and Decode")
+}
+
+func TestCustomExtensionDecodeFailure(t *testing.T) {
+	initTransport := newTestTransport(t, crypto.Ed25519, 2048)
+	require.NoError(t, initTransport.RegisterExtension(1, Extension{
+		Encode: func() []byte { return []byte("foobar") },
+		Decode: func(b []byte) (any, error) { return b, nil },
+	}))
+	respTransport := newTestTransport(t, crypto.Ed25519, 2048)
+	require.NoError(t, respTransport.RegisterExtension(1, Extension{
+		Encode: func() []byte { return nil },
+		Decode: func([]byte) (any, error) { return nil, errors.New("nope") },
+	}))
+
+	init, resp := newConnPair(t)
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := respTransport.SecureInbound(context.Background(), resp, "")
+		errChan <- err
+	}()
+	// The initiator sends its extensions with the last handshake message, so
+	// the handshake appears to succeed for it.
+	conn, err := initTransport.SecureOutbound(context.Background(), init, respTransport.localID)
+	if err == nil {
+		_, err = conn.Read([]byte{0})
+	}
+	require.Error(t, err)
+	select {
+	case err := <-errChan:
+		require.ErrorContains(t, err, "failed to decode extension 1: nope")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
@@ -109,6 +109,13 @@ func NewIdentity(privKey ic.PrivKey, opts ...IdentityOption) (*Identity, error)
 // It should be used to create a new tls.Config before securing either an
 // incoming or outgoing connection.
 func (i *Identity) ConfigForPeer(remote peer.ID) (*tls.Config, <-chan ic.PubKey) {
+	return i.configForPeer(remote, nil)
+}
+
+// configForPeer is ConfigForPeer, additionally calling verified with the
+// outcome of every verification of the peer's certificate. pubKey is nil if
+// it couldn't be extracted from the certificate.
+func (i *Identity) configForPeer(remote peer.ID, verified func(pubKey ic.PubKey, err error)) (*tls.Config, <-chan ic.PubKey) {
 	keyCh := make(chan ic.PubKey, 1)
 	// We need to check the peer ID in the VerifyPeerCertificate callback.
 	// The tls.Config it is also used for listening, and we might also have concurrent dials.
@@ -117,12 +124,16 @@ func (i *Identity) ConfigForPeer(remote peer.ID) (*tls.Config, <-chan ic.PubKey)
 	// We're using InsecureSkipVerify, so the verifiedChains parameter will always be empty.
 	// We need to parse the certificates ourselves from the raw certs.
 	conf.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) (err error) {
+		var pubKey ic.PubKey
 		defer func() {
 			if rerr := recover(); rerr != nil {
 				fmt.Fprintf(os.Stderr, "panic when processing peer certificate in TLS handshake: %s\n%s\n", rerr, debug.Stack())
 				err = fmt.Errorf("panic when processing peer certificate in TLS handshake: %s", rerr)
 
 			}
+			if verified != nil {
+				verified(pubKey, err)
+			}
 		}()
 
 		defer close(keyCh)
@@ -136,7 +147,7 @@ func (i *Identity) ConfigForPeer(remote peer.ID) (*tls.Config, <-chan ic.PubKey)
 			chain[i] = cert
 		}
 
-		pubKey, err := PubKeyFromCertChain(chain)
+		pubKey, err = PubKeyFromCertChain(chain)
 		if err != nil {
 			return err
 		}
@@ -2,7 +2,9 @@ package libp2ptls
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net"
@@ -17,15 +19,55 @@ import (
 	"github.com/libp2p/go-libp2p/core/sec"
 	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
 
+	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
 )
 
 // ID is the protocol ID (used when negotiating with multistream)
 const ID = "/tls/1.0.0"
 
+// ErrCertificatePinMismatch is returned by SecureOutbound if the certificate of
+// the peer doesn't match the one pinned with WithPinnedCertificate.
+var ErrCertificatePinMismatch = errors.New("certificate doesn't match the pinned fingerprint")
+
+type pinnedCertificateKey struct{}
+
+// WithPinnedCertificate returns a context that makes SecureOutbound calls
+// using it only accept a peer presenting the certificate with the given
+// fingerprint, the SHA-256 hash of its DER encoding, e.g. sha256.Sum256(cert.Raw).
+// This is checked in addition to the peer ID. Note that go-libp2p peers
+// generate a new certificate every time they start.
+func WithPinnedCertificate(ctx context.Context, fingerprint [sha256.Size]byte) context.Context {
+	return context.WithValue(ctx, pinnedCertificateKey{}, fingerprint)
+}
+
+// PeerVerifiedFunc is called with the outcome of every verification of a
+// peer's certificate. p is the peer ID derived from the certificate, which is
+// empty if it couldn't be derived. err is nil if the certificate was accepted.
+// raddr is nil if the remote address couldn't be converted to a multiaddr.
+type PeerVerifiedFunc func(raddr ma.Multiaddr, dir network.Direction, p peer.ID, err error)
+
+// Option is an option for the TLS transport.
+type Option func(*Transport) error
+
+// WithPeerVerifiedCallback sets a callback that observes every verification of
+// a peer's certificate, successful or not, e.g. for audit logging. It is called
+// during the handshake, so it should return quickly.
+func WithPeerVerifiedCallback(cb PeerVerifiedFunc) Option {
+	return func(t *Transport) error {
+		if cb == nil {
+			return errors.New("nil peer verified callback")
+		}
+		t.peerVerified = cb
+		return nil
+	}
+}
+
 // Transport constructs secure communication sessions for a peer.
 type Transport struct {
 	identity *Identity
+	// peerVerified is optional.
+	peerVerified PeerVerifiedFunc
 
 	localPeer  peer.ID
 	privKey    ci.PrivKey
@@ -36,7 +78,7 @@ type Transport struct {
 var _ sec.SecureTransport = &Transport{}
 
 // New creates a TLS encrypted transport
-func New(id protocol.ID, key ci.PrivKey, muxers []tptu.StreamMuxer) (*Transport, error) {
+func New(id protocol.ID, key ci.PrivKey, muxers []tptu.StreamMuxer, opts ...Option) (*Transport, error) {
 	localPeer, err := peer.IDFromPrivateKey(key)
 	if err != nil {
 		return nil, err
@@ -51,6 +93,11 @@ func New(id protocol.ID, key ci.PrivKey, muxers []tptu.StreamMuxer) (*Transport,
 		privKey:    key,
 		muxers:     muxerIDs,
 	}
+	for _, opt := range opts {
+		if err := opt(t); err != nil {
+			return nil, err
+		}
+	}
 
 	identity, err := NewIdentity(key)
 	if err != nil {
@@ -63,7 +110,7 @@ func New(id protocol.ID, key ci.PrivKey, muxers []tptu.StreamMuxer) (*Transport,
 // SecureInbound runs the TLS handshake as a server.
 // If p is empty, connections from any peer are accepted.
 func (t *Transport) SecureInbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
-	config, keyCh := t.identity.ConfigForPeer(p)
+	config, keyCh := t.configForPeer(insecure, p, network.DirInbound)
 	muxers := make([]string, 0, len(t.muxers))
 	for _, muxer := range t.muxers {
 		muxers = append(muxers, string(muxer))
@@ -108,7 +155,19 @@ func (t *Transport) SecureInbound(ctx context.Context, insecure net.Conn, p peer
 // If the handshake fails, the server will close the connection. The client will
 // notice this after 1 RTT when calling Read.
 func (t *Transport) SecureOutbound(ctx context.Context, insecure net.Conn, p peer.ID) (sec.SecureConn, error) {
-	config, keyCh := t.identity.ConfigForPeer(p)
+	config, keyCh := t.configForPeer(insecure, p, network.DirOutbound)
+	if fingerprint, ok := ctx.Value(pinnedCertificateKey{}).([sha256.Size]byte); ok {
+		verify := config.VerifyPeerCertificate
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 || sha256.Sum256(rawCerts[0]) != fingerprint {
+				if t.peerVerified != nil {
+					t.reportVerification(insecure, network.DirOutbound, peerFromRawCerts(rawCerts), ErrCertificatePinMismatch)
+				}
+				return ErrCertificatePinMismatch
+			}
+			return verify(rawCerts, chains)
+		}
+	}
 	muxers := make([]string, 0, len(t.muxers))
 	for _, muxer := range t.muxers {
 		muxers = append(muxers, (string)(muxer))
@@ -122,6 +181,53 @@ func (t *Transport) SecureOutbound(ctx context.Context, insecure net.Conn, p pee
 	return cs, err
 }
 
+// configForPeer returns the config to secure insecure with, reporting the
+// verification of the peer's certificate to the peerVerified callback.
+func (t *Transport) configForPeer(insecure net.Conn, p peer.ID, dir network.Direction) (*tls.Config, <-chan ci.PubKey) {
+	if t.peerVerified == nil {
+		return t.identity.ConfigForPeer(p)
+	}
+	return t.identity.configForPeer(p, func(pubKey ci.PubKey, err error) {
+		var remote peer.ID
+		if pubKey != nil {
+			if id, idErr := peer.IDFromPublicKey(pubKey); idErr == nil {
+				remote = id
+			} else if err == nil {
+				err = idErr
+			}
+		}
+		t.reportVerification(insecure, dir, remote, err)
+	})
+}
+
+// reportVerification calls the peerVerified callback, which must be set.
+func (t *Transport) reportVerification(insecure net.Conn, dir network.Direction, remote peer.ID, err error) {
+	raddr, _ := manet.FromNetAddr(insecure.RemoteAddr())
+	t.peerVerified(raddr, dir, remote, err)
+}
+
+// peerFromRawCerts returns the peer ID of the certificate chain rawCerts, or an
+// empty peer ID if it can't be determined.
+func peerFromRawCerts(rawCerts [][]byte) peer.ID {
+	chain := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return ""
+		}
+		chain = append(chain, cert)
+	}
+	pubKey, err := PubKeyFromCertChain(chain)
+	if err != nil {
+		return ""
+	}
+	id, err := peer.IDFromPublicKey(pubKey)
+	if err != nil {
+		return ""
+	}
+	return id
+}
+
 func (t *Transport) handshake(ctx context.Context, tlsConn *tls.Conn, keyCh <-chan ci.PubKey) (_sconn sec.SecureConn, err error) {
 	defer func() {
 		if rerr := recover(); rerr != nil {
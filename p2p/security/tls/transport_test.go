@@ -7,6 +7,7 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -21,11 +22,14 @@ import (
 	"time"
 
 	ic "github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/sec"
 	tptu "github.com/libp2p/go-libp2p/p2p/net/upgrader"
 
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -422,6 +426,129 @@ func TestPeerIDMismatch(t *testing.T) {
 	})
 }
 
+func TestPinnedCertificate(t *testing.T) {
+	_, clientKey := createPeer(t)
+	serverID, serverKey := createPeer(t)
+
+	serverTransport, err := New(ID, serverKey, nil)
+	require.NoError(t, err)
+	clientTransport, err := New(ID, clientKey, nil)
+	require.NoError(t, err)
+	fingerprint := sha256.Sum256(serverTransport.identity.config.Certificates[0].Certificate[0])
+
+	handshake := func(t *testing.T, pin [sha256.Size]byte) (clientErr, serverErr error) {
+		clientInsecureConn, serverInsecureConn := connect(t)
+		errChan := make(chan error, 1)
+		go func() {
+			conn, err := serverTransport.SecureInbound(context.Background(), serverInsecureConn, "")
+			if err == nil {
+				conn.Write([]byte{0})
+			}
+			errChan <- err
+		}()
+		conn, clientErr := clientTransport.SecureOutbound(WithPinnedCertificate(context.Background(), pin), clientInsecureConn, serverID)
+		if clientErr == nil {
+			_, clientErr = conn.Read([]byte{0})
+		}
+		select {
+		case serverErr = <-errChan:
+		case <-time.After(time.Second):
+			t.Fatal("expected handshake to return on the server side")
+		}
+		return clientErr, serverErr
+	}
+
+	t.Run("matching pin", func(t *testing.T) {
+		clientErr, serverErr := handshake(t, fingerprint)
+		require.NoError(t, clientErr)
+		require.NoError(t, serverErr)
+	})
+
+	t.Run("mismatching pin", func(t *testing.T) {
+		wrong := fingerprint
+		wrong[0] ^= 0xff
+		clientErr, serverErr := handshake(t, wrong)
+		require.ErrorIs(t, clientErr, ErrCertificatePinMismatch)
+		require.Error(t, serverErr)
+	})
+}
+
+func TestPeerVerifiedCallback(t *testing.T) {
+	type verification struct {
+		raddr ma.Multiaddr
+		dir   network.Direction
+		p     peer.ID
+		err   error
+	}
+	verified := make(chan verification, 1)
+
+	clientID, clientKey := createPeer(t)
+	serverID, serverKey := createPeer(t)
+	serverTransport, err := New(ID, serverKey, nil)
+	require.NoError(t, err)
+	clientTransport, err := New(ID, clientKey, nil, WithPeerVerifiedCallback(func(raddr ma.Multiaddr, dir network.Direction, p peer.ID, err error) {
+		verified <- verification{raddr: raddr, dir: dir, p: p, err: err}
+	}))
+	require.NoError(t, err)
+
+	handshake := func(t *testing.T, expected peer.ID) (ma.Multiaddr, error) {
+		clientInsecureConn, serverInsecureConn := connect(t)
+		go func() {
+			conn, err := serverTransport.SecureInbound(context.Background(), serverInsecureConn, "")
+			if err == nil {
+				conn.Close()
+			}
+		}()
+		_, err := clientTransport.SecureOutbound(context.Background(), clientInsecureConn, expected)
+		raddr, maErr := manet.FromNetAddr(clientInsecureConn.RemoteAddr())
+		require.NoError(t, maErr)
+		return raddr, err
+	}
+
+	t.Run("successful verification", func(t *testing.T) {
+		raddr, err := handshake(t, serverID)
+		require.NoError(t, err)
+		v := <-verified
+		require.NoError(t, v.err)
+		require.Equal(t, serverID, v.p)
+		require.Equal(t, network.DirOutbound, v.dir)
+		require.True(t, raddr.Equal(v.raddr))
+	})
+
+	t.Run("failed verification", func(t *testing.T) {
+		raddr, err := handshake(t, clientID)
+		require.Error(t, err)
+		v := <-verified
+		var mismatchErr sec.ErrPeerIDMismatch
+		require.ErrorAs(t, v.err, &mismatchErr)
+		require.Equal(t, serverID, v.p)
+		require.Equal(t, network.DirOutbound, v.dir)
+		require.True(t, raddr.Equal(v.raddr))
+	})
+
+	t.Run("pinned certificate mismatch", func(t *testing.T) {
+		clientInsecureConn, serverInsecureConn := connect(t)
+		go func() {
+			conn, err := serverTransport.SecureInbound(context.Background(), serverInsecureConn, "")
+			if err == nil {
+				conn.Close()
+			}
+		}()
+		var pin [sha256.Size]byte
+		_, err := clientTransport.SecureOutbound(WithPinnedCertificate(context.Background(), pin), clientInsecureConn, serverID)
+		require.ErrorIs(t, err, ErrCertificatePinMismatch)
+		v := <-verified
+		require.ErrorIs(t, v.err, ErrCertificatePinMismatch)
+		require.Equal(t, serverID, v.p)
+		require.Equal(t, network.DirOutbound, v.dir)
+	})
+
+	t.Run("nil callback", func(t *testing.T) {
+		_, err := New(ID, clientKey, nil, WithPeerVerifiedCallback(nil))
+		require.Error(t, err)
+	})
+}
+
 func TestInvalidCerts(t *testing.T) {
 	_, clientKey := createPeer(t)
 	serverID, serverKey := createPeer(t)
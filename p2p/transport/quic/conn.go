@@ -2,31 +2,102 @@ package libp2pquic
 
 import (
 	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	ic "github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	tpt "github.com/libp2p/go-libp2p/core/transport"
+	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
 
 	ma "github.com/multiformats/go-multiaddr"
 	"github.com/quic-go/quic-go"
 )
 
+// ErrConnDraining is returned by OpenStream on a connection that is being
+// drained. The error is transient: the stream can be opened on another
+// connection to the same peer. Streams opened by the peer are reset with
+// network.StreamShutdown.
+var ErrConnDraining = errors.New("connection is draining")
+
+var (
+	// ErrDatagramsDisabled is returned when sending or receiving datagrams on a
+	// connection of a transport constructed without WithDatagrams.
+	ErrDatagramsDisabled = errors.New("datagrams are disabled")
+	// ErrDatagramsUnsupported is returned by SendDatagram if the peer didn't
+	// negotiate datagram support.
+	ErrDatagramsUnsupported = errors.New("peer doesn't support datagrams")
+)
+
 type conn struct {
 	quicConn  *quic.Conn
 	transport *transport
 	scope     network.ConnManagementScope
+	version   quic.Version
+	draining  atomic.Bool
+
+	// streamsMx guards streams and drained.
+	streamsMx sync.Mutex
+	// streams is the number of streams that weren't closed or reset yet.
+	streams int
+	// drained is closed once the connection is draining and all streams are
+	// done.
+	drained chan struct{}
+
+	created     time.Time
+	holePunched atomic.Bool
+	// lastStream is the time a stream was last opened or accepted, in unix nanoseconds.
+	lastStream atomic.Int64
+	counters   byteCounters
 
 	localPeer      peer.ID
 	localMultiaddr ma.Multiaddr
 
-	remotePeerID    peer.ID
-	remotePubKey    ic.PubKey
+	remotePeerID peer.ID
+	remotePubKey ic.PubKey
+
+	// remoteAddrMx guards remoteAddr and remoteMultiaddr, which are updated
+	// when the peer migrates the connection to a new path.
+	remoteAddrMx sync.Mutex
+	// remoteAddr is the address of the peer that remoteMultiaddr was derived
+	// from.
+	remoteAddr      net.Addr
 	remoteMultiaddr ma.Multiaddr
+
+	// serverName is the server name presented by the peer of an inbound
+	// connection, if the transport exposes it.
+	serverName string
+	// ctx is the context derived by the WithConnContext function. nil if it
+	// isn't set, or for outbound connections.
+	ctx context.Context
 }
 
 var _ tpt.CapableConn = &conn{}
 
+// byteCounters count the bytes sent and received by the application on a
+// connection.
+type byteCounters struct {
+	sent, received atomic.Uint64
+}
+
+// ByteCountingConn is implemented by the connections of this transport.
+type ByteCountingConn interface {
+	// ByteCounts returns the number of bytes written to and read from the
+	// streams and datagrams of the connection over its lifetime. QUIC framing,
+	// packet headers, retransmissions and the handshake aren't counted.
+	ByteCounts() (sent, received uint64)
+}
+
+var _ ByteCountingConn = &conn{}
+
+func (c *conn) ByteCounts() (sent, received uint64) {
+	return c.counters.sent.Load(), c.counters.received.Load()
+}
+
 // Close closes the connection.
 // It must be called even if the peer closed the connection in order for
 // garbage collection to properly work in this package.
@@ -57,22 +128,204 @@ func (c *conn) allowWindowIncrease(size uint64) bool {
 	return c.scope.ReserveMemory(int(size), network.ReservationPriorityMedium) == nil
 }
 
+// Drain gracefully shuts down the connection. Opening new streams fails with
+// ErrConnDraining, and streams opened by the peer are refused. Drain waits for
+// the existing streams to be closed or reset, or for ctx to be done, and then
+// closes the connection.
+func (c *conn) Drain(ctx context.Context) error {
+	c.streamsMx.Lock()
+	c.draining.Store(true)
+	if c.drained == nil {
+		c.drained = make(chan struct{})
+		if c.streams == 0 {
+			close(c.drained)
+		}
+	}
+	drained := c.drained
+	c.streamsMx.Unlock()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+	case <-c.quicConn.Context().Done():
+	}
+	return c.CloseWithError(network.ConnShutdown)
+}
+
+// addStream tracks a new stream. It returns false if the connection is
+// draining.
+func (c *conn) addStream() bool {
+	c.streamsMx.Lock()
+	defer c.streamsMx.Unlock()
+	if c.draining.Load() {
+		return false
+	}
+	c.streams++
+	return true
+}
+
+func (c *conn) removeStream() {
+	c.streamsMx.Lock()
+	defer c.streamsMx.Unlock()
+	c.streams--
+	if c.streams == 0 && c.drained != nil {
+		close(c.drained)
+	}
+}
+
+func (c *conn) newStream(qstr *quic.Stream) *stream {
+	c.lastStream.Store(time.Now().UnixNano())
+	return &stream{Stream: qstr, counters: &c.counters, state: &streamState{done: c.removeStream}}
+}
+
 // OpenStream creates a new stream.
 func (c *conn) OpenStream(ctx context.Context) (network.MuxedStream, error) {
+	if !c.addStream() {
+		return nil, ErrConnDraining
+	}
 	qstr, err := c.quicConn.OpenStreamSync(ctx)
+	if errors.Is(err, quic.Err0RTTRejected) {
+		// The peer rejected 0-RTT. Retry on the 1-RTT connection.
+		if _, err = c.quicConn.NextConnection(ctx); err == nil {
+			qstr, err = c.quicConn.OpenStreamSync(ctx)
+		}
+	}
 	if err != nil {
+		c.removeStream()
 		return nil, parseStreamError(err)
 	}
-	return &stream{Stream: qstr}, nil
+	return c.newStream(qstr), nil
 }
 
-// AcceptStream accepts a stream opened by the other side.
+// AcceptStream accepts a stream opened by the other side. Streams opened
+// while the connection is draining are reset.
 func (c *conn) AcceptStream() (network.MuxedStream, error) {
-	qstr, err := c.quicConn.AcceptStream(context.Background())
-	if err != nil {
-		return nil, parseStreamError(err)
+	for {
+		qstr, err := c.quicConn.AcceptStream(context.Background())
+		if errors.Is(err, quic.Err0RTTRejected) {
+			if _, err = c.quicConn.NextConnection(context.Background()); err == nil {
+				qstr, err = c.quicConn.AcceptStream(context.Background())
+			}
+		}
+		if err != nil {
+			return nil, parseStreamError(err)
+		}
+		if !c.addStream() {
+			qstr.CancelRead(quic.StreamErrorCode(network.StreamShutdown))
+			qstr.CancelWrite(quic.StreamErrorCode(network.StreamShutdown))
+			continue
+		}
+		return c.newStream(qstr), nil
+	}
+}
+
+// DatagramConn is implemented by the connections of this transport, to
+// exchange QUIC datagrams if the transport was constructed WithDatagrams.
+type DatagramConn interface {
+	SendDatagram(b []byte) error
+	ReceiveDatagram(ctx context.Context) ([]byte, error)
+}
+
+var _ DatagramConn = &conn{}
+
+// SendDatagram sends b as a QUIC datagram. Datagrams are unreliable and
+// limited in size by the path MTU. Larger datagrams fail with a
+// *quic.DatagramTooLargeError.
+func (c *conn) SendDatagram(b []byte) error {
+	if !c.transport.datagrams {
+		return ErrDatagramsDisabled
+	}
+	if !c.quicConn.ConnectionState().SupportsDatagrams {
+		return ErrDatagramsUnsupported
 	}
-	return &stream{Stream: qstr}, nil
+	if err := c.quicConn.SendDatagram(b); err != nil {
+		return err
+	}
+	c.counters.sent.Add(uint64(len(b)))
+	return nil
+}
+
+// ReceiveDatagram blocks until a QUIC datagram is received or ctx is done.
+func (c *conn) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	if !c.transport.datagrams {
+		return nil, ErrDatagramsDisabled
+	}
+	b, err := c.quicConn.ReceiveDatagram(ctx)
+	c.counters.received.Add(uint64(len(b)))
+	return b, err
+}
+
+func (c *conn) lastStreamTime() time.Time {
+	return time.Unix(0, c.lastStream.Load())
+}
+
+// SmoothedRTT returns the smoothed RTT estimate of the connection, or 0 if
+// no estimate is available yet. It is only available if the transport's
+// ConnManager was constructed with quicreuse.EnableConnTracking.
+func (c *conn) SmoothedRTT() time.Duration {
+	return c.transport.connManager.SmoothedRTT(c.quicConn)
+}
+
+// IdleTimeout returns the idle timeout negotiated for the connection. The
+// connection is closed if nothing was received from the peer for this long.
+// Keep-alive intervals should stay well below it. Like SmoothedRTT, it is only
+// available with quicreuse.EnableConnTracking.
+func (c *conn) IdleTimeout() time.Duration {
+	return c.transport.connManager.IdleTimeout(c.quicConn)
+}
+
+// ServerNameConn is implemented by the connections of this transport. Connection
+// gaters can use it in InterceptAccept and InterceptSecured to gate inbound
+// connections on the server name.
+type ServerNameConn interface {
+	// ServerName returns the server name (SNI) the peer presented on an inbound
+	// connection. It is empty for outbound connections, if the peer didn't
+	// present one, or unless the transport was constructed WithServerNameIndication.
+	ServerName() string
+}
+
+var _ ServerNameConn = &conn{}
+
+func (c *conn) ServerName() string { return c.serverName }
+
+// KeyingMaterialExporter is implemented by the connections of this transport.
+// Application protocols can use it to bind tokens to the QUIC session.
+type KeyingMaterialExporter interface {
+	// ExportKeyingMaterial returns length bytes of keying material derived from
+	// the TLS session of the connection, as defined in RFC 5705. Both ends of
+	// the connection derive the same material for the same label and context.
+	ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error)
+}
+
+var _ KeyingMaterialExporter = &conn{}
+
+func (c *conn) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	// Connections using 0-RTT are returned before the handshake completes.
+	// The exporter is only available afterwards.
+	select {
+	case <-c.quicConn.HandshakeComplete():
+	default:
+		return nil, errors.New("handshake not complete")
+	}
+	state := c.quicConn.ConnectionState().TLS
+	return state.ExportKeyingMaterial(label, context, length)
+}
+
+// ContextConn is implemented by the connections of this transport.
+type ContextConn interface {
+	// Context returns the context of the connection, which is canceled when
+	// the connection is closed. For accepted connections, it is the context
+	// returned by the WithConnContext function, if set.
+	Context() context.Context
+}
+
+var _ ContextConn = &conn{}
+
+func (c *conn) Context() context.Context {
+	if c.ctx != nil {
+		return c.ctx
+	}
+	return c.quicConn.Context()
 }
 
 // LocalPeer returns our peer ID
@@ -87,11 +340,37 @@ func (c *conn) RemotePublicKey() ic.PubKey { return c.remotePubKey }
 // LocalMultiaddr returns the local Multiaddr associated
 func (c *conn) LocalMultiaddr() ma.Multiaddr { return c.localMultiaddr }
 
-// RemoteMultiaddr returns the remote Multiaddr associated
-func (c *conn) RemoteMultiaddr() ma.Multiaddr { return c.remoteMultiaddr }
+// RemoteMultiaddr returns the remote Multiaddr associated. If the peer
+// migrated the connection to a new path, e.g. because its address changed, it
+// returns the address of the new path. The connection keeps its resource
+// manager scope, which accounts to the peer regardless of its address.
+func (c *conn) RemoteMultiaddr() ma.Multiaddr {
+	// quic-go replaces the address when the connection migrates, so comparing
+	// it to the previous one is cheap.
+	addr := c.quicConn.RemoteAddr()
+	c.remoteAddrMx.Lock()
+	defer c.remoteAddrMx.Unlock()
+	if addr == c.remoteAddr {
+		return c.remoteMultiaddr
+	}
+	c.remoteAddr = addr
+	raddr, err := quicreuse.ToQuicMultiaddr(addr, c.version)
+	if err != nil {
+		log.Debugw("failed to convert migrated remote address", "peer", c.remotePeerID, "addr", addr, "error", err)
+		return c.remoteMultiaddr
+	}
+	if !raddr.Equal(c.remoteMultiaddr) {
+		log.Debugw("connection migrated to a new path", "peer", c.remotePeerID, "from", c.remoteMultiaddr, "to", raddr)
+		c.remoteMultiaddr = raddr
+	}
+	return c.remoteMultiaddr
+}
 
 func (c *conn) Transport() tpt.Transport { return c.transport }
 
+// Version returns the QUIC version negotiated for this connection.
+func (c *conn) Version() quic.Version { return c.version }
+
 func (c *conn) Scope() network.ConnScope { return c.scope }
 
 // ConnState is the state of security connection.
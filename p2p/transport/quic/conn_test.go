@@ -4,22 +4,32 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"errors"
+	"fmt"
 	"io"
+	"math/big"
 	mrand "math/rand"
 	"net"
+	"slices"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/connmgr"
 	ic "github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/network"
 	mocknetwork "github.com/libp2p/go-libp2p/core/network/mocks"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/sec"
 	tpt "github.com/libp2p/go-libp2p/core/transport"
+	p2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
 	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
 
 	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/quic-go/quic-go"
 	quicproxy "github.com/quic-go/quic-go/integrationtests/tools/proxy"
 	"github.com/stretchr/testify/require"
@@ -38,7 +48,7 @@ var connTestCases = []*connTestCase{
 	{"reuseport_off", []quicreuse.Option{quicreuse.DisableReuseport()}},
 }
 
-func createPeer(t *testing.T) (peer.ID, ic.PrivKey) {
+func createPeer(t testing.TB) (peer.ID, ic.PrivKey) {
 	var priv ic.PrivKey
 	var err error
 	switch mrand.Int() % 4 {
@@ -66,7 +76,7 @@ func runServer(t *testing.T, tr tpt.Transport, addr string) tpt.Listener {
 	return ln
 }
 
-func newConnManager(t *testing.T, opts ...quicreuse.Option) *quicreuse.ConnManager {
+func newConnManager(t testing.TB, opts ...quicreuse.Option) *quicreuse.ConnManager {
 	t.Helper()
 	cm, err := quicreuse.NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, opts...)
 	require.NoError(t, err)
@@ -475,6 +485,95 @@ func testConnectionGating(t *testing.T, tc *connTestCase) {
 	})
 }
 
+var testClaimOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 53594, 42}
+
+// certClaimGater only allows connections from peers whose certificate carries
+// the testClaimOID extension.
+type certClaimGater struct {
+	connmgr.ConnectionGater
+	certCalls atomic.Int32
+}
+
+var _ connmgr.CertificateGater = &certClaimGater{}
+
+func (g *certClaimGater) InterceptAccept(network.ConnMultiaddrs) bool { return true }
+
+func (g *certClaimGater) InterceptSecured(network.Direction, peer.ID, network.ConnMultiaddrs) bool {
+	panic("InterceptSecured shouldn't be called on a CertificateGater")
+}
+
+func (g *certClaimGater) InterceptSecuredWithCert(_ network.Direction, _ peer.ID, _ network.ConnMultiaddrs, chain []*x509.Certificate) bool {
+	g.certCalls.Add(1)
+	if len(chain) == 0 {
+		return false
+	}
+	for _, ext := range chain[0].Extensions {
+		if ext.Id.Equal(testClaimOID) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConnectionGatingWithCert(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	cg := &certClaimGater{}
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, cg, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+	accepted := make(chan tpt.CapableConn, 1)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	newClient := func(t *testing.T, exts ...pkix.Extension) tpt.Transport {
+		tr, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { tr.(io.Closer).Close() })
+		tmpl := &x509.Certificate{
+			SerialNumber:    big.NewInt(1),
+			NotBefore:       time.Now().Add(-time.Hour),
+			NotAfter:        time.Now().Add(time.Hour),
+			Subject:         pkix.Name{SerialNumber: "1"},
+			ExtraExtensions: exts,
+		}
+		tr.(*transport).identity, err = p2ptls.NewIdentity(clientKey, p2ptls.WithCertTemplate(tmpl))
+		require.NoError(t, err)
+		return tr
+	}
+
+	t.Run("without claim", func(t *testing.T) {
+		conn, err := newClient(t).Dial(context.Background(), ln.Multiaddr(), serverID)
+		if err == nil {
+			_, err = conn.AcceptStream()
+		}
+		require.ErrorContains(t, err, "connection gated")
+	})
+
+	t.Run("with claim", func(t *testing.T) {
+		conn, err := newClient(t, pkix.Extension{Id: testClaimOID, Value: []byte{0x05, 0x00}}).Dial(context.Background(), ln.Multiaddr(), serverID)
+		require.NoError(t, err)
+		defer conn.Close()
+		select {
+		case c := <-accepted:
+			c.Close()
+		case <-time.After(5 * time.Second):
+			t.Fatal("connection wasn't accepted")
+		}
+	})
+	require.Equal(t, int32(2), cg.certCalls.Load())
+}
+
 func TestDialTwo(t *testing.T) {
 	for _, tc := range connTestCases {
 		t.Run(tc.Name, func(t *testing.T) {
@@ -730,3 +829,925 @@ func TestHolePunching(t *testing.T) {
 	<-done1
 	<-done2
 }
+
+func TestConnVersion(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+	clientConn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer clientConn.Close()
+	serverConn, err := ln.Accept()
+	require.NoError(t, err)
+	defer serverConn.Close()
+
+	require.Equal(t, quic.Version1, clientConn.(*conn).Version())
+	require.Equal(t, quic.Version1, serverConn.(*conn).Version())
+}
+
+func TestConnDrain(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+	clientConn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer clientConn.Close()
+	serverConn, err := ln.Accept()
+	require.NoError(t, err)
+	defer serverConn.Close()
+
+	str, err := clientConn.OpenStream(context.Background())
+	require.NoError(t, err)
+	_, err = str.Write([]byte("foo"))
+	require.NoError(t, err)
+	sstr, err := serverConn.AcceptStream()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	drained := make(chan error, 1)
+	go func() { drained <- clientTransport.(*transport).Drain(ctx) }()
+
+	require.Eventually(t, func() bool { return clientConn.(*conn).draining.Load() }, time.Second, 10*time.Millisecond)
+	_, err = clientConn.OpenStream(context.Background())
+	require.ErrorIs(t, err, ErrConnDraining)
+
+	// The existing stream still works in both directions.
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(sstr, buf)
+	require.NoError(t, err)
+	require.Equal(t, "foo", string(buf))
+	_, err = sstr.Write([]byte("bar"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(str, buf)
+	require.NoError(t, err)
+	require.Equal(t, "bar", string(buf))
+
+	// Streams opened by the server are refused.
+	go clientConn.AcceptStream()
+	refused, err := serverConn.OpenStream(context.Background())
+	require.NoError(t, err)
+	_, err = refused.Write([]byte("foo"))
+	require.NoError(t, err)
+	_, err = refused.Read(buf)
+	var streamErr *network.StreamError
+	require.ErrorAs(t, err, &streamErr)
+	require.Equal(t, network.StreamShutdown, streamErr.ErrorCode)
+
+	select {
+	case <-drained:
+		t.Fatal("drain returned before the stream was closed")
+	default:
+	}
+	// Drain returns once the stream is closed, without waiting for ctx.
+	require.NoError(t, str.Close())
+	select {
+	case err := <-drained:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("drain didn't return")
+	}
+	require.NoError(t, ctx.Err())
+	cancel()
+	require.True(t, clientConn.IsClosed())
+
+	_, err = serverConn.AcceptStream()
+	var connErr *network.ConnError
+	require.ErrorAs(t, err, &connErr)
+	require.Equal(t, network.ConnShutdown, connErr.ErrorCode)
+}
+
+func TestConnDrainTimeout(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+	clientConn, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer clientConn.Close()
+
+	// A stream that is never closed holds up the drain until ctx is done.
+	str, err := clientConn.OpenStream(context.Background())
+	require.NoError(t, err)
+	defer str.Reset()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	require.NoError(t, clientConn.(*conn).Drain(ctx))
+	require.ErrorIs(t, ctx.Err(), context.DeadlineExceeded)
+	require.True(t, clientConn.IsClosed())
+}
+
+func TestZeroRTT(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	newServer := func(t *testing.T) tpt.Listener {
+		tr, err := NewTransport(serverKey, newConnManager(t, quicreuse.EnableZeroRTT()), nil, nil, nil, WithZeroRTT())
+		require.NoError(t, err)
+		t.Cleanup(func() { tr.(io.Closer).Close() })
+		ln := runServer(t, tr, "/ip4/127.0.0.1/udp/0/quic-v1")
+		t.Cleanup(func() { ln.Close() })
+		return ln
+	}
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil, WithZeroRTT())
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+
+	dialAndEcho := func(t *testing.T, ln tpt.Listener) *quic.Conn {
+		t.Helper()
+		c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		require.Equal(t, serverID, c.RemotePeer())
+		serverConn, err := ln.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { serverConn.Close() })
+		qconn := c.(*conn).quicConn
+		<-qconn.HandshakeComplete()
+
+		str, err := c.OpenStream(context.Background())
+		require.NoError(t, err)
+		_, err = str.Write([]byte("foobar"))
+		require.NoError(t, err)
+		str.CloseWrite()
+		sstr, err := serverConn.AcceptStream()
+		require.NoError(t, err)
+		data, err := io.ReadAll(sstr)
+		require.NoError(t, err)
+		require.Equal(t, []byte("foobar"), data)
+		return qconn
+	}
+	waitForTicket := func(t *testing.T) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			return clientTransport.(*transport).sessionCache.Contains(serverID)
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	ln := newServer(t)
+	qconn := dialAndEcho(t, ln)
+	require.False(t, qconn.ConnectionState().Used0RTT)
+	waitForTicket(t)
+
+	t.Run("resumption", func(t *testing.T) {
+		qconn := dialAndEcho(t, ln)
+		require.True(t, qconn.ConnectionState().Used0RTT)
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		// A new server instance uses a different session ticket key,
+		// so it can't resume the session and rejects 0-RTT.
+		ln := newServer(t)
+		qconn := dialAndEcho(t, ln)
+		require.False(t, qconn.ConnectionState().Used0RTT)
+	})
+}
+
+func TestMaxConnAge(t *testing.T) {
+	const maxAge = 100 * time.Millisecond
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	dial := func(t *testing.T, l ConnAgeLimit) (tpt.CapableConn, tpt.CapableConn) {
+		t.Helper()
+		tr, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil, WithMaxConnAge(l))
+		require.NoError(t, err)
+		t.Cleanup(func() { tr.(io.Closer).Close() })
+		c, err := tr.Dial(context.Background(), ln.Multiaddr(), serverID)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		serverConn, err := ln.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { serverConn.Close() })
+		return c, serverConn
+	}
+
+	t.Run("closes old connections", func(t *testing.T) {
+		c, serverConn := dial(t, ConnAgeLimit{MaxAge: maxAge})
+		require.Eventually(t, c.IsClosed, 5*time.Second, 10*time.Millisecond)
+		_, err := serverConn.AcceptStream()
+		var appErr *quic.ApplicationError
+		require.ErrorAs(t, err, &appErr)
+		require.Equal(t, quic.ApplicationErrorCode(network.ConnShutdown), appErr.ErrorCode)
+	})
+
+	t.Run("exempts hole punched connections", func(t *testing.T) {
+		c, _ := dial(t, ConnAgeLimit{MaxAge: maxAge, ExemptHolePunched: true})
+		c.(*conn).holePunched.Store(true)
+		time.Sleep(3 * maxAge)
+		require.False(t, c.IsClosed())
+	})
+
+	t.Run("exempts active connections", func(t *testing.T) {
+		c, _ := dial(t, ConnAgeLimit{MaxAge: maxAge, ExemptActiveWithin: 5 * maxAge})
+		for i := 0; i < 6; i++ {
+			str, err := c.OpenStream(context.Background())
+			require.NoError(t, err)
+			str.Close()
+			time.Sleep(maxAge / 2)
+		}
+		require.False(t, c.IsClosed())
+		// Once the connection goes idle, it is closed.
+		require.Eventually(t, c.IsClosed, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestSmoothedRTT(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	serverTransport, err := NewTransport(serverKey, newConnManager(t, quicreuse.EnableConnTracking()), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	type rttSample struct {
+		peer peer.ID
+		rtt  time.Duration
+	}
+	samples := make(chan rttSample, 1)
+	rttCallback := WithRTTCallback(func(p peer.ID, rtt time.Duration) {
+		samples <- rttSample{peer: p, rtt: rtt}
+	})
+	_, err = NewTransport(clientKey, newConnManager(t), nil, nil, nil, rttCallback)
+	require.Error(t, err)
+	clientTransport, err := NewTransport(clientKey, newConnManager(t, quicreuse.EnableConnTracking()), nil, nil, nil, rttCallback)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+
+	c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer c.Close()
+	serverConn, err := ln.Accept()
+	require.NoError(t, err)
+	defer serverConn.Close()
+
+	select {
+	case s := <-samples:
+		require.Equal(t, serverID, s.peer)
+		require.Positive(t, s.rtt)
+	case <-time.After(5 * time.Second):
+		t.Fatal("RTT callback not called")
+	}
+	require.Positive(t, c.(*conn).SmoothedRTT())
+	require.Positive(t, serverConn.(*conn).SmoothedRTT())
+
+	// Without connection tracking, no RTT is reported.
+	otherTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer otherTransport.(io.Closer).Close()
+	c2, err := otherTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer c2.Close()
+	require.Zero(t, c2.(*conn).SmoothedRTT())
+}
+
+func TestLocalAddrRewrite(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	// pretend we're behind a load balancer listening on port 443.
+	rewrite := func(addr ma.Multiaddr) ma.Multiaddr {
+		ip, err := manet.ToIP(addr)
+		require.NoError(t, err)
+		return ma.StringCast(fmt.Sprintf("/ip4/%s/udp/443/quic-v1", ip))
+	}
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil, WithLocalAddrRewrite(rewrite))
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil, WithLocalAddrRewrite(rewrite))
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+
+	c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer c.Close()
+	serverConn, err := ln.Accept()
+	require.NoError(t, err)
+	defer serverConn.Close()
+
+	for _, c := range []tpt.CapableConn{serverConn, c} {
+		port, err := c.LocalMultiaddr().ValueForProtocol(ma.P_UDP)
+		require.NoError(t, err)
+		require.Equal(t, "443", port)
+	}
+	// the listener still reports the address it is listening on.
+	port, err := ln.Multiaddr().ValueForProtocol(ma.P_UDP)
+	require.NoError(t, err)
+	require.NotEqual(t, "443", port)
+	// the remote side sees the original address.
+	require.Equal(t, ln.Multiaddr(), c.RemoteMultiaddr())
+	require.Equal(t, "quic-v1", c.ConnState().Transport)
+}
+
+func TestMaxIncomingStreams(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	serverTransport, err := NewTransport(serverKey, newConnManager(t, quicreuse.WithMaxIncomingStreams(2)), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+	c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer c.Close()
+	serverConn, err := ln.Accept()
+	require.NoError(t, err)
+	defer serverConn.Close()
+
+	var streams []network.MuxedStream
+	for i := 0; i < 2; i++ {
+		str, err := c.OpenStream(context.Background())
+		require.NoError(t, err)
+		streams = append(streams, str)
+	}
+	// the server doesn't grant more streams.
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = c.OpenStream(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// closing a stream allows opening another one.
+	for _, str := range streams[:1] {
+		_, err := str.Write([]byte("foo"))
+		require.NoError(t, err)
+		require.NoError(t, str.Close())
+		sstr, err := serverConn.AcceptStream()
+		require.NoError(t, err)
+		_, err = io.ReadAll(sstr)
+		require.NoError(t, err)
+		// the client already stopped reading, so this fails, but it still closes the stream.
+		sstr.Close()
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	str, err := c.OpenStream(ctx)
+	require.NoError(t, err)
+	str.Close()
+
+	_, err = quicreuse.NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, quicreuse.WithMaxIncomingStreams(0))
+	require.Error(t, err)
+}
+
+func TestDatagrams(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil, WithDatagrams())
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil, WithDatagrams())
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+
+	c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer c.Close()
+	sc, err := ln.Accept()
+	require.NoError(t, err)
+	defer sc.Close()
+	clientConn, serverConn := c.(*conn), sc.(*conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, dir := range []struct{ from, to *conn }{{clientConn, serverConn}, {serverConn, clientConn}} {
+		msg := []byte("ping from " + dir.from.LocalPeer().String())
+		require.NoError(t, dir.from.SendDatagram(msg))
+		b, err := dir.to.ReceiveDatagram(ctx)
+		require.NoError(t, err)
+		require.Equal(t, msg, b)
+	}
+
+	var tooLarge *quic.DatagramTooLargeError
+	require.ErrorAs(t, clientConn.SendDatagram(make([]byte, 1<<16)), &tooLarge)
+	require.Positive(t, tooLarge.MaxDatagramPayloadSize)
+
+	t.Run("disabled", func(t *testing.T) {
+		tr, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+		require.NoError(t, err)
+		defer tr.(io.Closer).Close()
+		c, err := tr.Dial(context.Background(), ln.Multiaddr(), serverID)
+		require.NoError(t, err)
+		defer c.Close()
+		sc, err := ln.Accept()
+		require.NoError(t, err)
+		defer sc.Close()
+
+		require.ErrorIs(t, c.(*conn).SendDatagram([]byte("foo")), ErrDatagramsDisabled)
+		_, err = c.(*conn).ReceiveDatagram(context.Background())
+		require.ErrorIs(t, err, ErrDatagramsDisabled)
+	})
+}
+
+func TestServerNameIndication(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	cg := NewMockConnectionGater(mockCtrl)
+	var gatedName string
+	cg.EXPECT().InterceptAccept(gomock.Any()).DoAndReturn(func(addrs network.ConnMultiaddrs) bool {
+		gatedName = addrs.(ServerNameConn).ServerName()
+		return true
+	})
+	cg.EXPECT().InterceptSecured(gomock.Any(), gomock.Any(), gomock.Any()).Return(true)
+
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, cg, nil, WithServerNameIndication())
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+
+	c, err := clientTransport.Dial(WithDialServerName(context.Background(), "svc.example.com"), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer c.Close()
+	sc, err := ln.Accept()
+	require.NoError(t, err)
+	defer sc.Close()
+
+	require.Equal(t, "svc.example.com", sc.(ServerNameConn).ServerName())
+	require.Equal(t, "svc.example.com", gatedName)
+	require.Empty(t, c.(ServerNameConn).ServerName())
+
+	t.Run("not exposed by default", func(t *testing.T) {
+		serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+		require.NoError(t, err)
+		defer serverTransport.(io.Closer).Close()
+		ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+		defer ln.Close()
+
+		c, err := clientTransport.Dial(WithDialServerName(context.Background(), "svc.example.com"), ln.Multiaddr(), serverID)
+		require.NoError(t, err)
+		defer c.Close()
+		sc, err := ln.Accept()
+		require.NoError(t, err)
+		defer sc.Close()
+		require.Empty(t, sc.(ServerNameConn).ServerName())
+	})
+}
+
+func TestByteCounts(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+	c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer c.Close()
+	sc, err := ln.Accept()
+	require.NoError(t, err)
+	defer sc.Close()
+
+	const request, response = 100 << 10, 5 << 10
+	str, err := c.OpenStream(context.Background())
+	require.NoError(t, err)
+	_, err = str.Write(make([]byte, request))
+	require.NoError(t, err)
+	require.NoError(t, str.CloseWrite())
+
+	sstr, err := sc.AcceptStream()
+	require.NoError(t, err)
+	data, err := io.ReadAll(sstr)
+	require.NoError(t, err)
+	require.Len(t, data, request)
+	_, err = sstr.Write(make([]byte, response))
+	require.NoError(t, err)
+	require.NoError(t, sstr.CloseWrite())
+
+	data, err = io.ReadAll(str)
+	require.NoError(t, err)
+	require.Len(t, data, response)
+
+	sent, received := c.(ByteCountingConn).ByteCounts()
+	require.Equal(t, uint64(request), sent)
+	require.Equal(t, uint64(response), received)
+	sent, received = sc.(ByteCountingConn).ByteCounts()
+	require.Equal(t, uint64(response), sent)
+	require.Equal(t, uint64(request), received)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	serverTransport, err := NewTransport(serverKey, newConnManager(t, quicreuse.EnableConnTracking()), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	var drop atomic.Bool
+	proxyConn, cleanup := newUDPConnLocalhost(t, 0)
+	defer cleanup()
+	proxy := quicproxy.Proxy{
+		Conn:       proxyConn,
+		ServerAddr: ln.Addr().(*net.UDPAddr),
+		DropPacket: func(quicproxy.Direction, net.Addr, net.Addr, []byte) bool { return drop.Load() },
+	}
+	require.NoError(t, proxy.Start())
+	defer proxy.Close()
+
+	clientTransport, err := NewTransport(clientKey, newConnManager(t, quicreuse.EnableConnTracking(), quicreuse.WithMaxIdleTimeout(500*time.Millisecond)), nil, nil, nil)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+	proxyAddr, err := quicreuse.ToQuicMultiaddr(proxy.LocalAddr(), quic.Version1)
+	require.NoError(t, err)
+	c, err := clientTransport.Dial(context.Background(), proxyAddr, serverID)
+	require.NoError(t, err)
+	defer c.Close()
+	serverConn, err := ln.Accept()
+	require.NoError(t, err)
+	defer serverConn.Close()
+
+	require.Equal(t, 500*time.Millisecond, c.(*conn).IdleTimeout())
+	// quic-go raises the idle timeout offered by the client to 5 seconds.
+	require.Equal(t, 5*time.Second, serverConn.(*conn).IdleTimeout())
+
+	// Keep-alives keep the connection open while the peer responds.
+	time.Sleep(time.Second)
+	require.False(t, c.IsClosed())
+
+	drop.Store(true)
+	require.Eventually(t, c.IsClosed, 3*time.Second, 10*time.Millisecond)
+	_, err = c.OpenStream(context.Background())
+	var idleErr *quic.IdleTimeoutError
+	require.ErrorAs(t, err, &idleErr)
+
+	_, err = quicreuse.NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, quicreuse.WithMaxIdleTimeout(0))
+	require.ErrorContains(t, err, "max idle timeout must be positive")
+}
+
+func TestExportKeyingMaterial(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+	c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer c.Close()
+	sc, err := ln.Accept()
+	require.NoError(t, err)
+	defer sc.Close()
+
+	ekm, err := c.(KeyingMaterialExporter).ExportKeyingMaterial("EXPORTER-test", []byte("context"), 32)
+	require.NoError(t, err)
+	require.Len(t, ekm, 32)
+	sekm, err := sc.(KeyingMaterialExporter).ExportKeyingMaterial("EXPORTER-test", []byte("context"), 32)
+	require.NoError(t, err)
+	require.Equal(t, ekm, sekm)
+
+	other, err := c.(KeyingMaterialExporter).ExportKeyingMaterial("EXPORTER-other", []byte("context"), 32)
+	require.NoError(t, err)
+	require.NotEqual(t, ekm, other)
+}
+
+func TestConnections(t *testing.T) {
+	_, clientKey := createPeer(t)
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+	require.Empty(t, clientTransport.(ConnLister).Connections())
+
+	var conns []tpt.CapableConn
+	for range 2 {
+		serverID, serverKey := createPeer(t)
+		serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+		require.NoError(t, err)
+		defer serverTransport.(io.Closer).Close()
+		ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+		defer ln.Close()
+
+		c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		require.NoError(t, err)
+		defer c.Close()
+		sc, err := ln.Accept()
+		require.NoError(t, err)
+		defer sc.Close()
+		conns = append(conns, c)
+
+		infos := serverTransport.(ConnLister).Connections()
+		require.Len(t, infos, 1)
+		require.Equal(t, sc.RemotePeer(), infos[0].RemotePeer)
+	}
+
+	infos := clientTransport.(ConnLister).Connections()
+	require.Len(t, infos, 2)
+	for _, c := range conns {
+		i := slices.IndexFunc(infos, func(info ConnInfo) bool { return info.RemotePeer == c.RemotePeer() })
+		require.NotEqual(t, -1, i)
+		require.Equal(t, c.RemoteMultiaddr(), infos[i].RemoteMultiaddr)
+		require.Equal(t, quic.Version1, infos[i].Version)
+		require.Positive(t, infos[i].Age)
+	}
+
+	require.NoError(t, conns[0].Close())
+	infos = clientTransport.(ConnLister).Connections()
+	require.Len(t, infos, 1)
+	require.Equal(t, conns[1].RemotePeer(), infos[0].RemotePeer)
+}
+
+func TestConnContext(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	type correlationIDKey struct{}
+	var hookAddr atomic.Value
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil, WithConnContext(
+		func(ctx context.Context, remote ma.Multiaddr) context.Context {
+			hookAddr.Store(remote)
+			return context.WithValue(ctx, correlationIDKey{}, "corr-1")
+		},
+	))
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+	c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer c.Close()
+	sc, err := ln.Accept()
+	require.NoError(t, err)
+	require.Equal(t, sc.RemoteMultiaddr(), hookAddr.Load())
+
+	str, err := c.OpenStream(context.Background())
+	require.NoError(t, err)
+	_, err = str.Write([]byte("foobar"))
+	require.NoError(t, err)
+	sstr, err := sc.AcceptStream()
+	require.NoError(t, err)
+	defer sstr.Close()
+	ctx := sc.(ContextConn).Context()
+	require.Equal(t, "corr-1", ctx.Value(correlationIDKey{}))
+	require.Nil(t, c.(ContextConn).Context().Value(correlationIDKey{}))
+
+	// The context is canceled when the connection is closed.
+	require.NoError(t, sc.Close())
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context wasn't canceled")
+	}
+}
+
+func TestStrictPeerIDCheck(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+	thirdPartyID, _ := createPeer(t)
+
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil, WithStrictPeerIDCheck())
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+
+	// dial, but expect the wrong peer ID
+	_, err = clientTransport.Dial(context.Background(), ln.Multiaddr(), thirdPartyID)
+	var mismatchErr sec.ErrPeerIDMismatch
+	require.ErrorAs(t, err, &mismatchErr)
+	require.Equal(t, thirdPartyID, mismatchErr.Expected)
+	require.Equal(t, serverID, mismatchErr.Actual)
+
+	// The server learns why the connection was closed.
+	sc, err := ln.Accept()
+	require.NoError(t, err)
+	_, err = sc.AcceptStream()
+	var connErr *network.ConnError
+	require.ErrorAs(t, err, &connErr)
+	require.True(t, connErr.Remote)
+	require.Equal(t, ConnPeerIDMismatch, connErr.ErrorCode)
+
+	// Dialing the right peer ID still works.
+	c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, serverID, c.RemotePeer())
+}
+
+func TestDialBudget(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+	otherID, _ := createPeer(t)
+
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil, WithDialBudget(0.01, 3))
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+
+	var dialed, rejected int
+	for range 10 {
+		c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+		if errors.Is(err, ErrDialBudgetExceeded) {
+			rejected++
+			continue
+		}
+		require.NoError(t, err)
+		defer c.Close()
+		dialed++
+	}
+	require.Equal(t, 3, dialed)
+	require.Equal(t, 7, rejected)
+
+	// Other peers have their own budget.
+	_, err = clientTransport.Dial(context.Background(), ln.Multiaddr(), otherID)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrDialBudgetExceeded)
+
+	_, err = NewTransport(clientKey, newConnManager(t), nil, nil, nil, WithDialBudget(1, 0))
+	require.ErrorContains(t, err, "dial budget rate and burst must be positive")
+}
+
+func TestConnMigration(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+
+	c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer c.Close()
+	sc, err := ln.Accept()
+	require.NoError(t, err)
+	defer sc.Close()
+	oldAddr := sc.RemoteMultiaddr()
+	scope := sc.Scope()
+
+	// Migrate the client to a new socket.
+	udpConn, closeUDPConn := newUDPConnLocalhost(t, 0)
+	defer closeUDPConn()
+	tr := &quic.Transport{Conn: udpConn}
+	defer tr.Close()
+	path, err := c.(*conn).quicConn.AddPath(tr)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, path.Probe(ctx))
+	require.NoError(t, path.Switch())
+
+	// The server switches to the new path once it receives data on it.
+	str, err := c.OpenStream(ctx)
+	require.NoError(t, err)
+	_, err = str.Write([]byte("foobar"))
+	require.NoError(t, err)
+	sstr, err := sc.AcceptStream()
+	require.NoError(t, err)
+	_, err = io.ReadFull(sstr, make([]byte, 6))
+	require.NoError(t, err)
+
+	newAddr, err := quicreuse.ToQuicMultiaddr(udpConn.LocalAddr(), quic.Version1)
+	require.NoError(t, err)
+	require.False(t, oldAddr.Equal(newAddr))
+	require.Eventually(t, func() bool { return sc.RemoteMultiaddr().Equal(newAddr) }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, newAddr, serverTransport.(ConnLister).Connections()[0].RemoteMultiaddr)
+	require.Equal(t, scope, sc.Scope())
+}
+
+func TestWarmup(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+	serverTransport, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport.(io.Closer).Close()
+	ln := runServer(t, serverTransport, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln.Close()
+	clientTransport, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer clientTransport.(io.Closer).Close()
+
+	require.NoError(t, clientTransport.(Warmer).Warmup(context.Background(), serverID, ln.Multiaddr()))
+	// Warming up again doesn't establish another connection.
+	require.NoError(t, clientTransport.(Warmer).Warmup(context.Background(), serverID, ln.Multiaddr()))
+	sc, err := ln.Accept()
+	require.NoError(t, err)
+	defer sc.Close()
+	require.Len(t, clientTransport.(ConnLister).Connections(), 1)
+
+	// Dials to other addresses of the peer don't use the warm connection.
+	serverTransport2, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer serverTransport2.(io.Closer).Close()
+	ln2 := runServer(t, serverTransport2, "/ip4/127.0.0.1/udp/0/quic-v1")
+	defer ln2.Close()
+	other, err := clientTransport.Dial(context.Background(), ln2.Multiaddr(), serverID)
+	require.NoError(t, err)
+	require.Equal(t, ln2.Multiaddr(), other.RemoteMultiaddr())
+	require.Len(t, clientTransport.(ConnLister).Connections(), 2)
+	sother, err := ln2.Accept()
+	require.NoError(t, err)
+	require.NoError(t, other.Close())
+	require.NoError(t, sother.Close())
+
+	// The next dial to the address returns the warm connection.
+	c, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer c.Close()
+	require.Len(t, clientTransport.(ConnLister).Connections(), 1)
+	str, err := c.OpenStream(context.Background())
+	require.NoError(t, err)
+	_, err = str.Write([]byte("foobar"))
+	require.NoError(t, err)
+	sstr, err := sc.AcceptStream()
+	require.NoError(t, err)
+	_, err = io.ReadFull(sstr, make([]byte, 6))
+	require.NoError(t, err)
+
+	// It is only returned once.
+	c2, err := clientTransport.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer c2.Close()
+	require.NotSame(t, c, c2)
+	require.Len(t, clientTransport.(ConnLister).Connections(), 2)
+	sc2, err := ln.Accept()
+	require.NoError(t, err)
+	defer sc2.Close()
+
+	// Warm connections that are closed before they are used are released.
+	require.NoError(t, clientTransport.(Warmer).Warmup(context.Background(), serverID, ln.Multiaddr()))
+	require.Len(t, clientTransport.(ConnLister).Connections(), 3)
+	sc3, err := ln.Accept()
+	require.NoError(t, err)
+	require.NoError(t, sc3.Close())
+	require.Eventually(t, func() bool { return len(clientTransport.(ConnLister).Connections()) == 2 }, 5*time.Second, 10*time.Millisecond)
+}
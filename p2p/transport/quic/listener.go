@@ -2,8 +2,16 @@ package libp2pquic
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"maps"
 	"net"
+	"os"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
 
 	ic "github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/network"
@@ -17,22 +25,43 @@ import (
 
 // A listener listens for QUIC connections.
 type listener struct {
-	reuseListener   quicreuse.Listener
-	transport       *transport
-	rcmgr           network.ResourceManager
-	privKey         ic.PrivKey
-	localPeer       peer.ID
+	reuseListener  quicreuse.Listener
+	transport      *transport
+	rcmgr          network.ResourceManager
+	privKey        ic.PrivKey
+	localPeer      peer.ID
+	scopeUnwrapper connScopeUnwrapper
+
+	addrsMu         sync.RWMutex
 	localMultiaddrs map[quic.Version]ma.Multiaddr
+
+	// stopWatch stops watching the multiaddrs of reuseListener. nil if they
+	// aren't watched, see WithListenAddrsChangedCallback.
+	stopWatch context.CancelFunc
+	watchDone chan struct{}
 }
 
-func newListener(ln quicreuse.Listener, t *transport, localPeer peer.ID, key ic.PrivKey, rcmgr network.ResourceManager) (listener, error) {
-	localMultiaddrs := make(map[quic.Version]ma.Multiaddr)
-	for _, addr := range ln.Multiaddrs() {
-		if _, err := addr.ValueForProtocol(ma.P_QUIC_V1); err == nil {
-			localMultiaddrs[quic.Version1] = addr
-		}
-	}
+// addrsCheckInterval is how often listeners check the multiaddrs of their
+// underlying quicreuse listener for changes, see WithListenAddrsChangedCallback.
+var addrsCheckInterval = 10 * time.Second
+
+// connScopeUnwrapper returns the resource scope attached to the context of an
+// inbound connection, if any. Connections without a scope get a new one from the
+// resource manager.
+type connScopeUnwrapper interface {
+	UnwrapConnManagementScope(ctx context.Context) (network.ConnManagementScope, error)
+}
+
+// ctxScopeUnwrapper unwraps scopes set with network.WithConnManagementScope,
+// typically by a quicreuse.ConnContext function.
+type ctxScopeUnwrapper struct{}
+
+func (ctxScopeUnwrapper) UnwrapConnManagementScope(ctx context.Context) (network.ConnManagementScope, error) {
+	return network.UnwrapConnManagementScope(ctx)
+}
 
+func newListener(ln quicreuse.Listener, t *transport, localPeer peer.ID, key ic.PrivKey, rcmgr network.ResourceManager) (listener, error) {
+	localMultiaddrs, _ := t.allowedMultiaddrs(ln.Multiaddrs())
 	return listener{
 		reuseListener:   ln,
 		transport:       t,
@@ -40,24 +69,100 @@ func newListener(ln quicreuse.Listener, t *transport, localPeer peer.ID, key ic.
 		privKey:         key,
 		localPeer:       localPeer,
 		localMultiaddrs: localMultiaddrs,
+		scopeUnwrapper:  ctxScopeUnwrapper{},
 	}, nil
 }
 
+// allowedMultiaddrs returns the multiaddrs of addrs with an allowed QUIC
+// version, both keyed by version and in the order of addrs.
+func (t *transport) allowedMultiaddrs(addrs []ma.Multiaddr) (map[quic.Version]ma.Multiaddr, []ma.Multiaddr) {
+	byVersion := make(map[quic.Version]ma.Multiaddr)
+	allowed := make([]ma.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		_, version, err := quicreuse.FromQuicMultiaddr(addr)
+		if err != nil {
+			log.Debugw("ignoring listen address with unknown QUIC version", "addr", addr, "error", err)
+			continue
+		}
+		if !t.isVersionAllowed(version) {
+			continue
+		}
+		byVersion[version] = addr
+		allowed = append(allowed, addr)
+	}
+	return byVersion, allowed
+}
+
+// localMultiaddr returns the multiaddr the listener advertises for version.
+func (l *listener) localMultiaddr(version quic.Version) (ma.Multiaddr, bool) {
+	l.addrsMu.RLock()
+	defer l.addrsMu.RUnlock()
+	addr, ok := l.localMultiaddrs[version]
+	return addr, ok
+}
+
+// watchAddrs checks the multiaddrs of the underlying quicreuse listener for
+// changes every addrsCheckInterval until the listener is closed. On every
+// change, it updates the advertised multiaddrs and calls cb with them.
+func (l *listener) watchAddrs(cb func([]ma.Multiaddr)) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l.stopWatch = cancel
+	l.watchDone = make(chan struct{})
+	go func() {
+		defer close(l.watchDone)
+		ticker := time.NewTicker(addrsCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if addrs, changed := l.updateAddrs(); changed {
+				cb(addrs)
+			}
+		}
+	}()
+}
+
+// updateAddrs updates the advertised multiaddrs from the underlying quicreuse
+// listener. It returns them if they changed.
+func (l *listener) updateAddrs() ([]ma.Multiaddr, bool) {
+	byVersion, addrs := l.transport.allowedMultiaddrs(l.reuseListener.Multiaddrs())
+	l.addrsMu.Lock()
+	defer l.addrsMu.Unlock()
+	if maps.EqualFunc(byVersion, l.localMultiaddrs, ma.Multiaddr.Equal) {
+		return nil, false
+	}
+	l.localMultiaddrs = byVersion
+	return addrs, true
+}
+
 // Accept accepts new connections.
 func (l *listener) Accept() (tpt.CapableConn, error) {
+	return l.AcceptContext(context.Background())
+}
+
+// AcceptContext accepts new connections. It returns ctx.Err() when the context
+// is canceled before a connection is accepted.
+func (l *listener) AcceptContext(ctx context.Context) (tpt.CapableConn, error) {
 	for {
-		qconn, err := l.reuseListener.Accept(context.Background())
+		qconn, err := l.reuseListener.Accept(ctx)
 		if err != nil {
 			return nil, err
 		}
+		start := time.Now()
 		c, err := l.wrapConn(qconn)
+		if mt := l.transport.metricsTracer; mt != nil {
+			mt.ConnEstablished(network.DirInbound, err == nil, time.Since(start))
+		}
 		if err != nil {
 			log.Debugf("failed to setup connection: %s", err)
-			qconn.CloseWithError(quic.ApplicationErrorCode(network.ConnResourceLimitExceeded), "")
+			qconn.CloseWithError(quic.ApplicationErrorCode(acceptErrorCode(err)), "")
 			continue
 		}
 		l.transport.addConn(qconn, c)
-		if l.transport.gater != nil && !(l.transport.gater.InterceptAccept(c) && l.transport.gater.InterceptSecured(network.DirInbound, c.remotePeerID, c)) {
+		if l.transport.gater != nil && !(l.transport.gater.InterceptAccept(c) && l.transport.interceptSecured(network.DirInbound, c, qconn.ConnectionState().TLS.PeerCertificates)) {
 			c.closeWithError(quic.ApplicationErrorCode(network.ConnGated), "connection gated")
 			continue
 		}
@@ -68,11 +173,22 @@ func (l *listener) Accept() (tpt.CapableConn, error) {
 		l.transport.holePunchingMx.Lock()
 		holePunch, ok := l.transport.holePunching[key]
 		if ok && !holePunch.fulfilled {
+			c.holePunched.Store(true)
 			holePunch.connCh <- c
 			wasHolePunch = true
 			holePunch.fulfilled = true
 		}
 		l.transport.holePunchingMx.Unlock()
+		if mt := l.transport.metricsTracer; mt != nil {
+			switch {
+			case wasHolePunch:
+				mt.HolePunchDelivered()
+			case ok:
+				mt.HolePunchLate()
+			default:
+				mt.RegularAccept()
+			}
+		}
 		if wasHolePunch {
 			continue
 		}
@@ -80,15 +196,71 @@ func (l *listener) Accept() (tpt.CapableConn, error) {
 	}
 }
 
+// acceptError is returned by wrapConn. It carries the application error code
+// the rejected connection is closed with, so that the remote peer can tell why
+// the connection was rejected.
+type acceptError struct {
+	code network.ConnErrorCode
+	err  error
+}
+
+func (e *acceptError) Error() string { return e.err.Error() }
+func (e *acceptError) Unwrap() error { return e.err }
+
+// acceptErrorCode returns the application error code to close a connection
+// with after wrapConn failed with err.
+func acceptErrorCode(err error) network.ConnErrorCode {
+	var aerr *acceptError
+	if errors.As(err, &aerr) {
+		return aerr.code
+	}
+	return network.ConnResourceLimitExceeded
+}
+
+// IdentityError is returned if the peer's identity can't be determined from
+// the certificate chain it presented.
+type IdentityError struct {
+	RemoteAddr net.Addr
+	// Subject is the subject of the peer's leaf certificate. It is empty if the
+	// peer didn't present a certificate.
+	Subject string
+	Err     error
+}
+
+func (e *IdentityError) Error() string {
+	return fmt.Sprintf("failed to determine identity of peer %s (certificate subject: %q): %s", e.RemoteAddr, e.Subject, e.Err)
+}
+
+func (e *IdentityError) Unwrap() error { return e.Err }
+
 // wrapConn wraps a QUIC connection into a libp2p [tpt.CapableConn].
 // If wrapping fails. The caller is responsible for cleaning up the
-// connection.
-func (l *listener) wrapConn(qconn *quic.Conn) (*conn, error) {
+// connection. A panic is turned into an error, so that a single connection
+// can't take down the listener.
+func (l *listener) wrapConn(qconn *quic.Conn) (c *conn, err error) {
+	var connScope network.ConnManagementScope
+	defer func() {
+		if rerr := recover(); rerr != nil {
+			fmt.Fprintf(os.Stderr, "panic when setting up QUIC connection from %s: %s\n%s\n", qconn.RemoteAddr(), rerr, debug.Stack())
+			if connScope != nil {
+				connScope.Done()
+			}
+			c = nil
+			err = fmt.Errorf("panic when setting up QUIC connection: %s", rerr)
+		}
+	}()
+	if v := qconn.ConnectionState().Version; !l.transport.isVersionAllowed(v) {
+		return nil, &acceptError{code: ConnVersionNotAllowed, err: fmt.Errorf("%w: %s", errVersionNotAllowed, v)}
+	}
 	remoteMultiaddr, err := quicreuse.ToQuicMultiaddr(qconn.RemoteAddr(), qconn.ConnectionState().Version)
 	if err != nil {
-		return nil, err
+		return nil, &acceptError{code: network.ConnProtocolViolation, err: err}
 	}
-	connScope, err := network.UnwrapConnManagementScope(qconn.Context())
+	ctx := qconn.Context()
+	if l.transport.connContext != nil {
+		ctx = l.transport.connContext(ctx, remoteMultiaddr)
+	}
+	connScope, err = l.scopeUnwrapper.UnwrapConnManagementScope(ctx)
 	if err != nil {
 		connScope = nil
 		// Don't error here.
@@ -100,15 +272,15 @@ func (l *listener) wrapConn(qconn *quic.Conn) (*conn, error) {
 		connScope, err = l.rcmgr.OpenConnection(network.DirInbound, false, remoteMultiaddr)
 		if err != nil {
 			log.Debugw("resource manager blocked incoming connection", "addr", qconn.RemoteAddr(), "error", err)
-			return nil, err
+			return nil, &acceptError{code: network.ConnResourceLimitExceeded, err: err}
 		}
 	}
-	c, err := l.wrapConnWithScope(qconn, connScope, remoteMultiaddr)
+	c, err = l.wrapConnWithScope(qconn, connScope, remoteMultiaddr)
 	if err != nil {
 		connScope.Done()
 		return nil, err
 	}
-
+	c.ctx = ctx
 	return c, nil
 }
 
@@ -116,31 +288,36 @@ func (l *listener) wrapConnWithScope(qconn *quic.Conn, connScope network.ConnMan
 	// The tls.Config used to establish this connection already verified the certificate chain.
 	// Since we don't have any way of knowing which tls.Config was used though,
 	// we have to re-determine the peer's identity here.
-	// Therefore, this is expected to never fail.
-	remotePubKey, err := p2ptls.PubKeyFromCertChain(qconn.ConnectionState().TLS.PeerCertificates)
-	if err != nil {
-		return nil, err
-	}
-	remotePeerID, err := peer.IDFromPublicKey(remotePubKey)
+	// Therefore, this is expected to never fail. If it does, the IdentityError
+	// tells where the offending certificate came from.
+	remotePubKey, remotePeerID, err := remoteIdentity(qconn.RemoteAddr(), qconn.ConnectionState().TLS.PeerCertificates)
 	if err != nil {
 		return nil, err
 	}
 	if err := connScope.SetPeer(remotePeerID); err != nil {
 		log.Debugw("resource manager blocked incoming connection for peer", "peer", remotePeerID, "addr", qconn.RemoteAddr(), "error", err)
-		return nil, err
+		return nil, &acceptError{code: network.ConnResourceLimitExceeded, err: err}
 	}
 
-	localMultiaddr, found := l.localMultiaddrs[qconn.ConnectionState().Version]
+	version := qconn.ConnectionState().Version
+	localMultiaddr, found := l.localMultiaddr(version)
 	if !found {
-		return nil, errors.New("unknown QUIC version:" + qconn.ConnectionState().Version.String())
+		return nil, &acceptError{code: network.ConnProtocolViolation, err: errors.New("unknown QUIC version:" + version.String())}
 	}
 
+	var serverName string
+	if l.transport.exposeServerName {
+		serverName = qconn.ConnectionState().TLS.ServerName
+	}
 	return &conn{
 		quicConn:        qconn,
 		transport:       l.transport,
+		serverName:      serverName,
 		scope:           connScope,
+		version:         version,
 		localPeer:       l.localPeer,
-		localMultiaddr:  localMultiaddr,
+		localMultiaddr:  l.transport.connLocalMultiaddr(localMultiaddr),
+		remoteAddr:      qconn.RemoteAddr(),
 		remoteMultiaddr: remoteMultiaddr,
 		remotePeerID:    remotePeerID,
 		remotePubKey:    remotePubKey,
@@ -149,6 +326,10 @@ func (l *listener) wrapConnWithScope(qconn *quic.Conn, connScope network.ConnMan
 
 // Close closes the listener.
 func (l *listener) Close() error {
+	if l.stopWatch != nil {
+		l.stopWatch()
+		<-l.watchDone
+	}
 	return l.reuseListener.Close()
 }
 
@@ -156,3 +337,44 @@ func (l *listener) Close() error {
 func (l *listener) Addr() net.Addr {
 	return l.reuseListener.Addr()
 }
+
+// StatsListener is implemented by the listeners of this transport. Stats
+// reports the state of the quicreuse listener shared by all QUIC versions
+// listening on the same address.
+type StatsListener interface {
+	tpt.Listener
+	Stats() quicreuse.ListenerStats
+}
+
+type statsReporter interface {
+	Stats() quicreuse.ListenerStats
+}
+
+// Stats returns a snapshot of the state of the underlying quicreuse listener.
+func (l *listener) Stats() quicreuse.ListenerStats {
+	if sl, ok := l.reuseListener.(statsReporter); ok {
+		return sl.Stats()
+	}
+	return quicreuse.ListenerStats{Multiaddrs: slices.Clone(l.reuseListener.Multiaddrs())}
+}
+
+// remoteIdentity determines the identity of the peer at addr from its certificate chain.
+// Failures are returned as an IdentityError.
+func remoteIdentity(addr net.Addr, chain []*x509.Certificate) (ic.PubKey, peer.ID, error) {
+	identityErr := func(err error) error {
+		ierr := &IdentityError{RemoteAddr: addr, Err: err}
+		if len(chain) > 0 {
+			ierr.Subject = chain[0].Subject.String()
+		}
+		return &acceptError{code: network.ConnProtocolViolation, err: ierr}
+	}
+	remotePubKey, err := p2ptls.PubKeyFromCertChain(chain)
+	if err != nil {
+		return nil, "", identityErr(err)
+	}
+	remotePeerID, err := peer.IDFromPublicKey(remotePubKey)
+	if err != nil {
+		return nil, "", identityErr(err)
+	}
+	return remotePubKey, remotePeerID, nil
+}
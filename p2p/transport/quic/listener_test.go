@@ -5,17 +5,24 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	ic "github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/network"
 	mocknetwork "github.com/libp2p/go-libp2p/core/network/mocks"
+	"github.com/libp2p/go-libp2p/core/peer"
 	tpt "github.com/libp2p/go-libp2p/core/transport"
+	p2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
 	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
 	"github.com/quic-go/quic-go"
 	"go.uber.org/mock/gomock"
@@ -167,3 +174,753 @@ func TestCleanupConnWhenBlocked(t *testing.T) {
 
 	t.Fatalf("expected network.ConnError, got %v", err)
 }
+
+// multiVersionListener advertises a custom set of multiaddrs on top of an
+// existing quicreuse.Listener.
+type multiVersionListener struct {
+	quicreuse.Listener
+	addrs []ma.Multiaddr
+}
+
+func (l *multiVersionListener) Multiaddrs() []ma.Multiaddr { return l.addrs }
+
+func TestListenerAdvertisedVersions(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	server, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer server.(io.Closer).Close()
+	serverTpt := server.(*transport)
+	ln, err := server.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	reuseLn := ln.(*virtualListener).listener.reuseListener
+	v1Addr, err := quicreuse.ToQuicMultiaddr(reuseLn.Addr(), quic.Version1)
+	require.NoError(t, err)
+	// Addresses of versions without a multiaddr mapping, like the legacy /quic
+	// for draft-29, are ignored.
+	legacyAddr := ma.StringCast("/ip4/127.0.0.1/udp/1234/quic")
+	addrs := []ma.Multiaddr{v1Addr, legacyAddr}
+	l, err := newListener(&multiVersionListener{Listener: reuseLn, addrs: addrs}, serverTpt, serverID, serverKey, serverTpt.rcmgr)
+	require.NoError(t, err)
+	require.Equal(t, map[quic.Version]ma.Multiaddr{quic.Version1: v1Addr}, l.localMultiaddrs)
+
+	client, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer client.(io.Closer).Close()
+	clientConn, err := client.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer clientConn.Close()
+
+	qconn, err := l.reuseListener.Accept(context.Background())
+	require.NoError(t, err)
+	c, err := l.wrapConn(qconn)
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, v1Addr, c.LocalMultiaddr())
+}
+
+// changingAddrsListener advertises multiaddrs that can be changed concurrently
+// on top of an existing quicreuse.Listener.
+type changingAddrsListener struct {
+	quicreuse.Listener
+	mu    sync.Mutex
+	addrs []ma.Multiaddr
+}
+
+func (l *changingAddrsListener) Multiaddrs() []ma.Multiaddr {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.addrs
+}
+
+func (l *changingAddrsListener) setMultiaddrs(addrs ...ma.Multiaddr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.addrs = addrs
+}
+
+func TestListenerAddrsChanged(t *testing.T) {
+	defer func(d time.Duration) { addrsCheckInterval = d }(addrsCheckInterval)
+	addrsCheckInterval = 10 * time.Millisecond
+
+	serverID, serverKey := createPeer(t)
+	changed := make(chan []ma.Multiaddr, 1)
+	server, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil,
+		WithAllowedVersions(quic.Version1),
+		WithListenAddrsChangedCallback(func(addrs []ma.Multiaddr) { changed <- addrs }),
+	)
+	require.NoError(t, err)
+	defer server.(io.Closer).Close()
+	serverTpt := server.(*transport)
+	ln, err := server.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	reuseLn := ln.(*virtualListener).listener.reuseListener
+	changingLn := &changingAddrsListener{Listener: reuseLn, addrs: reuseLn.Multiaddrs()}
+	l, err := newListener(changingLn, serverTpt, serverID, serverKey, serverTpt.rcmgr)
+	require.NoError(t, err)
+	l.watchAddrs(serverTpt.addrsChanged)
+	defer l.Close()
+
+	select {
+	case addrs := <-changed:
+		t.Fatalf("unexpected addrs change: %v", addrs)
+	case <-time.After(5 * addrsCheckInterval):
+	}
+
+	newAddr := ma.StringCast("/ip4/192.0.2.1/udp/1234/quic-v1")
+	changingLn.setMultiaddrs(newAddr)
+	select {
+	case addrs := <-changed:
+		require.Equal(t, []ma.Multiaddr{newAddr}, addrs)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for addrs change")
+	}
+	addr, ok := l.localMultiaddr(quic.Version1)
+	require.True(t, ok)
+	require.Equal(t, newAddr, addr)
+
+	// Addresses of disallowed versions are ignored.
+	changingLn.setMultiaddrs(newAddr, ma.StringCast("/ip4/192.0.2.1/udp/1234/quic"))
+	select {
+	case addrs := <-changed:
+		t.Fatalf("unexpected addrs change: %v", addrs)
+	case <-time.After(5 * addrsCheckInterval):
+	}
+}
+
+func TestListenerRejectsDisallowedVersion(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	cm := newConnManager(t)
+	server, err := NewTransport(serverKey, cm, nil, nil, nil)
+	require.NoError(t, err)
+	defer server.(io.Closer).Close()
+	ln, err := server.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	// A transport that doesn't allow QUIC v1 can't listen on a /quic-v1 address.
+	restricted, err := NewTransport(serverKey, cm, nil, nil, nil, WithAllowedVersions(quic.Version2))
+	require.NoError(t, err)
+	defer restricted.(io.Closer).Close()
+	restrictedTpt := restricted.(*transport)
+	_, err = restricted.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"))
+	require.Error(t, err)
+	require.False(t, restricted.CanDial(ln.Multiaddr()))
+	_, err = restricted.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.ErrorIs(t, err, errVersionNotAllowed)
+
+	// Accept QUIC v1 connections using the restricted transport.
+	reuseLn := ln.(*virtualListener).listener.reuseListener
+	l, err := newListener(reuseLn, restrictedTpt, serverID, serverKey, restrictedTpt.rcmgr)
+	require.NoError(t, err)
+	require.Empty(t, l.localMultiaddrs)
+	go l.Accept()
+
+	client, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer client.(io.Closer).Close()
+	conn, err := client.Dial(context.Background(), ln.Multiaddr(), serverID)
+	if err == nil {
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := conn.AcceptStream()
+			errCh <- err
+		}()
+		select {
+		case err = <-errCh:
+		case <-time.After(5 * time.Second):
+			t.Fatal("connection wasn't closed")
+		}
+	}
+	var connErr *network.ConnError
+	require.ErrorAs(t, err, &connErr)
+	require.True(t, connErr.Remote)
+	require.Equal(t, ConnVersionNotAllowed, connErr.ErrorCode)
+}
+
+type countingMetricsTracer struct {
+	delivered, late, regular atomic.Int32
+
+	mu sync.Mutex
+	// established records the directions and outcomes passed to ConnEstablished.
+	established []connEstablishment
+}
+
+type connEstablishment struct {
+	dir     network.Direction
+	success bool
+}
+
+var _ MetricsTracer = &countingMetricsTracer{}
+
+func (mt *countingMetricsTracer) HolePunchDelivered() { mt.delivered.Add(1) }
+func (mt *countingMetricsTracer) HolePunchLate()      { mt.late.Add(1) }
+func (mt *countingMetricsTracer) RegularAccept()      { mt.regular.Add(1) }
+
+func (mt *countingMetricsTracer) ConnEstablished(dir network.Direction, success bool, d time.Duration) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	mt.established = append(mt.established, connEstablishment{dir: dir, success: success})
+}
+
+func (mt *countingMetricsTracer) establishments() []connEstablishment {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+	return slices.Clone(mt.established)
+}
+
+func TestConnEstablishmentMetrics(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	serverMT := &countingMetricsTracer{}
+	server, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil, WithMetricsTracer(serverMT))
+	require.NoError(t, err)
+	defer server.(io.Closer).Close()
+	ln, err := server.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	clientMT := &countingMetricsTracer{}
+	client, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil, WithMetricsTracer(clientMT))
+	require.NoError(t, err)
+	defer client.(io.Closer).Close()
+	c, err := client.Dial(context.Background(), ln.Multiaddr(), serverID)
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, []connEstablishment{{dir: network.DirOutbound, success: true}}, clientMT.establishments())
+
+	sc, err := ln.Accept()
+	require.NoError(t, err)
+	defer sc.Close()
+	require.Equal(t, []connEstablishment{{dir: network.DirInbound, success: true}}, serverMT.establishments())
+
+	// Dialing the wrong peer fails.
+	otherID, _ := createPeer(t)
+	_, err = client.Dial(context.Background(), ln.Multiaddr(), otherID)
+	require.Error(t, err)
+	require.Equal(t, connEstablishment{dir: network.DirOutbound, success: false}, clientMT.establishments()[1])
+}
+
+func TestListenerHolePunchMetrics(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	clientID, clientKey := createPeer(t)
+
+	mt := &countingMetricsTracer{}
+	server, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil, WithMetricsTracer(mt))
+	require.NoError(t, err)
+	defer server.(io.Closer).Close()
+	serverTpt := server.(*transport)
+	ln, err := server.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"))
+	require.NoError(t, err)
+	defer ln.Close()
+	accepted := make(chan tpt.CapableConn, 1)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	// The client listens, so that its dials use the listening address.
+	client, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer client.(io.Closer).Close()
+	clientLn, err := client.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"))
+	require.NoError(t, err)
+	defer clientLn.Close()
+
+	key := holePunchKey{addr: clientLn.Addr().String(), peer: clientID}
+	connCh := make(chan tpt.CapableConn, 1)
+	serverTpt.holePunchingMx.Lock()
+	serverTpt.holePunching[key] = &activeHolePunch{connCh: connCh}
+	serverTpt.holePunchingMx.Unlock()
+
+	dial := func() {
+		t.Helper()
+		c, err := client.Dial(context.Background(), ln.Multiaddr(), serverID)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+	}
+
+	// The first connection fulfills the hole punch.
+	dial()
+	select {
+	case c := <-connCh:
+		defer c.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("hole punched connection wasn't delivered")
+	}
+	require.Equal(t, int32(1), mt.delivered.Load())
+
+	// The hole punch is already fulfilled, so this connection is accepted as usual.
+	dial()
+	select {
+	case c := <-accepted:
+		defer c.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection wasn't accepted")
+	}
+	require.Equal(t, int32(1), mt.late.Load())
+
+	serverTpt.holePunchingMx.Lock()
+	delete(serverTpt.holePunching, key)
+	serverTpt.holePunchingMx.Unlock()
+	dial()
+	select {
+	case c := <-accepted:
+		defer c.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection wasn't accepted")
+	}
+	require.Equal(t, int32(1), mt.regular.Load())
+	require.Equal(t, int32(1), mt.delivered.Load())
+	require.Equal(t, int32(1), mt.late.Load())
+}
+
+func TestAcceptContext(t *testing.T) {
+	tr := newTransport(t, nil)
+	defer tr.(io.Closer).Close()
+	ln, err := tr.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	ctxLn, ok := ln.(interface {
+		AcceptContext(context.Context) (tpt.CapableConn, error)
+	})
+	require.True(t, ok)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := ctxLn.AcceptContext(ctx)
+		errCh <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errCh:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("AcceptContext didn't return after the context was canceled")
+	}
+
+	// The listener is still usable after a canceled AcceptContext call.
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = ctxLn.AcceptContext(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestAcceptErrorCodes(t *testing.T) {
+	t.Run("resource manager block", func(t *testing.T) {
+		serverID, serverKey := createPeer(t)
+		_, clientKey := createPeer(t)
+
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		rcmgr := mocknetwork.NewMockResourceManager(ctrl)
+		rcmgr.EXPECT().OpenConnection(network.DirInbound, false, gomock.Any()).Return(nil, errors.New("denied")).AnyTimes()
+
+		server, err := NewTransport(serverKey, newConnManager(t), nil, nil, rcmgr)
+		require.NoError(t, err)
+		defer server.(io.Closer).Close()
+		ln, err := server.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"))
+		require.NoError(t, err)
+		defer ln.Close()
+		go ln.Accept()
+
+		client, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+		require.NoError(t, err)
+		defer client.(io.Closer).Close()
+		conn, err := client.Dial(context.Background(), ln.Multiaddr(), serverID)
+		if err == nil {
+			_, err = conn.AcceptStream()
+		}
+		var connErr *network.ConnError
+		require.ErrorAs(t, err, &connErr)
+		require.True(t, connErr.Remote)
+		require.Equal(t, network.ConnResourceLimitExceeded, connErr.ErrorCode)
+	})
+
+	t.Run("identity failure", func(t *testing.T) {
+		addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 1234}
+		_, _, err := remoteIdentity(addr, nil)
+		require.Error(t, err)
+		require.Equal(t, network.ConnProtocolViolation, acceptErrorCode(err))
+		var identityErr *IdentityError
+		require.ErrorAs(t, err, &identityErr)
+		require.Equal(t, addr, identityErr.RemoteAddr)
+		require.Empty(t, identityErr.Subject)
+
+		// A certificate without the libp2p extension.
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "not-libp2p"},
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		require.NoError(t, err)
+		cert, err := x509.ParseCertificate(der)
+		require.NoError(t, err)
+		_, _, err = remoteIdentity(addr, []*x509.Certificate{cert})
+		require.Error(t, err)
+		require.Equal(t, network.ConnProtocolViolation, acceptErrorCode(err))
+		require.ErrorAs(t, err, &identityErr)
+		require.Equal(t, addr, identityErr.RemoteAddr)
+		require.Equal(t, "CN=not-libp2p", identityErr.Subject)
+		require.ErrorContains(t, err, "1.2.3.4:1234")
+		require.ErrorContains(t, err, "CN=not-libp2p")
+		require.Error(t, identityErr.Err)
+	})
+
+	t.Run("unclassified", func(t *testing.T) {
+		require.Equal(t, network.ConnResourceLimitExceeded, acceptErrorCode(errors.New("unknown")))
+	})
+}
+
+func TestMaxConcurrentHandshakes(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	server, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil, WithMaxConcurrentHandshakes(2))
+	require.NoError(t, err)
+	defer server.(io.Closer).Close()
+	serverTpt := server.(*transport)
+	ln, err := server.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"))
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	udpAddr, _, err := quicreuse.FromQuicMultiaddr(ln.Multiaddr())
+	require.NoError(t, err)
+
+	clientIdentity, err := p2ptls.NewIdentity(clientKey)
+	require.NoError(t, err)
+	// dial starts a handshake with the server. If stall is not nil, the
+	// handshake stalls after receiving the server's certificate until stall is
+	// closed, while the server waits for the client's certificate.
+	dial := func(ctx context.Context, stalled chan<- struct{}, stall <-chan struct{}) error {
+		tlsConf, _ := clientIdentity.ConfigForPeer(serverID)
+		tlsConf.NextProtos = []string{"libp2p"}
+		if stall != nil {
+			verify := tlsConf.VerifyPeerCertificate
+			tlsConf.VerifyPeerCertificate = func(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+				stalled <- struct{}{}
+				<-stall
+				return verify(rawCerts, chains)
+			}
+		}
+		c, err := quic.DialAddr(ctx, udpAddr.String(), tlsConf, &quic.Config{})
+		if err != nil {
+			return err
+		}
+		return c.CloseWithError(0, "")
+	}
+
+	// Occupy all handshake slots.
+	stalled := make(chan struct{}, 2)
+	stall := make(chan struct{})
+	stalledErrs := make(chan error, 2)
+	for range 2 {
+		go func() { stalledErrs <- dial(context.Background(), stalled, stall) }()
+	}
+	for range 2 {
+		select {
+		case <-stalled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("handshake didn't start")
+		}
+	}
+	require.Len(t, serverTpt.handshakeSem, 2)
+
+	// The handshake of the next connection is aborted.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = dial(ctx, nil, nil)
+	var transportErr *quic.TransportError
+	require.ErrorAs(t, err, &transportErr)
+	require.True(t, transportErr.Remote)
+	require.NoError(t, ctx.Err())
+
+	// Once the stalled handshakes complete, their slots are freed.
+	close(stall)
+	for range 2 {
+		require.NoError(t, <-stalledErrs)
+	}
+	require.Eventually(t, func() bool { return len(serverTpt.handshakeSem) == 0 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, dial(ctx, nil, nil))
+}
+
+func TestListenerStats(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+
+	server, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer server.(io.Closer).Close()
+	ln, err := server.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"))
+	require.NoError(t, err)
+	defer ln.Close()
+	sl, ok := ln.(StatsListener)
+	require.True(t, ok)
+
+	stats := sl.Stats()
+	require.Zero(t, stats.QueuedConns)
+	require.Equal(t, []ma.Multiaddr{ln.Multiaddr()}, stats.Multiaddrs)
+	// The returned multiaddrs are a copy.
+	stats.Multiaddrs[0] = nil
+	require.Equal(t, []ma.Multiaddr{ln.Multiaddr()}, sl.Stats().Multiaddrs)
+
+	client, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer client.(io.Closer).Close()
+
+	// Nobody accepts, so the connections stay in the accept queue.
+	for range 2 {
+		conn, err := client.Dial(context.Background(), ln.Multiaddr(), serverID)
+		require.NoError(t, err)
+		defer conn.Close()
+	}
+	require.Eventually(t, func() bool { return sl.Stats().QueuedConns == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	c, err := ln.Accept()
+	require.NoError(t, err)
+	defer c.Close()
+	require.Eventually(t, func() bool { return sl.Stats().QueuedConns < 2 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func BenchmarkAcceptWithHandshakeLimit(b *testing.B) {
+	for _, limit := range []int{0, 1, 8} {
+		b.Run(fmt.Sprintf("limit=%d", limit), func(b *testing.B) {
+			serverID, serverKey := createPeer(b)
+			_, clientKey := createPeer(b)
+			var opts []Option
+			if limit > 0 {
+				opts = append(opts, WithMaxConcurrentHandshakes(limit))
+			}
+			server, err := NewTransport(serverKey, newConnManager(b), nil, nil, nil, opts...)
+			require.NoError(b, err)
+			defer server.(io.Closer).Close()
+			ln, err := server.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"))
+			require.NoError(b, err)
+			defer ln.Close()
+			go func() {
+				for {
+					c, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					c.Close()
+				}
+			}()
+			client, err := NewTransport(clientKey, newConnManager(b), nil, nil, nil)
+			require.NoError(b, err)
+			defer client.(io.Closer).Close()
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					c, err := client.Dial(context.Background(), ln.Multiaddr(), serverID)
+					if err == nil {
+						c.Close()
+					}
+				}
+			})
+		})
+	}
+}
+
+type funcScopeUnwrapper struct {
+	calls atomic.Int32
+	f     func() (network.ConnManagementScope, error)
+}
+
+func (u *funcScopeUnwrapper) UnwrapConnManagementScope(context.Context) (network.ConnManagementScope, error) {
+	u.calls.Add(1)
+	return u.f()
+}
+
+func TestListenerConnScope(t *testing.T) {
+	setup := func(t *testing.T, rcmgr network.ResourceManager, unwrapper connScopeUnwrapper) (tpt.Transport, *listener, peer.ID, peer.ID) {
+		serverID, serverKey := createPeer(t)
+		clientID, clientKey := createPeer(t)
+		server, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { server.(io.Closer).Close() })
+		ln, err := server.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"))
+		require.NoError(t, err)
+		t.Cleanup(func() { ln.Close() })
+
+		serverTpt := server.(*transport)
+		l, err := newListener(ln.(*virtualListener).listener.reuseListener, serverTpt, serverID, serverKey, rcmgr)
+		require.NoError(t, err)
+		l.scopeUnwrapper = unwrapper
+
+		client, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { client.(io.Closer).Close() })
+		return client, &l, serverID, clientID
+	}
+	acceptOne := func(t *testing.T, client tpt.Transport, l *listener, serverID peer.ID) {
+		accepted := make(chan tpt.CapableConn, 1)
+		go func() {
+			c, err := l.Accept()
+			if err == nil {
+				accepted <- c
+			}
+		}()
+		conn, err := client.Dial(context.Background(), l.localMultiaddrs[quic.Version1], serverID)
+		require.NoError(t, err)
+		defer conn.Close()
+		select {
+		case c := <-accepted:
+			c.Close()
+		case <-time.After(5 * time.Second):
+			t.Fatal("connection wasn't accepted")
+		}
+	}
+
+	t.Run("fallback to resource manager", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		rcmgr := mocknetwork.NewMockResourceManager(ctrl)
+		scope := mocknetwork.NewMockConnManagementScope(ctrl)
+		unwrapper := &funcScopeUnwrapper{f: func() (network.ConnManagementScope, error) {
+			return nil, errors.New("no scope")
+		}}
+		client, l, serverID, clientID := setup(t, rcmgr, unwrapper)
+
+		rcmgr.EXPECT().OpenConnection(network.DirInbound, false, gomock.Any()).Return(scope, nil)
+		scope.EXPECT().SetPeer(clientID)
+		scope.EXPECT().Done()
+		acceptOne(t, client, l, serverID)
+		require.Equal(t, int32(1), unwrapper.calls.Load())
+	})
+
+	t.Run("scope attached to the connection", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		// No OpenConnection call is expected.
+		rcmgr := mocknetwork.NewMockResourceManager(ctrl)
+		scope := mocknetwork.NewMockConnManagementScope(ctrl)
+		unwrapper := &funcScopeUnwrapper{f: func() (network.ConnManagementScope, error) {
+			return scope, nil
+		}}
+		client, l, serverID, clientID := setup(t, rcmgr, unwrapper)
+
+		scope.EXPECT().SetPeer(clientID)
+		scope.EXPECT().Done()
+		acceptOne(t, client, l, serverID)
+		require.Equal(t, int32(1), unwrapper.calls.Load())
+	})
+}
+
+func TestAcceptRecoversFromPanic(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+	server, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer server.(io.Closer).Close()
+	ln, err := server.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	l, err := newListener(ln.(*virtualListener).listener.reuseListener, server.(*transport), serverID, serverKey, &network.NullResourceManager{})
+	require.NoError(t, err)
+	// Setting up the first connection panics.
+	var unwrapper *funcScopeUnwrapper
+	unwrapper = &funcScopeUnwrapper{f: func() (network.ConnManagementScope, error) {
+		if unwrapper.calls.Load() == 1 {
+			panic("boom")
+		}
+		return nil, errors.New("no scope")
+	}}
+	l.scopeUnwrapper = unwrapper
+
+	client, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer client.(io.Closer).Close()
+
+	accepted := make(chan tpt.CapableConn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			accepted <- c
+		}
+	}()
+
+	// The connection is closed when setting it up panics.
+	conn, err := client.Dial(context.Background(), l.localMultiaddrs[quic.Version1], serverID)
+	require.NoError(t, err)
+	_, err = conn.AcceptStream()
+	require.Error(t, err)
+	require.Equal(t, int32(1), unwrapper.calls.Load())
+
+	// The listener keeps accepting connections.
+	conn, err = client.Dial(context.Background(), l.localMultiaddrs[quic.Version1], serverID)
+	require.NoError(t, err)
+	defer conn.Close()
+	select {
+	case c := <-accepted:
+		c.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection wasn't accepted")
+	}
+}
+
+func TestAcceptPanicReleasesScope(t *testing.T) {
+	serverID, serverKey := createPeer(t)
+	_, clientKey := createPeer(t)
+	server, err := NewTransport(serverKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer server.(io.Closer).Close()
+	ln, err := server.Listen(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"))
+	require.NoError(t, err)
+	defer ln.Close()
+
+	ctrl := gomock.NewController(t)
+	rcmgr := mocknetwork.NewMockResourceManager(ctrl)
+	scope := mocknetwork.NewMockConnManagementScope(ctrl)
+	l, err := newListener(ln.(*virtualListener).listener.reuseListener, server.(*transport), serverID, serverKey, rcmgr)
+	require.NoError(t, err)
+	l.scopeUnwrapper = &funcScopeUnwrapper{f: func() (network.ConnManagementScope, error) {
+		return nil, errors.New("no scope")
+	}}
+
+	// Setting up the connection panics after its scope was opened.
+	released := make(chan struct{})
+	rcmgr.EXPECT().OpenConnection(network.DirInbound, false, gomock.Any()).Return(scope, nil)
+	scope.EXPECT().SetPeer(gomock.Any()).Do(func(peer.ID) { panic("boom") })
+	scope.EXPECT().Done().Do(func() { close(released) })
+
+	client, err := NewTransport(clientKey, newConnManager(t), nil, nil, nil)
+	require.NoError(t, err)
+	defer client.(io.Closer).Close()
+	go l.Accept()
+	conn, err := client.Dial(context.Background(), l.localMultiaddrs[quic.Version1], serverID)
+	require.NoError(t, err)
+	defer conn.Close()
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatal("scope wasn't released")
+	}
+}
@@ -0,0 +1,109 @@
+package libp2pquic
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricNamespace = "libp2p_quic"
+
+var (
+	listenerAcceptsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "listener_accepts_total",
+			Help:      "Connections accepted by the QUIC listener, by hole punch outcome",
+		},
+		[]string{"type"},
+	)
+	connEstablishmentLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Name:      "connection_establishment_seconds",
+			Help:      "Time taken to establish QUIC connections, by direction and outcome",
+			Buckets:   []float64{0.001, 0.01, 0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.75, 1, 2},
+		},
+		[]string{"dir", "outcome"},
+	)
+
+	collectors = []prometheus.Collector{
+		listenerAcceptsTotal,
+		connEstablishmentLatency,
+	}
+)
+
+const (
+	acceptTypeHolePunch     = "hole_punch"
+	acceptTypeHolePunchLate = "hole_punch_late"
+	acceptTypeRegular       = "regular"
+)
+
+const (
+	outcomeSuccess = "success"
+	outcomeFailure = "failure"
+)
+
+// MetricsTracer is the interface for tracking metrics for the QUIC transport
+type MetricsTracer interface {
+	// HolePunchDelivered tracks an inbound connection that was handed to an active hole punch
+	HolePunchDelivered()
+	// HolePunchLate tracks an inbound connection for a hole punch that was already fulfilled
+	HolePunchLate()
+	// RegularAccept tracks an inbound connection that wasn't part of a hole punch
+	RegularAccept()
+	// ConnEstablished tracks the time taken to establish a connection. For dials,
+	// this includes the QUIC handshake. For accepts, which only see connections
+	// after the handshake, it covers setting up the libp2p connection.
+	ConnEstablished(dir network.Direction, success bool, d time.Duration)
+}
+
+type metricsTracer struct{}
+
+var _ MetricsTracer = &metricsTracer{}
+
+type metricsTracerSetting struct {
+	reg prometheus.Registerer
+}
+
+type MetricsTracerOption func(*metricsTracerSetting)
+
+func WithRegisterer(reg prometheus.Registerer) MetricsTracerOption {
+	return func(s *metricsTracerSetting) {
+		if reg != nil {
+			s.reg = reg
+		}
+	}
+}
+
+func NewMetricsTracer(opts ...MetricsTracerOption) MetricsTracer {
+	setting := &metricsTracerSetting{reg: prometheus.DefaultRegisterer}
+	for _, opt := range opts {
+		opt(setting)
+	}
+	metricshelper.RegisterCollectors(setting.reg, collectors...)
+	return &metricsTracer{}
+}
+
+func (mt *metricsTracer) HolePunchDelivered() {
+	listenerAcceptsTotal.WithLabelValues(acceptTypeHolePunch).Inc()
+}
+
+func (mt *metricsTracer) HolePunchLate() {
+	listenerAcceptsTotal.WithLabelValues(acceptTypeHolePunchLate).Inc()
+}
+
+func (mt *metricsTracer) RegularAccept() {
+	listenerAcceptsTotal.WithLabelValues(acceptTypeRegular).Inc()
+}
+
+func (mt *metricsTracer) ConnEstablished(dir network.Direction, success bool, d time.Duration) {
+	outcome := outcomeFailure
+	if success {
+		outcome = outcomeSuccess
+	}
+	connEstablishmentLatency.WithLabelValues(metricshelper.GetDirection(dir), outcome).Observe(d.Seconds())
+}
@@ -0,0 +1,26 @@
+//go:build nocover
+
+package libp2pquic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+func TestNoCoverNoAlloc(t *testing.T) {
+	mt := NewMetricsTracer()
+	tests := map[string]func(){
+		"HolePunchDelivered": func() { mt.HolePunchDelivered() },
+		"HolePunchLate":      func() { mt.HolePunchLate() },
+		"RegularAccept":      func() { mt.RegularAccept() },
+		"ConnEstablished":    func() { mt.ConnEstablished(network.DirInbound, true, time.Millisecond) },
+	}
+	for method, f := range tests {
+		allocs := testing.AllocsPerRun(1000, f)
+		if allocs > 0 {
+			t.Fatalf("Alloc Test: %s, got: %0.2f, expected: 0 allocs", method, allocs)
+		}
+	}
+}
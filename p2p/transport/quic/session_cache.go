@@ -0,0 +1,80 @@
+package libp2pquic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// sessionCacheSize is the number of peers we keep session tickets for.
+const sessionCacheSize = 1024
+
+type cachedSession struct {
+	state *tls.ClientSessionState
+	// chain is the peer's certificate chain, as verified on the connection the
+	// session was established on. crypto/tls doesn't expose it on resumed sessions.
+	chain []*x509.Certificate
+}
+
+// peerSessionCache is the tls.ClientSessionCache used for a dial to a single peer.
+// crypto/tls keys sessions by server name, which for QUIC is the IP address we dial.
+// We key them by the peer ID instead, so that we resume sessions with the peer
+// that issued them, no matter which of its addresses we dial.
+type peerSessionCache struct {
+	cache *lru.Cache[peer.ID, cachedSession]
+	peer  peer.ID
+
+	mx            sync.Mutex
+	resumedChain  []*x509.Certificate
+	verifiedChain []*x509.Certificate
+}
+
+var _ tls.ClientSessionCache = &peerSessionCache{}
+
+func (c *peerSessionCache) Get(string) (*tls.ClientSessionState, bool) {
+	s, ok := c.cache.Get(c.peer)
+	if !ok {
+		return nil, false
+	}
+	c.mx.Lock()
+	c.resumedChain = s.chain
+	c.mx.Unlock()
+	return s.state, true
+}
+
+func (c *peerSessionCache) Put(_ string, cs *tls.ClientSessionState) {
+	if cs == nil {
+		c.cache.Remove(c.peer)
+		return
+	}
+	c.mx.Lock()
+	chain := c.verifiedChain
+	c.mx.Unlock()
+	if chain == nil {
+		return
+	}
+	c.cache.Add(c.peer, cachedSession{state: cs, chain: chain})
+}
+
+// verifyConnection is used as the tls.Config.VerifyConnection callback. It is
+// called after the peer's certificate was verified, on resumed connections with
+// the certificate stored in the session, and always before new session tickets
+// are received. It records the chain to store it with these tickets.
+func (c *peerSessionCache) verifyConnection(cs tls.ConnectionState) error {
+	c.mx.Lock()
+	c.verifiedChain = cs.PeerCertificates
+	c.mx.Unlock()
+	return nil
+}
+
+// resumedCertChain returns the peer's certificate chain stored with the session
+// offered for resumption, or nil if no session was offered.
+func (c *peerSessionCache) resumedCertChain() []*x509.Certificate {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.resumedChain
+}
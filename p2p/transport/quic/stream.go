@@ -3,6 +3,8 @@ package libp2pquic
 import (
 	"errors"
 	"math"
+	"sync"
+	"sync/atomic"
 
 	"github.com/libp2p/go-libp2p/core/network"
 
@@ -15,10 +17,43 @@ const (
 
 type stream struct {
 	*quic.Stream
+	// counters are the byte counters of the stream's connection.
+	counters *byteCounters
+	// state tracks when the stream is done with, so that draining the
+	// connection can wait for it. nil if the stream isn't tracked.
+	state *streamState
 }
 
 var _ network.MuxedStream = stream{}
 
+// streamState tracks whether both directions of a stream were closed or
+// reset by the application.
+type streamState struct {
+	readClosed, writeClosed atomic.Bool
+	once                    sync.Once
+	done                    func()
+}
+
+func (s stream) closeRead() {
+	if s.state != nil {
+		s.state.readClosed.Store(true)
+		s.maybeDone()
+	}
+}
+
+func (s stream) closeWrite() {
+	if s.state != nil {
+		s.state.writeClosed.Store(true)
+		s.maybeDone()
+	}
+}
+
+func (s stream) maybeDone() {
+	if s.state.readClosed.Load() && s.state.writeClosed.Load() {
+		s.state.once.Do(s.state.done)
+	}
+}
+
 func parseStreamError(err error) error {
 	if err == nil {
 		return err
@@ -56,36 +91,48 @@ func parseStreamError(err error) error {
 
 func (s stream) Read(b []byte) (n int, err error) {
 	n, err = s.Stream.Read(b)
+	s.counters.received.Add(uint64(n))
 	return n, parseStreamError(err)
 }
 
 func (s stream) Write(b []byte) (n int, err error) {
 	n, err = s.Stream.Write(b)
+	s.counters.sent.Add(uint64(n))
 	return n, parseStreamError(err)
 }
 
 func (s stream) Reset() error {
 	s.Stream.CancelRead(reset)
 	s.Stream.CancelWrite(reset)
+	s.closeRead()
+	s.closeWrite()
 	return nil
 }
 
 func (s stream) ResetWithError(errCode network.StreamErrorCode) error {
 	s.Stream.CancelRead(quic.StreamErrorCode(errCode))
 	s.Stream.CancelWrite(quic.StreamErrorCode(errCode))
+	s.closeRead()
+	s.closeWrite()
 	return nil
 }
 
 func (s stream) Close() error {
 	s.Stream.CancelRead(reset)
-	return s.Stream.Close()
+	err := s.Stream.Close()
+	s.closeRead()
+	s.closeWrite()
+	return err
 }
 
 func (s stream) CloseRead() error {
 	s.Stream.CancelRead(reset)
+	s.closeRead()
 	return nil
 }
 
 func (s stream) CloseWrite() error {
-	return s.Stream.Close()
+	err := s.Stream.Close()
+	s.closeWrite()
+	return err
 }
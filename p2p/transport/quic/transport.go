@@ -2,7 +2,9 @@ package libp2pquic
 
 import (
 	"context"
+	crand "crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"math/rand"
@@ -15,15 +17,18 @@ import (
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/pnet"
+	"github.com/libp2p/go-libp2p/core/sec"
 	tpt "github.com/libp2p/go-libp2p/core/transport"
 	p2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
 	"github.com/libp2p/go-libp2p/p2p/transport/quicreuse"
 
+	lru "github.com/hashicorp/golang-lru/v2"
 	logging "github.com/ipfs/go-log/v2"
 	ma "github.com/multiformats/go-multiaddr"
 	mafmt "github.com/multiformats/go-multiaddr-fmt"
 	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/quic-go/quic-go"
+	"golang.org/x/time/rate"
 )
 
 const ListenOrder = 1
@@ -34,6 +39,49 @@ var ErrHolePunching = errors.New("hole punching attempted; no active dial")
 
 var HolePunchTimeout = 5 * time.Second
 
+// ErrDialBudgetExceeded is returned by dials to a peer that exhausted its dial
+// budget, see WithDialBudget.
+var ErrDialBudgetExceeded = errors.New("dial budget exceeded")
+
+// dialBudgetCacheSize is the number of peers we track the dial budget of.
+const dialBudgetCacheSize = 1024
+
+// ConnVersionNotAllowed is the application error code used to close inbound
+// connections that negotiated a QUIC version that isn't allowed by WithAllowedVersions.
+const ConnVersionNotAllowed network.ConnErrorCode = 0x1100
+
+var errVersionNotAllowed = errors.New("QUIC version not allowed")
+
+var errTooManyHandshakes = errors.New("too many concurrent handshakes")
+
+// ConnPeerIDMismatch is the application error code used to close outbound
+// connections to a peer that presented another peer ID than the dialed one,
+// see WithStrictPeerIDCheck.
+const ConnPeerIDMismatch network.ConnErrorCode = 0x1101
+
+type Option func(*transport) error
+
+// WithAllowedVersions restricts the QUIC versions used by the transport. Listeners
+// only advertise multiaddrs for allowed versions, dials to other versions fail,
+// and inbound connections that negotiated any other version are closed with
+// ConnVersionNotAllowed.
+// Since listeners are shared with other transports, this only takes effect once
+// the handshake completed. Use quicreuse.WithAllowedVersions with the same
+// versions to refuse other versions during version negotiation.
+// By default, all versions supported by quicreuse are allowed.
+func WithAllowedVersions(versions ...quic.Version) Option {
+	return func(t *transport) error {
+		if len(versions) == 0 {
+			return errors.New("at least one QUIC version must be allowed")
+		}
+		t.allowedVersions = make(map[quic.Version]struct{}, len(versions))
+		for _, v := range versions {
+			t.allowedVersions[v] = struct{}{}
+		}
+		return nil
+	}
+}
+
 // The Transport implements the tpt.Transport interface for QUIC connections.
 type transport struct {
 	privKey     ic.PrivKey
@@ -43,6 +91,46 @@ type transport struct {
 	gater       connmgr.ConnectionGater
 	rcmgr       network.ResourceManager
 
+	// allowedVersions is nil if all versions are allowed
+	allowedVersions map[quic.Version]struct{}
+
+	metricsTracer MetricsTracer
+
+	// sessionCache caches session tickets by peer ID. nil unless 0-RTT is enabled.
+	sessionCache     *lru.Cache[peer.ID, cachedSession]
+	sessionTicketKey [32]byte
+
+	// connAgeLimit.MaxAge is 0 if connections aren't closed based on their age.
+	connAgeLimit ConnAgeLimit
+	stopReaper   context.CancelFunc
+	reaperDone   chan struct{}
+
+	rttCallback func(peer.ID, time.Duration)
+
+	// localAddrRewrite is nil if local multiaddrs of conns aren't rewritten.
+	localAddrRewrite func(ma.Multiaddr) ma.Multiaddr
+
+	// datagrams is set if conns expose QUIC datagrams.
+	datagrams bool
+	// exposeServerName is set if inbound conns expose the server name presented by the peer.
+	exposeServerName bool
+	// strictPeerID is set if the peer ID is checked after the handshake on dials.
+	strictPeerID bool
+	// connContext derives the context of inbound conns. nil if not set.
+	connContext func(ctx context.Context, remote ma.Multiaddr) context.Context
+	// addrsChanged is called with the new multiaddrs of a listener when they change. nil if not set.
+	addrsChanged func([]ma.Multiaddr)
+
+	// handshakeSem limits the concurrent inbound handshakes. nil if there's no limit.
+	handshakeSem chan struct{}
+
+	// dialBudgets holds the dial budget of every recently dialed peer. nil if
+	// dials aren't limited.
+	dialBudgets     *lru.Cache[peer.ID, *rate.Limiter]
+	dialBudgetsMx   sync.Mutex
+	dialBudgetRate  rate.Limit
+	dialBudgetBurst int
+
 	holePunchingMx sync.Mutex
 	holePunching   map[holePunchKey]*activeHolePunch
 
@@ -52,6 +140,11 @@ type transport struct {
 	connMx sync.Mutex
 	conns  map[*quic.Conn]*conn
 
+	// warmConns holds the connections established by Warmup that Dial didn't
+	// return yet.
+	warmMx    sync.Mutex
+	warmConns map[peer.ID]*conn
+
 	listenersMu sync.Mutex
 	// map of UDPAddr as string to a virtualListeners
 	listeners map[string][]*virtualListener
@@ -70,7 +163,7 @@ type activeHolePunch struct {
 }
 
 // NewTransport creates a new QUIC transport
-func NewTransport(key ic.PrivKey, connManager *quicreuse.ConnManager, psk pnet.PSK, gater connmgr.ConnectionGater, rcmgr network.ResourceManager) (tpt.Transport, error) {
+func NewTransport(key ic.PrivKey, connManager *quicreuse.ConnManager, psk pnet.PSK, gater connmgr.ConnectionGater, rcmgr network.ResourceManager, opts ...Option) (tpt.Transport, error) {
 	if len(psk) > 0 {
 		log.Error("QUIC doesn't support private networks yet.")
 		return nil, errors.New("QUIC doesn't support private networks yet")
@@ -88,7 +181,7 @@ func NewTransport(key ic.PrivKey, connManager *quicreuse.ConnManager, psk pnet.P
 		rcmgr = &network.NullResourceManager{}
 	}
 
-	return &transport{
+	t := &transport{
 		privKey:      key,
 		localPeer:    localPeer,
 		identity:     identity,
@@ -100,7 +193,244 @@ func NewTransport(key ic.PrivKey, connManager *quicreuse.ConnManager, psk pnet.P
 		rnd:          *rand.New(rand.NewSource(time.Now().UnixNano())),
 
 		listeners: make(map[string][]*virtualListener),
-	}, nil
+	}
+	for _, opt := range opts {
+		if err := opt(t); err != nil {
+			return nil, err
+		}
+	}
+	if t.rttCallback != nil && !connManager.ConnTrackingEnabled() {
+		return nil, errors.New("the RTT callback requires a ConnManager with connection tracking enabled")
+	}
+	if t.connAgeLimit.MaxAge > 0 {
+		var ctx context.Context
+		ctx, t.stopReaper = context.WithCancel(context.Background())
+		t.reaperDone = make(chan struct{})
+		go t.reapOldConns(ctx)
+	}
+	return t, nil
+}
+
+// WithMetricsTracer configures a tracer for transport level metrics.
+func WithMetricsTracer(mt MetricsTracer) Option {
+	return func(t *transport) error {
+		t.metricsTracer = mt
+		return nil
+	}
+}
+
+// WithMaxConcurrentHandshakes limits the number of inbound handshakes that are
+// in flight concurrently, across all listeners of the transport. A handshake
+// counts from the ClientHello until the peer's certificate has been verified.
+// The handshakes of connections arriving while the limit is reached are aborted
+// before any signatures are created or verified.
+// By default, there's no limit.
+func WithMaxConcurrentHandshakes(n int) Option {
+	return func(t *transport) error {
+		if n <= 0 {
+			return errors.New("max concurrent handshakes must be positive")
+		}
+		t.handshakeSem = make(chan struct{}, n)
+		return nil
+	}
+}
+
+// WithDialBudget limits how often the transport dials the same peer, so that
+// flapping connections aren't redialed aggressively. Every peer has a budget of
+// burst dials, which is replenished at dialsPerSecond. Dials to a peer that
+// exhausted its budget fail with ErrDialBudgetExceeded without sending any
+// packets. The budget covers the dial attempts of the transport, including
+// those retried by higher layers, which see the error like any other failed
+// dial. Hole punches aren't limited. Budgets are kept for the 1024 most recently
+// dialed peers.
+// By default, dials aren't limited.
+func WithDialBudget(dialsPerSecond float64, burst int) Option {
+	return func(t *transport) error {
+		if dialsPerSecond <= 0 || burst <= 0 {
+			return errors.New("dial budget rate and burst must be positive")
+		}
+		c, err := lru.New[peer.ID, *rate.Limiter](dialBudgetCacheSize)
+		if err != nil {
+			return err
+		}
+		t.dialBudgets = c
+		t.dialBudgetRate = rate.Limit(dialsPerSecond)
+		t.dialBudgetBurst = burst
+		return nil
+	}
+}
+
+// WithZeroRTT enables 0-RTT for outbound dials to peers we connected to before.
+// The transport issues session tickets on inbound connections and caches the
+// tickets it receives, keyed by peer ID. When dialing a peer with a cached
+// ticket, the connection is returned before the handshake completes, and data
+// sent on the first streams is sent in 0-RTT.
+//
+// 0-RTT data can be replayed by an attacker and is sent before the peer proved
+// its identity for this connection. Only use this if the protocols spoken on the
+// first streams of a connection are idempotent.
+//
+// If the peer rejects 0-RTT, streams opened before the rejection fail with
+// quic.Err0RTTRejected, and new streams transparently use the 1-RTT connection.
+// For peers to accept 0-RTT, their quicreuse.ConnManager must be constructed
+// with quicreuse.EnableZeroRTT.
+func WithZeroRTT() Option {
+	return func(t *transport) error {
+		if _, err := crand.Read(t.sessionTicketKey[:]); err != nil {
+			return err
+		}
+		c, err := lru.New[peer.ID, cachedSession](sessionCacheSize)
+		if err != nil {
+			return err
+		}
+		t.sessionCache = c
+		return nil
+	}
+}
+
+// WithRTTCallback sets a callback that is invoked with the peer ID and the
+// smoothed RTT of every dialed connection once its handshake completes, e.g. to
+// prefer low-latency peers. The callback must not block.
+// The RTT of an existing connection can be obtained using its SmoothedRTT method.
+// The transport's ConnManager must be constructed with
+// quicreuse.EnableConnTracking.
+func WithRTTCallback(cb func(p peer.ID, rtt time.Duration)) Option {
+	return func(t *transport) error {
+		t.rttCallback = cb
+		return nil
+	}
+}
+
+// WithLocalAddrRewrite sets a function that rewrites the local multiaddr of
+// accepted and dialed connections, before it is attached to them. This allows
+// correcting the address when running behind a load balancer that listens on
+// a different address or port, without an address factory on the host.
+// The rewritten address must keep the QUIC version component. If f returns
+// nil, the address is used unchanged.
+func WithLocalAddrRewrite(f func(ma.Multiaddr) ma.Multiaddr) Option {
+	return func(t *transport) error {
+		t.localAddrRewrite = f
+		return nil
+	}
+}
+
+// WithDatagrams enables sending and receiving QUIC datagrams (RFC 9221) on
+// connections of the transport, see SendDatagram and ReceiveDatagram.
+// Datagrams are unreliable: they may be lost, reordered or dropped when the
+// receiver doesn't read them fast enough.
+// Datagram support is always negotiated during the handshake, since
+// WebTransport needs it. Without this option, connections don't expose it.
+func WithDatagrams() Option {
+	return func(t *transport) error {
+		t.datagrams = true
+		return nil
+	}
+}
+
+// WithServerNameIndication records the server name (SNI) that peers present
+// when connecting, and exposes it on inbound connections, see ServerNameConn.
+// This allows routing or gating connections to services hosted behind one QUIC
+// endpoint. libp2p-tls doesn't use the server name for authentication, so it
+// must not be relied on to identify the peer.
+// Peers set the server name with WithDialServerName.
+func WithServerNameIndication() Option {
+	return func(t *transport) error {
+		t.exposeServerName = true
+		return nil
+	}
+}
+
+// WithStrictPeerIDCheck makes dials complete the handshake with peers presenting
+// another peer ID than the dialed one, and then close the connection with
+// ConnPeerIDMismatch. The dial fails with a sec.ErrPeerIDMismatch. By default,
+// the handshake fails with a TLS alert instead, which doesn't tell the peer why
+// the connection was rejected.
+func WithStrictPeerIDCheck() Option {
+	return func(t *transport) error {
+		t.strictPeerID = true
+		return nil
+	}
+}
+
+// WithConnContext sets a function deriving the context of every accepted
+// connection from the context of the underlying QUIC connection, similar to
+// net/http's Server.ConnContext. It is called before the resource scope of the
+// connection is set up, and can be used to attach request-scoped values such
+// as a correlation ID, see ContextConn. The returned context must be derived
+// from ctx.
+func WithConnContext(f func(ctx context.Context, remote ma.Multiaddr) context.Context) Option {
+	return func(t *transport) error {
+		t.connContext = f
+		return nil
+	}
+}
+
+// WithListenAddrsChangedCallback sets a callback that is invoked with the new
+// multiaddrs of a listener whenever the multiaddrs of the underlying quicreuse
+// listener change, e.g. after a network interface went up or down, so that the
+// host can re-advertise its addresses. Listeners check for changes
+// periodically. The callback must not block.
+func WithListenAddrsChangedCallback(cb func(addrs []ma.Multiaddr)) Option {
+	return func(t *transport) error {
+		t.addrsChanged = cb
+		return nil
+	}
+}
+
+type serverNameKey struct{}
+
+// WithDialServerName returns a context that makes dials using it present name
+// as the server name (SNI) during the TLS handshake. By default, no server name
+// is sent. name must be a host name, IP addresses are not sent.
+func WithDialServerName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, serverNameKey{}, name)
+}
+
+// ConnAgeLimit configures WithMaxConnAge.
+type ConnAgeLimit struct {
+	// MaxAge is the age after which connections are closed.
+	MaxAge time.Duration
+	// ExemptHolePunched exempts connections established by hole punching.
+	// They are expensive to reestablish, since that requires another hole punch.
+	ExemptHolePunched bool
+	// ExemptActiveWithin exempts connections that opened or accepted a stream
+	// within this duration. If 0, activity doesn't exempt connections.
+	ExemptActiveWithin time.Duration
+}
+
+// WithMaxConnAge closes connections that are older than the configured
+// maximum age with network.ConnShutdown, which forces peers to reconnect
+// and do a new handshake, e.g. after rotating keys.
+// Connections are checked periodically, so they may live up to a quarter of
+// MaxAge longer than configured.
+func WithMaxConnAge(l ConnAgeLimit) Option {
+	return func(t *transport) error {
+		if l.MaxAge <= 0 {
+			return errors.New("max connection age must be positive")
+		}
+		t.connAgeLimit = l
+		return nil
+	}
+}
+
+// connLocalMultiaddr returns the local multiaddr to attach to a connection
+// with the given local address, applying WithLocalAddrRewrite.
+func (t *transport) connLocalMultiaddr(addr ma.Multiaddr) ma.Multiaddr {
+	if t.localAddrRewrite == nil {
+		return addr
+	}
+	if rewritten := t.localAddrRewrite(addr); rewritten != nil {
+		return rewritten
+	}
+	return addr
+}
+
+func (t *transport) isVersionAllowed(v quic.Version) bool {
+	if t.allowedVersions == nil {
+		return true
+	}
+	_, ok := t.allowedVersions[v]
+	return ok
 }
 
 func (t *transport) ListenOrder() int {
@@ -109,9 +439,27 @@ func (t *transport) ListenOrder() int {
 
 // Dial dials a new QUIC connection
 func (t *transport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (_c tpt.CapableConn, _err error) {
+	if _, v, err := quicreuse.FromQuicMultiaddr(raddr); err == nil && !t.isVersionAllowed(v) {
+		return nil, fmt.Errorf("can't dial %s: %w", raddr, errVersionNotAllowed)
+	}
+	if p == "" {
+		if ok, _ := network.GetAllowUnknownPeer(ctx); !ok {
+			return nil, errors.New("can't dial without a peer ID")
+		}
+	}
 	if ok, isClient, _ := network.GetSimultaneousConnect(ctx); ok && !isClient {
 		return t.holePunch(ctx, raddr, p)
 	}
+	if c := t.takeWarmConn(p, raddr); c != nil {
+		return c, nil
+	}
+	if !t.allowDial(p) {
+		return nil, fmt.Errorf("can't dial %s: %w", p, ErrDialBudgetExceeded)
+	}
+	if mt := t.metricsTracer; mt != nil {
+		start := time.Now()
+		defer func() { mt.ConnEstablished(network.DirOutbound, _err == nil, time.Since(start)) }()
+	}
 
 	scope, err := t.rcmgr.OpenConnection(network.DirOutbound, false, raddr)
 	if err != nil {
@@ -124,18 +472,132 @@ func (t *transport) Dial(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (_c
 		scope.Done()
 		return nil, err
 	}
+	if ok, _, _ := network.GetSimultaneousConnect(ctx); ok {
+		c.(*conn).holePunched.Store(true)
+	}
 	return c, nil
 }
 
+// allowDial takes a dial from the budget of p, see WithDialBudget.
+func (t *transport) allowDial(p peer.ID) bool {
+	if t.dialBudgets == nil {
+		return true
+	}
+	t.dialBudgetsMx.Lock()
+	defer t.dialBudgetsMx.Unlock()
+	l, ok := t.dialBudgets.Get(p)
+	if !ok {
+		l = rate.NewLimiter(t.dialBudgetRate, t.dialBudgetBurst)
+		t.dialBudgets.Add(p, l)
+	}
+	return l.Allow()
+}
+
+// Warmer is implemented by this transport, for latency-sensitive applications.
+type Warmer interface {
+	// Warmup establishes a connection to p at raddr ahead of time. The next
+	// Dial to p at raddr returns it, without waiting for a handshake. Until then, the connection is kept alive by the
+	// keep-alives configured by the quicreuse.ConnManager. Warmup does nothing
+	// if there already is a warm connection to p.
+	Warmup(ctx context.Context, p peer.ID, raddr ma.Multiaddr) error
+}
+
+var _ Warmer = &transport{}
+
+func (t *transport) Warmup(ctx context.Context, p peer.ID, raddr ma.Multiaddr) error {
+	t.warmMx.Lock()
+	_, ok := t.warmConns[p]
+	t.warmMx.Unlock()
+	if ok {
+		return nil
+	}
+	c, err := t.Dial(ctx, raddr, p)
+	if err != nil {
+		return err
+	}
+	wc := c.(*conn)
+
+	t.warmMx.Lock()
+	if _, ok := t.warmConns[p]; ok {
+		// A concurrent call was faster.
+		t.warmMx.Unlock()
+		return wc.Close()
+	}
+	if t.warmConns == nil {
+		t.warmConns = make(map[peer.ID]*conn)
+	}
+	t.warmConns[p] = wc
+	t.warmMx.Unlock()
+
+	// Release the connection if it is closed before it is used, e.g. by the
+	// peer or because it exceeded its maximum age.
+	context.AfterFunc(wc.quicConn.Context(), func() {
+		t.warmMx.Lock()
+		unused := t.warmConns[p] == wc
+		if unused {
+			delete(t.warmConns, p)
+		}
+		t.warmMx.Unlock()
+		if unused {
+			wc.Close()
+		}
+	})
+	return nil
+}
+
+// takeWarmConn removes the warm connection to p at raddr and returns it, see
+// Warmup. It returns nil if there is no usable warm connection.
+func (t *transport) takeWarmConn(p peer.ID, raddr ma.Multiaddr) *conn {
+	t.warmMx.Lock()
+	c, ok := t.warmConns[p]
+	if ok && !c.RemoteMultiaddr().Equal(raddr) {
+		ok = false
+	}
+	if ok {
+		delete(t.warmConns, p)
+	}
+	t.warmMx.Unlock()
+	if !ok {
+		return nil
+	}
+	if c.IsClosed() {
+		c.Close()
+		return nil
+	}
+	return c
+}
+
 func (t *transport) dialWithScope(ctx context.Context, raddr ma.Multiaddr, p peer.ID, scope network.ConnManagementScope) (tpt.CapableConn, error) {
-	if err := scope.SetPeer(p); err != nil {
-		log.Debugw("resource manager blocked outgoing connection for peer", "peer", p, "addr", raddr, "error", err)
-		return nil, err
+	// If the peer is unknown, it is set once the handshake completed.
+	if p != "" {
+		if err := scope.SetPeer(p); err != nil {
+			log.Debugw("resource manager blocked outgoing connection for peer", "peer", p, "addr", raddr, "error", err)
+			return nil, err
+		}
 	}
 
-	tlsConf, keyCh := t.identity.ConfigForPeer(p)
+	expectedPeer := p
+	if t.strictPeerID {
+		// The peer ID is checked once the handshake completed.
+		expectedPeer = ""
+	}
+	tlsConf, keyCh := t.identity.ConfigForPeer(expectedPeer)
+	if name, ok := ctx.Value(serverNameKey{}).(string); ok {
+		tlsConf.ServerName = name
+	}
 	ctx = quicreuse.WithAssociation(ctx, t)
-	pconn, err := t.connManager.DialQUIC(ctx, raddr, tlsConf, t.allowWindowIncrease)
+	var sessionCache *peerSessionCache
+	var pconn *quic.Conn
+	var err error
+	if t.sessionCache != nil && p != "" {
+		sessionCache = &peerSessionCache{cache: t.sessionCache, peer: p}
+		tlsConf.SessionTicketsDisabled = false
+		tlsConf.ClientSessionCache = sessionCache
+		tlsConf.VerifyConnection = sessionCache.verifyConnection
+		pconn, err = t.connManager.DialQUICEarly(ctx, raddr, tlsConf, t.allowWindowIncrease)
+	} else {
+		pconn, err = t.connManager.DialQUIC(ctx, raddr, tlsConf, t.allowWindowIncrease)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -146,12 +608,53 @@ func (t *transport) dialWithScope(ctx context.Context, raddr ma.Multiaddr, p pee
 	case remotePubKey = <-keyCh:
 	default:
 	}
+	chain := pconn.ConnectionState().TLS.PeerCertificates
+	if remotePubKey == nil && sessionCache != nil {
+		// The session was resumed, so the certificate wasn't verified on this connection.
+		// It was verified on the connection that the session ticket was issued on.
+		// When using 0-RTT, the handshake isn't complete yet, so we take the
+		// certificate from the session.
+		if len(chain) == 0 {
+			chain = sessionCache.resumedCertChain()
+		}
+		pubKey, remotePeerID, err := remoteIdentity(pconn.RemoteAddr(), chain)
+		if err != nil {
+			pconn.CloseWithError(1, "")
+			return nil, err
+		}
+		if remotePeerID != p {
+			pconn.CloseWithError(1, "")
+			return nil, fmt.Errorf("peer IDs don't match: expected %s, got %s", p, remotePeerID)
+		}
+		remotePubKey = pubKey
+	}
 	if remotePubKey == nil {
 		pconn.CloseWithError(1, "")
 		return nil, errors.New("p2p/transport/quic BUG: expected remote pub key to be set")
 	}
+	if t.strictPeerID && p != "" {
+		remotePeerID, err := peer.IDFromPublicKey(remotePubKey)
+		if err != nil || remotePeerID != p {
+			pconn.CloseWithError(quic.ApplicationErrorCode(ConnPeerIDMismatch), "peer ID mismatch")
+			return nil, sec.ErrPeerIDMismatch{Expected: p, Actual: remotePeerID}
+		}
+	}
+	remotePeerID := p
+	if p == "" {
+		remotePeerID, err = peer.IDFromPublicKey(remotePubKey)
+		if err != nil {
+			pconn.CloseWithError(1, "")
+			return nil, err
+		}
+		if err := scope.SetPeer(remotePeerID); err != nil {
+			log.Debugw("resource manager blocked outgoing connection for peer", "peer", remotePeerID, "addr", raddr, "error", err)
+			pconn.CloseWithError(1, "")
+			return nil, err
+		}
+	}
 
-	localMultiaddr, err := quicreuse.ToQuicMultiaddr(pconn.LocalAddr(), pconn.ConnectionState().Version)
+	version := pconn.ConnectionState().Version
+	localMultiaddr, err := quicreuse.ToQuicMultiaddr(pconn.LocalAddr(), version)
 	if err != nil {
 		pconn.CloseWithError(1, "")
 		return nil, err
@@ -160,21 +663,58 @@ func (t *transport) dialWithScope(ctx context.Context, raddr ma.Multiaddr, p pee
 		quicConn:        pconn,
 		transport:       t,
 		scope:           scope,
+		version:         version,
 		localPeer:       t.localPeer,
-		localMultiaddr:  localMultiaddr,
+		localMultiaddr:  t.connLocalMultiaddr(localMultiaddr),
 		remotePubKey:    remotePubKey,
-		remotePeerID:    p,
+		remotePeerID:    remotePeerID,
+		remoteAddr:      pconn.RemoteAddr(),
 		remoteMultiaddr: raddr,
 	}
-	if t.gater != nil && !t.gater.InterceptSecured(network.DirOutbound, p, c) {
+	if t.gater != nil && !t.interceptSecured(network.DirOutbound, c, chain) {
 		pconn.CloseWithError(quic.ApplicationErrorCode(network.ConnGated), "connection gated")
 		return nil, fmt.Errorf("secured connection gated")
 	}
 	t.addConn(pconn, c)
+	if t.rttCallback != nil {
+		t.reportRTT(c)
+	}
 	return c, nil
 }
 
+// interceptSecured asks the gater whether to allow the secured connection c,
+// passing it the remote certificate chain if the gater is a
+// connmgr.CertificateGater.
+func (t *transport) interceptSecured(dir network.Direction, c *conn, chain []*x509.Certificate) bool {
+	if cg, ok := t.gater.(connmgr.CertificateGater); ok {
+		return cg.InterceptSecuredWithCert(dir, c.remotePeerID, c, chain)
+	}
+	return t.gater.InterceptSecured(dir, c.remotePeerID, c)
+}
+
+// acquireHandshake reserves a slot for an inbound handshake, if the limit set
+// with WithMaxConcurrentHandshakes isn't reached. The slot is released by
+// calling release, or when ctx, the context of the connection, is done.
+func (t *transport) acquireHandshake(ctx context.Context) (release func(), ok bool) {
+	if t.handshakeSem == nil {
+		return func() {}, true
+	}
+	select {
+	case t.handshakeSem <- struct{}{}:
+	default:
+		return nil, false
+	}
+	var once sync.Once
+	releaseSlot := func() { once.Do(func() { <-t.handshakeSem }) }
+	stop := context.AfterFunc(ctx, releaseSlot)
+	return func() {
+		stop()
+		releaseSlot()
+	}, true
+}
+
 func (t *transport) addConn(conn *quic.Conn, c *conn) {
+	c.created = time.Now()
 	t.connMx.Lock()
 	t.conns[conn] = c
 	t.connMx.Unlock()
@@ -186,6 +726,96 @@ func (t *transport) removeConn(conn *quic.Conn) {
 	t.connMx.Unlock()
 }
 
+// ConnInfo describes a connection of the transport, see ConnLister.
+type ConnInfo struct {
+	RemotePeer      peer.ID
+	RemoteMultiaddr ma.Multiaddr
+	// Version is the negotiated QUIC version.
+	Version quic.Version
+	// Age is the time since the connection was established.
+	Age time.Duration
+}
+
+// ConnLister is implemented by this transport, for diagnostics.
+type ConnLister interface {
+	// Connections returns a snapshot of the open connections, dialed and
+	// accepted, in no particular order.
+	Connections() []ConnInfo
+}
+
+var _ ConnLister = &transport{}
+
+func (t *transport) Connections() []ConnInfo {
+	now := time.Now()
+	t.connMx.Lock()
+	defer t.connMx.Unlock()
+	infos := make([]ConnInfo, 0, len(t.conns))
+	for _, c := range t.conns {
+		infos = append(infos, ConnInfo{
+			RemotePeer:      c.remotePeerID,
+			RemoteMultiaddr: c.RemoteMultiaddr(),
+			Version:         c.version,
+			Age:             now.Sub(c.created),
+		})
+	}
+	return infos
+}
+
+// reportRTT calls the RTT callback once the handshake of c completes.
+func (t *transport) reportRTT(c *conn) {
+	select {
+	case <-c.quicConn.HandshakeComplete():
+		t.rttCallback(c.remotePeerID, c.SmoothedRTT())
+	default:
+		go func() {
+			select {
+			case <-c.quicConn.HandshakeComplete():
+				t.rttCallback(c.remotePeerID, c.SmoothedRTT())
+			case <-c.quicConn.Context().Done():
+			}
+		}()
+	}
+}
+
+// reapOldConns periodically closes connections exceeding the configured maximum age.
+func (t *transport) reapOldConns(ctx context.Context) {
+	defer close(t.reaperDone)
+	ticker := time.NewTicker(t.connAgeLimit.MaxAge / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.closeOldConns(now)
+		}
+	}
+}
+
+func (t *transport) closeOldConns(now time.Time) {
+	l := t.connAgeLimit
+	var old []*conn
+	t.connMx.Lock()
+	for _, c := range t.conns {
+		if now.Sub(c.created) < l.MaxAge {
+			continue
+		}
+		if l.ExemptHolePunched && c.holePunched.Load() {
+			continue
+		}
+		if l.ExemptActiveWithin > 0 && now.Sub(c.lastStreamTime()) < l.ExemptActiveWithin {
+			continue
+		}
+		old = append(old, c)
+	}
+	t.connMx.Unlock()
+
+	for _, c := range old {
+		log.Debugw("closing connection exceeding max age", "peer", c.remotePeerID, "addr", c.RemoteMultiaddr())
+		c.closeWithError(quic.ApplicationErrorCode(network.ConnShutdown), "max connection age exceeded")
+	}
+}
+
 func (t *transport) holePunch(ctx context.Context, raddr ma.Multiaddr, p peer.ID) (tpt.CapableConn, error) {
 	network, saddr, err := manet.DialArgs(raddr)
 	if err != nil {
@@ -273,23 +903,53 @@ loop:
 	}
 }
 
-// Don't use mafmt.QUIC as we don't want to dial DNS addresses. Just /ip{4,6}/udp/quic-v1
-var dialMatcher = mafmt.And(mafmt.IP, mafmt.Base(ma.P_UDP), mafmt.Base(ma.P_QUIC_V1))
+// Don't use mafmt.QUIC as we don't want to dial DNS addresses. Just /ip{4,6}/udp/quic-v1,
+// optionally prefixed with an /ip6zone for link-local IPv6 addresses.
+var dialMatcher = mafmt.And(
+	mafmt.Or(mafmt.IP, mafmt.And(mafmt.Base(ma.P_IP6ZONE), mafmt.Base(ma.P_IP6))),
+	mafmt.Base(ma.P_UDP),
+	mafmt.Base(ma.P_QUIC_V1),
+)
 
 // CanDial determines if we can dial to an address
 func (t *transport) CanDial(addr ma.Multiaddr) bool {
-	return dialMatcher.Matches(addr)
+	if !dialMatcher.Matches(addr) {
+		return false
+	}
+	_, v, err := quicreuse.FromQuicMultiaddr(addr)
+	return err == nil && t.isVersionAllowed(v)
 }
 
 // Listen listens for new QUIC connections on the passed multiaddr.
 func (t *transport) Listen(addr ma.Multiaddr) (tpt.Listener, error) {
 	var tlsConf tls.Config
-	tlsConf.GetConfigForClient = func(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+	tlsConf.GetConfigForClient = func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+		release, ok := t.acquireHandshake(info.Context())
+		if !ok {
+			log.Debugw("too many concurrent handshakes, aborting handshake", "addr", info.Conn.RemoteAddr())
+			return nil, errTooManyHandshakes
+		}
 		// return a tls.Config that verifies the peer's certificate chain.
 		// Note that since we have no way of associating an incoming QUIC connection with
 		// the peer ID calculated here, we don't actually receive the peer's public key
 		// from the key chan.
 		conf, _ := t.identity.ConfigForPeer("")
+		// VerifyConnection is called once the peer's certificate has been
+		// verified, also when resuming a session.
+		verify := conf.VerifyConnection
+		conf.VerifyConnection = func(cs tls.ConnectionState) error {
+			release()
+			if verify != nil {
+				return verify(cs)
+			}
+			return nil
+		}
+		if t.sessionCache != nil {
+			// All connections need to use the same key, otherwise we can't
+			// decrypt the tickets we issued.
+			conf.SessionTicketsDisabled = false
+			conf.SetSessionTicketKeys([][32]byte{t.sessionTicketKey})
+		}
 		return conf, nil
 	}
 	tlsConf.NextProtos = []string{"libp2p"}
@@ -308,7 +968,7 @@ func (t *transport) Listen(addr ma.Multiaddr) (tpt.Listener, error) {
 		underlyingListener = listeners[0].listener
 		acceptRunner = listeners[0].acceptRunnner
 		// Make sure our underlying listener is listening on the specified QUIC version
-		if _, ok := underlyingListener.localMultiaddrs[version]; !ok {
+		if _, ok := underlyingListener.localMultiaddr(version); !ok {
 			return nil, fmt.Errorf("can't listen on quic version %v, underlying listener doesn't support it", version)
 		}
 	} else {
@@ -321,7 +981,14 @@ func (t *transport) Listen(addr ma.Multiaddr) (tpt.Listener, error) {
 			_ = ln.Close()
 			return nil, err
 		}
+		if _, ok := l.localMultiaddrs[version]; !ok {
+			_ = ln.Close()
+			return nil, fmt.Errorf("can't listen on quic version %v, underlying listener doesn't support it", version)
+		}
 		underlyingListener = &l
+		if t.addrsChanged != nil {
+			underlyingListener.watchAddrs(t.addrsChanged)
+		}
 
 		acceptRunner = &acceptLoopRunner{
 			acceptSem: make(chan struct{}, 1),
@@ -373,9 +1040,45 @@ func (t *transport) String() string {
 }
 
 func (t *transport) Close() error {
+	if t.stopReaper != nil {
+		t.stopReaper()
+		<-t.reaperDone
+	}
+	// Warm connections weren't handed out, so nobody else closes them.
+	t.warmMx.Lock()
+	warmConns := t.warmConns
+	t.warmConns = nil
+	t.warmMx.Unlock()
+	for _, c := range warmConns {
+		c.Close()
+	}
 	return nil
 }
 
+// Drain drains all connections of the transport concurrently, see conn.Drain.
+// It returns once all connections are closed, which happens at the latest when
+// ctx is done. This is intended to be used on shutdown.
+func (t *transport) Drain(ctx context.Context) error {
+	t.connMx.Lock()
+	conns := make([]*conn, 0, len(t.conns))
+	for _, c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.connMx.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(conns))
+	for i, c := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = c.Drain(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
 func (t *transport) CloseVirtualListener(l *virtualListener) error {
 	t.listenersMu.Lock()
 	defer t.listenersMu.Unlock()
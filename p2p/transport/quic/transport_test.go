@@ -47,10 +47,12 @@ func TestCanDial(t *testing.T) {
 		"/ip4/5.5.5.5/tcp/1234",
 		"/dns/google.com/udp/443/quic-v1",
 		"/ip4/127.0.0.1/udp/1234/quic",
+		"/ip6zone/eth0/ip4/127.0.0.1/udp/1234/quic-v1",
 	}
 	valid := []string{
 		"/ip4/127.0.0.1/udp/1234/quic-v1",
 		"/ip4/5.5.5.5/udp/0/quic-v1",
+		"/ip6zone/eth0/ip6/fe80::1/udp/1234/quic-v1",
 	}
 	for _, s := range invalid {
 		invalidAddr, err := ma.NewMultiaddr(s)
@@ -1,6 +1,7 @@
 package libp2pquic
 
 import (
+	"context"
 	"sync"
 
 	"github.com/libp2p/go-libp2p/core/network"
@@ -23,10 +24,11 @@ type virtualListener struct {
 	acceptChan    chan acceptVal
 }
 
-var _ tpt.Listener = &virtualListener{}
+var _ StatsListener = &virtualListener{}
 
 func (l *virtualListener) Multiaddr() ma.Multiaddr {
-	return l.listener.localMultiaddrs[l.version]
+	addr, _ := l.listener.localMultiaddr(l.version)
+	return addr
 }
 
 func (l *virtualListener) Close() error {
@@ -35,7 +37,13 @@ func (l *virtualListener) Close() error {
 }
 
 func (l *virtualListener) Accept() (tpt.CapableConn, error) {
-	return l.acceptRunnner.Accept(l.listener, l.version, l.acceptChan)
+	return l.AcceptContext(context.Background())
+}
+
+// AcceptContext accepts new connections. It returns ctx.Err() when the context
+// is canceled before a connection is accepted.
+func (l *virtualListener) AcceptContext(ctx context.Context) (tpt.CapableConn, error) {
+	return l.acceptRunnner.Accept(ctx, l.listener, l.version, l.acceptChan)
 }
 
 type acceptVal struct {
@@ -99,7 +107,7 @@ func (r *acceptLoopRunner) sendErrAndClose(err error) {
 // innerAccept is the inner logic of the Accept loop. Assume caller holds the
 // acceptSemaphore. May return both a nil conn and nil error if it didn't find a
 // conn with the expected version
-func (r *acceptLoopRunner) innerAccept(l *listener, expectedVersion quic.Version, bufferedConnChan chan acceptVal) (tpt.CapableConn, error) {
+func (r *acceptLoopRunner) innerAccept(ctx context.Context, l *listener, expectedVersion quic.Version, bufferedConnChan chan acceptVal) (tpt.CapableConn, error) {
 	select {
 	// Check if we have a buffered connection first from an earlier Accept call
 	case v, ok := <-bufferedConnChan:
@@ -110,9 +118,13 @@ func (r *acceptLoopRunner) innerAccept(l *listener, expectedVersion quic.Version
 	default:
 	}
 
-	conn, err := l.Accept()
+	conn, err := l.AcceptContext(ctx)
 
 	if err != nil {
+		if ctx.Err() != nil {
+			// Only this Accept call was canceled, the listener is still usable.
+			return nil, ctx.Err()
+		}
 		r.sendErrAndClose(err)
 		return nil, err
 	}
@@ -151,13 +163,13 @@ func (r *acceptLoopRunner) innerAccept(l *listener, expectedVersion quic.Version
 	return nil, nil
 }
 
-func (r *acceptLoopRunner) Accept(l *listener, expectedVersion quic.Version, bufferedConnChan chan acceptVal) (tpt.CapableConn, error) {
+func (r *acceptLoopRunner) Accept(ctx context.Context, l *listener, expectedVersion quic.Version, bufferedConnChan chan acceptVal) (tpt.CapableConn, error) {
 	for {
 		var conn tpt.CapableConn
 		var err error
 		select {
 		case r.acceptSem <- struct{}{}:
-			conn, err = r.innerAccept(l, expectedVersion, bufferedConnChan)
+			conn, err = r.innerAccept(ctx, l, expectedVersion, bufferedConnChan)
 			<-r.acceptSem
 
 			if conn == nil && err == nil {
@@ -170,6 +182,8 @@ func (r *acceptLoopRunner) Accept(l *listener, expectedVersion quic.Version, buf
 			}
 			conn = v.conn
 			err = v.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
 		return conn, err
 	}
@@ -1,6 +1,7 @@
 package quicreuse
 
 import (
+	"os"
 	"time"
 
 	"github.com/quic-go/quic-go"
@@ -13,6 +14,21 @@ var quicConfig = &quic.Config{
 	MaxConnectionReceiveWindow: 15 * (1 << 20), // 15 MB
 	KeepAlivePeriod:            15 * time.Second,
 	Versions:                   []quic.Version{quic.Version1},
-	// We don't use datagrams (yet), but this is necessary for WebTransport
+	// Necessary for WebTransport, and for datagrams on libp2p QUIC connections.
 	EnableDatagrams: true,
+	// The congestion control algorithm isn't configurable: quic-go always
+	// uses Cubic.
+}
+
+// DisableGSO disables generic segmentation offload (GSO), which quic-go uses to
+// send batches of packets with a single syscall where the kernel supports it.
+// Some kernels and network drivers drop packets sent this way. By default, GSO
+// is used if it is supported.
+// quic-go can only disable GSO process-wide, so this affects all QUIC
+// connections of the process, including those of other ConnManagers. It sets
+// the QUIC_GO_DISABLE_GSO environment variable, which quic-go reads whenever it
+// starts using a socket, so it should be called before any ConnManager is
+// created.
+func DisableGSO() error {
+	return os.Setenv("QUIC_GO_DISABLE_GSO", "true")
 }
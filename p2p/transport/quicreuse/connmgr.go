@@ -11,7 +11,10 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/libp2p/go-netroute"
 	ma "github.com/multiformats/go-multiaddr"
@@ -53,9 +56,29 @@ type ConnManager struct {
 
 	listenUDP          listenUDP
 	sourceIPSelectorFn func() (SourceIPSelector, error)
+	listenInterface    string
 
 	enableMetrics bool
 	registerer    prometheus.Registerer
+	// newTracer, if set, returns an additional tracer for every connection.
+	newTracer func(context.Context, quiclogging.Perspective, quic.ConnectionID) *quiclogging.ConnectionTracer
+
+	enableZeroRTT           bool
+	maxIncomingStreams      int64
+	maxIdleTimeout          time.Duration
+	handshakeIdleTimeout    time.Duration
+	disablePathMTUDiscovery bool
+	// versions is nil if the default versions are used.
+	versions []quic.Version
+	// dialPort is the local port dials originate from, if possible. 0 means any port.
+	dialPort int
+
+	// receiveBufferWarning is nil unless ReceiveBufferWarningThreshold is used.
+	receiveBufferWarning *receiveBufferWarning
+	receiveBufferSize    atomic.Int64
+
+	// conns is nil unless EnableConnTracking is used.
+	conns *connTracker
 
 	serverConfig *quic.Config
 	clientConfig *quic.Config
@@ -106,9 +129,35 @@ func NewConnManager(statelessResetKey quic.StatelessResetKey, tokenKey quic.Toke
 		}
 	}
 
+	if cm.receiveBufferWarning != nil {
+		listen := cm.listenUDP
+		cm.listenUDP = func(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+			conn, err := listen(network, laddr)
+			if err != nil {
+				return nil, err
+			}
+			cm.setReceiveBuffer(conn)
+			return conn, nil
+		}
+	}
+
 	quicConf := quicConfig.Clone()
 	quicConf.Tracer = cm.getTracer()
+	if cm.maxIncomingStreams > 0 {
+		quicConf.MaxIncomingStreams = cm.maxIncomingStreams
+	}
+	if cm.maxIdleTimeout > 0 {
+		quicConf.MaxIdleTimeout = cm.maxIdleTimeout
+	}
+	if cm.handshakeIdleTimeout > 0 {
+		quicConf.HandshakeIdleTimeout = cm.handshakeIdleTimeout
+	}
+	quicConf.DisablePathMTUDiscovery = cm.disablePathMTUDiscovery
+	if cm.versions != nil {
+		quicConf.Versions = cm.versions
+	}
 	serverConfig := quicConf.Clone()
+	serverConfig.Allow0RTT = cm.enableZeroRTT
 
 	cm.clientConfig = quicConf
 	cm.serverConfig = serverConfig
@@ -130,12 +179,14 @@ func NewConnManager(statelessResetKey quic.StatelessResetKey, tokenKey quic.Toke
 	if cm.enableReuseport {
 		cm.reuseUDP4 = newReuse(&statelessResetKey, &tokenKey, cm.listenUDP, cm.sourceIPSelectorFn, cm.connContext, cm.verifySourceAddress)
 		cm.reuseUDP6 = newReuse(&statelessResetKey, &tokenKey, cm.listenUDP, cm.sourceIPSelectorFn, cm.connContext, cm.verifySourceAddress)
+		cm.reuseUDP4.dialPort = cm.dialPort
+		cm.reuseUDP6.dialPort = cm.dialPort
 	}
 	return cm, nil
 }
 
 func (c *ConnManager) getTracer() func(context.Context, quiclogging.Perspective, quic.ConnectionID) *quiclogging.ConnectionTracer {
-	return func(_ context.Context, p quiclogging.Perspective, ci quic.ConnectionID) *quiclogging.ConnectionTracer {
+	return func(ctx context.Context, p quiclogging.Perspective, ci quic.ConnectionID) *quiclogging.ConnectionTracer {
 		var promTracer *quiclogging.ConnectionTracer
 		if c.enableMetrics {
 			switch p {
@@ -147,18 +198,58 @@ func (c *ConnManager) getTracer() func(context.Context, quiclogging.Perspective,
 				log.Error("invalid logging perspective: %s", p)
 			}
 		}
-		var tracer *quiclogging.ConnectionTracer
+		var tracers []*quiclogging.ConnectionTracer
 		if qlogTracerDir != "" {
-			tracer = qloggerForDir(qlogTracerDir, p, ci)
 			if promTracer != nil {
-				tracer = quiclogging.NewMultiplexedConnectionTracer(promTracer,
-					tracer)
+				tracers = append(tracers, promTracer)
+			}
+			tracers = append(tracers, qloggerForDir(qlogTracerDir, p, ci))
+		}
+		if c.newTracer != nil {
+			if t := c.newTracer(ctx, p, ci); t != nil {
+				tracers = append(tracers, t)
 			}
 		}
-		return tracer
+		if c.conns != nil {
+			if t := c.conns.connectionTracer(ctx); t != nil {
+				tracers = append(tracers, t)
+			}
+		}
+		switch len(tracers) {
+		case 0:
+			return nil
+		case 1:
+			return tracers[0]
+		default:
+			return quiclogging.NewMultiplexedConnectionTracer(tracers...)
+		}
 	}
 }
 
+// SmoothedRTT returns the smoothed RTT estimate of a connection dialed or
+// accepted by this ConnManager. After the handshake completed, it is based on
+// at least one RTT sample. It returns 0 if no estimate is available, or if the
+// ConnManager wasn't constructed with EnableConnTracking.
+func (c *ConnManager) SmoothedRTT(conn *quic.Conn) time.Duration {
+	return c.conns.smoothedRTT(conn)
+}
+
+// IdleTimeout returns the idle timeout negotiated for a connection dialed or
+// accepted by this ConnManager, which is the smaller of the idle timeouts
+// offered by both endpoints. quic-go raises idle timeouts offered by the peer
+// to at least 5 seconds, so both endpoints don't necessarily use the same
+// value. It returns 0 if the connection's transport parameters are unknown, or
+// if the ConnManager wasn't constructed with EnableConnTracking.
+func (c *ConnManager) IdleTimeout(conn *quic.Conn) time.Duration {
+	return c.conns.idleTimeout(conn)
+}
+
+// ConnTrackingEnabled returns whether the ConnManager was constructed with
+// EnableConnTracking.
+func (c *ConnManager) ConnTrackingEnabled() bool {
+	return c.conns != nil
+}
+
 func (c *ConnManager) getReuse(network string) (*reuse, error) {
 	switch network {
 	case "udp4":
@@ -217,6 +308,9 @@ func (c *ConnManager) ListenQUICAndAssociate(association any, addr ma.Multiaddr,
 	if err != nil {
 		return nil, err
 	}
+	if laddr, err = c.interfaceListenAddr(netw, laddr); err != nil {
+		return nil, err
+	}
 
 	c.quicListenersMu.Lock()
 	defer c.quicListenersMu.Unlock()
@@ -274,7 +368,11 @@ func (c *ConnManager) onListenerClosed(key string) {
 }
 
 // SharedNonQUICPacketConn returns a `net.PacketConn` for `laddr` for non QUIC uses.
-func (c *ConnManager) SharedNonQUICPacketConn(_ string, laddr *net.UDPAddr) (net.PacketConn, error) {
+func (c *ConnManager) SharedNonQUICPacketConn(network string, laddr *net.UDPAddr) (net.PacketConn, error) {
+	laddr, err := c.interfaceListenAddr(network, laddr)
+	if err != nil {
+		return nil, err
+	}
 	c.quicListenersMu.Lock()
 	defer c.quicListenersMu.Unlock()
 	key := laddr.String()
@@ -296,6 +394,50 @@ func (c *ConnManager) SharedNonQUICPacketConn(_ string, laddr *net.UDPAddr) (net
 	return nil, errors.New("expected to be able to share with a QUIC listener, but the QUIC listener is not using a refcountedTransport. `DisableReuseport` should not be set")
 }
 
+// interfaceListenAddr returns the address to listen on for laddr, taking the
+// ListenOnInterface option into account. Unspecified addresses are replaced by
+// the first address of the interface in the same IP family. Link-local IPv6
+// addresses are skipped, as they can't be used without a zone.
+func (c *ConnManager) interfaceListenAddr(network string, laddr *net.UDPAddr) (*net.UDPAddr, error) {
+	if c.listenInterface == "" {
+		return laddr, nil
+	}
+	iface, err := net.InterfaceByName(c.listenInterface)
+	if err != nil {
+		return nil, err
+	}
+	ifaddrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses of interface %s: %w", iface.Name, err)
+	}
+	wantIPv4 := network == "udp4" || (network == "udp" && laddr.IP.To4() != nil)
+	for _, ifaddr := range ifaddrs {
+		ipnet, ok := ifaddr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipnet.IP
+		if !laddr.IP.IsUnspecified() {
+			if ip.Equal(laddr.IP) {
+				return laddr, nil
+			}
+			continue
+		}
+		if (ip.To4() != nil) != wantIPv4 || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		return &net.UDPAddr{IP: ip, Port: laddr.Port}, nil
+	}
+	if !laddr.IP.IsUnspecified() {
+		return nil, fmt.Errorf("%s is not an address of interface %s", laddr.IP, iface.Name)
+	}
+	family := "IPv6"
+	if wantIPv4 {
+		family = "IPv4"
+	}
+	return nil, fmt.Errorf("interface %s has no %s address", iface.Name, family)
+}
+
 func (c *ConnManager) transportForListen(association any, network string, laddr *net.UDPAddr) (RefCountedQUICTransport, error) {
 	if c.enableReuseport {
 		reuse, err := c.getReuse(network)
@@ -333,6 +475,18 @@ func WithAssociation(ctx context.Context, association any) context.Context {
 // - Any transport previously used for dialing
 // If none of these are available, it'll create a new transport.
 func (c *ConnManager) DialQUIC(ctx context.Context, raddr ma.Multiaddr, tlsConf *tls.Config, allowWindowIncrease func(conn *quic.Conn, delta uint64) bool) (*quic.Conn, error) {
+	return c.dialQUIC(ctx, raddr, tlsConf, allowWindowIncrease, false)
+}
+
+// DialQUICEarly is like DialQUIC, but attempts to use 0-RTT if tlsConf has a
+// session cached for the peer. In that case the connection is returned before
+// the handshake completes, see quic.Transport.DialEarly for details.
+// If the transport used for dialing doesn't support 0-RTT, it falls back to a regular dial.
+func (c *ConnManager) DialQUICEarly(ctx context.Context, raddr ma.Multiaddr, tlsConf *tls.Config, allowWindowIncrease func(conn *quic.Conn, delta uint64) bool) (*quic.Conn, error) {
+	return c.dialQUIC(ctx, raddr, tlsConf, allowWindowIncrease, true)
+}
+
+func (c *ConnManager) dialQUIC(ctx context.Context, raddr ma.Multiaddr, tlsConf *tls.Config, allowWindowIncrease func(conn *quic.Conn, delta uint64) bool, early bool) (*quic.Conn, error) {
 	naddr, v, err := FromQuicMultiaddr(raddr)
 	if err != nil {
 		return nil, err
@@ -345,12 +499,11 @@ func (c *ConnManager) DialQUIC(ctx context.Context, raddr ma.Multiaddr, tlsConf
 	quicConf := c.clientConfig.Clone()
 	quicConf.AllowConnectionWindowIncrease = allowWindowIncrease
 
-	if v == quic.Version1 {
-		// The endpoint has explicit support for QUIC v1, so we'll only use that version.
-		quicConf.Versions = []quic.Version{quic.Version1}
-	} else {
-		return nil, errors.New("unknown QUIC version")
+	if !slices.Contains(c.clientConfig.Versions, v) {
+		return nil, fmt.Errorf("QUIC version %s not allowed", v)
 	}
+	// The endpoint has explicit support for this version, so we'll only use that version.
+	quicConf.Versions = []quic.Version{v}
 
 	var tr RefCountedQUICTransport
 	association := ctx.Value(associationKey{})
@@ -358,7 +511,12 @@ func (c *ConnManager) DialQUIC(ctx context.Context, raddr ma.Multiaddr, tlsConf
 	if err != nil {
 		return nil, err
 	}
-	conn, err := tr.Dial(ctx, naddr, tlsConf, quicConf)
+	var conn *quic.Conn
+	if early {
+		conn, err = dialEarly(ctx, tr, naddr, tlsConf, quicConf)
+	} else {
+		conn, err = tr.Dial(ctx, naddr, tlsConf, quicConf)
+	}
 	if err != nil {
 		tr.DecreaseCount()
 		return nil, err
@@ -385,14 +543,11 @@ func (c *ConnManager) TransportWithAssociationForDial(association any, network s
 		return reuse.TransportWithAssociationForDial(association, network, raddr)
 	}
 
-	var laddr *net.UDPAddr
-	switch network {
-	case "udp4":
-		laddr = &net.UDPAddr{IP: net.IPv4zero, Port: 0}
-	case "udp6":
-		laddr = &net.UDPAddr{IP: net.IPv6zero, Port: 0}
+	conn, err := c.listenUDP(network, unspecifiedUDPAddr(network, c.dialPort))
+	if err != nil && c.dialPort != 0 {
+		log.Debugw("dial source port unavailable, dialing from another port", "port", c.dialPort, "error", err)
+		conn, err = c.listenUDP(network, unspecifiedUDPAddr(network, 0))
 	}
-	conn, err := c.listenUDP(network, laddr)
 	if err != nil {
 		return nil, err
 	}
@@ -443,6 +598,10 @@ func (t *wrappedQUICTransport) Listen(tlsConf *tls.Config, conf *quic.Config) (Q
 	return t.Transport.Listen(tlsConf, conf)
 }
 
+func (t *wrappedQUICTransport) ListenEarly(tlsConf *tls.Config, conf *quic.Config) (QUICListener, error) {
+	return t.Transport.ListenEarly(tlsConf, conf)
+}
+
 func newQUICTransport(
 	conn net.PacketConn,
 	tokenGeneratorKey *quic.TokenGeneratorKey,
@@ -7,7 +7,9 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
 	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,6 +20,7 @@ import (
 	ma "github.com/multiformats/go-multiaddr"
 	manet "github.com/multiformats/go-multiaddr/net"
 	"github.com/quic-go/quic-go"
+	quiclogging "github.com/quic-go/quic-go/logging"
 	"github.com/stretchr/testify/require"
 )
 
@@ -489,3 +492,359 @@ func TestConnContext(t *testing.T) {
 		})
 	}
 }
+
+func TestAllowedVersions(t *testing.T) {
+	_, err := NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, WithAllowedVersions())
+	require.Error(t, err)
+	// QUIC v2 doesn't have a multiaddr, so it couldn't be advertised or dialed.
+	_, err = NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, WithAllowedVersions(quic.Version2))
+	require.ErrorContains(t, err, "not supported")
+
+	cm, err := NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, WithAllowedVersions(quic.Version1))
+	require.NoError(t, err)
+	defer cm.Close()
+	_, tlsConf := getTLSConfForProto(t, "proto")
+	ln, err := cm.ListenQUIC(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"), tlsConf, nil)
+	require.NoError(t, err)
+	defer ln.Close()
+	addr := ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/udp/%d/quic-v1", ln.Addr().(*net.UDPAddr).Port))
+	require.Equal(t, []ma.Multiaddr{addr}, ln.Multiaddrs())
+
+	// The listener refuses other versions during version negotiation.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = quic.DialAddr(ctx, ln.Addr().String(), &tls.Config{NextProtos: []string{"proto"}, InsecureSkipVerify: true}, &quic.Config{Versions: []quic.Version{quic.Version2}})
+	var verErr *quic.VersionNegotiationError
+	require.ErrorAs(t, err, &verErr)
+	require.Contains(t, verErr.Theirs, quic.Version1)
+	require.NotContains(t, verErr.Theirs, quic.Version2)
+}
+
+func loopbackInterface(t *testing.T) net.Interface {
+	t.Helper()
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			return iface
+		}
+	}
+	t.Skip("no loopback interface")
+	return net.Interface{}
+}
+
+func TestListenOnInterface(t *testing.T) {
+	lo := loopbackInterface(t)
+	ifaddrs, err := lo.Addrs()
+	require.NoError(t, err)
+	hasIP := func(ip net.IP) bool {
+		for _, a := range ifaddrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	cm, err := NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, ListenOnInterface(lo.Name))
+	require.NoError(t, err)
+	defer cm.Close()
+
+	for _, tc := range []struct {
+		name string
+		addr string
+		ip   net.IP
+	}{
+		{"IPv4", "/ip4/0.0.0.0/udp/0/quic-v1", net.IPv4(127, 0, 0, 1)},
+		{"IPv6", "/ip6/::/udp/0/quic-v1", net.IPv6loopback},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if !hasIP(tc.ip) {
+				t.Skipf("%s has no address %s", lo.Name, tc.ip)
+			}
+			ln, err := cm.ListenQUIC(ma.StringCast(tc.addr), &tls.Config{NextProtos: []string{"proto"}}, nil)
+			require.NoError(t, err)
+			defer ln.Close()
+
+			require.True(t, ln.Addr().(*net.UDPAddr).IP.Equal(tc.ip))
+			require.NotEmpty(t, ln.Multiaddrs())
+			for _, addr := range ln.Multiaddrs() {
+				ip, err := manet.ToIP(addr)
+				require.NoError(t, err)
+				require.True(t, ip.Equal(tc.ip), "unexpected listen address %s", addr)
+			}
+		})
+	}
+
+	t.Run("address of another interface", func(t *testing.T) {
+		_, err := cm.ListenQUIC(ma.StringCast("/ip4/192.0.2.1/udp/0/quic-v1"), &tls.Config{NextProtos: []string{"proto"}}, nil)
+		require.ErrorContains(t, err, "not an address of interface "+lo.Name)
+	})
+
+	t.Run("unknown interface", func(t *testing.T) {
+		cm, err := NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, ListenOnInterface("nonexistent0"))
+		require.NoError(t, err)
+		defer cm.Close()
+		_, err = cm.ListenQUIC(ma.StringCast("/ip4/0.0.0.0/udp/0/quic-v1"), &tls.Config{NextProtos: []string{"proto"}}, nil)
+		require.Error(t, err)
+	})
+}
+
+// configRecordingTransport records the quic.Configs it is used with.
+type configRecordingTransport struct {
+	*wrappedQUICTransport
+	listenConf, dialConf *quic.Config
+}
+
+func (t *configRecordingTransport) Listen(tlsConf *tls.Config, conf *quic.Config) (QUICListener, error) {
+	t.listenConf = conf
+	return t.wrappedQUICTransport.Listen(tlsConf, conf)
+}
+
+func (t *configRecordingTransport) Dial(ctx context.Context, addr net.Addr, tlsConf *tls.Config, conf *quic.Config) (*quic.Conn, error) {
+	t.dialConf = conf
+	return t.wrappedQUICTransport.Dial(ctx, addr, tlsConf, conf)
+}
+
+func TestDisablePathMTUDiscovery(t *testing.T) {
+	for _, disable := range []bool{true, false} {
+		t.Run(fmt.Sprintf("disabled: %t", disable), func(t *testing.T) {
+			var opts []Option
+			if disable {
+				opts = append(opts, DisablePathMTUDiscovery())
+			}
+			cm, err := NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, opts...)
+			require.NoError(t, err)
+			defer cm.Close()
+
+			conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+			require.NoError(t, err)
+			defer conn.Close()
+			tr := &configRecordingTransport{wrappedQUICTransport: &wrappedQUICTransport{&quic.Transport{Conn: conn}}}
+			defer tr.Close()
+			_, err = cm.LendTransport("udp4", tr, conn)
+			require.NoError(t, err)
+
+			ln, err := cm.ListenQUIC(
+				ma.StringCast(fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1", conn.LocalAddr().(*net.UDPAddr).Port)),
+				&tls.Config{NextProtos: []string{"libp2p"}},
+				func(*quic.Conn, uint64) bool { return false },
+			)
+			require.NoError(t, err)
+			defer ln.Close()
+			require.NotNil(t, tr.listenConf)
+			require.Equal(t, disable, tr.listenConf.DisablePathMTUDiscovery)
+
+			udpLn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			require.NoError(t, err)
+			defer udpLn.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			_, err = cm.DialQUIC(
+				ctx,
+				ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/udp/%d/quic-v1", udpLn.LocalAddr().(*net.UDPAddr).Port)),
+				&tls.Config{NextProtos: []string{"libp2p"}},
+				func(*quic.Conn, uint64) bool { return false },
+			)
+			require.ErrorIs(t, err, context.DeadlineExceeded)
+			require.NotNil(t, tr.dialConf)
+			require.Equal(t, disable, tr.dialConf.DisablePathMTUDiscovery)
+		})
+	}
+}
+
+func TestConnectionTracer(t *testing.T) {
+	var called atomic.Int32
+	var perspective atomic.Value
+	cm, err := NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, WithConnectionTracer(
+		func(_ context.Context, p quiclogging.Perspective, _ quic.ConnectionID) *quiclogging.ConnectionTracer {
+			called.Add(1)
+			perspective.Store(p)
+			return &quiclogging.ConnectionTracer{}
+		},
+	))
+	require.NoError(t, err)
+	defer cm.Close()
+
+	udpLn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer udpLn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = cm.DialQUIC(
+		ctx,
+		ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/udp/%d/quic-v1", udpLn.LocalAddr().(*net.UDPAddr).Port)),
+		&tls.Config{NextProtos: []string{"libp2p"}},
+		func(*quic.Conn, uint64) bool { return false },
+	)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, int32(1), called.Load())
+	require.Equal(t, quiclogging.PerspectiveClient, perspective.Load())
+}
+
+func TestHandshakeIdleTimeout(t *testing.T) {
+	cm, err := NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, WithHandshakeIdleTimeout(200*time.Millisecond))
+	require.NoError(t, err)
+	defer cm.Close()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	require.NoError(t, err)
+	defer conn.Close()
+	tr := &configRecordingTransport{wrappedQUICTransport: &wrappedQUICTransport{&quic.Transport{Conn: conn}}}
+	defer tr.Close()
+	_, err = cm.LendTransport("udp4", tr, conn)
+	require.NoError(t, err)
+	ln, err := cm.ListenQUIC(
+		ma.StringCast(fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1", conn.LocalAddr().(*net.UDPAddr).Port)),
+		&tls.Config{NextProtos: []string{"libp2p"}},
+		func(*quic.Conn, uint64) bool { return false },
+	)
+	require.NoError(t, err)
+	defer ln.Close()
+	require.NotNil(t, tr.listenConf)
+	require.Equal(t, 200*time.Millisecond, tr.listenConf.HandshakeIdleTimeout)
+
+	// The peer never answers, stalling the handshake.
+	udpLn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer udpLn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	start := time.Now()
+	_, err = cm.DialQUIC(
+		ctx,
+		ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/udp/%d/quic-v1", udpLn.LocalAddr().(*net.UDPAddr).Port)),
+		&tls.Config{NextProtos: []string{"libp2p"}},
+		func(*quic.Conn, uint64) bool { return false },
+	)
+	var nerr net.Error
+	require.ErrorAs(t, err, &nerr)
+	require.True(t, nerr.Timeout())
+	require.NoError(t, ctx.Err())
+	require.Less(t, time.Since(start), 5*time.Second)
+	require.Equal(t, 200*time.Millisecond, tr.dialConf.HandshakeIdleTimeout)
+
+	_, err = NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, WithHandshakeIdleTimeout(0))
+	require.ErrorContains(t, err, "handshake idle timeout must be positive")
+}
+
+func TestDialSourcePort(t *testing.T) {
+	freePort := func(t *testing.T) int {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.LocalAddr().(*net.UDPAddr).Port
+	}
+	_, serverTLSConf := getTLSConfForProto(t, "proto")
+	ln, err := quic.ListenAddr("127.0.0.1:0", serverTLSConf, nil)
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		// Connections are closed along with the listener.
+		for {
+			if _, err := ln.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+	raddr := ma.StringCast(fmt.Sprintf("/ip4/127.0.0.1/udp/%d/quic-v1", ln.Addr().(*net.UDPAddr).Port))
+
+	dial := func(t *testing.T, cm *ConnManager) *quic.Conn {
+		clientKey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+		clientIdentity, err := libp2ptls.NewIdentity(clientKey)
+		require.NoError(t, err)
+		tlsConf, _ := clientIdentity.ConfigForPeer("")
+		tlsConf.NextProtos = []string{"proto"}
+		conn, err := cm.DialQUIC(context.Background(), raddr, tlsConf, func(*quic.Conn, uint64) bool { return false })
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.CloseWithError(0, "") })
+		return conn
+	}
+
+	for _, reuse := range []bool{true, false} {
+		t.Run(fmt.Sprintf("reuseport: %t", reuse), func(t *testing.T) {
+			port := freePort(t)
+			opts := []Option{WithDialSourcePort(port)}
+			if !reuse {
+				opts = append(opts, DisableReuseport())
+			}
+			cm, err := NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, opts...)
+			require.NoError(t, err)
+			defer cm.Close()
+
+			conn := dial(t, cm)
+			require.Equal(t, port, conn.LocalAddr().(*net.UDPAddr).Port)
+			if !reuse {
+				return
+			}
+			// Subsequent dials share the socket, and listeners on the port take it over.
+			require.Equal(t, port, dial(t, cm).LocalAddr().(*net.UDPAddr).Port)
+			l, err := cm.ListenQUIC(
+				ma.StringCast(fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1", port)),
+				&tls.Config{NextProtos: []string{"libp2p"}},
+				func(*quic.Conn, uint64) bool { return false },
+			)
+			require.NoError(t, err)
+			defer l.Close()
+			require.Equal(t, port, dial(t, cm).LocalAddr().(*net.UDPAddr).Port)
+		})
+	}
+
+	t.Run("port in use", func(t *testing.T) {
+		occupied, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+		require.NoError(t, err)
+		defer occupied.Close()
+		port := occupied.LocalAddr().(*net.UDPAddr).Port
+		cm, err := NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, WithDialSourcePort(port))
+		require.NoError(t, err)
+		defer cm.Close()
+		require.NotEqual(t, port, dial(t, cm).LocalAddr().(*net.UDPAddr).Port)
+	})
+
+	_, err = NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, WithDialSourcePort(0))
+	require.ErrorContains(t, err, "dial source port must be between 1 and 65535")
+}
+
+func TestDisableGSO(t *testing.T) {
+	// DisableGSO sets the environment variable, restore it afterwards.
+	t.Setenv("QUIC_GO_DISABLE_GSO", "")
+
+	cm, err := NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{})
+	require.NoError(t, err)
+	cm.Close()
+	require.Empty(t, os.Getenv("QUIC_GO_DISABLE_GSO"), "GSO is only disabled if requested")
+
+	require.NoError(t, DisableGSO())
+	require.Equal(t, "true", os.Getenv("QUIC_GO_DISABLE_GSO"))
+}
+
+func TestReceiveBufferSize(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetReadBuffer(desiredReceiveBufferSize))
+	expected, err := inspectReceiveBuffer(conn)
+	if err != nil {
+		t.Skipf("can't inspect receive buffer: %s", err)
+	}
+
+	listen := func(t *testing.T, opts ...Option) *ConnManager {
+		t.Helper()
+		cm, err := NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() { cm.Close() })
+		require.Zero(t, cm.ReceiveBufferSize())
+		ln, err := cm.ListenQUIC(ma.StringCast("/ip4/127.0.0.1/udp/0/quic-v1"), &tls.Config{NextProtos: []string{"proto"}}, func(*quic.Conn, uint64) bool { return false })
+		require.NoError(t, err)
+		t.Cleanup(func() { ln.Close() })
+		return cm
+	}
+	// The buffer is only inspected if the option is set.
+	require.Zero(t, listen(t).ReceiveBufferSize())
+	require.Equal(t, expected, listen(t, ReceiveBufferWarningThreshold(1<<30)).ReceiveBufferSize())
+	require.Equal(t, expected, listen(t, ReceiveBufferWarningThreshold(0)).ReceiveBufferSize())
+
+	_, err = NewConnManager(quic.StatelessResetKey{}, quic.TokenGeneratorKey{}, ReceiveBufferWarningThreshold(-1))
+	require.Error(t, err)
+}
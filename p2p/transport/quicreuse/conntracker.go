@@ -0,0 +1,100 @@
+package quicreuse
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	quiclogging "github.com/quic-go/quic-go/logging"
+)
+
+// connTracker records the smoothed RTT and the idle timeouts of connections,
+// as reported by quic-go's connection tracer. quic.Conn doesn't expose them
+// otherwise.
+// Connections are identified by their tracing ID, which is stored in the
+// context passed to the tracer as well as in the connection's context.
+type connTracker struct {
+	mx    sync.Mutex
+	conns map[quic.ConnectionTracingID]*trackedConn
+}
+
+type trackedConn struct {
+	rtt atomic.Int64
+	// localIdleTimeout and remoteIdleTimeout are the idle timeouts sent and
+	// received in the transport parameters. A remote idle timeout of 0 means
+	// the peer didn't set one.
+	localIdleTimeout, remoteIdleTimeout atomic.Int64
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[quic.ConnectionTracingID]*trackedConn)}
+}
+
+func (t *connTracker) connectionTracer(ctx context.Context) *quiclogging.ConnectionTracer {
+	id, ok := ctx.Value(quic.ConnectionTracingKey).(quic.ConnectionTracingID)
+	if !ok {
+		return nil
+	}
+	c := &trackedConn{}
+	t.mx.Lock()
+	t.conns[id] = c
+	t.mx.Unlock()
+	return &quiclogging.ConnectionTracer{
+		SentTransportParameters: func(params *quiclogging.TransportParameters) {
+			c.localIdleTimeout.Store(int64(params.MaxIdleTimeout))
+		},
+		ReceivedTransportParameters: func(params *quiclogging.TransportParameters) {
+			c.remoteIdleTimeout.Store(int64(params.MaxIdleTimeout))
+		},
+		UpdatedMetrics: func(rttStats *quiclogging.RTTStats, _, _ quiclogging.ByteCount, _ int) {
+			c.rtt.Store(int64(rttStats.SmoothedRTT()))
+		},
+		Close: func() {
+			t.mx.Lock()
+			delete(t.conns, id)
+			t.mx.Unlock()
+		},
+	}
+}
+
+func (t *connTracker) get(conn *quic.Conn) *trackedConn {
+	if t == nil {
+		return nil
+	}
+	id, ok := conn.Context().Value(quic.ConnectionTracingKey).(quic.ConnectionTracingID)
+	if !ok {
+		return nil
+	}
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return t.conns[id]
+}
+
+func (t *connTracker) smoothedRTT(conn *quic.Conn) time.Duration {
+	c := t.get(conn)
+	if c == nil {
+		return 0
+	}
+	return time.Duration(c.rtt.Load())
+}
+
+// idleTimeout returns the idle timeout in effect for conn: the smaller of the
+// idle timeouts of both endpoints, like quic-go computes it.
+func (t *connTracker) idleTimeout(conn *quic.Conn) time.Duration {
+	c := t.get(conn)
+	if c == nil {
+		return 0
+	}
+	local := time.Duration(c.localIdleTimeout.Load())
+	remote := time.Duration(c.remoteIdleTimeout.Load())
+	if local == 0 {
+		// The transport parameters weren't exchanged yet.
+		return 0
+	}
+	if remote > 0 {
+		return min(local, remote)
+	}
+	return local
+}
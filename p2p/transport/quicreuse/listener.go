@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"slices"
 	"strings"
 	"sync"
 
@@ -34,23 +35,29 @@ type quicListener struct {
 	transport RefCountedQUICTransport
 	running   chan struct{}
 	addrs     []ma.Multiaddr
+	// early is set if the listener accepts 0-RTT connections. These are
+	// returned by Accept before the handshake completes.
+	early bool
 
 	protocolsMu sync.Mutex
 	protocols   map[string]protoConf
 }
 
 func newQuicListener(tr RefCountedQUICTransport, quicConfig *quic.Config) (*quicListener, error) {
-	localMultiaddrs := make([]ma.Multiaddr, 0, 2)
-	a, err := ToQuicMultiaddr(tr.LocalAddr(), quic.Version1)
-	if err != nil {
-		return nil, err
+	// Only advertise the versions accepted by the listener. Versions without a
+	// multiaddr representation are accepted, but not advertised.
+	localMultiaddrs := make([]ma.Multiaddr, 0, len(quicConfig.Versions))
+	for _, v := range quicConfig.Versions {
+		if a, err := ToQuicMultiaddr(tr.LocalAddr(), v); err == nil {
+			localMultiaddrs = append(localMultiaddrs, a)
+		}
 	}
-	localMultiaddrs = append(localMultiaddrs, a)
 	cl := &quicListener{
 		protocols: map[string]protoConf{},
 		running:   make(chan struct{}),
 		transport: tr,
 		addrs:     localMultiaddrs,
+		early:     quicConfig.Allow0RTT,
 	}
 	tlsConf := &tls.Config{
 		SessionTicketsDisabled: true, // This is set for the config for client, but we set it here as well: https://github.com/quic-go/quic-go/issues/4029
@@ -71,7 +78,13 @@ func newQuicListener(tr RefCountedQUICTransport, quicConfig *quic.Config) (*quic
 	}
 	quicConf := quicConfig.Clone()
 	quicConf.AllowConnectionWindowIncrease = cl.allowWindowIncrease
-	ln, err := tr.Listen(tlsConf, quicConf)
+	var ln QUICListener
+	var err error
+	if cl.early {
+		ln, err = listenEarly(tr, tlsConf, quicConf)
+	} else {
+		ln, err = tr.Listen(tlsConf, quicConf)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -134,16 +147,39 @@ func (l *quicListener) Run() error {
 			}
 			return err
 		}
-		proto := conn.ConnectionState().TLS.NegotiatedProtocol
-
-		l.protocolsMu.Lock()
-		ln, ok := l.protocols[proto]
-		if !ok {
-			l.protocolsMu.Unlock()
-			return fmt.Errorf("negotiated unknown protocol: %s", proto)
+		if l.early {
+			go l.addAfterHandshake(conn)
+			continue
 		}
-		ln.ln.add(conn)
-		l.protocolsMu.Unlock()
+		if err := l.add(conn); err != nil {
+			return err
+		}
+	}
+}
+
+func (l *quicListener) add(conn *quic.Conn) error {
+	proto := conn.ConnectionState().TLS.NegotiatedProtocol
+
+	l.protocolsMu.Lock()
+	defer l.protocolsMu.Unlock()
+	ln, ok := l.protocols[proto]
+	if !ok {
+		return fmt.Errorf("negotiated unknown protocol: %s", proto)
+	}
+	ln.ln.add(conn)
+	return nil
+}
+
+// addAfterHandshake waits for the handshake of a connection accepted before
+// handshake completion, so that the listeners only ever see verified connections.
+func (l *quicListener) addAfterHandshake(conn *quic.Conn) {
+	select {
+	case <-conn.HandshakeComplete():
+	case <-conn.Context().Done():
+		return
+	}
+	if err := l.add(conn); err != nil {
+		conn.CloseWithError(1, err.Error())
 	}
 }
 
@@ -207,6 +243,23 @@ func (l *listener) Multiaddrs() []ma.Multiaddr {
 	return l.addrs
 }
 
+// ListenerStats is a snapshot of the state of a Listener.
+type ListenerStats struct {
+	// QueuedConns is the number of connections waiting to be accepted.
+	QueuedConns int
+	// Multiaddrs are the multiaddrs the listener advertises.
+	Multiaddrs []ma.Multiaddr
+}
+
+// Stats returns a snapshot of the listener's state. The returned value is not
+// updated and may be modified by the caller.
+func (l *listener) Stats() ListenerStats {
+	return ListenerStats{
+		QueuedConns: len(l.queue),
+		Multiaddrs:  slices.Clone(l.addrs),
+	}
+}
+
 func (l *listener) Close() error {
 	l.closeOnce.Do(func() {
 		l.remove()
@@ -3,10 +3,14 @@ package quicreuse
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
+	"slices"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
 )
 
 type Option func(*ConnManager) error
@@ -34,6 +38,17 @@ func DisableReuseport() Option {
 	}
 }
 
+// DisablePathMTUDiscovery disables path MTU discovery on all connections dialed
+// and accepted through the ConnManager. Packets are then limited to the minimum
+// size permitted by QUIC. This avoids connectivity issues on networks that drop
+// ICMP messages or oversized packets.
+func DisablePathMTUDiscovery() Option {
+	return func(m *ConnManager) error {
+		m.disablePathMTUDiscovery = true
+		return nil
+	}
+}
+
 // ConnContext sets the context for all connections accepted by listeners. This doesn't affect the
 // context for dialed connections. To reject a connection, return a non nil error.
 func ConnContext(f func(ctx context.Context, clientInfo *quic.ClientInfo) (context.Context, error)) Option {
@@ -55,6 +70,161 @@ func VerifySourceAddress(f func(addr net.Addr) bool) Option {
 	}
 }
 
+// EnableZeroRTT makes listeners accept 0-RTT connection attempts. Incoming
+// connections are still only passed to the listeners once the handshake has
+// completed, so the client's identity is verified before they are handed out.
+// Data sent in 0-RTT is buffered until then.
+// Only protocols whose tls.Config issues session tickets can be resumed using 0-RTT.
+func EnableZeroRTT() Option {
+	return func(m *ConnManager) error {
+		m.enableZeroRTT = true
+		return nil
+	}
+}
+
+// EnableConnTracking makes the ConnManager record the smoothed RTT and the
+// idle timeout of connections, see ConnManager.SmoothedRTT and
+// ConnManager.IdleTimeout. This installs a tracer on every connection, so it
+// is off by default.
+func EnableConnTracking() Option {
+	return func(m *ConnManager) error {
+		m.conns = newConnTracker()
+		return nil
+	}
+}
+
+// WithAllowedVersions restricts the QUIC versions of connections dialed and
+// accepted through the ConnManager. Listeners only advertise multiaddrs for
+// these versions, and quic-go refuses connection attempts using any other
+// version during version negotiation. Dials to other versions fail.
+// Only versions with a multiaddr representation, see ToQuicMultiaddr, are
+// supported, as listeners couldn't advertise other versions.
+// By default, only QUIC v1 is used.
+func WithAllowedVersions(versions ...quic.Version) Option {
+	return func(m *ConnManager) error {
+		if len(versions) == 0 {
+			return errors.New("at least one QUIC version must be allowed")
+		}
+		for _, v := range versions {
+			if _, err := ToQuicMultiaddr(&net.UDPAddr{IP: net.IPv4zero}, v); err != nil {
+				return fmt.Errorf("QUIC version %s not supported", v)
+			}
+		}
+		m.versions = slices.Clone(versions)
+		return nil
+	}
+}
+
+// ListenOnInterface restricts listeners to the interface with the given name.
+// Listening on an unspecified address binds to the interface's address of the
+// same IP family instead, so that listeners only advertise that address.
+// Listening on a specific address fails unless it belongs to the interface.
+// The interface's addresses are resolved every time a listener is started.
+func ListenOnInterface(name string) Option {
+	return func(m *ConnManager) error {
+		if name == "" {
+			return errors.New("empty interface name")
+		}
+		m.listenInterface = name
+		return nil
+	}
+}
+
+// WithMaxIncomingStreams limits the number of concurrent bidirectional streams
+// a peer can open on a connection to n, independently of the resource manager.
+// Streams exceeding the limit are refused by QUIC flow control: the peer's
+// attempts to open them block until a stream is closed.
+// QUIC applies this limit per connection, so a peer with several connections
+// can open n streams on each of them.
+// Defaults to 256.
+func WithMaxIncomingStreams(n int64) Option {
+	return func(m *ConnManager) error {
+		if n <= 0 {
+			return errors.New("max incoming streams must be positive")
+		}
+		m.maxIncomingStreams = n
+		return nil
+	}
+}
+
+// WithMaxIdleTimeout sets the idle timeout offered to peers during the
+// handshake. Connections are closed once no packet was received for the
+// smaller of the idle timeouts offered by both endpoints, see
+// ConnManager.IdleTimeout. Since keep-alive packets are sent at least every
+// half of that, this closes connections to peers that stopped responding.
+// Defaults to 30 seconds.
+func WithMaxIdleTimeout(d time.Duration) Option {
+	return func(m *ConnManager) error {
+		if d <= 0 {
+			return errors.New("max idle timeout must be positive")
+		}
+		m.maxIdleTimeout = d
+		return nil
+	}
+}
+
+// WithHandshakeIdleTimeout sets how long a handshake may go without receiving
+// a packet from the peer before it is abandoned, for connections dialed and
+// accepted through the ConnManager. This tears down stalled handshakes
+// independently of the context of the dial, which may be long-lived.
+// Defaults to quic-go's default of 5 seconds.
+func WithHandshakeIdleTimeout(d time.Duration) Option {
+	return func(m *ConnManager) error {
+		if d <= 0 {
+			return errors.New("handshake idle timeout must be positive")
+		}
+		m.handshakeIdleTimeout = d
+		return nil
+	}
+}
+
+// WithDialSourcePort makes dials originate from the local UDP port port, e.g.
+// so that firewalls only need to allow traffic from that port. Dials use a
+// listener on the port if there is one, and otherwise bind a socket to it that
+// listeners on the unspecified address later take over. If the port is used by
+// another socket, dials fall back to the usual choice of transport, see
+// ConnManager.DialQUIC.
+// With reuseport disabled, only one dial at a time can use the port.
+func WithDialSourcePort(port int) Option {
+	return func(m *ConnManager) error {
+		if port <= 0 || port > 65535 {
+			return errors.New("dial source port must be between 1 and 65535")
+		}
+		m.dialPort = port
+		return nil
+	}
+}
+
+// WithConnectionTracer sets a function returning a tracer for every connection
+// dialed and accepted through the ConnManager, e.g. to write a qlog file per
+// connection using qlog.NewConnectionTracer. It is called with the connection's
+// perspective and original destination connection ID, and may return nil to
+// not trace a connection.
+// Tracers are called synchronously by quic-go and should return quickly.
+func WithConnectionTracer(f func(ctx context.Context, p logging.Perspective, odcid quic.ConnectionID) *logging.ConnectionTracer) Option {
+	return func(m *ConnManager) error {
+		m.newTracer = f
+		return nil
+	}
+}
+
+// ReceiveBufferWarningThreshold makes the ConnManager raise the receive buffer
+// of the UDP sockets it creates to the 7 MiB quic-go asks for, and log a warning
+// once if a buffer stays smaller than size bytes. A size of 0 disables the
+// warning. The effective buffer size is reported by ConnManager.ReceiveBufferSize.
+// quic-go logs its own warning about receive buffers smaller than 7 MiB, which
+// can only be disabled process-wide, by setting the
+// QUIC_GO_DISABLE_RECEIVE_BUFFER_WARNING environment variable.
+func ReceiveBufferWarningThreshold(size int) Option {
+	return func(m *ConnManager) error {
+		if size < 0 {
+			return errors.New("receive buffer warning threshold must not be negative")
+		}
+		m.receiveBufferWarning = &receiveBufferWarning{threshold: size}
+		return nil
+	}
+}
+
 // EnableMetrics enables Prometheus metrics collection. If reg is nil,
 // prometheus.DefaultRegisterer will be used as the registerer.
 func EnableMetrics(reg prometheus.Registerer) Option {
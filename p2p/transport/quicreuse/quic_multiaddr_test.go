@@ -32,3 +32,21 @@ func TestConvertFromQuicV1Multiaddr(t *testing.T) {
 	require.Equal(t, 1337, udpAddr.Port)
 	require.Equal(t, quic.Version1, v)
 }
+
+func TestConvertIPv6ZoneMultiaddr(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 1337, Zone: "eth0"}
+	maddr, err := ToQuicMultiaddr(addr, quic.Version1)
+	require.NoError(t, err)
+	require.Equal(t, "/ip6zone/eth0/ip6/fe80::1/udp/1337/quic-v1", maddr.String())
+
+	udpAddr, v, err := FromQuicMultiaddr(maddr)
+	require.NoError(t, err)
+	require.Equal(t, quic.Version1, v)
+	require.Equal(t, addr.String(), udpAddr.String())
+	require.Equal(t, "eth0", udpAddr.Zone)
+
+	// Round-tripping yields an equal multiaddr.
+	maddr2, err := ToQuicMultiaddr(udpAddr, v)
+	require.NoError(t, err)
+	require.True(t, maddr.Equal(maddr2))
+}
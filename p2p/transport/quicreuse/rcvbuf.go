@@ -0,0 +1,49 @@
+package quicreuse
+
+import (
+	"net"
+	"sync"
+)
+
+// desiredReceiveBufferSize is the receive buffer size quic-go asks for on the
+// UDP sockets it uses.
+const desiredReceiveBufferSize = 7 << 20
+
+// receiveBufferWarning configures the warning about small UDP receive buffers,
+// see ReceiveBufferWarningThreshold.
+type receiveBufferWarning struct {
+	// threshold is the size below which the warning is logged. 0 if the
+	// warning is suppressed.
+	threshold int
+	once      sync.Once
+}
+
+// setReceiveBuffer raises the receive buffer of conn to the size quic-go asks
+// for, and records the effective size. If the warning threshold isn't 0, it
+// warns once if the buffer stays smaller.
+func (c *ConnManager) setReceiveBuffer(conn net.PacketConn) {
+	if rb, ok := conn.(interface{ SetReadBuffer(int) error }); ok {
+		// We check if this succeeded by querying the buffer size afterwards.
+		_ = rb.SetReadBuffer(desiredReceiveBufferSize)
+	}
+	size, err := inspectReceiveBuffer(conn)
+	if err != nil {
+		log.Debugw("failed to determine UDP receive buffer size", "addr", conn.LocalAddr(), "error", err)
+		return
+	}
+	c.receiveBufferSize.Store(int64(size))
+	if w := c.receiveBufferWarning; w.threshold > 0 && size < w.threshold {
+		w.once.Do(func() {
+			log.Warnf("UDP receive buffer of %d kiB is smaller than %d kiB. See https://github.com/quic-go/quic-go/wiki/UDP-Buffer-Sizes for details.", size/1024, w.threshold/1024)
+		})
+	}
+}
+
+// ReceiveBufferSize returns the receive buffer size of the UDP socket the
+// ConnManager created last, as reported by the operating system after it was
+// raised. It is 0 unless the ConnManager was constructed with
+// ReceiveBufferWarningThreshold, if no socket was created yet, or if the size
+// can't be determined on this platform.
+func (c *ConnManager) ReceiveBufferSize() int {
+	return int(c.receiveBufferSize.Load())
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package quicreuse
+
+import (
+	"errors"
+	"net"
+)
+
+func inspectReceiveBuffer(net.PacketConn) (int, error) {
+	return 0, errors.New("inspecting the receive buffer size is not supported on this platform")
+}
@@ -29,6 +29,36 @@ type RefCountedQUICTransport interface {
 	Listen(tlsConf *tls.Config, conf *quic.Config) (QUICListener, error)
 }
 
+// earlyQUICTransport is implemented by QUICTransports that support 0-RTT.
+// It's not part of QUICTransport, so that transports passed to LendTransport
+// don't have to implement it.
+type earlyQUICTransport interface {
+	DialEarly(ctx context.Context, addr net.Addr, tlsConf *tls.Config, conf *quic.Config) (*quic.Conn, error)
+	ListenEarly(tlsConf *tls.Config, conf *quic.Config) (QUICListener, error)
+}
+
+var _ earlyQUICTransport = (*wrappedQUICTransport)(nil)
+
+// dialEarly dials using 0-RTT if tr supports it, and falls back to a regular dial otherwise.
+func dialEarly(ctx context.Context, tr interface {
+	Dial(ctx context.Context, addr net.Addr, tlsConf *tls.Config, conf *quic.Config) (*quic.Conn, error)
+}, addr net.Addr, tlsConf *tls.Config, conf *quic.Config) (*quic.Conn, error) {
+	if etr, ok := tr.(earlyQUICTransport); ok {
+		return etr.DialEarly(ctx, addr, tlsConf, conf)
+	}
+	return tr.Dial(ctx, addr, tlsConf, conf)
+}
+
+// listenEarly listens for 0-RTT connections if tr supports it, and falls back to a regular listener otherwise.
+func listenEarly(tr interface {
+	Listen(tlsConf *tls.Config, conf *quic.Config) (QUICListener, error)
+}, tlsConf *tls.Config, conf *quic.Config) (QUICListener, error) {
+	if etr, ok := tr.(earlyQUICTransport); ok {
+		return etr.ListenEarly(tlsConf, conf)
+	}
+	return tr.Listen(tlsConf, conf)
+}
+
 type singleOwnerTransport struct {
 	Transport QUICTransport
 
@@ -49,6 +79,10 @@ func (c *singleOwnerTransport) Dial(ctx context.Context, addr net.Addr, tlsConf
 	return c.Transport.Dial(ctx, addr, tlsConf, conf)
 }
 
+func (c *singleOwnerTransport) DialEarly(ctx context.Context, addr net.Addr, tlsConf *tls.Config, conf *quic.Config) (*quic.Conn, error) {
+	return dialEarly(ctx, c.Transport, addr, tlsConf, conf)
+}
+
 func (c *singleOwnerTransport) ReadNonQUICPacket(ctx context.Context, b []byte) (int, net.Addr, error) {
 	return c.Transport.ReadNonQUICPacket(ctx, b)
 }
@@ -67,6 +101,10 @@ func (c *singleOwnerTransport) Listen(tlsConf *tls.Config, conf *quic.Config) (Q
 	return c.Transport.Listen(tlsConf, conf)
 }
 
+func (c *singleOwnerTransport) ListenEarly(tlsConf *tls.Config, conf *quic.Config) (QUICListener, error) {
+	return listenEarly(c.Transport, tlsConf, conf)
+}
+
 // Constant. Defined as variables to simplify testing.
 var (
 	garbageCollectInterval = 30 * time.Second
@@ -149,6 +187,14 @@ func (c *refcountedTransport) Listen(tlsConf *tls.Config, conf *quic.Config) (QU
 	return c.QUICTransport.Listen(tlsConf, conf)
 }
 
+func (c *refcountedTransport) DialEarly(ctx context.Context, addr net.Addr, tlsConf *tls.Config, conf *quic.Config) (*quic.Conn, error) {
+	return dialEarly(ctx, c.QUICTransport, addr, tlsConf, conf)
+}
+
+func (c *refcountedTransport) ListenEarly(tlsConf *tls.Config, conf *quic.Config) (QUICListener, error) {
+	return listenEarly(c.QUICTransport, tlsConf, conf)
+}
+
 func (c *refcountedTransport) DecreaseCount() {
 	c.mutex.Lock()
 	c.refCount--
@@ -187,6 +233,9 @@ type reuse struct {
 	tokenGeneratorKey   *quic.TokenGeneratorKey
 	connContext         connContextFunc
 	verifySourceAddress func(addr net.Addr) bool
+
+	// dialPort is the local port dials originate from, if possible. 0 means any port.
+	dialPort int
 }
 
 func newReuse(srk *quic.StatelessResetKey, tokenKey *quic.TokenGeneratorKey, listenUDP listenUDP, sourceIPSelectorFn func() (SourceIPSelector, error),
@@ -301,6 +350,12 @@ func (r *reuse) TransportWithAssociationForDial(association any, network string,
 }
 
 func (r *reuse) transportForDialLocked(association any, network string, source *net.IP) (*refcountedTransport, error) {
+	if r.dialPort != 0 {
+		if tr := r.transportForDialPortLocked(network, source); tr != nil {
+			return tr, nil
+		}
+	}
+
 	if source != nil {
 		// We already have at least one suitable transport...
 		if trs, ok := r.unicast[source.String()]; ok {
@@ -337,14 +392,7 @@ func (r *reuse) transportForDialLocked(association any, network string, source *
 
 	// We don't have a transport that we can use for dialing.
 	// Dial a new connection from a random port.
-	var addr *net.UDPAddr
-	switch network {
-	case "udp4":
-		addr = &net.UDPAddr{IP: net.IPv4zero, Port: 0}
-	case "udp6":
-		addr = &net.UDPAddr{IP: net.IPv6zero, Port: 0}
-	}
-	conn, err := r.listenUDP(network, addr)
+	conn, err := r.listenUDP(network, unspecifiedUDPAddr(network, 0))
 	if err != nil {
 		return nil, err
 	}
@@ -353,6 +401,41 @@ func (r *reuse) transportForDialLocked(association any, network string, source *
 	return tr, nil
 }
 
+// transportForDialPortLocked returns a transport bound to the dial port, preferring a listening one, and creates a
+// new one if there isn't any. It returns nil if the port is used by another socket.
+func (r *reuse) transportForDialPortLocked(network string, source *net.IP) *refcountedTransport {
+	if source != nil {
+		if tr, ok := r.unicast[source.String()][r.dialPort]; ok {
+			return tr
+		}
+	}
+	if tr, ok := r.globalListeners[r.dialPort]; ok {
+		return tr
+	}
+	if tr, ok := r.globalDialers[r.dialPort]; ok {
+		return tr
+	}
+	conn, err := r.listenUDP(network, unspecifiedUDPAddr(network, r.dialPort))
+	if err != nil {
+		log.Debugw("dial source port unavailable, dialing from another port", "port", r.dialPort, "error", err)
+		return nil
+	}
+	tr := r.newTransport(conn)
+	r.globalDialers[r.dialPort] = tr
+	return tr
+}
+
+// unspecifiedUDPAddr returns the address listening on all interfaces on port.
+func unspecifiedUDPAddr(network string, port int) *net.UDPAddr {
+	switch network {
+	case "udp4":
+		return &net.UDPAddr{IP: net.IPv4zero, Port: port}
+	case "udp6":
+		return &net.UDPAddr{IP: net.IPv6zero, Port: port}
+	}
+	return nil
+}
+
 func (r *reuse) AddTransport(tr *refcountedTransport, laddr *net.UDPAddr) error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
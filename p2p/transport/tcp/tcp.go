@@ -267,9 +267,12 @@ func (t *TcpTransport) DialWithUpdates(ctx context.Context, raddr ma.Multiaddr,
 }
 
 func (t *TcpTransport) dialWithScope(ctx context.Context, raddr ma.Multiaddr, p peer.ID, connScope network.ConnManagementScope, updateChan chan<- transport.DialUpdate) (transport.CapableConn, error) {
-	if err := connScope.SetPeer(p); err != nil {
-		log.Debugw("resource manager blocked outgoing connection for peer", "peer", p, "addr", raddr, "error", err)
-		return nil, err
+	// If the peer is unknown, the upgrader sets it once the connection is secured.
+	if p != "" {
+		if err := connScope.SetPeer(p); err != nil {
+			log.Debugw("resource manager blocked outgoing connection for peer", "peer", p, "addr", raddr, "error", err)
+			return nil, err
+		}
 	}
 	conn, err := t.maDial(ctx, raddr)
 	if err != nil {